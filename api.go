@@ -0,0 +1,623 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiPrefix REST API 路径前缀
+const apiPrefix = "/api/v1"
+
+// probeTimeout 探测文件信息的超时时间
+const probeTimeout = 30 * time.Second
+
+//go:embed docs/openapi.json
+var openAPISpec []byte
+
+// APIError 统一的API错误信息
+type APIError struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Details []FieldError `json:"details,omitempty"` // 配置校验失败时每个配置项的错误
+}
+
+// registerAPI 注册 /api/v1 下的REST接口
+func (ws *WebServer) registerAPI(mux *http.ServeMux) {
+	mux.HandleFunc(apiPrefix+"/tasks", func(w http.ResponseWriter, r *http.Request) {
+		ws.updateLastActive()
+		ws.handleAPITasks(w, r)
+	})
+	mux.HandleFunc(apiPrefix+"/tasks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		ws.updateLastActive()
+		ws.handleAPITask(w, r)
+	})
+	mux.HandleFunc(apiPrefix+"/tasks/{id}/cancel", func(w http.ResponseWriter, r *http.Request) {
+		ws.updateLastActive()
+		ws.handleAPICancelTask(w, r)
+	})
+	mux.HandleFunc(apiPrefix+"/tasks/{id}/pause", func(w http.ResponseWriter, r *http.Request) {
+		ws.updateLastActive()
+		ws.handleAPITaskAction(w, r, ws.tasks.Pause)
+	})
+	mux.HandleFunc(apiPrefix+"/tasks/{id}/resume", func(w http.ResponseWriter, r *http.Request) {
+		ws.updateLastActive()
+		ws.handleAPITaskAction(w, r, ws.tasks.Resume)
+	})
+	mux.HandleFunc(apiPrefix+"/tasks/{id}/retry", func(w http.ResponseWriter, r *http.Request) {
+		ws.updateLastActive()
+		ws.handleAPITaskAction(w, r, ws.tasks.Retry)
+	})
+	mux.HandleFunc(apiPrefix+"/probe", func(w http.ResponseWriter, r *http.Request) {
+		ws.updateLastActive()
+		ws.handleAPIProbe(w, r)
+	})
+	mux.HandleFunc(apiPrefix+"/config", func(w http.ResponseWriter, r *http.Request) {
+		ws.updateLastActive()
+		ws.handleAPIConfig(w, r)
+	})
+	mux.HandleFunc(apiPrefix+"/profiles", func(w http.ResponseWriter, r *http.Request) {
+		ws.updateLastActive()
+		ws.handleAPIProfiles(w, r)
+	})
+	mux.HandleFunc(apiPrefix+"/profiles/{name}", func(w http.ResponseWriter, r *http.Request) {
+		ws.updateLastActive()
+		ws.handleAPIProfile(w, r)
+	})
+	mux.HandleFunc(apiPrefix+"/profiles/{name}/activate", func(w http.ResponseWriter, r *http.Request) {
+		ws.updateLastActive()
+		ws.handleAPIActivateProfile(w, r)
+	})
+	mux.HandleFunc(apiPrefix+"/search", func(w http.ResponseWriter, r *http.Request) {
+		ws.updateLastActive()
+		ws.handleAPISearch(w, r)
+	})
+	mux.HandleFunc(apiPrefix+"/library/{path...}", func(w http.ResponseWriter, r *http.Request) {
+		ws.updateLastActive()
+		ws.handleAPILibrary(w, r)
+	})
+	mux.HandleFunc(apiPrefix+"/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		ws.handleOpenAPI(w, r)
+	})
+	mux.HandleFunc(apiPrefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		sendAPIError(w, http.StatusNotFound, "not_found", fmt.Sprintf("接口不存在: %s", r.URL.Path))
+	})
+}
+
+// handleAPITasks 处理任务列表查询和任务创建
+func (ws *WebServer) handleAPITasks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		sendAPIResponse(w, http.StatusOK, map[string]interface{}{
+			"tasks": ws.tasks.List(),
+		})
+	case http.MethodPost:
+		var req struct {
+			URL     string `json:"url"`
+			Profile string `json:"profile"` // 为空时使用当前配置方案
+		}
+		if err := parseJSON(r, &req); err != nil {
+			sendAPIError(w, http.StatusBadRequest, "invalid_json", fmt.Sprintf("请求体不是有效的JSON: %v", err))
+			return
+		}
+		if req.URL == "" {
+			sendAPIError(w, http.StatusBadRequest, "invalid_argument", "请提供PDF文件URL")
+			return
+		}
+		progress, err := ws.startTask(req.URL, req.Profile)
+		if err != nil {
+			sendAPIError(w, http.StatusBadRequest, "invalid_argument", err.Error())
+			return
+		}
+		sendAPIResponse(w, http.StatusCreated, progress)
+	default:
+		sendMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+// handleAPITask 处理单个任务的查询和删除
+func (ws *WebServer) handleAPITask(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	switch r.Method {
+	case http.MethodGet:
+		progress, err := ws.tasks.Get(id)
+		if err != nil {
+			sendTaskError(w, err)
+			return
+		}
+		sendAPIResponse(w, http.StatusOK, progress)
+	case http.MethodDelete:
+		if err := ws.tasks.Remove(id); err != nil {
+			sendTaskError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		sendMethodNotAllowed(w, http.MethodGet, http.MethodDelete)
+	}
+}
+
+// handleAPICancelTask 取消任务但保留在任务列表中
+func (ws *WebServer) handleAPICancelTask(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	progress, err := ws.tasks.Cancel(r.PathValue("id"))
+	if err != nil {
+		sendTaskError(w, err)
+		return
+	}
+	sendAPIResponse(w, http.StatusOK, progress)
+}
+
+// handleAPITaskAction 处理任务的暂停、继续和重试
+func (ws *WebServer) handleAPITaskAction(w http.ResponseWriter, r *http.Request, action func(id string) (*DownloadProgress, error)) {
+	if r.Method != http.MethodPost {
+		sendMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	progress, err := action(r.PathValue("id"))
+	if err != nil {
+		sendTaskError(w, err)
+		return
+	}
+	sendAPIResponse(w, http.StatusOK, progress)
+}
+
+// probeResponse 探测结果，检查不通过时Error说明不能开始下载的原因
+type probeResponse struct {
+	*ProbeResult
+	Error *APIError `json:"error,omitempty"`
+}
+
+// handleAPIProbe 在创建任务前探测文件信息和磁盘空间，供页面在确认下载前显示
+func (ws *WebServer) handleAPIProbe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	var req struct {
+		URL     string `json:"url"`
+		Profile string `json:"profile"` // 为空时使用当前配置方案
+	}
+	if err := parseJSON(r, &req); err != nil {
+		sendAPIError(w, http.StatusBadRequest, "invalid_json", fmt.Sprintf("请求体不是有效的JSON: %v", err))
+		return
+	}
+	if req.URL == "" {
+		sendAPIError(w, http.StatusBadRequest, "invalid_argument", "请提供PDF文件URL")
+		return
+	}
+	config, _, err := ws.taskConfig(req.URL, req.Profile)
+	if err != nil {
+		sendAPIError(w, http.StatusBadRequest, "invalid_argument", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), probeTimeout)
+	defer cancel()
+	result, err := probeFile(ctx, config)
+	if err != nil {
+		// 请求文件服务器失败，错误码与下载失败时相同
+		sendAPIError(w, http.StatusBadGateway, errorCode(err), config.RedactString(err.Error()))
+		return
+	}
+	resp := probeResponse{ProbeResult: result}
+	if err := result.check(); err != nil {
+		resp.Error = &APIError{Code: errorCode(err), Message: err.Error()}
+	}
+	sendAPIResponse(w, http.StatusOK, resp)
+}
+
+// handleAPIConfig 处理配置的查询和更新
+func (ws *WebServer) handleAPIConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		sendAPIResponse(w, http.StatusOK, ws.currentConfig().Redacted())
+	case http.MethodPut:
+		var config Config
+		if err := parseJSON(r, &config); err != nil {
+			sendAPIError(w, http.StatusBadRequest, "invalid_json", fmt.Sprintf("请求体不是有效的JSON: %v", err))
+			return
+		}
+		if err := ws.replaceConfig(&config); err != nil {
+			var verr *ValidationError
+			if errors.As(err, &verr) {
+				sendAPIResponse(w, http.StatusBadRequest, map[string]interface{}{
+					"error": APIError{Code: "invalid_config", Message: err.Error(), Details: verr.Fields},
+				})
+				return
+			}
+			sendAPIError(w, http.StatusInternalServerError, "internal", fmt.Sprintf("保存配置失败: %v", err))
+			return
+		}
+		sendAPIResponse(w, http.StatusOK, ws.currentConfig().Redacted())
+	default:
+		sendMethodNotAllowed(w, http.MethodGet, http.MethodPut)
+	}
+}
+
+// defaultSearchLimit 搜索接口默认返回的结果数
+const defaultSearchLimit = 20
+
+// handleAPISearch 在输出目录中已下载的PDF中全文搜索。只搜索已经建立的索引，不等待后台更新，
+// 更新未完成时 indexing 为true，新下载的文件可能还搜索不到
+func (ws *WebServer) handleAPISearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	query := r.URL.Query().Get("q")
+	if len(searchTokens(query)) == 0 {
+		sendAPIError(w, http.StatusBadRequest, "invalid_argument", "请提供搜索关键字")
+		return
+	}
+	limit := defaultSearchLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			sendAPIError(w, http.StatusBadRequest, "invalid_argument", fmt.Sprintf("limit 必须是非负整数: %q", s))
+			return
+		}
+		limit = n
+	}
+
+	dir := ws.currentConfig().OutputDir
+	ws.searchMu.Lock()
+	idx, indexing, err := ws.searchIdx, ws.searchIndexing, ws.searchErr
+	if ws.searchDir != dir {
+		idx = nil
+	}
+	ws.searchMu.Unlock()
+	if idx == nil && err != nil && !indexing {
+		sendAPIError(w, http.StatusInternalServerError, ErrCodeFilesystem, err.Error())
+		return
+	}
+
+	results := []SearchResult{}
+	if idx != nil {
+		results = append(results, idx.search(query, limit)...)
+	}
+	for i := range results {
+		results[i].URL = libraryURL(results[i].Path, results[i].Page)
+	}
+	sendAPIResponse(w, http.StatusOK, map[string]interface{}{
+		"query":    query,
+		"results":  results,
+		"indexing": indexing,
+	})
+}
+
+// startSearchIndexer 建立全文索引，并在下载完成或配置变化后更新
+func (ws *WebServer) startSearchIndexer() {
+	ws.events.Subscribe(searchIndexTrigger{ws})
+	ws.refreshSearchIndex()
+}
+
+// searchIndexTrigger 收到任务完成和配置变化的事件时请求更新全文索引
+type searchIndexTrigger struct {
+	ws *WebServer
+}
+
+// Deliver 实现EventSubscriber接口，只发出更新请求，不会阻塞
+func (t searchIndexTrigger) Deliver(evt *Event) {
+	completed := evt.Type == EventStateChanged && evt.Task != nil && evt.Task.Status == TaskStatusCompleted
+	if completed || evt.Type == EventConfigChanged {
+		t.ws.refreshSearchIndex()
+	}
+}
+
+// refreshSearchIndex 请求在后台更新全文索引，已有等待中的请求时合并为一次
+func (ws *WebServer) refreshSearchIndex() {
+	ws.searchOnce.Do(func() { go ws.searchIndexLoop() })
+	ws.searchMu.Lock()
+	defer ws.searchMu.Unlock()
+	ws.searchIndexing = true
+	select {
+	case ws.searchRefresh <- struct{}{}:
+	default:
+	}
+}
+
+// searchIndexLoop 依次处理更新请求
+func (ws *WebServer) searchIndexLoop() {
+	for range ws.searchRefresh {
+		ws.updateSearchIndex()
+	}
+}
+
+// updateSearchIndex 在当前索引的副本上提取新增和修改过的文件，有变化时保存，完成后替换当前索引。
+// 输出目录变化时从新目录中的索引文件开始
+func (ws *WebServer) updateSearchIndex() {
+	dir := ws.currentConfig().OutputDir
+	ws.searchMu.Lock()
+	current, currentDir := ws.searchIdx, ws.searchDir
+	ws.searchMu.Unlock()
+
+	var idx *SearchIndex
+	var err error
+	if current != nil && currentDir == dir {
+		idx = current.clone()
+	} else {
+		idx, err = loadSearchIndex(dir)
+	}
+	if err == nil {
+		var changed bool
+		if changed, err = idx.update(dir, nil); err == nil && changed {
+			err = idx.save(dir)
+		}
+	}
+
+	ws.searchMu.Lock()
+	defer ws.searchMu.Unlock()
+	ws.searchErr = err
+	if err == nil {
+		ws.searchIdx, ws.searchDir = idx, dir
+	}
+	// 更新期间收到的请求还在等待处理
+	ws.searchIndexing = len(ws.searchRefresh) > 0
+}
+
+// libraryURL 返回通过Web界面打开输出目录中PDF指定页的地址
+func libraryURL(path string, page int) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return fmt.Sprintf("%s/library/%s#page=%d", apiPrefix, strings.Join(segments, "/"), page)
+}
+
+// handleAPILibrary 返回输出目录中已下载的PDF文件，只允许访问输出目录内的PDF
+func (ws *WebServer) handleAPILibrary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		sendMethodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	path := r.PathValue("path")
+	if !fs.ValidPath(path) || !strings.EqualFold(filepath.Ext(path), ".pdf") {
+		sendAPIError(w, http.StatusNotFound, "not_found", fmt.Sprintf("文件不存在: %s", path))
+		return
+	}
+	dir := ws.currentConfig().OutputDir
+	if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(path))); err != nil {
+		sendAPIError(w, http.StatusNotFound, "not_found", fmt.Sprintf("文件不存在: %s", path))
+		return
+	}
+	http.ServeFileFS(w, r, os.DirFS(dir), path)
+}
+
+// handleOpenAPI 返回OpenAPI接口描述文档
+func (ws *WebServer) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// sendAPIResponse 以指定状态码发送JSON响应
+func sendAPIResponse(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	sendJSONResponse(w, data)
+}
+
+// sendAPIError 发送统一格式的错误响应
+func sendAPIError(w http.ResponseWriter, status int, code, message string) {
+	sendAPIResponse(w, status, map[string]interface{}{
+		"error": APIError{Code: code, Message: message},
+	})
+}
+
+// sendMethodNotAllowed 发送405错误响应
+func sendMethodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	for _, method := range allowed {
+		w.Header().Add("Allow", method)
+	}
+	sendAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "不支持的请求方法")
+}
+
+// ProfileResponse 单个配置方案
+type ProfileResponse struct {
+	Name   string  `json:"name"`
+	Active bool    `json:"active"`
+	Config *Config `json:"config"` // 配置文件中保存的内容，不含环境变量和命令行参数的覆盖值
+}
+
+// newProfileResponse 创建配置方案响应，敏感请求头只返回占位符
+func newProfileResponse(name string, active bool, config *Config) ProfileResponse {
+	return ProfileResponse{Name: name, Active: active, Config: config.Redacted()}
+}
+
+// handleAPIProfiles 处理配置方案列表和创建。
+// 创建时可以提供完整配置，否则复制copy_from指定的方案（默认为当前方案）
+func (ws *WebServer) handleAPIProfiles(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		cf, err := ws.readProfiles()
+		if err != nil {
+			sendProfileError(w, err)
+			return
+		}
+		active := ws.currentProfile()
+		profiles := make([]ProfileResponse, 0, len(cf.Profiles))
+		for _, name := range cf.Names() {
+			profiles = append(profiles, newProfileResponse(name, name == active, cf.Profiles[name]))
+		}
+		sendAPIResponse(w, http.StatusOK, map[string]interface{}{
+			"active":   active,
+			"profiles": profiles,
+		})
+	case http.MethodPost:
+		var req struct {
+			Name     string  `json:"name"`
+			Config   *Config `json:"config"`
+			CopyFrom string  `json:"copy_from"`
+		}
+		if err := parseJSON(r, &req); err != nil {
+			sendAPIError(w, http.StatusBadRequest, "invalid_json", fmt.Sprintf("请求体不是有效的JSON: %v", err))
+			return
+		}
+		if req.Config != nil {
+			if err := req.Config.withDefaults().Validate(); err != nil {
+				sendProfileError(w, err)
+				return
+			}
+		}
+		var created *Config
+		err := ws.updateProfiles(func(cf *ConfigFile) error {
+			if _, ok := cf.Profiles[req.Name]; ok {
+				return fmt.Errorf("%w: %q", errProfileExists, req.Name)
+			}
+			created = req.Config
+			if created == nil {
+				from := req.CopyFrom
+				if from == "" {
+					from = ws.currentProfile()
+				}
+				template, err := cf.Profile(from)
+				if err != nil {
+					return err
+				}
+				created = template
+			}
+			return cf.SetProfile(req.Name, created)
+		})
+		if err != nil {
+			sendProfileError(w, err)
+			return
+		}
+		sendAPIResponse(w, http.StatusCreated, newProfileResponse(req.Name, false, created))
+	default:
+		sendMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+// handleAPIProfile 处理单个配置方案的查询、替换和删除。替换当前方案时同时更新当前配置
+func (ws *WebServer) handleAPIProfile(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	switch r.Method {
+	case http.MethodGet:
+		cf, err := ws.readProfiles()
+		if err != nil {
+			sendProfileError(w, err)
+			return
+		}
+		config, err := cf.Profile(name)
+		if err != nil {
+			sendProfileError(w, err)
+			return
+		}
+		sendAPIResponse(w, http.StatusOK, newProfileResponse(name, name == ws.currentProfile(), config))
+	case http.MethodPut:
+		var config Config
+		if err := parseJSON(r, &config); err != nil {
+			sendAPIError(w, http.StatusBadRequest, "invalid_json", fmt.Sprintf("请求体不是有效的JSON: %v", err))
+			return
+		}
+		if name == ws.currentProfile() {
+			if err := ws.replaceConfig(&config); err != nil {
+				sendProfileError(w, err)
+				return
+			}
+		} else {
+			if err := config.withDefaults().Validate(); err != nil {
+				sendProfileError(w, err)
+				return
+			}
+			err := ws.updateProfiles(func(cf *ConfigFile) error {
+				previous, err := cf.Profile(name)
+				if err != nil {
+					return err
+				}
+				config.restoreSecrets(previous)
+				return cf.SetProfile(name, &config)
+			})
+			if err != nil {
+				sendProfileError(w, err)
+				return
+			}
+		}
+		sendAPIResponse(w, http.StatusOK, newProfileResponse(name, name == ws.currentProfile(), &config))
+	case http.MethodDelete:
+		err := ws.updateProfiles(func(cf *ConfigFile) error {
+			// 当前会话使用的方案也不能删除，即使配置文件中的当前方案不同
+			if name == ws.currentProfile() {
+				return fmt.Errorf("%w: %q", ErrProfileActive, name)
+			}
+			return cf.DeleteProfile(name)
+		})
+		if err != nil {
+			sendProfileError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		sendMethodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodDelete)
+	}
+}
+
+// handleAPIActivateProfile 切换当前配置方案
+func (ws *WebServer) handleAPIActivateProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	name := r.PathValue("name")
+	if err := ws.switchProfile(name); err != nil {
+		sendProfileError(w, err)
+		return
+	}
+	sendAPIResponse(w, http.StatusOK, map[string]interface{}{
+		"active": name,
+		"config": ws.currentConfig().Redacted(),
+	})
+}
+
+// errProfileExists 创建的配置方案已存在
+var errProfileExists = errors.New("配置方案已存在")
+
+// sendProfileError 根据错误类型返回配置方案相关的错误响应
+func sendProfileError(w http.ResponseWriter, err error) {
+	var verr *ValidationError
+	switch {
+	case errors.As(err, &verr):
+		sendAPIResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"error": APIError{Code: "invalid_config", Message: err.Error(), Details: verr.Fields},
+		})
+	case errors.Is(err, ErrProfileNotFound):
+		sendAPIError(w, http.StatusNotFound, "profile_not_found", err.Error())
+	case errors.Is(err, ErrProfileActive):
+		sendAPIError(w, http.StatusConflict, "profile_in_use", err.Error())
+	case errors.Is(err, errProfileExists):
+		sendAPIError(w, http.StatusConflict, "profile_exists", err.Error())
+	case errors.Is(err, errInvalidProfileName):
+		sendAPIError(w, http.StatusBadRequest, "invalid_argument", err.Error())
+	default:
+		sendAPIError(w, http.StatusInternalServerError, "internal", err.Error())
+	}
+}
+
+// sendTaskError 将任务管理器返回的错误转换为API错误响应
+func sendTaskError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrTaskNotFound) {
+		sendAPIError(w, http.StatusNotFound, "task_not_found", err.Error())
+		return
+	}
+	if errors.Is(err, ErrTaskState) {
+		sendAPIError(w, http.StatusConflict, "invalid_task_state", err.Error())
+		return
+	}
+	sendAPIError(w, http.StatusInternalServerError, "internal", err.Error())
+}
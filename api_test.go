@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestWebServer 创建用于测试的Web服务，配置文件和输出目录位于临时目录
+func newTestWebServer(t *testing.T) (*WebServer, http.Handler) {
+	t.Helper()
+	dir := t.TempDir()
+	config := getDefaultConfig()
+	config.OutputDir = dir
+	ws := NewWebServer(&ResolvedConfig{Config: config, Profile: defaultProfileName}, filepath.Join(dir, "config.json"))
+	// 等待后台任务结束再删除临时目录，否则任务创建的文件会导致删除失败
+	t.Cleanup(func() { waitTasksDone(ws.tasks) })
+	return ws, ws.routes()
+}
+
+// waitTasksDone 等待所有任务结束，最多等待5秒
+func waitTasksDone(tm *TaskManager) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		running := false
+		for _, p := range tm.List() {
+			if p.Status == TaskStatusPending || p.Status == TaskStatusDownloading {
+				running = true
+			}
+		}
+		if !running {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// doRequest 发送请求并返回响应记录
+func doRequest(handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// TestAPI_TaskLifecycle 测试任务的创建、查询、列表和删除
+func TestAPI_TaskLifecycle(t *testing.T) {
+	pdf := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("%PDF-1.4 test"))
+	}))
+	defer pdf.Close()
+
+	_, handler := newTestWebServer(t)
+
+	rec := doRequest(handler, http.MethodPost, "/api/v1/tasks", `{"url":"`+pdf.URL+`/book.pdf"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var created DownloadProgress
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	if created.TaskID == "" || created.Filename != "book.pdf" {
+		t.Errorf("Unexpected task: %+v", created)
+	}
+
+	// 等待任务完成
+	var task DownloadProgress
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		rec = doRequest(handler, http.MethodGet, "/api/v1/tasks/"+created.TaskID, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", rec.Code)
+		}
+		json.Unmarshal(rec.Body.Bytes(), &task)
+		if task.Status == TaskStatusCompleted || task.Status == TaskStatusFailed {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if task.Status != TaskStatusCompleted {
+		t.Fatalf("Expected completed task, got %+v", task)
+	}
+
+	rec = doRequest(handler, http.MethodGet, "/api/v1/tasks", "")
+	var list struct {
+		Tasks []DownloadProgress `json:"tasks"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &list)
+	if len(list.Tasks) != 1 || list.Tasks[0].TaskID != created.TaskID {
+		t.Errorf("Unexpected task list: %+v", list.Tasks)
+	}
+
+	rec = doRequest(handler, http.MethodDelete, "/api/v1/tasks/"+created.TaskID, "")
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", rec.Code)
+	}
+	rec = doRequest(handler, http.MethodGet, "/api/v1/tasks/"+created.TaskID, "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 after delete, got %d", rec.Code)
+	}
+}
+
+// TestAPI_ErrorEnvelope 测试错误响应格式
+func TestAPI_ErrorEnvelope(t *testing.T) {
+	_, handler := newTestWebServer(t)
+
+	tests := []struct {
+		method, path, body string
+		status             int
+		code               string
+	}{
+		{http.MethodGet, "/api/v1/tasks/unknown", "", http.StatusNotFound, "task_not_found"},
+		{http.MethodPost, "/api/v1/tasks", "{", http.StatusBadRequest, "invalid_json"},
+		{http.MethodPost, "/api/v1/tasks", "{}", http.StatusBadRequest, "invalid_argument"},
+		{http.MethodPatch, "/api/v1/config", "", http.StatusMethodNotAllowed, "method_not_allowed"},
+		{http.MethodGet, "/api/v1/unknown", "", http.StatusNotFound, "not_found"},
+		{http.MethodPut, "/api/v1/config", `{"output_dir":"out","timeout":"30s","chunk_size":0}`, http.StatusBadRequest, "invalid_config"},
+	}
+	for _, tt := range tests {
+		rec := doRequest(handler, tt.method, tt.path, tt.body)
+		if rec.Code != tt.status {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.path, tt.status, rec.Code)
+		}
+		var envelope struct {
+			Error APIError `json:"error"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &envelope); err != nil {
+			t.Errorf("%s %s: invalid error body: %v", tt.method, tt.path, err)
+		}
+		if envelope.Error.Code != tt.code {
+			t.Errorf("%s %s: expected code %s, got %s", tt.method, tt.path, tt.code, envelope.Error.Code)
+		}
+	}
+}
+
+// TestAPI_OpenAPI 测试OpenAPI文档是否为有效JSON
+func TestAPI_OpenAPI(t *testing.T) {
+	_, handler := newTestWebServer(t)
+
+	rec := doRequest(handler, http.MethodGet, "/api/v1/openapi.json", "")
+	var spec map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("Invalid OpenAPI document: %v", err)
+	}
+	if spec["openapi"] == nil || spec["paths"] == nil {
+		t.Errorf("OpenAPI document is missing required fields")
+	}
+}
+
+// TestAPI_Profiles 测试配置方案的增删改查、切换，以及任务记录使用的配置方案
+func TestAPI_Profiles(t *testing.T) {
+	ws, handler := newTestWebServer(t)
+	outputDir := ws.currentConfig().OutputDir
+
+	rec := doRequest(handler, http.MethodPost, "/api/v1/profiles", `{"name":"school-b"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(handler, http.MethodPost, "/api/v1/profiles", `{"name":"school-b"}`)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for duplicate profile, got %d", rec.Code)
+	}
+	rec = doRequest(handler, http.MethodPost, "/api/v1/profiles", `{"name":"bad/name"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid name, got %d", rec.Code)
+	}
+
+	body := fmt.Sprintf(`{"output_dir":%q,"headers":{"X-Nd-Auth":"token-b"}}`, filepath.Join(outputDir, "b"))
+	rec = doRequest(handler, http.MethodPut, "/api/v1/profiles/school-b", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ws.currentConfig().Headers["X-Nd-Auth"] != "" {
+		t.Errorf("Updating another profile changed the current config")
+	}
+	rec = doRequest(handler, http.MethodPut, "/api/v1/profiles/missing", body)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for missing profile, got %d", rec.Code)
+	}
+
+	var list struct {
+		Active   string            `json:"active"`
+		Profiles []ProfileResponse `json:"profiles"`
+	}
+	rec = doRequest(handler, http.MethodGet, "/api/v1/profiles", "")
+	json.Unmarshal(rec.Body.Bytes(), &list)
+	if list.Active != defaultProfileName || len(list.Profiles) != 2 {
+		t.Errorf("Unexpected profile list: %+v", list)
+	}
+
+	// 使用非当前方案创建任务
+	rec = doRequest(handler, http.MethodPost, "/api/v1/tasks", `{"url":"http://127.0.0.1:1/a.pdf","profile":"school-b"}`)
+	var task DownloadProgress
+	json.Unmarshal(rec.Body.Bytes(), &task)
+	if rec.Code != http.StatusCreated || task.Profile != "school-b" || filepath.Dir(task.OutputPath) != filepath.Join(outputDir, "b") {
+		t.Errorf("Unexpected task for profile school-b: %d %+v", rec.Code, task)
+	}
+	rec = doRequest(handler, http.MethodPost, "/api/v1/tasks", `{"url":"http://127.0.0.1:1/a.pdf","profile":"missing"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for task with missing profile, got %d", rec.Code)
+	}
+
+	rec = doRequest(handler, http.MethodPost, "/api/v1/profiles/school-b/activate", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ws.currentProfile() != "school-b" || ws.currentConfig().Headers["X-Nd-Auth"] != "token-b" {
+		t.Errorf("Activate did not switch the current config")
+	}
+	rec = doRequest(handler, http.MethodPost, "/api/v1/tasks", `{"url":"http://127.0.0.1:1/b.pdf"}`)
+	json.Unmarshal(rec.Body.Bytes(), &task)
+	if task.Profile != "school-b" {
+		t.Errorf("Expected task to record the active profile, got %q", task.Profile)
+	}
+
+	rec = doRequest(handler, http.MethodDelete, "/api/v1/profiles/school-b", "")
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 when deleting the active profile, got %d", rec.Code)
+	}
+	rec = doRequest(handler, http.MethodDelete, "/api/v1/profiles/"+defaultProfileName, "")
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	cf, err := LoadConfigFile(ws.configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cf.ActiveProfile != "school-b" || len(cf.Profiles) != 1 {
+		t.Errorf("Unexpected config file: active %s, profiles %v", cf.ActiveProfile, cf.Names())
+	}
+}
+
+// TestAPI_TaskActions 测试任务的暂停、继续和重试接口
+func TestAPI_TaskActions(t *testing.T) {
+	ws, handler := newTestWebServer(t)
+
+	rec := doRequest(handler, http.MethodPost, "/api/v1/tasks", `{"url":"http://127.0.0.1:1/a.pdf"}`)
+	var task DownloadProgress
+	json.Unmarshal(rec.Body.Bytes(), &task)
+	waitTaskStatus(t, ws.tasks, task.TaskID, TaskStatusFailed)
+
+	rec = doRequest(handler, http.MethodPost, "/api/v1/tasks/"+task.TaskID+"/pause", "")
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "invalid_task_state") {
+		t.Errorf("Expected 409 when pausing a failed task, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(handler, http.MethodPost, "/api/v1/tasks/"+task.TaskID+"/retry", "")
+	json.Unmarshal(rec.Body.Bytes(), &task)
+	if rec.Code != http.StatusOK || task.ErrorMsg != "" {
+		t.Errorf("Expected retried task, got %d: %s", rec.Code, rec.Body.String())
+	}
+	waitTaskStatus(t, ws.tasks, task.TaskID, TaskStatusFailed)
+
+	rec = doRequest(handler, http.MethodPost, "/api/v1/tasks/missing/resume", "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for missing task, got %d", rec.Code)
+	}
+	rec = doRequest(handler, http.MethodGet, "/api/v1/tasks/"+task.TaskID+"/retry", "")
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// bookManifestName 教材文件夹中记录全部文件来源和校验值的文件名
+const bookManifestName = "book.json"
+
+// 教材文件的种类
+const (
+	AssetMain      = "main"      // 教材正文，保存在文件夹外
+	AssetCover     = "cover"     // 封面图片
+	AssetCompanion = "companion" // 配套资源，如答案和音频压缩包
+)
+
+// coverFileFlags 平台元数据中表示封面图片的 ti_file_flag
+var coverFileFlags = []string{"thumbnail", "cover"}
+
+// BookManifest book.json 的格式
+type BookManifest struct {
+	ID        string      `json:"id"`
+	Title     string      `json:"title"`
+	Tags      string      `json:"tags,omitempty"`
+	UpdatedAt time.Time   `json:"updated_at"`
+	Assets    []BookAsset `json:"assets"`
+}
+
+// BookAsset 教材的一个文件
+type BookAsset struct {
+	Kind   string `json:"kind"` // main、cover 或 companion
+	URL    string `json:"url"`
+	Path   string `json:"path"` // 相对于 book.json 所在文件夹的路径，以 / 分隔
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	MD5    string `json:"md5"`
+}
+
+// bookFile 已下载的文件
+type bookFile struct {
+	URL  string
+	Path string
+}
+
+// CoverURLs 返回资源的封面图片地址：ti_items 中的封面文件和 custom_properties 中的缩略图，去除重复
+func (r *PlatformResource) CoverURLs() []string {
+	var urls []string
+	add := func(u string) {
+		if u != "" && !slices.Contains(urls, u) {
+			urls = append(urls, u)
+		}
+	}
+	for _, item := range r.Items {
+		if slices.Contains(coverFileFlags, item.FileFlag) && len(item.Storages) > 0 {
+			add(item.Storages[0])
+		}
+	}
+	for _, u := range r.CustomProperties.Thumbnails {
+		add(u)
+	}
+	return urls
+}
+
+// CompanionItems 返回资源的配套文件：除源文件、封面和网页链接外带有下载地址的文件
+func (r *PlatformResource) CompanionItems() []PlatformItem {
+	var items []PlatformItem
+	for _, item := range r.Items {
+		switch {
+		case len(item.Storages) == 0, item.FileFlag == "", item.FileFlag == "source", item.FileFlag == "href":
+		case slices.Contains(coverFileFlags, item.FileFlag):
+		default:
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// itemMD5 返回元数据中下载地址对应文件的MD5和大小，没有时返回空字符串
+func (r *PlatformResource) itemMD5(rawURL string) (string, int64) {
+	for _, item := range r.Items {
+		if slices.Contains(item.Storages, rawURL) {
+			return strings.ToLower(item.MD5), item.Size
+		}
+	}
+	return "", 0
+}
+
+// bookDir 教材文件夹的路径：与正文同名、在正文旁边的文件夹
+func bookDir(mainOutput string) string {
+	return strings.TrimSuffix(mainOutput, filepath.Ext(mainOutput))
+}
+
+// assetFilename 按地址中的文件名保存配套文件，保留原来的扩展名；地址中没有可用的文件名时使用fallback。
+// 文件名经过 safeFilename 处理，不会指向教材文件夹以外的位置
+func assetFilename(rawURL, fallback string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fallback
+	}
+	name, err := url.PathUnescape(path.Base(u.Path))
+	if err != nil || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fallback
+	}
+	if name = safeFilename(name); name == "" {
+		return fallback
+	}
+	return name
+}
+
+// coverFilename 封面图片的文件名，第一张为 cover.<扩展名>，之后为 cover-2.<扩展名> 等
+func coverFilename(rawURL string, index int) string {
+	ext := strings.ToLower(filepath.Ext(assetFilename(rawURL, "")))
+	if ext == "" || len(ext) > 5 {
+		ext = ".jpg"
+	}
+	if index == 0 {
+		return "cover" + ext
+	}
+	return fmt.Sprintf("cover-%d%s", index+1, ext)
+}
+
+// uniqueAssetName 依次选择第一个未使用的文件名（不区分大小写）并记为已使用，都已使用时在最后一个名称后加序号。
+// 名称只与同一本教材的其他文件比较，与磁盘上已有的文件无关，重复下载时保存为相同的名称
+func uniqueAssetName(used map[string]bool, names ...string) string {
+	for _, name := range names {
+		if !used[strings.ToLower(name)] {
+			used[strings.ToLower(name)] = true
+			return name
+		}
+	}
+	last := names[len(names)-1]
+	ext := filepath.Ext(last)
+	for i := 2; ; i++ {
+		name := fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(last, ext), i, ext)
+		if !used[strings.ToLower(name)] {
+			used[strings.ToLower(name)] = true
+			return name
+		}
+	}
+}
+
+// hashFile 计算文件的大小、SHA-256和MD5
+func hashFile(path string) (int64, string, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", "", err
+	}
+	defer f.Close()
+	sha, sum := sha256.New(), md5.New()
+	size, err := io.Copy(io.MultiWriter(sha, sum), f)
+	if err != nil {
+		return 0, "", "", err
+	}
+	return size, hex.EncodeToString(sha.Sum(nil)), hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// prefixMD5 计算文件前n字节的MD5，读取失败时返回空字符串
+func prefixMD5(path string, n int64) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := io.CopyN(h, f, n); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// bookAsset 计算已下载文件的校验值，平台元数据给出了MD5时检查是否一致
+func bookAsset(resource *PlatformResource, kind, dir string, file bookFile) (BookAsset, error) {
+	size, sha, sum, err := hashFile(file.Path)
+	if err != nil {
+		return BookAsset{}, downloadErrorf(ErrCodeFilesystem, "无法读取文件：%v", err)
+	}
+	if want, wantSize := resource.itemMD5(file.URL); want != "" && want != sum {
+		// 生成书签后正文末尾追加了增量更新，校验其中原文件的部分
+		if kind != AssetMain || wantSize <= 0 || wantSize >= size || prefixMD5(file.Path, wantSize) != want {
+			return BookAsset{}, downloadErrorf(ErrCodeInvalidResponse, "文件 %s 的MD5为 %s，与平台记录的 %s 不一致", filepath.Base(file.Path), sum, want)
+		}
+	}
+	rel, err := filepath.Rel(dir, file.Path)
+	if err != nil {
+		rel = file.Path
+	}
+	return BookAsset{Kind: kind, URL: file.URL, Path: filepath.ToSlash(rel), Size: size, SHA256: sha, MD5: sum}, nil
+}
+
+// downloadBookAssets 正文下载完成后，把封面和配套文件下载到正文旁边的教材文件夹，并写入 book.json。
+// 单个文件失败时继续下载其他文件，book.json 只记录成功的文件，最后返回第一个错误
+func downloadBookAssets(base *Config, resource *PlatformResource, mains []bookFile, df *downloadFlags, out *reporter) error {
+	if len(mains) == 0 {
+		return nil
+	}
+	dir := bookDir(mains[0].Path)
+	manifest := &BookManifest{ID: resource.ID, Title: resource.Title, Tags: resource.Tags()}
+	var firstErr error
+	fail := func(url string, err error) {
+		out.failed(url, err, base)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	record := func(kind string, file bookFile) {
+		asset, err := bookAsset(resource, kind, dir, file)
+		if err != nil {
+			fail(file.URL, err)
+			return
+		}
+		manifest.Assets = append(manifest.Assets, asset)
+	}
+	download := func(kind, url, name string) {
+		config := base.Copy()
+		config.OutputPath = filepath.Join(dir, name)
+		output, err := downloadOne(config, url, df, out)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return
+		}
+		record(kind, bookFile{URL: url, Path: output})
+	}
+
+	for _, main := range mains {
+		record(AssetMain, main)
+	}
+	// 不同地址的文件名可能相同（如 .../1/answer.pdf 和 .../2/answer.pdf），每个文件保存为不同的名称
+	used := map[string]bool{strings.ToLower(bookManifestName): true}
+	for i, url := range resource.CoverURLs() {
+		download(AssetCover, url, uniqueAssetName(used, coverFilename(url, i)))
+	}
+	for i, item := range resource.CompanionItems() {
+		url := item.Storages[0]
+		// 备用文件名来自平台的元数据，同样不能包含路径
+		fallback := safeFilename(fmt.Sprintf("%s-%d.%s", item.FileFlag, i+1, item.Format))
+		download(AssetCompanion, url, uniqueAssetName(used, assetFilename(url, fallback), fallback))
+	}
+
+	manifest.UpdatedAt = time.Now()
+	if err := writeBookManifest(dir, manifest); err != nil {
+		fail(resource.ID, err)
+		return firstErr
+	}
+	out.emit(cliEvent{Event: cliEventBook, URL: resource.ID, Output: filepath.Join(dir, bookManifestName)})
+	return firstErr
+}
+
+// writeBookManifest 写入 book.json，先写临时文件再重命名，避免中断时留下不完整的文件
+func writeBookManifest(dir string, manifest *BookManifest) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return downloadErrorf(ErrCodeFilesystem, "无法创建教材文件夹：%v", err)
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, bookManifestName)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return downloadErrorf(ErrCodeFilesystem, "写入 %s 失败：%v", bookManifestName, err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return downloadErrorf(ErrCodeFilesystem, "写入 %s 失败：%v", bookManifestName, err)
+	}
+	return nil
+}
+
+// resolveBook 与resolveTarget相同，平台页面地址或资源ID还返回资源的元数据，其他地址返回nil
+func resolveBook(ctx context.Context, config *Config, target string) (*PlatformResource, []string, error) {
+	id, ok := parseContentID(target)
+	if !ok {
+		return nil, []string{target}, nil
+	}
+	resource, err := fetchResource(ctx, config, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	types := config.enabledResourceTypes()
+	urls := resource.DownloadURLs(types)
+	if len(urls) == 0 {
+		return nil, nil, fmt.Errorf("资源 %s 中没有可下载的文件（资源类型：%s）", id, strings.Join(types, "、"))
+	}
+	return resource, urls, nil
+}
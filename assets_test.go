@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestPlatformResource_Assets 测试从元数据中找出封面和配套文件
+func TestPlatformResource_Assets(t *testing.T) {
+	r := &PlatformResource{Items: []PlatformItem{
+		{FileFlag: "source", Format: "pdf", Storages: []string{"https://a/book.pdf"}},
+		{FileFlag: "thumbnail", Format: "jpg", Storages: []string{"https://a/cover.jpg"}},
+		{FileFlag: "href", Format: "html", Storages: []string{"https://a/page"}},
+		{FileFlag: "answer", Format: "pdf", Storages: []string{"https://a/answer.pdf"}},
+		{FileFlag: "audio", Format: "zip"},
+	}}
+	r.CustomProperties.Thumbnails = []string{"https://a/cover.jpg", "https://a/t/2.png"}
+
+	if got := r.CoverURLs(); !reflect.DeepEqual(got, []string{"https://a/cover.jpg", "https://a/t/2.png"}) {
+		t.Errorf("Unexpected covers: %q", got)
+	}
+	if got := r.CompanionItems(); len(got) != 1 || got[0].FileFlag != "answer" {
+		t.Errorf("Unexpected companions: %+v", got)
+	}
+	for _, tt := range []struct{ url, want string }{
+		{"https://a/t/2.png?x=1", "cover-2.png"},
+		{"https://a/thumb", "cover-2.jpg"},
+	} {
+		if got := coverFilename(tt.url, 1); got != tt.want {
+			t.Errorf("coverFilename(%s) = %s, want %s", tt.url, got, tt.want)
+		}
+	}
+	if got := assetFilename("https://a/%E7%AD%94%E6%A1%88.pdf", "x"); got != "答案.pdf" {
+		t.Errorf("Unexpected asset filename %s", got)
+	}
+	for _, rawURL := range []string{"https://a/", "https://a/b/%2e%2e", "https://a/b/%2e%2e%5c%2e%2e", "https://a/b/..."} {
+		if got := assetFilename(rawURL, "answer-1.pdf"); got != "answer-1.pdf" {
+			t.Errorf("assetFilename(%s): expected fallback name, got %s", rawURL, got)
+		}
+	}
+	if got := assetFilename("https://a/%3Canswer%3E.pdf", "x"); got != "_answer_.pdf" {
+		t.Errorf("Expected invalid characters to be replaced, got %s", got)
+	}
+
+	used := map[string]bool{"book.json": true}
+	var names []string
+	for _, candidates := range [][]string{{"Answer.pdf", "answer-1.pdf"}, {"answer.pdf", "answer-2.pdf"}, {"answer.PDF", "answer-2.pdf"}, {"BOOK.json"}} {
+		names = append(names, uniqueAssetName(used, candidates...))
+	}
+	if want := []string{"Answer.pdf", "answer-2.pdf", "answer-2 (2).pdf", "BOOK (2).json"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected unique names %q, got %q", want, names)
+	}
+}
+
+// TestDownload_BookAssets 测试 -assets 把封面和配套资源下载到教材文件夹并写入 book.json
+func TestDownload_BookAssets(t *testing.T) {
+	const id = "b8e9a3fe-dae7-49c0-86cb-d146f883fd8e"
+	files := map[string]string{
+		"/book.pdf":   "%PDF-1.4 book",
+		"/cover.jpg":  "\xff\xd8\xff cover",
+		"/answer.pdf": "%PDF-1.4 answer",
+		// 与上一个配套资源的文件名相同
+		"/2/answer.pdf": "%PDF-1.4 answer key",
+		"/audio.zip":    "PK\x03\x04 audio",
+		// 地址中的文件名和元数据中的格式都试图指向教材文件夹以外
+		"/3/..": "%PDF-1.4 notes",
+	}
+	sum := func(s string) string {
+		h := md5.Sum([]byte(s))
+		return hex.EncodeToString(h[:])
+	}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/details/"+id+".json" {
+			fmt.Fprintf(w, `{"id":%q,"title":"英语三年级上册","ti_items":[
+				{"ti_file_flag":"source","ti_format":"pdf","ti_md5":%q,"ti_storages":["%[3]s/book.pdf"]},
+				{"ti_file_flag":"thumbnail","ti_format":"jpg","ti_storages":["%[3]s/cover.jpg"]},
+				{"ti_file_flag":"answer","ti_format":"pdf","ti_md5":%q,"ti_storages":["%[3]s/answer.pdf"]},
+				{"ti_file_flag":"audio","ti_format":"zip","ti_md5":"0123","ti_storages":["%[3]s/audio.zip"]},
+				{"ti_file_flag":"answer","ti_format":"pdf","ti_storages":["%[3]s/2/answer.pdf"]},
+				{"ti_file_flag":"notes","ti_format":"pdf/../../evil","ti_storages":["%[3]s/3/%%2e%%2e"]}]}`,
+				id, sum(files["/book.pdf"]), server.URL, sum(files["/answer.pdf"]))
+			return
+		}
+		if data, ok := files[r.URL.Path]; ok {
+			w.Write([]byte(data))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+	oldURL := platformDetailsURL
+	platformDetailsURL = server.URL + "/details/%s.json"
+	defer func() { platformDetailsURL = oldURL }()
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.json")
+	code := runCLI([]string{"download", "-config", configPath, "-dir", dir, "-quiet", "-retries", "0", "-assets", id})
+	// 音频压缩包的MD5与元数据不一致，下载失败
+	if code != exitError {
+		t.Errorf("Expected exit code %d for the corrupted companion, got %d", exitError, code)
+	}
+
+	bookFolder := filepath.Join(dir, "book")
+	data, err := os.ReadFile(filepath.Join(bookFolder, bookManifestName))
+	if err != nil {
+		t.Fatalf("book.json not written: %v", err)
+	}
+	var manifest BookManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.ID != id || manifest.Title != "英语三年级上册" {
+		t.Errorf("Unexpected manifest: %s", data)
+	}
+	want := []struct{ kind, path, content string }{
+		{AssetMain, "../book.pdf", files["/book.pdf"]},
+		{AssetCover, "cover.jpg", files["/cover.jpg"]},
+		{AssetCompanion, "answer.pdf", files["/answer.pdf"]},
+		{AssetCompanion, "answer-3.pdf", files["/2/answer.pdf"]},
+		{AssetCompanion, "notes-4.pdf_.._.._evil", files["/3/.."]},
+	}
+	if len(manifest.Assets) != len(want) {
+		t.Fatalf("Expected %d assets, got %s", len(want), data)
+	}
+	for i, w := range want {
+		a := manifest.Assets[i]
+		if a.Kind != w.kind || a.Path != w.path || a.Size != int64(len(w.content)) || a.MD5 != sum(w.content) || len(a.SHA256) != 64 {
+			t.Errorf("Unexpected asset %d: %+v", i, a)
+		}
+		if content, err := os.ReadFile(filepath.Join(bookFolder, filepath.FromSlash(w.path))); err != nil || string(content) != w.content {
+			t.Errorf("Asset %s not saved: %v", w.path, err)
+		}
+	}
+}
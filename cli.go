@@ -0,0 +1,802 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// 退出码
+const (
+	exitOK     = 0 // 成功
+	exitError  = 1 // 运行时错误，例如下载失败
+	exitUsage  = 2 // 命令行参数错误
+	exitConfig = 3 // 配置文件无法读取或保存
+)
+
+// command 子命令
+type command struct {
+	name    string
+	usage   string // 参数说明，显示在命令名之后
+	summary string
+	run     func(args []string) int
+}
+
+// commandList 返回所有子命令，按帮助信息中的显示顺序排列
+func commandList() []*command {
+	return []*command{
+		{"download", "[选项] <URL|资源ID>...", "下载一个或多个文件，支持直接的文件地址、平台教材页面地址或资源ID", cmdDownload},
+		{"batch", "[选项] <文件|->", "从文件（或标准输入）逐行读取地址并批量下载，# 开头的行为注释", cmdBatch},
+		{"resolve", "[选项] <页面地址|资源ID>...", "解析平台教材页面，输出标题和PDF下载地址", cmdResolve},
+		{"catalog", "[选项] [关键字...]", "列出平台上的教材目录，可按关键字过滤", cmdCatalog},
+		{"config", "[选项] <show|get|set|explain|profiles|use|create|delete|convert> [参数]", "查看或修改配置文件，explain 显示有效配置及每一项的来源，profiles 等管理配置方案，convert 转换配置文件格式", cmdConfig},
+		{"search", "[选项] <关键字...>", "在已下载的PDF中全文搜索，输出教材、页码和上下文，首次搜索时提取文字并建立索引", cmdSearch},
+		{"pdf", "<merge|outline> [选项] [参数]", "处理本地PDF文件，merge 按顺序合并多个文件并为每个文件生成书签，outline 根据平台的章节目录生成书签", cmdPDF},
+		{"serve", "[选项]", "启动Web界面", cmdServe},
+		{"tui", "[选项] [URL|资源ID]...", "全屏终端界面，显示下载队列、进度、速度和剩余时间，可以暂停、取消、重试任务和粘贴新地址", cmdTUI},
+		{"help", "[命令]", "显示帮助信息", cmdHelp},
+	}
+}
+
+// progName 返回当前程序名，用于帮助信息
+func progName() string {
+	return strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+}
+
+// runCLI 解析子命令并执行，返回退出码
+func runCLI(args []string) int {
+	if len(args) == 0 {
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+	switch args[0] {
+	case "-h", "-help", "--help":
+		printUsage(os.Stdout)
+		return exitOK
+	}
+	// 兼容旧版本的 -mode/-url 参数
+	if strings.HasPrefix(args[0], "-") {
+		return runLegacy(args)
+	}
+
+	for _, cmd := range commandList() {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "错误: 未知的命令 %q\n\n", args[0])
+	printUsage(os.Stderr)
+	return exitUsage
+}
+
+// printUsage 打印总体帮助信息
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "国家中小学教育平台资源下载器\n\n用法:\n  %s <命令> [选项] [参数]\n\n命令:\n", progName())
+	for _, cmd := range commandList() {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\n使用 \"%s help <命令>\" 查看命令的详细说明。\n", progName())
+	fmt.Fprintf(w, "\n退出码: 0 成功, 1 运行失败, 2 参数错误, 3 配置错误\n")
+}
+
+// newFlagSet 为子命令创建FlagSet，帮助信息包含用法和说明
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		for _, cmd := range commandList() {
+			if cmd.name == name {
+				fmt.Fprintf(fs.Output(), "用法: %s %s %s\n\n%s\n", progName(), cmd.name, cmd.usage, cmd.summary)
+			}
+		}
+		fmt.Fprintln(fs.Output(), "\n选项:")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags 解析子命令参数，失败时返回应使用的退出码
+func parseFlags(fs *flag.FlagSet, args []string) (int, bool) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK, false
+		}
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+// usageError 打印参数错误和子命令帮助，返回参数错误退出码
+func usageError(fs *flag.FlagSet, format string, args ...interface{}) int {
+	fmt.Fprintf(fs.Output(), "错误: "+format+"\n\n", args...)
+	fs.Usage()
+	return exitUsage
+}
+
+// loadOptions 加载配置，失败时打印错误
+func loadOptions(opts *configOptions) (*Config, bool, int) {
+	config, exists, err := opts.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		return nil, exists, exitConfig
+	}
+	return config, exists, exitOK
+}
+
+// cmdDownload 下载一个或多个文件
+func cmdDownload(args []string) int {
+	fs := newFlagSet("download")
+	opts := registerConfigFlags(fs, true)
+	df := registerDownloadFlags(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() == 0 {
+		return usageError(fs, "必须提供至少一个下载地址")
+	}
+	if err := df.validate(); err != nil {
+		return usageError(fs, "%v", err)
+	}
+	return runDownload(opts, df, fs.Args())
+}
+
+// runDownload 依次下载所有目标，任意一个失败时返回运行失败
+func runDownload(opts *configOptions, df *downloadFlags, targets []string) int {
+	config, _, code := loadOptions(opts)
+	if config == nil {
+		return code
+	}
+	if config.OutputPath != "" && len(targets) > 1 {
+		fmt.Fprintln(os.Stderr, "错误: -out 只能用于单个文件的下载")
+		return exitUsage
+	}
+
+	out := df.reporter()
+	failed := 0
+	for _, target := range targets {
+		resource, urls, err := resolveBook(context.Background(), config, target)
+		if err != nil {
+			out.failed(target, resolveError(err), config)
+			failed++
+			continue
+		}
+		var mains []bookFile
+		for _, url := range urls {
+			output, err := downloadOne(config, url, df, out)
+			if err != nil {
+				failed++
+				continue
+			}
+			mains = append(mains, bookFile{URL: url, Path: output})
+		}
+		// 正文都下载成功后生成书签，再下载封面和配套资源
+		if resource == nil || len(mains) != len(urls) {
+			continue
+		}
+		if err := outlineBook(config, resource, mains, "", out); err != nil {
+			failed++
+		}
+		if df.assets {
+			if err := downloadBookAssets(config, resource, mains, df, out); err != nil {
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		return exitError
+	}
+	return exitOK
+}
+
+// downloadOne 按配置下载单个文件，暂时性的错误最多重试df.retries次，每次重试前的等待时间翻倍。
+// 重试时从已下载的位置继续，超时时间包括所有重试。启用探测时先检查文件信息和磁盘空间，检查不通过不开始下载。
+// 返回实际保存的路径
+func downloadOne(base *Config, url string, df *downloadFlags, out *reporter) (string, error) {
+	taskConfig := newTaskConfig(base, url)
+	ctx, cancel := context.WithTimeout(context.Background(), taskConfig.GetTimeoutDuration())
+	defer cancel()
+
+	out.emit(cliEvent{Event: cliEventStarted, URL: url, Output: taskConfig.OutputPath, ResumeFrom: resumableSize(taskConfig)})
+	progress := func(stats downloadStats) {
+		out.emit(cliEvent{
+			Event: cliEventProgress, URL: url, Output: taskConfig.OutputPath,
+			Downloaded: stats.Downloaded, Total: stats.Total, Percent: stats.Percent,
+			SpeedBPS: stats.SpeedBPS, ETASeconds: stats.ETASeconds, Elapsed: stats.Elapsed.Seconds(),
+		})
+	}
+
+	probed := !df.probe
+	for attempt := 1; ; attempt++ {
+		var err error
+		if !probed {
+			err = probeBeforeDownload(ctx, taskConfig, out)
+			probed = err == nil
+		}
+		output := taskConfig.OutputPath
+		if probed {
+			output, err = downloadResource(ctx, *taskConfig, progress)
+		}
+		if err == nil {
+			completed := cliEvent{Event: cliEventCompleted, URL: url, Output: output, Percent: 100}
+			if info, err := os.Stat(output); err == nil {
+				completed.Downloaded, completed.Total = info.Size(), info.Size()
+			}
+			out.emit(completed)
+			return output, nil
+		}
+		if attempt <= df.retries && isRetryable(err) {
+			delay := retryDelay << (attempt - 1)
+			out.emit(cliEvent{Event: cliEventRetry, URL: url, Output: taskConfig.OutputPath, Attempt: attempt, DelayMS: delay.Milliseconds(), Error: newCLIError(err, taskConfig)})
+			select {
+			case <-time.After(delay):
+				continue
+			case <-ctx.Done():
+				err = contextError(ctx)
+			}
+		}
+		out.failed(url, err, taskConfig)
+		return "", err
+	}
+}
+
+// probeBeforeDownload 探测文件信息并输出，检查不通过时返回错误
+func probeBeforeDownload(ctx context.Context, config *Config, out *reporter) error {
+	result, err := probeFile(ctx, config)
+	if err != nil {
+		return err
+	}
+	out.emit(cliEvent{Event: cliEventProbe, URL: config.URL, Output: config.OutputPath, Probe: result})
+	return result.check()
+}
+
+// resolveError 为解析平台页面失败的错误加上错误码
+func resolveError(err error) error {
+	if errorCode(err) != ErrCodeUnknown {
+		return err
+	}
+	return &DownloadError{Code: ErrCodeResolve, Err: err}
+}
+
+// cmdBatch 批量下载
+func cmdBatch(args []string) int {
+	fs := newFlagSet("batch")
+	opts := registerConfigFlags(fs, false)
+	df := registerDownloadFlags(fs)
+	parallel := fs.Int("parallel", 1, "同时下载的文件数")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		return usageError(fs, "必须提供一个地址列表文件")
+	}
+	if *parallel < 1 {
+		return usageError(fs, "-parallel 必须大于0")
+	}
+	if err := df.validate(); err != nil {
+		return usageError(fs, "%v", err)
+	}
+
+	var input io.Reader = os.Stdin
+	if name := fs.Arg(0); name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 无法打开地址列表: %v\n", err)
+			return exitError
+		}
+		defer file.Close()
+		input = file
+	}
+	targets, err := readBatchFile(input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 读取地址列表失败: %v\n", err)
+		return exitError
+	}
+	if len(targets) == 0 {
+		fmt.Fprintln(os.Stderr, "地址列表为空")
+		return exitOK
+	}
+
+	config, _, code := loadOptions(opts)
+	if config == nil {
+		return code
+	}
+
+	// 先解析所有条目，再并发下载
+	out := df.reporter()
+	var (
+		urls  []string
+		books []resolvedBook
+	)
+	// 解析失败的条目没有要下载的文件，与下载失败分开计数
+	resolveFailed, downloadFailed := 0, 0
+	for _, target := range targets {
+		resource, resolved, err := resolveBook(context.Background(), config, target)
+		if err != nil {
+			out.failed(target, resolveError(err), config)
+			resolveFailed++
+			continue
+		}
+		urls = append(urls, resolved...)
+		if resource != nil {
+			books = append(books, resolvedBook{resource: resource, urls: resolved})
+		}
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	outputs := make(map[string]string) // 下载成功的地址和保存路径
+	jobs := make(chan string)
+	for i := 0; i < *parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for url := range jobs {
+				output, err := downloadOne(config, url, df, out)
+				mu.Lock()
+				if err != nil {
+					downloadFailed++
+				} else {
+					outputs[url] = output
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, url := range urls {
+		jobs <- url
+	}
+	close(jobs)
+	wg.Wait()
+
+	// 全部文件下载结束后，为正文都下载成功的教材合并分册、生成书签，并下载封面和配套资源
+	assetsFailed, mergeFailed := 0, 0
+	for _, book := range books {
+		mains, ok := book.downloaded(outputs)
+		if !ok {
+			continue
+		}
+		merged, err := mergeBookParts(config, book.resource, mains, out)
+		if err != nil {
+			mergeFailed++
+		} else if err := outlineBook(config, book.resource, mains, merged, out); err != nil {
+			mergeFailed++
+		}
+		if !df.assets {
+			continue
+		}
+		if err := downloadBookAssets(config, book.resource, mains, df, out); err != nil {
+			assetsFailed++
+		}
+	}
+
+	out.printf("\n批量下载结束：成功 %d 个，失败 %d 个\n", len(urls)-downloadFailed, downloadFailed)
+	if resolveFailed > 0 {
+		out.printf("%d 个地址解析失败\n", resolveFailed)
+	}
+	if assetsFailed > 0 {
+		out.printf("%d 本教材的封面或配套资源下载失败\n", assetsFailed)
+	}
+	if mergeFailed > 0 {
+		out.printf("%d 本教材的分册合并或书签生成失败\n", mergeFailed)
+	}
+	if resolveFailed > 0 || downloadFailed > 0 || assetsFailed > 0 || mergeFailed > 0 {
+		return exitError
+	}
+	return exitOK
+}
+
+// resolvedBook 批量下载中从平台页面地址或资源ID解析出的教材
+type resolvedBook struct {
+	resource *PlatformResource
+	urls     []string
+}
+
+// downloaded 返回教材已下载的正文文件，有文件没有下载成功时返回false
+func (b *resolvedBook) downloaded(outputs map[string]string) ([]bookFile, bool) {
+	mains := make([]bookFile, 0, len(b.urls))
+	for _, url := range b.urls {
+		output, ok := outputs[url]
+		if !ok {
+			return nil, false
+		}
+		mains = append(mains, bookFile{URL: url, Path: output})
+	}
+	return mains, true
+}
+
+// readBatchFile 读取地址列表，忽略空行和 # 开头的注释
+func readBatchFile(r io.Reader) ([]string, error) {
+	var targets []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		targets = append(targets, line)
+	}
+	return targets, scanner.Err()
+}
+
+// cmdResolve 解析平台教材页面
+func cmdResolve(args []string) int {
+	fs := newFlagSet("resolve")
+	opts := registerConfigFlags(fs, false)
+	asJSON := fs.Bool("json", false, "以JSON格式输出")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() == 0 {
+		return usageError(fs, "必须提供至少一个页面地址或资源ID")
+	}
+	config, _, code := loadOptions(opts)
+	if config == nil {
+		return code
+	}
+
+	var resources []*PlatformResource
+	failed := 0
+	for _, target := range fs.Args() {
+		id, ok := parseContentID(target)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "无法从 %s 中识别资源ID\n", target)
+			failed++
+			continue
+		}
+		resource, err := fetchResource(context.Background(), config, id)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "解析 %s 失败：%v\n", target, err)
+			failed++
+			continue
+		}
+		resources = append(resources, resource)
+	}
+
+	if *asJSON {
+		printJSON(resources)
+	} else {
+		for _, resource := range resources {
+			fmt.Printf("%s\n  ID: %s\n  标签: %s\n", resource.Title, resource.ID, resource.Tags())
+			for _, url := range resource.DownloadURLs(config.enabledResourceTypes()) {
+				fmt.Printf("  %s\n", url)
+			}
+		}
+	}
+	if failed > 0 {
+		return exitError
+	}
+	return exitOK
+}
+
+// cmdCatalog 列出平台教材目录
+func cmdCatalog(args []string) int {
+	fs := newFlagSet("catalog")
+	opts := registerConfigFlags(fs, false)
+	asJSON := fs.Bool("json", false, "以JSON格式输出")
+	limit := fs.Int("limit", 0, "最多输出的条目数（0表示不限制）")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	config, _, code := loadOptions(opts)
+	if config == nil {
+		return code
+	}
+
+	catalog, err := fetchCatalog(context.Background(), config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "获取教材目录失败：%v\n", err)
+		return exitError
+	}
+
+	// 所有关键字都出现在标题或标签中才算匹配
+	var matched []PlatformResource
+	for _, resource := range catalog {
+		text := resource.Title + " " + resource.Tags()
+		ok := true
+		for _, keyword := range fs.Args() {
+			if !strings.Contains(text, keyword) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			matched = append(matched, resource)
+		}
+		if *limit > 0 && len(matched) >= *limit {
+			break
+		}
+	}
+
+	if *asJSON {
+		printJSON(matched)
+		return exitOK
+	}
+	for _, resource := range matched {
+		fmt.Printf("%s\t%s\t%s\n", resource.ID, resource.Title, resource.Tags())
+	}
+	return exitOK
+}
+
+// cmdConfig 查看或修改配置文件
+func cmdConfig(args []string) int {
+	fs := newFlagSet("config")
+	configPath := fs.String("config", defaultConfigPath, "配置文件路径")
+	profile := fs.String("profile", os.Getenv(envProfile), "要查看或修改的配置方案（默认为当前方案）")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() == 0 {
+		return usageError(fs, "必须指定子命令 show、get、set、explain、profiles、use、create、delete 或 convert")
+	}
+
+	switch sub := fs.Arg(0); sub {
+	case "explain":
+		return runConfigExplain(*configPath, *profile, fs.Args()[1:])
+	case "profiles", "use", "create", "delete":
+		return runConfigProfiles(fs, *configPath, *profile)
+	case "convert":
+		return runConfigConvert(fs)
+	}
+
+	config, _, err := loadConfigFile(*configPath, *profile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		return exitConfig
+	}
+
+	switch sub := fs.Arg(0); sub {
+	case "show":
+		printJSON(config.Redacted())
+	case "get":
+		if fs.NArg() != 2 {
+			return usageError(fs, "用法: config get <键>")
+		}
+		value, err := getConfigValue(config, fs.Arg(1))
+		if err != nil {
+			return usageError(fs, "%v", err)
+		}
+		fmt.Println(value)
+	case "set":
+		if fs.NArg() != 3 {
+			return usageError(fs, "用法: config set <键> <值>")
+		}
+		if err := setConfigValue(config, fs.Arg(1), fs.Arg(2)); err != nil {
+			return usageError(fs, "%v", err)
+		}
+		if err := config.withDefaults().Validate(); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+			return exitConfig
+		}
+		if err := SaveProfile(*configPath, *profile, config); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 保存配置失败: %v\n", err)
+			return exitConfig
+		}
+	default:
+		return usageError(fs, "未知的子命令 %q", sub)
+	}
+	return exitOK
+}
+
+// runConfigProfiles 管理配置方案：profiles 列出所有方案（* 标记当前方案），use 切换当前方案，
+// create 以 -profile 指定的方案（默认为当前方案）为模板创建新方案，delete 删除方案
+func runConfigProfiles(fs *flag.FlagSet, configPath, from string) int {
+	sub := fs.Arg(0)
+	if sub == "profiles" {
+		if fs.NArg() != 1 {
+			return usageError(fs, "用法: config profiles")
+		}
+		cf := newConfigFile(defaultProfileName, getDefaultConfig())
+		if _, err := os.Stat(configPath); err == nil {
+			if cf, err = LoadConfigFile(configPath); err != nil {
+				fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+				return exitConfig
+			}
+		}
+		for _, name := range cf.Names() {
+			mark := " "
+			if name == cf.ActiveProfile {
+				mark = "*"
+			}
+			fmt.Printf("%s %s\n", mark, name)
+		}
+		return exitOK
+	}
+
+	if fs.NArg() != 2 {
+		return usageError(fs, "用法: config %s <名称>", sub)
+	}
+	name := fs.Arg(1)
+	err := updateConfigFile(configPath, func(cf *ConfigFile) error {
+		if len(cf.Profiles) == 0 {
+			// 配置文件不存在时先写入默认方案
+			cf.ActiveProfile = defaultProfileName
+			cf.Profiles[defaultProfileName] = getDefaultConfig()
+		}
+		switch sub {
+		case "use":
+			return cf.Activate(name)
+		case "create":
+			if _, ok := cf.Profiles[name]; ok {
+				return fmt.Errorf("配置方案 %q 已存在", name)
+			}
+			template, err := cf.Profile(from)
+			if err != nil {
+				return err
+			}
+			return cf.SetProfile(name, template)
+		default:
+			return cf.DeleteProfile(name)
+		}
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		return exitConfig
+	}
+	return exitOK
+}
+
+// runConfigConvert 在JSON、YAML和TOML格式之间转换配置文件，格式由扩展名决定
+func runConfigConvert(fs *flag.FlagSet) int {
+	if fs.NArg() != 3 {
+		return usageError(fs, "用法: config convert <输入文件> <输出文件>")
+	}
+	src, dst := fs.Arg(1), fs.Arg(2)
+	if filepath.Clean(src) == filepath.Clean(dst) {
+		return usageError(fs, "输入文件和输出文件不能相同")
+	}
+	if _, err := os.Stat(dst); err == nil {
+		fmt.Fprintf(os.Stderr, "错误: 输出文件 %s 已存在\n", dst)
+		return exitConfig
+	}
+
+	if err := convertConfigFile(src, dst); err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		return exitConfig
+	}
+	fmt.Printf("已将 %s 转换为 %s（%s 格式）\n", src, dst, strings.ToUpper(configFormatOf(dst)))
+	return exitOK
+}
+
+// runConfigExplain 显示合并后的有效配置以及每一项的来源，可附带与下载命令相同的参数
+func runConfigExplain(configPath, profile string, args []string) int {
+	fs := flag.NewFlagSet("config explain", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "用法: %s config [-config 文件] explain [选项]\n\n选项:\n", progName())
+		fs.PrintDefaults()
+	}
+	opts := registerConfigFlags(fs, true)
+	opts.configPath = configPath
+	opts.profile = profile
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	resolved, err := opts.resolve()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		return exitConfig
+	}
+
+	fmt.Printf("配置方案: %s\n\n", resolved.Profile)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "配置项\t值\t来源")
+	for _, key := range resolved.Keys() {
+		setting := resolved.Sources[key]
+		source := setting.Source
+		if setting.Origin != "" {
+			source += " (" + setting.Origin + ")"
+		}
+		value := setting.Value
+		if name, ok := strings.CutPrefix(key, "header."); ok && value != "" && resolved.Config.IsSecretHeader(name) {
+			value = redactedValue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", key, value, source)
+	}
+	w.Flush()
+	return exitOK
+}
+
+// cmdServe 启动Web界面
+func cmdServe(args []string) int {
+	fs := newFlagSet("serve")
+	opts := registerConfigFlags(fs, false)
+	port := fs.String("port", "8080", "Web服务端口")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	return runServe(opts, *port)
+}
+
+// runServe 启动Web服务，配置文件不存在时写入默认配置
+func runServe(opts *configOptions, port string) int {
+	resolved, err := opts.resolve()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		return exitConfig
+	}
+	if !resolved.FileExists {
+		// 环境变量和命令行参数的值不写入配置文件
+		config := persistableConfig(resolved.Config, resolved.Overrides(), opts.configPath, resolved.Profile)
+		if err := SaveProfile(opts.configPath, resolved.Profile, config); err != nil {
+			fmt.Printf("警告: 无法保存默认配置文件: %v\n", err)
+		}
+	}
+
+	server := NewWebServer(resolved, opts.configPath)
+	if err := server.Start(port); err != nil {
+		fmt.Fprintf(os.Stderr, "Web服务器启动失败: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// cmdHelp 显示总体或指定命令的帮助信息
+func cmdHelp(args []string) int {
+	if len(args) == 0 {
+		printUsage(os.Stdout)
+		return exitOK
+	}
+	for _, cmd := range commandList() {
+		if cmd.name == args[0] && cmd.name != "help" {
+			return cmd.run([]string{"-h"})
+		}
+	}
+	fmt.Fprintf(os.Stderr, "错误: 未知的命令 %q\n", args[0])
+	return exitUsage
+}
+
+// runLegacy 兼容旧版本的 -mode/-url 命令行参数
+func runLegacy(args []string) int {
+	fs := flag.NewFlagSet(progName(), flag.ContinueOnError)
+	fs.Usage = func() { printUsage(fs.Output()) }
+	mode := fs.String("mode", "cli", "运行模式: cli(命令行模式) 或 web(Web界面模式)")
+	port := fs.String("port", "8080", "Web服务端口(仅在-web模式下有效)")
+	url := fs.String("url", "", "PDF 文件的 HTTP/HTTPS URL（必填，仅CLI模式）")
+	opts := registerConfigFlags(fs, true)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	if *mode == "web" {
+		return runServe(opts, *port)
+	}
+	targets := fs.Args()
+	if *url != "" {
+		targets = append([]string{*url}, targets...)
+	}
+	if len(targets) == 0 {
+		// 与旧版本相同，没有指定地址时下载配置文件中的 url
+		config, _, code := loadOptions(opts)
+		if config == nil {
+			return code
+		}
+		if config.URL != "" {
+			targets = []string{config.URL}
+		}
+	}
+	if len(targets) == 0 {
+		fmt.Fprintln(os.Stderr, "错误: 必须提供PDF文件URL")
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+	return runDownload(opts, &downloadFlags{output: outputText, retries: 2}, targets)
+}
+
+// printJSON 以缩进格式输出JSON
+func printJSON(v interface{}) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(v)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRunCLI_ExitCodes 测试子命令的退出码
+func TestRunCLI_ExitCodes(t *testing.T) {
+	pdf := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("%PDF-1.4 test"))
+	}))
+	defer pdf.Close()
+	oldDelay := retryDelay
+	retryDelay = time.Millisecond
+	defer func() { retryDelay = oldDelay }()
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.json")
+
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{nil, exitUsage},
+		{[]string{"unknown"}, exitUsage},
+		{[]string{"help"}, exitOK},
+		{[]string{"download", "-h"}, exitOK},
+		{[]string{"download"}, exitUsage},
+		{[]string{"download", "-bogus"}, exitUsage},
+		{[]string{"download", "-output", "xml", pdf.URL}, exitUsage},
+		{[]string{"batch", "-retries", "-1", "list.txt"}, exitUsage},
+		{[]string{"config", "-config", configPath, "get", "nope"}, exitUsage},
+		{[]string{"config", "-config", configPath, "set", "timeout", "1m"}, exitOK},
+		{[]string{"download", "-config", configPath, "-dir", dir, pdf.URL + "/a.pdf"}, exitOK},
+		{[]string{"download", "-config", configPath, "-dir", dir, "http://127.0.0.1:1/b.pdf"}, exitError},
+		{[]string{"-config", configPath, "-dir", dir, "-url", pdf.URL + "/c.pdf"}, exitOK},
+		// 旧版本的参数没有 -url 时下载配置文件中的 url
+		{[]string{"config", "-config", configPath, "set", "url", pdf.URL + "/d.pdf"}, exitOK},
+		{[]string{"-config", configPath, "-dir", dir}, exitOK},
+	}
+	for _, tt := range tests {
+		if code := runCLI(tt.args); code != tt.code {
+			t.Errorf("runCLI(%q): expected exit code %d, got %d", tt.args, tt.code, code)
+		}
+	}
+
+	for _, name := range []string{"a.pdf", "c.pdf", "d.pdf"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Expected %s to be downloaded: %v", name, err)
+		}
+	}
+	config, err := LoadConfig(configPath)
+	if err != nil || config.Timeout != "1m" {
+		t.Errorf("Expected config set to persist timeout, got %+v (%v)", config, err)
+	}
+}
+
+// TestReadBatchFile 测试地址列表解析
+func TestReadBatchFile(t *testing.T) {
+	input := "# 注释\nhttps://example.com/a.pdf\n\n  https://example.com/b.pdf  \n"
+	targets, err := readBatchFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("readBatchFile failed: %v", err)
+	}
+	if len(targets) != 2 || targets[1] != "https://example.com/b.pdf" {
+		t.Errorf("Unexpected targets: %q", targets)
+	}
+}
+
+// TestResolveTarget 测试平台页面地址的解析
+func TestResolveTarget(t *testing.T) {
+	const id = "b8e9a3fe-dae7-49c0-86cb-d146f883fd8e"
+	platform := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"id":%q,"title":"数学一年级上册","ti_items":[
+			{"ti_file_flag":"thumbnail","ti_format":"jpg","ti_storages":["https://example.com/cover.jpg"]},
+			{"ti_file_flag":"source","ti_format":"pdf","ti_storages":["https://example.com/book.pdf"]},
+			{"ti_file_flag":"source","ti_format":"mp3","ti_storages":["https://example.com/unit1.mp3"]},
+			{"ti_file_flag":"source","ti_format":"m3u8","ti_storages":["https://example.com/lesson.m3u8"]}]}`, id)
+	}))
+	defer platform.Close()
+	oldURL := platformDetailsURL
+	platformDetailsURL = platform.URL + "/details/%s.json"
+	defer func() { platformDetailsURL = oldURL }()
+
+	config := getDefaultConfig()
+	for _, target := range []string{id, "https://basic.smartedu.cn/tchMaterial/detail?contentType=assets_document&contentId=" + id} {
+		urls, err := resolveTarget(context.Background(), config, target)
+		if err != nil {
+			t.Fatalf("resolveTarget(%s) failed: %v", target, err)
+		}
+		if len(urls) != 1 || urls[0] != "https://example.com/book.pdf" {
+			t.Errorf("Unexpected urls: %q", urls)
+		}
+	}
+
+	// 按配置的资源类型选择文件
+	config.ResourceTypes = []string{ResourceAudio, ResourceVideo}
+	urls, err := resolveTarget(context.Background(), config, id)
+	if err != nil || len(urls) != 2 || urls[0] != "https://example.com/unit1.mp3" || urls[1] != "https://example.com/lesson.m3u8" {
+		t.Errorf("Unexpected urls for audio and video: %q (%v)", urls, err)
+	}
+	config.ResourceTypes = []string{ResourceCourseware}
+	if _, err := resolveTarget(context.Background(), config, id); err == nil || !strings.Contains(err.Error(), "courseware") {
+		t.Errorf("Expected error without courseware, got %v", err)
+	}
+
+	// 普通地址原样返回
+	urls, _ = resolveTarget(context.Background(), config, "https://example.com/x.pdf")
+	if len(urls) != 1 || urls[0] != "https://example.com/x.pdf" {
+		t.Errorf("Unexpected urls for direct link: %q", urls)
+	}
+}
+
+// TestResolveTarget_Timeout 测试平台接口没有响应时按配置的超时时间返回错误
+func TestResolveTarget_Timeout(t *testing.T) {
+	const id = "b8e9a3fe-dae7-49c0-86cb-d146f883fd8e"
+	done := make(chan struct{})
+	platform := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer platform.Close()
+	defer close(done)
+	oldURL := platformDetailsURL
+	platformDetailsURL = platform.URL + "/details/%s.json"
+	defer func() { platformDetailsURL = oldURL }()
+
+	config := getDefaultConfig()
+	config.Timeout = "100ms"
+	start := time.Now()
+	if _, err := resolveTarget(context.Background(), config, id); err == nil {
+		t.Fatal("Expected a timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the request to time out after 100ms, took %v", elapsed)
+	}
+}
+
+// TestBatch_ResolveFailures 测试解析失败的条目不计入下载结果
+func TestBatch_ResolveFailures(t *testing.T) {
+	const missing = "3c1d2b4a-9e8f-4a7b-8c6d-5e4f3a2b1c0d"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/book.pdf" {
+			w.Write([]byte("%PDF-1.4 test"))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+	oldURL := platformDetailsURL
+	platformDetailsURL = server.URL + "/details/%s.json"
+	defer func() { platformDetailsURL = oldURL }()
+
+	for _, tt := range []struct {
+		name    string
+		targets []string
+		want    []string
+	}{
+		{"mixed", []string{missing, server.URL + "/book.pdf"}, []string{"成功 1 个，失败 0 个", "1 个地址解析失败"}},
+		{"resolve only", []string{missing}, []string{"成功 0 个，失败 0 个", "1 个地址解析失败"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			list := filepath.Join(dir, "urls.txt")
+			os.WriteFile(list, []byte(strings.Join(tt.targets, "\n")), 0644)
+
+			// 结果汇总输出到标准错误
+			stderr, _ := os.Create(filepath.Join(dir, "stderr.txt"))
+			oldStderr := os.Stderr
+			os.Stderr = stderr
+			code := runCLI([]string{"batch", "-config", filepath.Join(dir, "config.json"), "-dir", dir, "-retries", "0", list})
+			os.Stderr = oldStderr
+			stderr.Close()
+
+			if code != exitError {
+				t.Errorf("Expected exit code %d, got %d", exitError, code)
+			}
+			output, _ := os.ReadFile(stderr.Name())
+			for _, want := range tt.want {
+				if !strings.Contains(string(output), want) {
+					t.Errorf("Expected %q in output:\n%s", want, output)
+				}
+			}
+		})
+	}
+}
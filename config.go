@@ -1,48 +1,81 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
 const testMode = false
 
-// Config 配置结构体
+// Config 配置结构体，也是配置文件中单个配置方案的格式。未设置的字段不写入文件，加载时使用默认值
 type Config struct {
-	URL        string            `json:"url,omitempty"`
-	OutputDir  string            `json:"output_dir"`
-	OutputPath string            `json:"output_path,omitempty"`
-	Timeout    string            `json:"timeout"`
-	ChunkSize  int64             `json:"chunk_size"`
-	Headers    map[string]string `json:"headers"`
+	URL        string            `json:"url,omitempty" yaml:"url,omitempty" toml:"url,omitempty"`
+	OutputDir  string            `json:"output_dir,omitempty" yaml:"output_dir,omitempty" toml:"output_dir,omitempty"`
+	OutputPath string            `json:"output_path,omitempty" yaml:"output_path,omitempty" toml:"output_path,omitempty"`
+	Timeout    string            `json:"timeout,omitempty" yaml:"timeout,omitempty" toml:"timeout,omitempty"`
+	ChunkSize  int64             `json:"chunk_size,omitempty" yaml:"chunk_size,omitempty" toml:"chunk_size,omitzero"`
+	Headers    map[string]string `json:"headers,omitempty" yaml:"headers,omitempty" toml:"headers,omitempty"`
+	// OnExists 输出文件已存在时的处理方式：skip、overwrite、rename 或 verify
+	OnExists string `json:"on_exists,omitempty" yaml:"on_exists,omitempty" toml:"on_exists,omitempty"`
+	// PartialFiles 启动时发现上次未完成的下载时的处理方式：resume 或 remove
+	PartialFiles string `json:"partial_files,omitempty" yaml:"partial_files,omitempty" toml:"partial_files,omitempty"`
+	// ResourceTypes 解析平台页面时下载的资源类型：document、audio、video 和 courseware
+	ResourceTypes []string `json:"resource_types,omitempty" yaml:"resource_types,omitempty" toml:"resource_types,omitempty"`
+	// SecretHeaders 除X-Nd-Auth等内置的敏感请求头外，需要存入保险库并在输出中隐藏的请求头
+	SecretHeaders []string `json:"secret_headers,omitempty" yaml:"secret_headers,omitempty" toml:"secret_headers,omitempty"`
 }
 
-// LoadConfig 加载配置文件
+// LoadConfig 加载并校验配置文件，返回当前使用的配置方案。旧版本的文件会先备份再升级到当前版本，无法写入时只在内存中升级。
+// 扩展名为 .yaml/.yml 或 .toml 时按YAML或TOML解析，其他为JSON
 func LoadConfig(filePath string) (*Config, error) {
-	data, err := os.ReadFile(filePath)
+	cf, err := LoadConfigFile(filePath)
 	if err != nil {
-		return nil, fmt.Errorf("无法读取配置文件: %v", err)
+		return nil, err
 	}
+	return cf.Profile("")
+}
 
-	var config Config
-	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("无法解析配置文件: %v", err)
+// loadConfigFields 加载配置文件，同时返回每个配置方案中实际出现的字段
+func loadConfigFields(filePath string) (*ConfigFile, map[string]map[string]interface{}, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("无法读取配置文件: %v", err)
 	}
 
-	return &config, nil
-}
-
-// SaveConfig 保存配置到文件
-func SaveConfig(filePath string, config *Config) error {
-	data, err := json.MarshalIndent(config, "", "  ")
+	jsonData, err := configDataToJSON(configFormatOf(filePath), data)
+	if err != nil {
+		return nil, nil, err
+	}
+	cf, fields, version, err := parseConfigData(jsonData)
 	if err != nil {
-		return fmt.Errorf("无法序列化配置: %v", err)
+		return nil, nil, err
+	}
+	plaintext := cf.plaintextSecrets()
+	if err := mergeVaultSecrets(filePath, cf); err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case version < configVersion:
+		// 升级已在内存中完成，配置文件所在目录只读（如挂载的配置）时继续使用升级后的配置，下次加载时再尝试写入
+		if err := migrateConfigFile(filePath, data, cf, version); err != nil {
+			fmt.Fprintf(os.Stderr, "警告: %v，本次使用升级后的配置，配置文件保持不变\n", err)
+		}
+	case len(plaintext) > 0:
+		// 手动写入配置文件的令牌在保存配置时移入保险库，加载时不修改文件
+		fmt.Fprintf(os.Stderr, "警告: 配置文件 %s 中以明文保存了敏感请求头 %s，保存配置（如 config set 或在Web界面中保存设置）时会移入保险库 %s\n",
+			filePath, strings.Join(plaintext, ", "), vaultPath(filePath))
 	}
+	return cf, fields, nil
+}
 
-	return os.WriteFile(filePath, data, 0644)
+// SaveConfig 将配置保存到当前使用的配置方案，其他配置方案保持不变
+func SaveConfig(filePath string, config *Config) error {
+	return SaveProfile(filePath, "", config)
 }
 
 // GetTimeoutDuration 获取超时时间
@@ -53,16 +86,66 @@ func (dc *Config) GetTimeoutDuration() time.Duration {
 	}
 	return d
 }
+
+// Copy 深拷贝配置，请求头map也会复制，修改副本不会影响原配置
 func (dc *Config) Copy() *Config {
+	var headers map[string]string
+	if dc.Headers != nil {
+		headers = make(map[string]string, len(dc.Headers))
+		for k, v := range dc.Headers {
+			headers[k] = v
+		}
+	}
 	return &Config{
 		URL:        dc.URL,
 		OutputDir:  dc.OutputDir,
 		OutputPath: dc.OutputPath,
 		Timeout:    dc.Timeout,
 		ChunkSize:  dc.ChunkSize,
-		Headers:    dc.Headers,
+		Headers:    headers,
+
+		OnExists:      dc.OnExists,
+		PartialFiles:  dc.PartialFiles,
+		ResourceTypes: append([]string(nil), dc.ResourceTypes...),
+		SecretHeaders: append([]string(nil), dc.SecretHeaders...),
+	}
+}
+
+// withDefaults 返回未设置的字段使用默认值后的副本，请求头合并到默认请求头上。
+// 配置方案可以只包含部分字段，校验前需要先补全
+func (dc *Config) withDefaults() *Config {
+	config := getDefaultConfig()
+	if dc.URL != "" {
+		config.URL = dc.URL
+	}
+	if dc.OutputDir != "" {
+		config.OutputDir = dc.OutputDir
+	}
+	if dc.OutputPath != "" {
+		config.OutputPath = dc.OutputPath
 	}
+	if dc.Timeout != "" {
+		config.Timeout = dc.Timeout
+	}
+	if dc.ChunkSize != 0 {
+		config.ChunkSize = dc.ChunkSize
+	}
+	if dc.OnExists != "" {
+		config.OnExists = dc.OnExists
+	}
+	if dc.PartialFiles != "" {
+		config.PartialFiles = dc.PartialFiles
+	}
+	if len(dc.ResourceTypes) > 0 {
+		config.ResourceTypes = append([]string(nil), dc.ResourceTypes...)
+	}
+	for k, v := range dc.Headers {
+		config.Headers[k] = v
+	}
+	config.SecretHeaders = append([]string(nil), dc.SecretHeaders...)
+	return config
 }
+
 func getDefaultHttpHeaders() map[string]string {
 	return map[string]string{
 		"Origin":     "https://basic.smartedu.cn",
@@ -74,9 +157,105 @@ func getDefaultHttpHeaders() map[string]string {
 func getDefaultConfig() *Config {
 	dir, _ := os.Getwd()
 	return &Config{
-		OutputDir: filepath.Join(dir, "output"),
-		Timeout:   "30s",
-		ChunkSize: 4 * 1024 * 1024,
-		Headers:   getDefaultHttpHeaders(),
+		OutputDir:     filepath.Join(dir, "output"),
+		Timeout:       "30s",
+		ChunkSize:     4 * 1024 * 1024,
+		Headers:       getDefaultHttpHeaders(),
+		OnExists:      OnExistsSkip,
+		PartialFiles:  PartialResume,
+		ResourceTypes: []string{ResourceDocument},
+	}
+}
+
+// configKeys 可按键名访问的配置项，请求头使用 header.<名称>
+var configKeys = []string{"url", "output_dir", "output_path", "timeout", "chunk_size", "on_exists", "partial_files", "resource_types", "secret_headers"}
+
+// getConfigValue 按键名读取配置项
+func getConfigValue(config *Config, key string) (string, error) {
+	if name, ok := strings.CutPrefix(key, "header."); ok {
+		return config.Headers[name], nil
 	}
+	switch key {
+	case "url":
+		return config.URL, nil
+	case "output_dir":
+		return config.OutputDir, nil
+	case "output_path":
+		return config.OutputPath, nil
+	case "timeout":
+		return config.Timeout, nil
+	case "chunk_size":
+		return strconv.FormatInt(config.ChunkSize, 10), nil
+	case "on_exists":
+		return config.OnExists, nil
+	case "partial_files":
+		return config.PartialFiles, nil
+	case "resource_types":
+		return strings.Join(config.ResourceTypes, ","), nil
+	case "secret_headers":
+		return strings.Join(config.SecretHeaders, ","), nil
+	}
+	return "", unknownConfigKeyError(key)
+}
+
+// setConfigValue 按键名修改配置项，请求头的值为空时删除该请求头
+func setConfigValue(config *Config, key, value string) error {
+	if name, ok := strings.CutPrefix(key, "header."); ok {
+		if config.Headers == nil {
+			config.Headers = make(map[string]string)
+		}
+		if value == "" {
+			delete(config.Headers, name)
+		} else {
+			config.Headers[name] = value
+		}
+		return nil
+	}
+	switch key {
+	case "url":
+		config.URL = value
+	case "output_dir":
+		config.OutputDir = value
+	case "output_path":
+		config.OutputPath = value
+	case "timeout":
+		config.Timeout = value
+	case "chunk_size":
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("chunk_size 必须是整数: %v", err)
+		}
+		config.ChunkSize = size
+	case "on_exists":
+		config.OnExists = value
+	case "partial_files":
+		config.PartialFiles = value
+	case "resource_types":
+		// 以逗号分隔的资源类型
+		config.ResourceTypes = splitList(value)
+	case "secret_headers":
+		// 以逗号分隔的请求头名称
+		config.SecretHeaders = splitList(value)
+	default:
+		return unknownConfigKeyError(key)
+	}
+	return nil
+}
+
+// splitList 拆分以逗号分隔的列表，忽略空项
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// unknownConfigKeyError 生成未知配置项的错误信息
+func unknownConfigKeyError(key string) error {
+	keys := append([]string(nil), configKeys...)
+	sort.Strings(keys)
+	return fmt.Errorf("未知的配置项 %q，可用的配置项: %s, header.<名称>", key, strings.Join(keys, ", "))
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// contentRange 解析后的 Content-Range 响应头（RFC 9110 14.4），支持三种形式：
+//
+//	bytes 0-499/1234  已知完整长度的范围
+//	bytes 0-499/*     完整长度未知的范围
+//	bytes */1234      416响应中表示请求的范围无法满足
+type contentRange struct {
+	First    int64 // 第一个字节的位置，无法满足的范围为-1
+	Last     int64 // 最后一个字节的位置（包含），无法满足的范围为-1
+	Complete int64 // 完整长度，未知（*）时为-1
+}
+
+// unsatisfied 是否是 bytes */N 形式
+func (cr contentRange) unsatisfied() bool {
+	return cr.First < 0
+}
+
+// String 按标准格式输出，解析结果再次格式化后可以得到相同的值
+func (cr contentRange) String() string {
+	complete := "*"
+	if cr.Complete >= 0 {
+		complete = strconv.FormatInt(cr.Complete, 10)
+	}
+	if cr.unsatisfied() {
+		return "bytes */" + complete
+	}
+	return fmt.Sprintf("bytes %d-%d/%s", cr.First, cr.Last, complete)
+}
+
+// parseContentRange 解析 Content-Range 头，只支持 bytes 单位。
+// 结束位置小于开始位置、完整长度不大于结束位置的值按RFC规定视为无效
+func parseContentRange(value string) (contentRange, error) {
+	invalid := func(reason string) (contentRange, error) {
+		return contentRange{}, fmt.Errorf("无效的 Content-Range：%q，%s", value, reason)
+	}
+	if value == "" {
+		return contentRange{}, errors.New("缺少 Content-Range 头")
+	}
+
+	unit, spec, ok := strings.Cut(strings.TrimSpace(value), " ")
+	if !ok {
+		return invalid("缺少范围")
+	}
+	// 范围单位不区分大小写
+	if !strings.EqualFold(unit, "bytes") {
+		return invalid("不支持的单位 " + unit)
+	}
+	rangePart, completePart, ok := strings.Cut(spec, "/")
+	if !ok {
+		return invalid("缺少完整长度")
+	}
+
+	cr := contentRange{First: -1, Last: -1, Complete: -1}
+	if completePart != "*" {
+		complete, err := parseRangeNumber(completePart)
+		if err != nil {
+			return invalid("完整长度" + err.Error())
+		}
+		cr.Complete = complete
+	}
+
+	if rangePart == "*" {
+		if cr.Complete < 0 {
+			return invalid("无法满足的范围必须给出完整长度")
+		}
+		return cr, nil
+	}
+	firstPart, lastPart, ok := strings.Cut(rangePart, "-")
+	if !ok {
+		return invalid("范围缺少 -")
+	}
+	first, err := parseRangeNumber(firstPart)
+	if err != nil {
+		return invalid("开始位置" + err.Error())
+	}
+	last, err := parseRangeNumber(lastPart)
+	if err != nil {
+		return invalid("结束位置" + err.Error())
+	}
+	if last < first {
+		return invalid("结束位置小于开始位置")
+	}
+	if cr.Complete >= 0 && cr.Complete <= last {
+		return invalid("完整长度不大于结束位置")
+	}
+	cr.First, cr.Last = first, last
+	return cr, nil
+}
+
+// parseRangeNumber 解析只由数字组成的非负整数，不接受符号和空格
+func parseRangeNumber(s string) (int64, error) {
+	if s == "" {
+		return 0, errors.New("为空")
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, errors.New("不是数字")
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, errors.New("超出范围")
+	}
+	return n, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+
+// TestParseContentRange 测试 RFC 9110 中 Content-Range 的各种形式和无效值
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		value string
+		want  contentRange
+	}{
+		{"bytes 0-499/1234", contentRange{0, 499, 1234}},
+		{"bytes 500-1233/1234", contentRange{500, 1233, 1234}},
+		{"bytes 0-0/1", contentRange{0, 0, 1}},
+		{"bytes 42-1233/*", contentRange{42, 1233, -1}},
+		{"bytes */1234", contentRange{-1, -1, 1234}},
+		{"bytes */0", contentRange{-1, -1, 0}},
+		{"BYTES 1-2/3", contentRange{1, 2, 3}},
+		{" bytes 1-2/3 ", contentRange{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		got, err := parseContentRange(tt.value)
+		if err != nil || got != tt.want {
+			t.Errorf("parseContentRange(%q) = %+v, %v; want %+v", tt.value, got, err, tt.want)
+		}
+	}
+
+	for _, value := range []string{
+		"",
+		"bytes",
+		"bytes 0-499",
+		"bytes 499-0/1234",  // 结束位置小于开始位置
+		"bytes 0-1234/1234", // 完整长度不大于结束位置
+		"bytes */*",
+		"bytes 1024-/4096",
+		"bytes -1-2/3",
+		"bytes +1-2/3",
+		"bytes 1 -2/3",
+		"bytes 0-1/99999999999999999999",
+		"items 0-1/2",
+		"bytes=0-1/2",
+	} {
+		if cr, err := parseContentRange(value); err == nil {
+			t.Errorf("parseContentRange(%q) = %+v, expected error", value, cr)
+		}
+	}
+}
+
+// FuzzParseContentRange 解析成功的值必须满足RFC的约束，并且格式化后再解析得到相同的结果
+func FuzzParseContentRange(f *testing.F) {
+	for _, seed := range []string{"bytes 0-499/1234", "bytes 42-1233/*", "bytes */1234", "bytes 00-1/2", "bytes 499-0/1234", "bytes 1-/"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, value string) {
+		cr, err := parseContentRange(value)
+		if err != nil {
+			return
+		}
+		if cr.unsatisfied() {
+			if cr.Last != -1 || cr.Complete < 0 {
+				t.Fatalf("Invalid unsatisfied range %+v from %q", cr, value)
+			}
+		} else if cr.First < 0 || cr.Last < cr.First || (cr.Complete >= 0 && cr.Complete <= cr.Last) {
+			t.Fatalf("Invalid range %+v from %q", cr, value)
+		}
+		again, err := parseContentRange(cr.String())
+		if err != nil || again != cr {
+			t.Fatalf("Round trip of %q: %q parsed as %+v, %v", value, cr.String(), again, err)
+		}
+	})
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// errDiskSpaceUnsupported 当前系统无法查询磁盘可用空间
+var errDiskSpaceUnsupported = errors.New("当前系统不支持查询磁盘可用空间")
+
+// freeSpace 返回目录所在磁盘当前用户可用的字节数。目录还不存在时查询最近的已存在的上级目录
+func freeSpace(dir string) (int64, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return diskFree(dir)
+		} else if !os.IsNotExist(err) {
+			return 0, err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return 0, os.ErrNotExist
+		}
+		dir = parent
+	}
+}
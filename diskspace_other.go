@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd || dragonfly || windows)
+
+package main
+
+// diskFree 其他系统不检查磁盘空间
+func diskFree(path string) (int64, error) {
+	return 0, errDiskSpaceUnsupported
+}
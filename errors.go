@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// 下载错误码，用于JSON输出和判断是否可以重试
+const (
+	ErrCodeNetwork         = "network"            // 连接失败或读取响应中断，可以重试
+	ErrCodeTimeout         = "timeout"            // 超过配置的超时时间
+	ErrCodeCanceled        = "canceled"           // 被用户取消
+	ErrCodeUnauthorized    = "unauthorized"       // 服务器返回401或403，通常是令牌失效
+	ErrCodeNotFound        = "not_found"          // 服务器返回404
+	ErrCodeRateLimited     = "rate_limited"       // 服务器返回429，可以重试
+	ErrCodeServerError     = "server_error"       // 服务器返回5xx，可以重试
+	ErrCodeHTTPStatus      = "http_status"        // 服务器返回其他非2xx状态码
+	ErrCodeInvalidResponse = "invalid_response"   // 响应缺少文件大小等必要信息
+	ErrCodeFilesystem      = "filesystem"         // 无法创建或写入本地文件
+	ErrCodeNoSpace         = "insufficient_space" // 输出目录所在磁盘的可用空间不足
+	ErrCodeUnexpectedType  = "unexpected_content" // 服务器返回的是网页等非文件内容
+	ErrCodeInvalidConfig   = "invalid_config"     // 配置无效，如分块大小为0
+	ErrCodeResolve         = "resolve_failed"     // 无法解析平台页面地址
+	ErrCodeUnknown         = "unknown"
+)
+
+// DownloadError 带错误码的下载错误，错误信息与原来的中文提示一致
+type DownloadError struct {
+	Code   string
+	Status int // HTTP状态码，仅服务器返回错误状态时设置
+	Err    error
+}
+
+func (e *DownloadError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DownloadError) Unwrap() error {
+	return e.Err
+}
+
+// downloadErrorf 创建带错误码的下载错误
+func downloadErrorf(code, format string, args ...interface{}) error {
+	return &DownloadError{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+// httpStatusError 根据服务器返回的状态码创建下载错误，会读取响应体中的错误信息
+func httpStatusError(resp *http.Response) error {
+	code := ErrCodeHTTPStatus
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		code = ErrCodeUnauthorized
+	case resp.StatusCode == http.StatusNotFound:
+		code = ErrCodeNotFound
+	case resp.StatusCode == http.StatusTooManyRequests:
+		code = ErrCodeRateLimited
+	case resp.StatusCode >= 500:
+		code = ErrCodeServerError
+	}
+	err := fmt.Errorf("服务器返回错误状态码：%d (%s)", resp.StatusCode, resp.Status)
+	// 响应体中有平台的错误信息时一起显示，如令牌过期
+	if pe := readPlatformError(resp); pe != nil {
+		err = fmt.Errorf("服务器返回错误状态码：%d (%s)：%s", resp.StatusCode, resp.Status, pe)
+	}
+	return &DownloadError{Code: code, Status: resp.StatusCode, Err: err}
+}
+
+// errorCode 返回错误对应的错误码
+func errorCode(err error) string {
+	var derr *DownloadError
+	switch {
+	case errors.As(err, &derr):
+		return derr.Code
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrCodeTimeout
+	case errors.Is(err, context.Canceled):
+		return ErrCodeCanceled
+	}
+	return ErrCodeUnknown
+}
+
+// isRetryable 判断错误是否是暂时性的，重试可能成功
+func isRetryable(err error) bool {
+	switch errorCode(err) {
+	case ErrCodeNetwork, ErrCodeRateLimited, ErrCodeServerError:
+		return true
+	}
+	return false
+}
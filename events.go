@@ -0,0 +1,148 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// 事件类型
+const (
+	EventSnapshot      = "snapshot"       // 连接建立时的全量任务快照
+	EventCreated       = "created"        // 任务已创建
+	EventProgress      = "progress"       // 任务进度更新
+	EventStateChanged  = "state_changed"  // 任务状态变化
+	EventLog           = "log"            // 日志消息
+	EventConfigChanged = "config_changed" // 配置文件被修改并已重新加载
+	EventAck           = "ack"            // 客户端命令执行成功
+	EventError         = "error"          // 客户端命令执行失败
+)
+
+// 日志级别
+const (
+	LogLevelInfo  = "info"
+	LogLevelError = "error"
+)
+
+// Event 推送给客户端的事件消息
+type Event struct {
+	ID        uint64              `json:"id,omitempty"`  // 全局事件编号，由EventHub分配
+	Seq       uint64              `json:"seq,omitempty"` // 连接内的消息序号，用于检测丢失的消息
+	Type      string              `json:"type"`
+	TaskID    string              `json:"task_id,omitempty"`
+	Time      time.Time           `json:"time"`
+	Task      *DownloadProgress   `json:"task,omitempty"`
+	Tasks     []*DownloadProgress `json:"tasks,omitempty"` // 仅snapshot事件使用
+	Level     string              `json:"level,omitempty"`
+	Message   string              `json:"message,omitempty"`
+	Profile   string              `json:"profile,omitempty"`    // 仅config_changed事件使用
+	Config    *Config             `json:"config,omitempty"`     // 仅config_changed事件使用，敏感请求头已隐藏
+	RequestID string              `json:"request_id,omitempty"` // 对应客户端命令的请求ID
+}
+
+// eventHistorySize 事件环形缓冲区的容量，用于断线重连后补发事件
+const eventHistorySize = 512
+
+// EventSubscriber 事件订阅者
+type EventSubscriber interface {
+	Deliver(evt *Event)
+}
+
+// EventHub 事件分发中心，为事件分配全局编号并分发给所有订阅者
+type EventHub struct {
+	publishMu   sync.Mutex // 串行化发布过程，保证订阅者按编号顺序收到事件
+	mu          sync.RWMutex
+	lastID      uint64
+	subscribers map[EventSubscriber]struct{}
+	history     []Event // 最近发布的事件，环形缓冲区
+	next        int     // 下一个写入history的位置
+}
+
+// NewEventHub 创建事件分发中心
+func NewEventHub() *EventHub {
+	return &EventHub{
+		subscribers: make(map[EventSubscriber]struct{}),
+		history:     make([]Event, 0, eventHistorySize),
+	}
+}
+
+// Publish 发布事件
+func (h *EventHub) Publish(evt Event) {
+	h.publishMu.Lock()
+	defer h.publishMu.Unlock()
+
+	h.mu.Lock()
+	h.lastID++
+	evt.ID = h.lastID
+	if evt.Time.IsZero() {
+		evt.Time = time.Now()
+	}
+	if len(h.history) < eventHistorySize {
+		h.history = append(h.history, evt)
+	} else {
+		h.history[h.next] = evt
+	}
+	h.next = (h.next + 1) % eventHistorySize
+	subscribers := make([]EventSubscriber, 0, len(h.subscribers))
+	for s := range h.subscribers {
+		subscribers = append(subscribers, s)
+	}
+	h.mu.Unlock()
+
+	for _, s := range subscribers {
+		s.Deliver(&evt)
+	}
+}
+
+// SubscribeSince 添加订阅者，并返回编号大于afterID的历史事件。
+// 如果这些事件已经不在缓冲区中，ok返回false，调用方应改为发送全量快照。
+func (h *EventHub) SubscribeSince(s EventSubscriber, afterID uint64) (missed []Event, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscribers[s] = struct{}{}
+
+	if afterID > h.lastID {
+		return nil, false
+	}
+	if afterID == h.lastID {
+		return nil, true
+	}
+	oldest := h.lastID - uint64(len(h.history)) + 1
+	if afterID+1 < oldest {
+		return nil, false
+	}
+
+	// history按写入位置循环存放，从最旧的事件开始读取
+	start := 0
+	if len(h.history) == eventHistorySize {
+		start = h.next
+	}
+	for i := 0; i < len(h.history); i++ {
+		evt := h.history[(start+i)%len(h.history)]
+		if evt.ID > afterID {
+			missed = append(missed, evt)
+		}
+	}
+	return missed, true
+}
+
+// Subscribe 添加订阅者，返回当前最后一个事件的编号
+func (h *EventHub) Subscribe(s EventSubscriber) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscribers[s] = struct{}{}
+	return h.lastID
+}
+
+// Unsubscribe 移除订阅者
+func (h *EventHub) Unsubscribe(s EventSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers, s)
+}
+
+// LastID 返回最后一个事件的编号
+func (h *EventHub) LastID() uint64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.lastID
+}
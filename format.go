@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// 配置文件格式，按扩展名区分
+const (
+	formatJSON = "json"
+	formatYAML = "yaml"
+	formatTOML = "toml"
+)
+
+// configFormatOf 根据扩展名判断配置文件格式：.yaml/.yml 为YAML，.toml 为TOML，其他为JSON
+func configFormatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return formatYAML
+	case ".toml":
+		return formatTOML
+	}
+	return formatJSON
+}
+
+// configDataToJSON 将YAML或TOML格式的配置文件内容转换为JSON，之后按JSON配置文件统一迁移和校验，
+// 保证各格式的语义一致
+func configDataToJSON(format string, data []byte) ([]byte, error) {
+	var raw interface{}
+	switch format {
+	case formatYAML:
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("无法解析YAML配置文件: %v", err)
+		}
+	case formatTOML:
+		var table map[string]interface{}
+		if err := toml.Unmarshal(data, &table); err != nil {
+			return nil, fmt.Errorf("无法解析TOML配置文件: %v", err)
+		}
+		raw = table
+	default:
+		return data, nil
+	}
+	if raw == nil {
+		// 空的YAML文件
+		raw = map[string]interface{}{}
+	}
+	converted, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("配置文件包含不支持的内容: %v", err)
+	}
+	return converted, nil
+}
+
+// marshalConfigFile 按格式序列化配置文件。previous为文件原来的内容，YAML格式会保留其中的注释
+func marshalConfigFile(format string, cf *ConfigFile, previous []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case formatYAML:
+		var node yaml.Node
+		if err := node.Encode(cf); err != nil {
+			return nil, err
+		}
+		var old yaml.Node
+		if len(previous) > 0 && yaml.Unmarshal(previous, &old) == nil && len(old.Content) > 0 {
+			copyYAMLComments(&node, old.Content[0])
+		}
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(&node); err != nil {
+			return nil, err
+		}
+		if err := enc.Close(); err != nil {
+			return nil, err
+		}
+	case formatTOML:
+		if err := toml.NewEncoder(&buf).Encode(cf); err != nil {
+			return nil, err
+		}
+	default:
+		data, err := json.MarshalIndent(cf, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+	}
+	return buf.Bytes(), nil
+}
+
+// copyYAMLComments 将旧文件中的注释复制到新内容中键相同的位置，手工编辑的注释在程序保存配置后不会丢失
+func copyYAMLComments(dst, src *yaml.Node) {
+	if dst.Kind != src.Kind {
+		return
+	}
+	dst.HeadComment, dst.LineComment, dst.FootComment = src.HeadComment, src.LineComment, src.FootComment
+	switch dst.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(dst.Content); i += 2 {
+			for j := 0; j+1 < len(src.Content); j += 2 {
+				if src.Content[j].Value == dst.Content[i].Value {
+					copyYAMLComments(dst.Content[i], src.Content[j])
+					copyYAMLComments(dst.Content[i+1], src.Content[j+1])
+					break
+				}
+			}
+		}
+	case yaml.SequenceNode:
+		for i := 0; i < len(dst.Content) && i < len(src.Content); i++ {
+			copyYAMLComments(dst.Content[i], src.Content[i])
+		}
+	}
+}
+
+// convertConfigFile 将配置文件转换为dst扩展名对应的格式。输入文件按原格式加载和校验，
+// 旧版本只在内存中升级，不改写输入文件；敏感请求头写入输出文件对应的保险库
+func convertConfigFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("无法读取配置文件: %v", err)
+	}
+	jsonData, err := configDataToJSON(configFormatOf(src), data)
+	if err != nil {
+		return err
+	}
+	cf, _, _, err := parseConfigData(jsonData)
+	if err != nil {
+		return err
+	}
+	if err := mergeVaultSecrets(src, cf); err != nil {
+		return err
+	}
+	return SaveConfigFile(dst, cf)
+}
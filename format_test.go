@@ -0,0 +1,164 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestConfigFormats_RoundTrip 测试各格式保存后再加载得到相同的配置
+func TestConfigFormats_RoundTrip(t *testing.T) {
+	t.Setenv(envVaultPassphrase, "")
+	for _, name := range []string{"config.json", "config.yaml", "config.yml", "config.toml"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			config := &Config{
+				OutputDir:     "/books",
+				Timeout:       "1m",
+				ChunkSize:     2048,
+				Headers:       map[string]string{"User-Agent": "test", "X-Nd-Auth": "token"},
+				SecretHeaders: []string{"X-Token"},
+			}
+			if err := SaveConfig(path, config); err != nil {
+				t.Fatalf("SaveConfig failed: %v", err)
+			}
+			if err := SaveProfile(path, "school-b", &Config{Timeout: "2m"}); err != nil {
+				t.Fatal(err)
+			}
+			cf, err := LoadConfigFile(path)
+			if err != nil {
+				t.Fatalf("LoadConfigFile failed: %v", err)
+			}
+			if !reflect.DeepEqual(cf.Profiles[defaultProfileName], config) {
+				t.Errorf("Expected %+v, got %+v", config, cf.Profiles[defaultProfileName])
+			}
+			if cf.Profiles["school-b"].Timeout != "2m" || cf.Profiles["school-b"].ChunkSize != 0 {
+				t.Errorf("Unexpected profile %+v", cf.Profiles["school-b"])
+			}
+			data, _ := os.ReadFile(path)
+			if strings.Contains(string(data), "token") {
+				t.Errorf("Token stored in plaintext: %s", data)
+			}
+		})
+	}
+}
+
+// TestConfigFormats_Validation 测试YAML和TOML文件与JSON使用相同的校验和迁移规则
+func TestConfigFormats_Validation(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		field   string
+	}{
+		{"yaml unknown field", "c.yaml", "version: 2\nactive_profile: default\nprofiles:\n  default:\n    timout: 1m\n", "profiles.default.timout"},
+		{"yaml bad timeout", "c.yaml", "version: 2\nactive_profile: default\nprofiles:\n  default:\n    timeout: abc\n", "profiles.default.timeout"},
+		{"toml bad chunk", "c.toml", "version = 2\nactive_profile = \"default\"\n[profiles.default]\nchunk_size = -1\n", "profiles.default.chunk_size"},
+		{"toml wrong type", "c.toml", "version = 2\nactive_profile = \"default\"\n[profiles.default]\nchunk_size = \"big\"\n", "profiles.default.chunk_size"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadConfig(path)
+			if err == nil || !strings.Contains(err.Error(), tt.field) {
+				t.Errorf("Expected error for %s, got %v", tt.field, err)
+			}
+		})
+	}
+
+	// 没有version字段的YAML文件按版本0迁移
+	path := filepath.Join(t.TempDir(), "old.yml")
+	if err := os.WriteFile(path, []byte("timeout: 90\noutput_dir: /books\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if config.Timeout != "1m30s" || config.OutputDir != "/books" {
+		t.Errorf("Unexpected migrated config %+v", config)
+	}
+	if _, err := os.Stat(path + ".v0.bak"); err != nil {
+		t.Errorf("Expected backup: %v", err)
+	}
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "active_profile: default") {
+		t.Errorf("Expected migrated file in YAML format: %s", data)
+	}
+}
+
+// TestConfigFormats_YAMLComments 测试保存YAML配置时保留手写的注释
+func TestConfigFormats_YAMLComments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `# 团队共用的配置
+version: 2
+active_profile: default
+profiles:
+  # 学校A的账号
+  default:
+    timeout: 1m # 网络较慢
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ChunkSize = 1024
+	if err := SaveConfig(path, config); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	for _, comment := range []string{"# 团队共用的配置", "# 学校A的账号", "# 网络较慢", "chunk_size: 1024"} {
+		if !strings.Contains(string(data), comment) {
+			t.Errorf("Expected %q in saved file:\n%s", comment, data)
+		}
+	}
+}
+
+// TestCLI_ConfigConvert 测试 config convert 在各格式间转换
+func TestCLI_ConfigConvert(t *testing.T) {
+	t.Setenv(envVaultPassphrase, "")
+	dir := t.TempDir()
+	src := filepath.Join(dir, "config.json")
+	writeConfigFileAt(t, src, `{"timeout":"1m","headers":{"X-Nd-Auth":"token"}}`)
+
+	yamlPath := filepath.Join(dir, "config.yaml")
+	tomlPath := filepath.Join(dir, "config.toml")
+	if code := cmdConfig([]string{"convert", src, yamlPath}); code != exitOK {
+		t.Fatalf("convert to YAML failed with %d", code)
+	}
+	if code := cmdConfig([]string{"convert", yamlPath, tomlPath}); code != exitOK {
+		t.Fatalf("convert to TOML failed with %d", code)
+	}
+	config, err := LoadConfig(tomlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Timeout != "1m" || config.Headers["X-Nd-Auth"] != "token" {
+		t.Errorf("Unexpected converted config %+v", config)
+	}
+	if data, _ := os.ReadFile(src); !strings.Contains(string(data), `"timeout":"1m"`) {
+		t.Errorf("convert rewrote the source file: %s", data)
+	}
+
+	if code := cmdConfig([]string{"convert", src, yamlPath}); code != exitConfig {
+		t.Errorf("Expected exitConfig when the destination exists, got %d", code)
+	}
+	if code := cmdConfig([]string{"convert", src}); code != exitUsage {
+		t.Errorf("Expected exitUsage for missing destination, got %d", code)
+	}
+}
+
+// writeConfigFileAt 在指定路径写入配置文件
+func writeConfigFileAt(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
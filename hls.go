@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HLS（m3u8）视频的下载：解析播放列表，依次下载分片，AES-128加密的分片解密后按顺序写入同一个文件。
+// MPEG-TS分片直接拼接就是完整的 .ts 文件；fMP4分片在最前面写入初始化片段（EXT-X-MAP），拼接后是分段的 .mp4 文件。
+// 已完成的分片数记录在下载记录中，断点续传时从下一个分片开始
+
+// maxKeySize AES-128密钥的长度
+const maxKeySize = 16
+
+// hlsPlaylist 解析后的播放列表。主播放列表只有Variants，媒体播放列表只有Segments
+type hlsPlaylist struct {
+	Variants []hlsVariant
+	Segments []hlsSegment
+	EndList  bool // 有 EXT-X-ENDLIST，即点播而不是直播
+}
+
+// hlsVariant 主播放列表中的一种码率
+type hlsVariant struct {
+	URL       string
+	Bandwidth int64
+}
+
+// hlsByteRange 分片在文件中的范围（EXT-X-BYTERANGE），Length为0表示整个文件
+type hlsByteRange struct {
+	Length int64
+	Offset int64
+}
+
+// hlsMap 初始化片段（EXT-X-MAP），URL为空表示没有
+type hlsMap struct {
+	URL   string
+	Range hlsByteRange
+}
+
+// hlsKey 分片的加密方式（EXT-X-KEY），IV为空时使用分片序号
+type hlsKey struct {
+	Method string
+	URL    string
+	IV     []byte
+}
+
+// hlsSegment 媒体播放列表中的一个分片
+type hlsSegment struct {
+	URL      string
+	Duration float64
+	Sequence int64
+	Range    hlsByteRange
+	Key      *hlsKey // nil表示不加密
+	Map      hlsMap
+}
+
+// fragmentedMP4 分片是否是fMP4格式
+func (p *hlsPlaylist) fragmentedMP4() bool {
+	return len(p.Segments) > 0 && p.Segments[0].Map.URL != ""
+}
+
+// duration 全部分片的总时长，秒
+func (p *hlsPlaylist) duration() float64 {
+	var total float64
+	for _, seg := range p.Segments {
+		total += seg.Duration
+	}
+	return total
+}
+
+// parseHLSPlaylist 解析m3u8播放列表，分片等地址相对于base解析为完整地址
+func parseHLSPlaylist(data []byte, base *url.URL) (*hlsPlaylist, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	if !scanner.Scan() || strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff")) != "#EXTM3U" {
+		return nil, fmt.Errorf("不是有效的m3u8播放列表（缺少 #EXTM3U）")
+	}
+
+	playlist := &hlsPlaylist{}
+	var (
+		sequence    int64
+		duration    float64
+		rng         hlsByteRange
+		hasRange    bool
+		key         *hlsKey
+		initMap     hlsMap
+		streamInf   map[string]string
+		nextOffsets = map[string]int64{} // 同一文件中下一个分片的默认起始位置
+	)
+	resolve := func(ref string) (string, error) {
+		u, err := base.Parse(ref)
+		if err != nil {
+			return "", fmt.Errorf("无效的地址 %q：%v", ref, err)
+		}
+		return u.String(), nil
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		tag, value, _ := strings.Cut(line, ":")
+		switch {
+		case line == "":
+		case tag == "#EXT-X-MEDIA-SEQUENCE":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("无效的 EXT-X-MEDIA-SEQUENCE：%s", value)
+			}
+			sequence = n
+		case tag == "#EXTINF":
+			d, _, _ := strings.Cut(value, ",")
+			n, err := strconv.ParseFloat(strings.TrimSpace(d), 64)
+			if err != nil {
+				return nil, fmt.Errorf("无效的 EXTINF：%s", value)
+			}
+			duration = n
+		case tag == "#EXT-X-BYTERANGE":
+			r, err := parseHLSByteRange(value)
+			if err != nil {
+				return nil, err
+			}
+			rng, hasRange = r, true
+		case tag == "#EXT-X-KEY":
+			attrs := parseHLSAttributes(value)
+			switch method := attrs["METHOD"]; method {
+			case "NONE":
+				key = nil
+			case "AES-128":
+				k := &hlsKey{Method: method}
+				var err error
+				if k.URL, err = resolve(attrs["URI"]); err != nil {
+					return nil, err
+				}
+				if iv := attrs["IV"]; iv != "" {
+					b, err := hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(iv, "0x"), "0X"))
+					if err != nil || len(b) != aes.BlockSize {
+						return nil, fmt.Errorf("无效的 IV：%s", iv)
+					}
+					k.IV = b
+				}
+				key = k
+			default:
+				return nil, fmt.Errorf("不支持的加密方式 %s，只支持 AES-128", method)
+			}
+		case tag == "#EXT-X-MAP":
+			attrs := parseHLSAttributes(value)
+			u, err := resolve(attrs["URI"])
+			if err != nil {
+				return nil, err
+			}
+			initMap = hlsMap{URL: u}
+			if br := attrs["BYTERANGE"]; br != "" {
+				if initMap.Range, err = parseHLSByteRange(br); err != nil {
+					return nil, err
+				}
+				initMap.Range.Offset = max(initMap.Range.Offset, 0)
+			}
+		case tag == "#EXT-X-STREAM-INF":
+			streamInf = parseHLSAttributes(value)
+		case tag == "#EXT-X-ENDLIST":
+			playlist.EndList = true
+		case strings.HasPrefix(line, "#"):
+			// 其他标签和注释不影响下载
+		case streamInf != nil:
+			u, err := resolve(line)
+			if err != nil {
+				return nil, err
+			}
+			bandwidth, _ := strconv.ParseInt(streamInf["BANDWIDTH"], 10, 64)
+			playlist.Variants = append(playlist.Variants, hlsVariant{URL: u, Bandwidth: bandwidth})
+			streamInf = nil
+		default:
+			u, err := resolve(line)
+			if err != nil {
+				return nil, err
+			}
+			seg := hlsSegment{URL: u, Duration: duration, Sequence: sequence, Key: key, Map: initMap}
+			if hasRange {
+				// 没有给出起始位置时紧接着同一文件中的上一个分片
+				if rng.Offset < 0 {
+					rng.Offset = nextOffsets[u]
+				}
+				seg.Range = rng
+				nextOffsets[u] = rng.Offset + rng.Length
+			}
+			playlist.Segments = append(playlist.Segments, seg)
+			sequence++
+			duration, hasRange = 0, false
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取播放列表失败：%v", err)
+	}
+	return playlist, nil
+}
+
+// parseHLSByteRange 解析 <长度>[@<起始位置>]，没有起始位置时Offset为-1
+func parseHLSByteRange(value string) (hlsByteRange, error) {
+	length, offset, hasOffset := strings.Cut(strings.Trim(value, `"`), "@")
+	r := hlsByteRange{Offset: -1}
+	var err error
+	if r.Length, err = strconv.ParseInt(length, 10, 64); err != nil || r.Length <= 0 {
+		return r, fmt.Errorf("无效的 BYTERANGE：%s", value)
+	}
+	if hasOffset {
+		if r.Offset, err = strconv.ParseInt(offset, 10, 64); err != nil || r.Offset < 0 {
+			return r, fmt.Errorf("无效的 BYTERANGE：%s", value)
+		}
+	}
+	return r, nil
+}
+
+// parseHLSAttributes 解析 KEY=VALUE,KEY="VALUE" 形式的属性列表，引号中的值可以包含逗号
+func parseHLSAttributes(s string) map[string]string {
+	attrs := make(map[string]string)
+	for s != "" {
+		name, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+			rest = strings.TrimPrefix(rest, ",")
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		attrs[strings.ToUpper(strings.TrimSpace(name))] = strings.TrimSpace(value)
+		s = rest
+	}
+	return attrs
+}
+
+// hlsFetch 按下载配置的请求头请求播放列表、密钥或分片，r不为空时只请求其中的范围
+func hlsFetch(ctx context.Context, config *Config, rawURL string, r hlsByteRange) (*http.Response, error) {
+	reqConfig := *config
+	reqConfig.URL = rawURL
+	req, err := newDownloadRequest(ctx, http.MethodGet, &reqConfig)
+	if err != nil {
+		return nil, err
+	}
+	if r.Length > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", r.Offset, r.Offset+r.Length-1))
+	}
+	resp, err := newHTTPClient().Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, contextError(ctx)
+		}
+		return nil, downloadErrorf(ErrCodeNetwork, "请求失败：%v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, httpStatusError(resp)
+	}
+	return resp, nil
+}
+
+// hlsReadAll 读取响应体，服务器不支持Range返回了整个文件时截取需要的范围
+func hlsReadAll(ctx context.Context, resp *http.Response, body io.Reader, r hlsByteRange) ([]byte, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, contextError(ctx)
+		}
+		return nil, downloadErrorf(ErrCodeNetwork, "读取数据失败：%v", err)
+	}
+	if r.Length > 0 && resp.StatusCode == http.StatusOK {
+		if int64(len(data)) < r.Offset+r.Length {
+			return nil, downloadErrorf(ErrCodeInvalidResponse, "文件长度 %d 小于分片范围 %d@%d", len(data), r.Length, r.Offset)
+		}
+		data = data[r.Offset : r.Offset+r.Length]
+	}
+	return data, nil
+}
+
+// fetchHLSPlaylist 获取媒体播放列表。地址是主播放列表时选择码率最高的一种
+func fetchHLSPlaylist(ctx context.Context, config *Config) (*hlsPlaylist, error) {
+	playlistURL := config.URL
+	for depth := 0; ; depth++ {
+		resp, err := hlsFetch(ctx, config, playlistURL, hlsByteRange{})
+		if err != nil {
+			return nil, err
+		}
+		playlist, err := readHLSPlaylist(ctx, resp)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(playlist.Variants) == 0 {
+			switch {
+			case !playlist.EndList:
+				return nil, downloadErrorf(ErrCodeInvalidResponse, "播放列表没有 EXT-X-ENDLIST，不支持下载直播")
+			case len(playlist.Segments) == 0:
+				return nil, downloadErrorf(ErrCodeInvalidResponse, "播放列表中没有分片")
+			}
+			return playlist, nil
+		}
+		if depth > 0 {
+			return nil, downloadErrorf(ErrCodeInvalidResponse, "码率 %s 的播放列表仍然是主播放列表", playlistURL)
+		}
+		best := playlist.Variants[0]
+		for _, v := range playlist.Variants[1:] {
+			if v.Bandwidth > best.Bandwidth {
+				best = v
+			}
+		}
+		playlistURL = best.URL
+	}
+}
+
+// readHLSPlaylist 检查并解析播放列表响应，服务器返回网页等错误信息时返回与下载文件时相同的错误
+func readHLSPlaylist(ctx context.Context, resp *http.Response) (*hlsPlaylist, error) {
+	body, err := guardContent(resp, true)
+	if err != nil {
+		return nil, err
+	}
+	data, err := hlsReadAll(ctx, resp, body, hlsByteRange{})
+	if err != nil {
+		return nil, err
+	}
+	playlist, err := parseHLSPlaylist(data, resp.Request.URL)
+	if err != nil {
+		return nil, &DownloadError{Code: ErrCodeInvalidResponse, Err: err}
+	}
+	return playlist, nil
+}
+
+// hlsDownloader 下载分片时缓存已获取的密钥
+type hlsDownloader struct {
+	config *Config
+	keys   map[string][]byte
+}
+
+// fetch 下载分片或初始化片段，加密的分片解密后返回
+func (d *hlsDownloader) fetch(ctx context.Context, rawURL string, r hlsByteRange, key *hlsKey, sequence int64) ([]byte, error) {
+	resp, err := hlsFetch(ctx, d.config, rawURL, r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// 加密的数据无法通过内容判断类型，只检查服务器声明的类型
+	body, err := guardContent(resp, key == nil)
+	if err != nil {
+		return nil, err
+	}
+	data, err := hlsReadAll(ctx, resp, body, r)
+	if err != nil || key == nil {
+		return data, err
+	}
+
+	secret, err := d.key(ctx, key.URL)
+	if err != nil {
+		return nil, err
+	}
+	iv := key.IV
+	if iv == nil {
+		// 没有给出IV时使用分片序号，大端序填充到16字节
+		iv = make([]byte, aes.BlockSize)
+		binary.BigEndian.PutUint64(iv[8:], uint64(sequence))
+	}
+	return decryptAES128(data, secret, iv)
+}
+
+// key 获取解密密钥，同一个密钥只请求一次
+func (d *hlsDownloader) key(ctx context.Context, rawURL string) ([]byte, error) {
+	if key, ok := d.keys[rawURL]; ok {
+		return key, nil
+	}
+	resp, err := hlsFetch(ctx, d.config, rawURL, hlsByteRange{})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// 多读一个字节以发现长度不对的响应，如错误信息
+	key, err := io.ReadAll(io.LimitReader(resp.Body, maxKeySize+1))
+	if err != nil {
+		return nil, downloadErrorf(ErrCodeNetwork, "读取密钥失败：%v", err)
+	}
+	if len(key) != maxKeySize {
+		if pe := parsePlatformError(key); pe != nil {
+			return nil, downloadErrorf(ErrCodeUnexpectedType, "获取密钥失败：%s", pe)
+		}
+		return nil, downloadErrorf(ErrCodeInvalidResponse, "密钥长度应为 %d 字节", maxKeySize)
+	}
+	d.keys[rawURL] = key
+	return key, nil
+}
+
+// decryptAES128 使用AES-128-CBC解密分片并去除PKCS#7填充
+func decryptAES128(data, key, iv []byte) ([]byte, error) {
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, downloadErrorf(ErrCodeInvalidResponse, "加密分片的长度 %d 不是 %d 的整数倍", len(data), aes.BlockSize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, downloadErrorf(ErrCodeInvalidResponse, "无效的密钥：%v", err)
+	}
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, data)
+	pad := int(data[len(data)-1])
+	if pad == 0 || pad > aes.BlockSize || !bytes.Equal(data[len(data)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, downloadErrorf(ErrCodeInvalidResponse, "解密失败，密钥或IV不正确")
+	}
+	return data[:len(data)-pad], nil
+}
+
+// downloadHLS 下载HLS播放列表中的全部分片并合并为一个文件，返回实际保存的路径。
+// 与普通下载一样先写入 .part 文件，完成后再重命名
+func downloadHLS(ctx context.Context, config Config, progressCallback func(stats downloadStats)) (string, error) {
+	playlist, err := fetchHLSPlaylist(ctx, &config)
+	if err != nil {
+		return config.OutputPath, err
+	}
+	config.OutputPath = hlsOutputPath(config.OutputPath, playlist.fragmentedMP4())
+
+	if info, err := skipExisting(ctx, &config); err != nil {
+		return config.OutputPath, err
+	} else if info != nil {
+		reportExisting(info, progressCallback)
+		return config.OutputPath, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(config.OutputPath), 0755); err != nil {
+		return config.OutputPath, downloadErrorf(ErrCodeFilesystem, "无法创建目录：%v", err)
+	}
+
+	outputFile, err := os.OpenFile(partialPath(config.OutputPath), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return config.OutputPath, downloadErrorf(ErrCodeFilesystem, "无法创建文件：%v", err)
+	}
+	defer outputFile.Close()
+
+	// 下载记录中的分片都已完整写入时从下一个分片继续，否则从头开始
+	meta := readPartialMeta(config.OutputPath)
+	var start int
+	var written int64
+	if info, err := outputFile.Stat(); err == nil && meta != nil && meta.URL == config.URL &&
+		meta.Segments > 0 && meta.Segments <= len(playlist.Segments) && info.Size() >= meta.SegmentBytes {
+		start, written = meta.Segments, meta.SegmentBytes
+	} else {
+		meta = &partialMeta{URL: config.URL, Total: -1, CreatedAt: time.Now()}
+	}
+	if err := outputFile.Truncate(written); err != nil {
+		return config.OutputPath, downloadErrorf(ErrCodeFilesystem, "清空文件失败：%v", err)
+	}
+	if _, err := outputFile.Seek(written, io.SeekStart); err != nil {
+		return config.OutputPath, downloadErrorf(ErrCodeFilesystem, "移动文件指针失败：%v", err)
+	}
+	if err := writePartialMeta(config.OutputPath, meta); err != nil {
+		return config.OutputPath, downloadErrorf(ErrCodeFilesystem, "写入下载记录失败：%v", err)
+	}
+
+	d := &hlsDownloader{config: &config, keys: make(map[string][]byte)}
+	tracker := newProgressTracker(time.Now(), written)
+	totalDuration := playlist.duration()
+	var doneDuration float64
+	var currentMap hlsMap
+	for _, seg := range playlist.Segments[:start] {
+		doneDuration += seg.Duration
+		currentMap = seg.Map
+	}
+
+	for i := start; i < len(playlist.Segments); i++ {
+		seg := playlist.Segments[i]
+		if seg.Map != currentMap {
+			// 拼接后的文件只能有一个初始化片段
+			if currentMap.URL != "" {
+				return config.OutputPath, downloadErrorf(ErrCodeInvalidResponse, "不支持中途切换初始化片段（EXT-X-MAP）的播放列表")
+			}
+			data, err := d.fetch(ctx, seg.Map.URL, seg.Map.Range, nil, 0)
+			if err != nil {
+				return config.OutputPath, err
+			}
+			if _, err := outputFile.Write(data); err != nil {
+				return config.OutputPath, downloadErrorf(ErrCodeFilesystem, "写入文件失败：%v", err)
+			}
+			written += int64(len(data))
+			currentMap = seg.Map
+		}
+
+		data, err := d.fetch(ctx, seg.URL, seg.Range, seg.Key, seg.Sequence)
+		if err != nil {
+			return config.OutputPath, err
+		}
+		if _, err := outputFile.Write(data); err != nil {
+			return config.OutputPath, downloadErrorf(ErrCodeFilesystem, "写入文件失败：%v", err)
+		}
+		written += int64(len(data))
+		doneDuration += seg.Duration
+
+		meta.Segments, meta.SegmentBytes = i+1, written
+		if err := writePartialMeta(config.OutputPath, meta); err != nil {
+			return config.OutputPath, downloadErrorf(ErrCodeFilesystem, "写入下载记录失败：%v", err)
+		}
+		if progressCallback != nil {
+			progressCallback(tracker.stats(time.Now(), written, estimateHLSSize(written, doneDuration, totalDuration, i+1 == len(playlist.Segments))))
+		}
+	}
+
+	if err := finalizeDownload(outputFile, config.OutputPath); err != nil {
+		return config.OutputPath, err
+	}
+	return config.OutputPath, nil
+}
+
+// estimateHLSSize 按已下载部分的码率估算合并后的文件大小，用于显示进度和剩余时间。
+// 下载完成前估算值至少比已下载的多一个字节，避免提前显示100%
+func estimateHLSSize(written int64, doneDuration, totalDuration float64, done bool) int64 {
+	if done {
+		return written
+	}
+	if doneDuration <= 0 || totalDuration <= 0 {
+		return -1
+	}
+	return max(int64(float64(written)*totalDuration/doneDuration), written+1)
+}
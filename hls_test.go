@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// encryptAES128 使用AES-128-CBC加密并加上PKCS#7填充，模拟平台的加密分片
+func encryptAES128(data, key, iv []byte) []byte {
+	pad := aes.BlockSize - len(data)%aes.BlockSize
+	out := append(append([]byte{}, data...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	block, _ := aes.NewCipher(key)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, out)
+	return out
+}
+
+// hlsTestServer 本地HLS服务：/master.m3u8 列出两种码率，/high.m3u8 的分片用AES-128加密，
+// 第一个分片给出IV，其余使用分片序号；/fmp4.m3u8 是带初始化片段的fMP4分片
+type hlsTestServer struct {
+	*httptest.Server
+	key      []byte
+	segments [][]byte // 解密后的分片内容
+	mu       sync.Mutex
+	requests map[string]int
+	failAt   string // 请求该路径时返回500，用于测试断点续传
+	wrongKey bool   // 返回与加密时不同的密钥
+}
+
+func newHLSTestServer(t *testing.T) *hlsTestServer {
+	t.Helper()
+	s := &hlsTestServer{key: []byte("0123456789abcdef"), requests: make(map[string]int)}
+	for i := 0; i < 4; i++ {
+		// MPEG-TS包以0x47开头
+		s.segments = append(s.segments, append([]byte{0x47}, bytes.Repeat([]byte{byte('a' + i)}, 1000+i)...))
+	}
+	explicitIV := bytes.Repeat([]byte{0x11}, aes.BlockSize)
+	const firstSequence = 7
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests[r.URL.Path]++
+		fail := s.failAt == r.URL.Path
+		s.mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		switch path := r.URL.Path; {
+		case path == "/master.m3u8":
+			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+			fmt.Fprint(w, "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=200000,RESOLUTION=640x360,CODECS=\"avc1.4d401e,mp4a.40.2\"\nlow.m3u8\n"+
+				"#EXT-X-STREAM-INF:BANDWIDTH=800000\nhigh.m3u8\n")
+		case path == "/high.m3u8":
+			var b strings.Builder
+			fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:10\n#EXT-X-MEDIA-SEQUENCE:%d\n", firstSequence)
+			fmt.Fprintf(&b, "#EXT-X-KEY:METHOD=AES-128,URI=\"/keys/k1.key\",IV=0x%x\n#EXTINF:10.0,\nseg/0.ts\n", explicitIV)
+			b.WriteString("#EXT-X-KEY:METHOD=AES-128,URI=\"/keys/k1.key\"\n")
+			for i := 1; i < len(s.segments); i++ {
+				fmt.Fprintf(&b, "#EXTINF:10.0,\nseg/%d.ts\n", i)
+			}
+			b.WriteString("#EXT-X-ENDLIST\n")
+			w.Write([]byte(b.String()))
+		case path == "/live.m3u8":
+			fmt.Fprint(w, "#EXTM3U\n#EXTINF:10.0,\nseg/0.ts\n")
+		case path == "/keys/k1.key":
+			s.mu.Lock()
+			wrong := s.wrongKey
+			s.mu.Unlock()
+			if wrong {
+				w.Write([]byte("fedcba9876543210"))
+				return
+			}
+			w.Write(s.key)
+		case strings.HasPrefix(path, "/seg/"):
+			var i int
+			fmt.Sscanf(path, "/seg/%d.ts", &i)
+			iv := explicitIV
+			if i > 0 {
+				iv = make([]byte, aes.BlockSize)
+				binary.BigEndian.PutUint64(iv[8:], uint64(firstSequence+i))
+			}
+			w.Header().Set("Content-Type", "video/mp2t")
+			w.Write(encryptAES128(s.segments[i], s.key, iv))
+		case path == "/fmp4.m3u8":
+			fmt.Fprint(w, "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-MAP:URI=\"media.mp4\",BYTERANGE=\"8@0\"\n"+
+				"#EXTINF:4.0,\n#EXT-X-BYTERANGE:5@8\nmedia.mp4\n#EXTINF:4.0,\n#EXT-X-BYTERANGE:6\nmedia.mp4\n#EXT-X-ENDLIST\n")
+		case path == "/media.mp4":
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader("INITINITfrag1frag22"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *hlsTestServer) count(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+func (s *hlsTestServer) fail(path string) {
+	s.mu.Lock()
+	s.failAt = path
+	s.mu.Unlock()
+}
+
+// TestParseHLSPlaylist 测试播放列表中的属性、相对地址和分片范围
+func TestParseHLSPlaylist(t *testing.T) {
+	base, _ := url.Parse("https://cdn.example.com/video/index.m3u8?token=x")
+	data := "\ufeff#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:3\n#EXT-X-KEY:METHOD=AES-128,URI=\"key?id=1,2\",IV=0X000102030405060708090a0b0c0d0e0f\n" +
+		"#EXTINF:9.5,标题\n#EXT-X-BYTERANGE:100@50\nall.ts\n#EXT-X-KEY:METHOD=NONE\n#EXTINF:3,\n#EXT-X-BYTERANGE:20\nall.ts\n" +
+		"#EXTINF:2,\n/abs/2.ts\n#EXT-X-ENDLIST\n"
+	p, err := parseHLSPlaylist([]byte(data), base)
+	if err != nil {
+		t.Fatalf("parseHLSPlaylist failed: %v", err)
+	}
+	if !p.EndList || len(p.Segments) != 3 || p.duration() != 14.5 {
+		t.Fatalf("Unexpected playlist: %+v", p)
+	}
+	first, second, third := p.Segments[0], p.Segments[1], p.Segments[2]
+	if first.URL != "https://cdn.example.com/video/all.ts" || first.Sequence != 3 || first.Range != (hlsByteRange{100, 50}) ||
+		first.Key == nil || first.Key.URL != "https://cdn.example.com/video/key?id=1,2" || first.Key.IV[15] != 0x0f {
+		t.Errorf("Unexpected first segment: %+v %+v", first, first.Key)
+	}
+	if second.Key != nil || second.Range != (hlsByteRange{20, 150}) || second.Sequence != 4 {
+		t.Errorf("Unexpected second segment: %+v", second)
+	}
+	if third.URL != "https://cdn.example.com/abs/2.ts" || third.Range != (hlsByteRange{}) {
+		t.Errorf("Unexpected third segment: %+v", third)
+	}
+
+	for _, bad := range []string{
+		"<html></html>",
+		"#EXTM3U\n#EXT-X-KEY:METHOD=SAMPLE-AES,URI=\"k\"\n",
+		"#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"k\",IV=0x01\n",
+		"#EXTM3U\n#EXTINF:abc,\n1.ts\n",
+	} {
+		if _, err := parseHLSPlaylist([]byte(bad), base); err == nil {
+			t.Errorf("Expected error for playlist %q", bad)
+		}
+	}
+}
+
+// TestDownloadHLS 测试选择最高码率、解密AES-128分片并合并为 .ts 文件
+func TestDownloadHLS(t *testing.T) {
+	server := newHLSTestServer(t)
+	dir := t.TempDir()
+	config := newTaskConfig(&Config{OutputDir: dir, ChunkSize: 1024}, server.URL+"/master.m3u8")
+	if want := filepath.Join(dir, "master.ts"); config.OutputPath != want {
+		t.Fatalf("Expected output path %s, got %s", want, config.OutputPath)
+	}
+
+	var last downloadStats
+	output, err := downloadResource(context.Background(), *config, func(stats downloadStats) { last = stats })
+	if err != nil {
+		t.Fatalf("downloadResource failed: %v", err)
+	}
+	content, _ := os.ReadFile(output)
+	if output != config.OutputPath || !bytes.Equal(content, bytes.Join(server.segments, nil)) {
+		t.Errorf("Unexpected content in %s: %d bytes", output, len(content))
+	}
+	if last.Percent != 100 || last.Total != int64(len(content)) {
+		t.Errorf("Unexpected final stats: %+v", last)
+	}
+	if server.count("/low.m3u8") != 0 || server.count("/keys/k1.key") != 1 {
+		t.Errorf("Expected the high bitrate variant and one key request, got %v", server.requests)
+	}
+	if fileExists(partialPath(output)) || fileExists(partialMetaPath(output)) {
+		t.Errorf("Partial files should be removed")
+	}
+}
+
+// TestDownloadHLS_Resume 测试中断后从下一个分片继续，已下载的分片不再请求
+func TestDownloadHLS_Resume(t *testing.T) {
+	server := newHLSTestServer(t)
+	config := newTaskConfig(&Config{OutputDir: t.TempDir(), ChunkSize: 1024}, server.URL+"/high.m3u8")
+
+	server.fail("/seg/2.ts")
+	if _, err := downloadResource(context.Background(), *config, nil); errorCode(err) != ErrCodeServerError {
+		t.Fatalf("Expected server_error, got %v", err)
+	}
+	meta := readPartialMeta(config.OutputPath)
+	if meta == nil || meta.Segments != 2 || fileExists(config.OutputPath) {
+		t.Fatalf("Expected 2 completed segments in meta, got %+v", meta)
+	}
+	if got := resumableSize(config); got != meta.SegmentBytes {
+		t.Errorf("Expected resumable size %d, got %d", meta.SegmentBytes, got)
+	}
+
+	server.fail("")
+	output, err := downloadResource(context.Background(), *config, nil)
+	if err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	content, _ := os.ReadFile(output)
+	if !bytes.Equal(content, bytes.Join(server.segments, nil)) {
+		t.Errorf("Unexpected content after resuming")
+	}
+	if server.count("/seg/0.ts") != 1 || server.count("/seg/1.ts") != 1 || server.count("/seg/3.ts") != 1 {
+		t.Errorf("Completed segments should not be downloaded again: %v", server.requests)
+	}
+
+	// 再次下载时文件已存在，按默认的 on_exists 跳过
+	if _, err := downloadResource(context.Background(), *config, nil); err != nil || server.count("/seg/3.ts") != 1 {
+		t.Errorf("Expected existing file to be skipped, got %v", err)
+	}
+}
+
+// TestDownloadHLS_FragmentedMP4 测试fMP4分片写入初始化片段后保存为 .mp4，以及按范围请求分片
+func TestDownloadHLS_FragmentedMP4(t *testing.T) {
+	server := newHLSTestServer(t)
+	tm := NewTaskManager(nil)
+	config := newTaskConfig(&Config{OutputDir: t.TempDir(), Timeout: "10s", ChunkSize: 1024}, server.URL+"/fmp4.m3u8")
+	task := tm.Start(config, "")
+	done := waitTaskStatus(t, tm, task.TaskID, TaskStatusCompleted)
+
+	want := strings.TrimSuffix(config.OutputPath, ".ts") + ".mp4"
+	if done.OutputPath != want || done.Filename != "fmp4.mp4" {
+		t.Errorf("Expected task output %s, got %s (%s)", want, done.OutputPath, done.Filename)
+	}
+	if content, _ := os.ReadFile(want); string(content) != "INITINITfrag1frag22" {
+		t.Errorf("Unexpected content %q", content)
+	}
+	if fileExists(config.OutputPath) {
+		t.Errorf("No .ts file should be written for fMP4 segments")
+	}
+}
+
+// TestDownloadHLS_Errors 测试直播、密钥错误和播放列表被错误页面替代时的错误码
+func TestDownloadHLS_Errors(t *testing.T) {
+	server := newHLSTestServer(t)
+	dir := t.TempDir()
+	download := func(name string) error {
+		config := newTaskConfig(&Config{OutputDir: dir, ChunkSize: 1024}, server.URL+name)
+		_, err := downloadResource(context.Background(), *config, nil)
+		return err
+	}
+
+	if err := download("/live.m3u8"); errorCode(err) != ErrCodeInvalidResponse || !strings.Contains(err.Error(), "直播") {
+		t.Errorf("Expected live playlist to be rejected, got %v", err)
+	}
+	if err := download("/missing.m3u8"); errorCode(err) != ErrCodeNotFound {
+		t.Errorf("Expected not_found, got %v", err)
+	}
+
+	server.mu.Lock()
+	server.wrongKey = true
+	server.mu.Unlock()
+	if err := download("/high.m3u8"); errorCode(err) != ErrCodeInvalidResponse {
+		t.Errorf("Expected decryption failure, got %v", err)
+	}
+	if _, err := decryptAES128([]byte("short"), server.key, make([]byte, 16)); err == nil {
+		t.Errorf("Expected error for data not aligned to the block size")
+	}
+}
@@ -1,6 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -53,6 +61,18 @@ func TestGetDefaultFilename(t *testing.T) {
 	if actual != expected {
 		t.Errorf("Expected %s, got %s", expected, actual)
 	}
+
+	// 测试音视频和课件保留扩展名，HLS播放列表保存为 .ts
+	for url, expected := range map[string]string{
+		"https://example.com/unit1.mp3":        "unit1.mp3",
+		"https://example.com/lesson.pptx?x=1":  "lesson.pptx",
+		"https://example.com/video/index.m3u8": "index.ts",
+		"https://example.com/.m3u8":            "download.ts",
+	} {
+		if actual := getDefaultFilename(url); actual != expected {
+			t.Errorf("getDefaultFilename(%s): expected %s, got %s", url, expected, actual)
+		}
+	}
 }
 
 // TestConfig_Copy 测试配置复制
@@ -89,4 +109,100 @@ func TestConfig_Copy(t *testing.T) {
 	if copy.Headers["User-Agent"] != original.Headers["User-Agent"] {
 		t.Errorf("Headers not copied correctly")
 	}
-}
\ No newline at end of file
+}
+
+// TestDownloadPDF_UnknownSize 测试没有 Content-Length 的分块传输、完整长度为 * 的续传，以及服务器忽略 Range 的情况
+func TestDownloadPDF_UnknownSize(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 3000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/chunked.pdf":
+			// 分多次发送并刷新，响应使用分块传输编码，没有 Content-Length
+			for i := 0; i < len(data); i += 10000 {
+				w.Write(data[i : i+10000])
+				w.(http.Flusher).Flush()
+			}
+		case "/star.pdf":
+			var start int
+			fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &start)
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", start, len(data)-1))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[start:])
+		case "/norange.pdf":
+			w.Write(data)
+		case "/short.pdf":
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.Write(data[:1000])
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	download := func(name string, existing []byte) ([]downloadStats, error) {
+		path := filepath.Join(dir, name)
+		config := Config{URL: server.URL + "/" + name, OutputPath: path, ChunkSize: 4096}
+		if existing != nil {
+			writePartial(t, path, config.URL, existing)
+		}
+		var reports []downloadStats
+		err := downloadPDFWithProgress(context.Background(), config, func(stats downloadStats) {
+			reports = append(reports, stats)
+		})
+		if err == nil {
+			if content, _ := os.ReadFile(path); !bytes.Equal(content, data) {
+				t.Errorf("%s: got %d bytes, expected %d", name, len(content), len(data))
+			}
+		}
+		return reports, err
+	}
+
+	for _, tt := range []struct {
+		name     string
+		existing []byte
+	}{
+		{"chunked.pdf", nil},
+		{"star.pdf", data[:1234]},
+		{"norange.pdf", []byte("stale partial content")},
+	} {
+		reports, err := download(tt.name, tt.existing)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		last := reports[len(reports)-1]
+		if last.Total != int64(len(data)) || last.Percent != 100 {
+			t.Errorf("%s: expected the size learned at EOF, got %+v", tt.name, last)
+		}
+		for _, stats := range reports[:len(reports)-1] {
+			if stats.Total == -1 && (stats.Percent != 0 || stats.ETASeconds != -1) {
+				t.Errorf("%s: unexpected progress for unknown size %+v", tt.name, stats)
+			}
+		}
+	}
+
+	// 连接在收到完整文件前关闭，返回可以重试的错误
+	if _, err := download("short.pdf", nil); !isRetryable(err) {
+		t.Errorf("Expected a retryable error for a truncated body, got %v", err)
+	}
+}
+
+// TestDownloadPDF_AlreadyComplete 测试已下载完整时服务器返回416
+func TestDownloadPDF_AlreadyComplete(t *testing.T) {
+	data := []byte("%PDF-1.4 complete")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "a.pdf")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var last downloadStats
+	config := Config{URL: server.URL + "/a.pdf", OutputPath: path, ChunkSize: 4096}
+	if err := downloadPDFWithProgress(context.Background(), config, func(stats downloadStats) { last = stats }); err != nil {
+		t.Fatalf("Expected success for a complete file, got %v", err)
+	}
+	if last.Percent != 100 || last.Total != int64(len(data)) {
+		t.Errorf("Unexpected final progress %+v", last)
+	}
+}
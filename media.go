@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// 资源类型（resource_types 配置项），解析平台页面时只下载其中列出的类型
+const (
+	ResourceDocument   = "document"   // 教材PDF
+	ResourceAudio      = "audio"      // 音频，如英语教材的配套录音
+	ResourceVideo      = "video"      // 视频，包括HLS（m3u8）格式的课程视频
+	ResourceCourseware = "courseware" // 课件，如PPT、Word文档和压缩包
+)
+
+// resourceTypes resource_types 的可选值
+var resourceTypes = []string{ResourceDocument, ResourceAudio, ResourceVideo, ResourceCourseware}
+
+// resourceExtensions 各类资源文件的扩展名
+var resourceExtensions = map[string]string{
+	".pdf":  ResourceDocument,
+	".mp3":  ResourceAudio,
+	".m4a":  ResourceAudio,
+	".aac":  ResourceAudio,
+	".wav":  ResourceAudio,
+	".ogg":  ResourceAudio,
+	".mp4":  ResourceVideo,
+	".m3u8": ResourceVideo,
+	".ts":   ResourceVideo,
+	".mov":  ResourceVideo,
+	".webm": ResourceVideo,
+	".ppt":  ResourceCourseware,
+	".pptx": ResourceCourseware,
+	".doc":  ResourceCourseware,
+	".docx": ResourceCourseware,
+	".xls":  ResourceCourseware,
+	".xlsx": ResourceCourseware,
+	".zip":  ResourceCourseware,
+}
+
+// resourceTypeOf 根据文件名或平台元数据中的格式（如 mp3）判断资源类型，无法识别时返回空字符串
+func resourceTypeOf(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == "" {
+		ext = "." + strings.ToLower(name)
+	}
+	return resourceExtensions[ext]
+}
+
+// isHLS 地址是否是HLS播放列表（.m3u8）
+func isHLS(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(path.Ext(u.Path), ".m3u8")
+}
+
+// hlsOutputPath 按分片格式确定HLS下载合并后的文件扩展名：MPEG-TS分片保存为 .ts，
+// fMP4分片保存为 .mp4。只替换这两种扩展名，用户指定的其他文件名保持不变
+func hlsOutputPath(outputPath string, fragmentedMP4 bool) string {
+	ext := filepath.Ext(outputPath)
+	base := strings.TrimSuffix(outputPath, ext)
+	switch {
+	case fragmentedMP4 && strings.EqualFold(ext, ".ts"):
+		return base + ".mp4"
+	case !fragmentedMP4 && strings.EqualFold(ext, ".mp4"):
+		return base + ".ts"
+	}
+	return outputPath
+}
+
+// enabledResourceTypes 返回配置中要下载的资源类型，未设置时只下载教材PDF
+func (dc *Config) enabledResourceTypes() []string {
+	if len(dc.ResourceTypes) == 0 {
+		return []string{ResourceDocument}
+	}
+	return dc.ResourceTypes
+}
+
+// downloadResource 按资源类型下载单个文件：HLS播放列表下载全部分片并合并，其他文件直接下载。
+// 返回实际保存的路径，HLS下载会按分片格式调整扩展名
+func downloadResource(ctx context.Context, config Config, progressCallback func(stats downloadStats)) (string, error) {
+	if isHLS(config.URL) {
+		return downloadHLS(ctx, config, progressCallback)
+	}
+	return config.OutputPath, downloadPDFWithProgress(ctx, config, progressCallback)
+}
+
+// wantsResource 资源类型是否在要下载的类型中
+func wantsResource(types []string, name string) bool {
+	t := resourceTypeOf(name)
+	return t != "" && slices.Contains(types, t)
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// defaultConfigPath 默认配置文件路径
+const defaultConfigPath = "config.json"
+
+// envPrefix 配置相关环境变量的前缀
+const envPrefix = "TBD_"
+
+// 配置来源，优先级从低到高
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// headerFlags 实现flag.Value接口，用于处理多个-H参数
+type headerFlags map[string]string
+
+func (h *headerFlags) String() string {
+	return fmt.Sprintf("%v", *h)
+}
+
+func (h *headerFlags) Set(value string) error {
+	if *h == nil {
+		*h = make(map[string]string)
+	}
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("请求头格式错误: %s，应为 Key:Value", value)
+	}
+	key := strings.TrimSpace(parts[0])
+	val := strings.TrimSpace(parts[1])
+	(*h)[key] = val
+	return nil
+}
+
+// configSetting 某一层配置中的单个配置项
+type configSetting struct {
+	Key    string // 配置项键名，见configKeys，请求头为 header.<名称>
+	Value  string
+	Source string // 来源类型
+	Origin string // 具体来源，如文件路径、环境变量名或参数名
+}
+
+// ResolvedConfig 合并各层后的有效配置，以及每个配置项的最终来源
+type ResolvedConfig struct {
+	Config     *Config
+	Profile    string                   // 使用的配置方案名称
+	Sources    map[string]configSetting // 键为配置项键名
+	FileExists bool                     // 配置文件是否存在
+}
+
+// resolveConfig 按顺序合并各层配置，后面的层覆盖前面的层
+func resolveConfig(layers ...[]configSetting) (*ResolvedConfig, error) {
+	resolved := &ResolvedConfig{
+		Config:  &Config{Headers: make(map[string]string)},
+		Sources: make(map[string]configSetting),
+	}
+	for _, layer := range layers {
+		for _, setting := range layer {
+			if err := setConfigValue(resolved.Config, setting.Key, setting.Value); err != nil {
+				return nil, fmt.Errorf("%s %s: %v", setting.Source, setting.Origin, err)
+			}
+			resolved.Sources[setting.Key] = setting
+		}
+	}
+	return resolved, nil
+}
+
+// Keys 返回所有已设置的配置项键名，普通配置项在前，请求头按名称排序
+func (rc *ResolvedConfig) Keys() []string {
+	var keys, headers []string
+	for _, key := range configKeys {
+		if _, ok := rc.Sources[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	for key := range rc.Sources {
+		if strings.HasPrefix(key, "header.") {
+			headers = append(headers, key)
+		}
+	}
+	sort.Strings(headers)
+	return append(keys, headers...)
+}
+
+// configToSettings 将配置转换为配置项列表，只包含present返回true的配置项
+func configToSettings(config *Config, source, origin string, present func(key string) bool) []configSetting {
+	var settings []configSetting
+	for _, key := range configKeys {
+		if !present(key) {
+			continue
+		}
+		value, _ := getConfigValue(config, key)
+		settings = append(settings, configSetting{Key: key, Value: value, Source: source, Origin: origin})
+	}
+	for name, value := range config.Headers {
+		key := "header." + name
+		if present(key) {
+			settings = append(settings, configSetting{Key: key, Value: value, Source: source, Origin: origin})
+		}
+	}
+	return settings
+}
+
+// defaultSettings 默认配置层
+func defaultSettings() []configSetting {
+	config := getDefaultConfig()
+	return configToSettings(config, SourceDefault, "", func(key string) bool {
+		value, _ := getConfigValue(config, key)
+		return value != ""
+	})
+}
+
+// fileSettings 配置文件层，只包含所选配置方案中实际出现的字段。
+// profile为空时使用文件中的当前方案，返回实际使用的方案名称。文件不存在时exists返回false
+func fileSettings(path, profile string) (settings []configSetting, name string, exists bool, err error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		if profile != "" && profile != defaultProfileName {
+			return nil, "", false, fmt.Errorf("%w: %q，配置文件 %s 不存在", ErrProfileNotFound, profile, path)
+		}
+		return nil, defaultProfileName, false, nil
+	}
+	// 根据文件中实际出现的字段生成配置项，避免把缺省的零值当作配置
+	cf, fields, err := loadConfigFields(path)
+	if err != nil {
+		return nil, "", true, err
+	}
+	config, err := cf.Profile(profile)
+	if err != nil {
+		return nil, "", true, err
+	}
+	name = profile
+	if name == "" {
+		name = cf.ActiveProfile
+	}
+	settings = configToSettings(config, SourceFile, path+"#"+name, func(key string) bool {
+		if strings.HasPrefix(key, "header.") {
+			return true
+		}
+		_, ok := fields[name][key]
+		return ok
+	})
+	return settings, name, true, nil
+}
+
+// 特殊环境变量
+const (
+	envConfigPath = envPrefix + "CONFIG"    // 配置文件路径
+	envProfile    = envPrefix + "PROFILE"   // 配置方案名称
+	envAuthFile   = envPrefix + "AUTH_FILE" // 存放 X-Nd-Auth 令牌的文件
+	envHeader     = envPrefix + "HEADER_"   // 请求头前缀，如 TBD_HEADER_X_ND_AUTH
+	envFileSuffix = "_FILE"                 // 变量名加上此后缀表示从文件读取值
+	authHeader    = "X-Nd-Auth"             // 平台的认证请求头
+)
+
+// envSettings 环境变量层。
+// 普通配置项的变量名为 TBD_ 加上大写的键名，如 TBD_OUTPUT_DIR；
+// 请求头为 TBD_HEADER_ 加上以下划线分隔的名称，如 TBD_HEADER_X_ND_AUTH 对应 X-Nd-Auth；
+// 变量名加上 _FILE 后缀时从该文件读取值，适合在容器中通过文件传递令牌
+func envSettings(environ []string) ([]configSetting, error) {
+	vars := make(map[string]string)
+	for _, kv := range environ {
+		if name, value, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(name, envPrefix) {
+			vars[name] = value
+		}
+	}
+
+	// 展开 _FILE 变量，同时设置时直接赋值的变量优先
+	type envValue struct{ value, origin string }
+	values := make(map[string]envValue)
+	var names []string
+	for name, value := range vars {
+		if name == envAuthFile || name == envConfigPath || name == envProfile {
+			continue
+		}
+		if base, ok := strings.CutSuffix(name, envFileSuffix); ok {
+			if _, direct := vars[base]; direct {
+				continue
+			}
+			secret, err := readSecretFile(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %v", SourceEnv, name, err)
+			}
+			values[base] = envValue{secret, name}
+		} else {
+			values[name] = envValue{value, name}
+		}
+		names = append(names, strings.TrimSuffix(name, envFileSuffix))
+	}
+	sort.Strings(names)
+
+	var settings []configSetting
+	if path, ok := vars[envAuthFile]; ok {
+		secret, err := readSecretFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %v", SourceEnv, envAuthFile, err)
+		}
+		settings = append(settings, configSetting{Key: "header." + authHeader, Value: secret, Source: SourceEnv, Origin: envAuthFile})
+	}
+	for _, key := range configKeys {
+		if v, ok := values[envPrefix+strings.ToUpper(key)]; ok {
+			settings = append(settings, configSetting{Key: key, Value: v.value, Source: SourceEnv, Origin: v.origin})
+		}
+	}
+	for i, name := range names {
+		header, ok := strings.CutPrefix(name, envHeader)
+		if !ok || header == "" || (i > 0 && names[i-1] == name) {
+			continue
+		}
+		v := values[name]
+		settings = append(settings, configSetting{Key: "header." + envHeaderName(header), Value: v.value, Source: SourceEnv, Origin: v.origin})
+	}
+	return settings, nil
+}
+
+// envHeaderName 将环境变量中的请求头名称转换为HTTP格式，如 X_ND_AUTH 转换为 X-Nd-Auth
+func envHeaderName(name string) string {
+	parts := strings.Split(strings.ToLower(name), "_")
+	for i, part := range parts {
+		if part != "" {
+			parts[i] = strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// readSecretFile 读取存放敏感信息的文件，去掉首尾空白
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("无法读取文件: %v", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// configOptions CLI和Web模式共用的配置选项
+type configOptions struct {
+	configPath string
+	profile    string
+	outputDir  string
+	outputPath string
+	timeout    string
+	chunkSize  int64
+	headers    headerFlags
+
+	fs      *flag.FlagSet
+	environ func() []string // 读取环境变量，测试时可以替换
+}
+
+// registerConfigFlags 在子命令的FlagSet上注册通用的配置参数
+func registerConfigFlags(fs *flag.FlagSet, withOutputPath bool) *configOptions {
+	opts := &configOptions{fs: fs, environ: os.Environ}
+	configPath := defaultConfigPath
+	if path := os.Getenv(envConfigPath); path != "" {
+		configPath = path
+	}
+	fs.StringVar(&opts.configPath, "config", configPath, "配置文件路径（也可以通过 TBD_CONFIG 指定）")
+	fs.StringVar(&opts.profile, "profile", os.Getenv(envProfile), "使用的配置方案（默认为配置文件中的当前方案，也可以通过 TBD_PROFILE 指定）")
+	fs.StringVar(&opts.outputDir, "dir", "", "输出目录（覆盖配置文件中的 output_dir）")
+	if withOutputPath {
+		fs.StringVar(&opts.outputPath, "out", "", "输出文件路径（默认为输出目录下的原文件名）")
+	}
+	fs.StringVar(&opts.timeout, "timeout", "30s", "下载超时时间（如 1m 表示1分钟）")
+	fs.Int64Var(&opts.chunkSize, "chunk", 4*1024*1024, "分块下载大小（字节）")
+	fs.Var(&opts.headers, "H", "HTTP请求头，格式: Key:Value（可多次使用）")
+	return opts
+}
+
+// flagKeys 命令行参数名与配置项键名的对应关系
+var flagKeys = map[string]string{
+	"dir":     "output_dir",
+	"out":     "output_path",
+	"timeout": "timeout",
+	"chunk":   "chunk_size",
+}
+
+// flagSettings 命令行参数层，只包含用户显式设置的参数
+func (opts *configOptions) flagSettings() []configSetting {
+	var settings []configSetting
+	opts.fs.Visit(func(f *flag.Flag) {
+		if f.Name == "H" {
+			for name, value := range opts.headers {
+				settings = append(settings, configSetting{Key: "header." + name, Value: value, Source: SourceFlag, Origin: "-H"})
+			}
+			return
+		}
+		if key, ok := flagKeys[f.Name]; ok {
+			settings = append(settings, configSetting{Key: key, Value: f.Value.String(), Source: SourceFlag, Origin: "-" + f.Name})
+		}
+	})
+	return settings
+}
+
+// resolve 按 默认值 < 配置文件 < 环境变量 < 命令行参数 的优先级合并配置
+func (opts *configOptions) resolve() (*ResolvedConfig, error) {
+	file, profile, exists, err := fileSettings(opts.configPath, opts.profile)
+	if err != nil {
+		return nil, err
+	}
+	env, err := envSettings(opts.environ())
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := resolveConfig(defaultSettings(), file, env, opts.flagSettings())
+	if err != nil {
+		return nil, err
+	}
+	// 合并后再整体校验，错误信息中注明取值来源
+	if verr := resolved.Config.validate(func(string) bool { return true }); len(verr.Fields) > 0 {
+		for i, f := range verr.Fields {
+			if setting, ok := resolved.Sources[f.Field]; ok && setting.Source != SourceDefault {
+				verr.Fields[i].Message += fmt.Sprintf("（来自 %s %s）", setting.Source, setting.Origin)
+			}
+		}
+		return nil, verr
+	}
+	resolved.Profile = profile
+	resolved.FileExists = exists
+	return resolved, nil
+}
+
+// load 加载有效配置。配置文件不存在时exists返回false
+func (opts *configOptions) load() (config *Config, exists bool, err error) {
+	resolved, err := opts.resolve()
+	if err != nil {
+		return nil, false, err
+	}
+	return resolved.Config, resolved.FileExists, nil
+}
+
+// loadConfigFile 加载配置文件中的配置方案（profile为空时为当前方案），文件不存在时返回默认配置
+func loadConfigFile(path, profile string) (*Config, bool, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return getDefaultConfig(), false, nil
+	}
+	cf, err := LoadConfigFile(path)
+	if err != nil {
+		return nil, true, err
+	}
+	config, err := cf.Profile(profile)
+	if err != nil {
+		return nil, true, err
+	}
+	return config, true, nil
+}
+
+// Overrides 返回来自环境变量和命令行参数的配置项
+func (rc *ResolvedConfig) Overrides() []configSetting {
+	var overrides []configSetting
+	for _, key := range rc.Keys() {
+		if setting := rc.Sources[key]; setting.Source == SourceEnv || setting.Source == SourceFlag {
+			overrides = append(overrides, setting)
+		}
+	}
+	return overrides
+}
+
+// applyOverrides 返回在config基础上应用覆盖值后的副本。覆盖值在加载时已经过校验，这里忽略错误
+func applyOverrides(config *Config, overrides []configSetting) *Config {
+	config = config.Copy()
+	for _, setting := range overrides {
+		_ = setConfigValue(config, setting.Key, setting.Value)
+	}
+	return config
+}
+
+// persistableConfig 返回要写入配置文件的配置。
+// 仍等于环境变量或命令行参数所给值的配置项恢复为配置文件（或默认值）中的值，
+// 避免把通过环境变量传入的令牌等信息写进配置文件；用户修改过的配置项照常保存
+func persistableConfig(config *Config, overrides []configSetting, configPath, profile string) *Config {
+	if len(overrides) == 0 {
+		return config
+	}
+	// 配置文件无法读取时以默认值为准，保存后即可修复损坏的配置文件
+	file, _, _, err := fileSettings(configPath, profile)
+	if err != nil {
+		file = nil
+	}
+	lower, err := resolveConfig(defaultSettings(), file)
+	if err != nil {
+		lower, _ = resolveConfig(defaultSettings())
+	}
+
+	persisted := config.Copy()
+	for _, setting := range overrides {
+		if value, _ := getConfigValue(persisted, setting.Key); value != setting.Value {
+			continue
+		}
+		// 下层没有的请求头取到空值，setConfigValue会将其删除
+		value, _ := getConfigValue(lower.Config, setting.Key)
+		_ = setConfigValue(persisted, setting.Key, value)
+	}
+	return persisted
+}
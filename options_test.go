@@ -0,0 +1,229 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestConfigOptions_Precedence 测试每个配置项在 默认值 < 配置文件 < 环境变量 < 命令行参数 下的取值和来源
+func TestConfigOptions_Precedence(t *testing.T) {
+	defaults := getDefaultConfig()
+	fileJSON := `{
+		"url": "https://file.example.com/a.pdf",
+		"output_dir": "/file/dir",
+		"output_path": "/file/out.pdf",
+		"timeout": "1m",
+		"chunk_size": 1024,
+		"headers": {"User-Agent": "file-agent", "X-Nd-Auth": "file-token"}
+	}`
+	env := map[string]string{
+		"TBD_OUTPUT_DIR":       "/env/dir",
+		"TBD_TIMEOUT":          "2m",
+		"TBD_URL":              "https://env.example.com/a.pdf",
+		"TBD_HEADER_X_ND_AUTH": "env-token",
+		"TBD_HEADER_REFERER":   "https://env.example.com/",
+	}
+
+	tests := []struct {
+		name     string
+		file     string
+		env      map[string]string
+		args     []string
+		key      string
+		expected string
+		source   string
+	}{
+		{"default output_dir", "", nil, nil, "output_dir", defaults.OutputDir, SourceDefault},
+		{"default timeout", "", nil, nil, "timeout", "30s", SourceDefault},
+		{"default chunk_size", "", nil, nil, "chunk_size", "4194304", SourceDefault},
+		{"default header", "", nil, nil, "header.User-Agent", defaults.Headers["User-Agent"], SourceDefault},
+
+		{"file url", fileJSON, nil, nil, "url", "https://file.example.com/a.pdf", SourceFile},
+		{"file output_dir", fileJSON, nil, nil, "output_dir", "/file/dir", SourceFile},
+		{"file output_path", fileJSON, nil, nil, "output_path", "/file/out.pdf", SourceFile},
+		{"file timeout", fileJSON, nil, nil, "timeout", "1m", SourceFile},
+		{"file chunk_size", fileJSON, nil, nil, "chunk_size", "1024", SourceFile},
+		{"file header overrides default", fileJSON, nil, nil, "header.User-Agent", "file-agent", SourceFile},
+		{"file header added", fileJSON, nil, nil, "header.X-Nd-Auth", "file-token", SourceFile},
+		{"file keeps other default headers", fileJSON, nil, nil, "header.Origin", defaults.Headers["Origin"], SourceDefault},
+		{"missing field keeps default", `{"timeout":"1m"}`, nil, nil, "chunk_size", "4194304", SourceDefault},
+
+		{"env url", fileJSON, env, nil, "url", "https://env.example.com/a.pdf", SourceEnv},
+		{"env output_dir", fileJSON, env, nil, "output_dir", "/env/dir", SourceEnv},
+		{"env timeout", fileJSON, env, nil, "timeout", "2m", SourceEnv},
+		{"env absent keeps file", fileJSON, env, nil, "chunk_size", "1024", SourceFile},
+		{"env header overrides file", fileJSON, env, nil, "header.X-Nd-Auth", "env-token", SourceEnv},
+		{"env header overrides default", fileJSON, env, nil, "header.Referer", "https://env.example.com/", SourceEnv},
+
+		{"flag output_dir", fileJSON, env, []string{"-dir", "/flag/dir"}, "output_dir", "/flag/dir", SourceFlag},
+		{"flag output_path", fileJSON, env, []string{"-out", "/flag/out.pdf"}, "output_path", "/flag/out.pdf", SourceFlag},
+		{"flag timeout", fileJSON, env, []string{"-timeout", "3m"}, "timeout", "3m", SourceFlag},
+		{"flag chunk_size", fileJSON, env, []string{"-chunk", "2048"}, "chunk_size", "2048", SourceFlag},
+		{"flag header overrides env", fileJSON, env, []string{"-H", "X-Nd-Auth: flag-token"}, "header.X-Nd-Auth", "flag-token", SourceFlag},
+		{"flag default value is not an override", fileJSON, nil, []string{}, "timeout", "1m", SourceFile},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			configPath := filepath.Join(dir, "config.json")
+			if tt.file != "" {
+				if err := os.WriteFile(configPath, []byte(tt.file), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			fs := newFlagSet("download")
+			opts := registerConfigFlags(fs, true)
+			opts.environ = func() []string { return environList(tt.env) }
+			if err := fs.Parse(append([]string{"-config", configPath}, tt.args...)); err != nil {
+				t.Fatal(err)
+			}
+
+			resolved, err := opts.resolve()
+			if err != nil {
+				t.Fatalf("resolve failed: %v", err)
+			}
+			value, err := getConfigValue(resolved.Config, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value != tt.expected {
+				t.Errorf("Expected %s = %q, got %q", tt.key, tt.expected, value)
+			}
+			if source := resolved.Sources[tt.key].Source; source != tt.source {
+				t.Errorf("Expected %s from %s, got %s", tt.key, tt.source, source)
+			}
+			if resolved.FileExists != (tt.file != "") {
+				t.Errorf("Unexpected FileExists %v", resolved.FileExists)
+			}
+		})
+	}
+}
+
+// TestConfigOptions_InvalidEnv 测试无效的环境变量值会报告来源
+func TestConfigOptions_InvalidEnv(t *testing.T) {
+	fs := newFlagSet("download")
+	opts := registerConfigFlags(fs, true)
+	opts.configPath = filepath.Join(t.TempDir(), "missing.json")
+	opts.environ = func() []string { return []string{"TBD_CHUNK_SIZE=abc"} }
+	if _, err := opts.resolve(); err == nil {
+		t.Errorf("Expected error for invalid TBD_CHUNK_SIZE")
+	}
+}
+
+// environList 将map转换为os.Environ格式
+func environList(env map[string]string) []string {
+	var list []string
+	for name, value := range env {
+		list = append(list, name+"="+value)
+	}
+	return list
+}
+
+// TestEnvSettings 测试请求头变量和从文件读取的变量
+func TestEnvSettings(t *testing.T) {
+	dir := t.TempDir()
+	authFile := filepath.Join(dir, "auth")
+	if err := os.WriteFile(authFile, []byte("file-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	dirFile := filepath.Join(dir, "dir")
+	if err := os.WriteFile(dirFile, []byte("/secret/dir"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		env      []string
+		key      string
+		expected string
+		origin   string
+	}{
+		{"header name", []string{"TBD_HEADER_X_ND_AUTH=abc"}, "header.X-Nd-Auth", "abc", "TBD_HEADER_X_ND_AUTH"},
+		{"header name case", []string{"TBD_HEADER_user_agent=ua"}, "header.User-Agent", "ua", "TBD_HEADER_user_agent"},
+		{"auth file", []string{"TBD_AUTH_FILE=" + authFile}, "header.X-Nd-Auth", "file-token", "TBD_AUTH_FILE"},
+		{"header file", []string{"TBD_HEADER_X_ND_AUTH_FILE=" + authFile}, "header.X-Nd-Auth", "file-token", "TBD_HEADER_X_ND_AUTH_FILE"},
+		{"field file", []string{"TBD_OUTPUT_DIR_FILE=" + dirFile}, "output_dir", "/secret/dir", "TBD_OUTPUT_DIR_FILE"},
+		{"direct value wins over file", []string{"TBD_OUTPUT_DIR=/direct", "TBD_OUTPUT_DIR_FILE=" + dirFile}, "output_dir", "/direct", "TBD_OUTPUT_DIR"},
+		{"header overrides auth file", []string{"TBD_AUTH_FILE=" + authFile, "TBD_HEADER_X_ND_AUTH=abc"}, "header.X-Nd-Auth", "abc", "TBD_HEADER_X_ND_AUTH"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings, err := envSettings(append(tt.env, "HOME=/root", "OTHER_TIMEOUT=1"))
+			if err != nil {
+				t.Fatalf("envSettings failed: %v", err)
+			}
+			resolved, err := resolveConfig(settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			value, _ := getConfigValue(resolved.Config, tt.key)
+			if value != tt.expected {
+				t.Errorf("Expected %s = %q, got %q", tt.key, tt.expected, value)
+			}
+			if origin := resolved.Sources[tt.key].Origin; origin != tt.origin {
+				t.Errorf("Expected origin %s, got %s", tt.origin, origin)
+			}
+		})
+	}
+
+	if _, err := envSettings([]string{"TBD_AUTH_FILE=" + filepath.Join(dir, "missing")}); err == nil {
+		t.Errorf("Expected error for missing TBD_AUTH_FILE")
+	}
+}
+
+// TestWebServer_EnvSecretsNotPersisted 测试Web模式保存配置时不写入来自环境变量的令牌
+func TestWebServer_EnvSecretsNotPersisted(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(configPath, []byte(`{"timeout":"1m","headers":{"X-Nd-Auth":"file-token"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := newFlagSet("serve")
+	opts := registerConfigFlags(fs, false)
+	opts.environ = func() []string { return []string{"TBD_HEADER_X_ND_AUTH=env-token", "TBD_HEADER_X_ENV=1"} }
+	if err := fs.Parse([]string{"-config", configPath}); err != nil {
+		t.Fatal(err)
+	}
+	resolved, err := opts.resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws := NewWebServer(resolved, configPath)
+
+	config := ws.currentConfig().Copy()
+	config.Timeout = "2m"
+	if err := ws.replaceConfig(config); err != nil {
+		t.Fatalf("replaceConfig failed: %v", err)
+	}
+	saved, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Timeout != "2m" {
+		t.Errorf("Expected edited timeout to be saved, got %s", saved.Timeout)
+	}
+	if token := saved.Headers["X-Nd-Auth"]; token != "file-token" {
+		t.Errorf("Expected file token to be kept, got %q", token)
+	}
+	if _, ok := saved.Headers["X-Env"]; ok {
+		t.Errorf("Env-only header was written to the config file")
+	}
+	if token := ws.currentConfig().Headers["X-Nd-Auth"]; token != "env-token" {
+		t.Errorf("Expected env token to stay effective, got %q", token)
+	}
+
+	if err := ws.resetConfig(); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ = LoadConfig(configPath)
+	if _, ok := saved.Headers["X-Nd-Auth"]; ok {
+		t.Errorf("Reset wrote the env token to the config file")
+	}
+	if token := ws.currentConfig().Headers["X-Nd-Auth"]; token != "env-token" {
+		t.Errorf("Expected env token to survive reset, got %q", token)
+	}
+}
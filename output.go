@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 输出格式
+const (
+	outputText = "text" // 进度条和中文提示，输出到标准错误
+	outputJSON = "json" // 每行一个JSON事件（NDJSON），输出到标准输出
+)
+
+// 命令行下载事件类型
+const (
+	cliEventStarted   = "started"
+	cliEventProbe     = "probe"
+	cliEventProgress  = "progress"
+	cliEventRetry     = "retry"
+	cliEventCompleted = "completed"
+	cliEventFailed    = "failed"
+	cliEventBook      = "book"
+	cliEventMerged    = "merged"
+	cliEventOutline   = "outline"
+)
+
+// retryDelay 第一次重试前的等待时间，之后每次翻倍
+var retryDelay = time.Second
+
+// downloadFlags download和batch命令共用的输出和重试参数
+type downloadFlags struct {
+	output  string
+	quiet   bool
+	retries int
+	probe   bool
+	assets  bool
+}
+
+// registerDownloadFlags 注册输出和重试参数
+func registerDownloadFlags(fs *flag.FlagSet) *downloadFlags {
+	df := &downloadFlags{}
+	fs.StringVar(&df.output, "output", outputText, "输出格式: text 或 json（每行一个JSON事件，输出到标准输出）")
+	fs.BoolVar(&df.quiet, "quiet", false, "不输出下载信息，只通过退出码表示结果")
+	fs.IntVar(&df.retries, "retries", 2, "网络错误或服务器暂时不可用时的重试次数")
+	fs.BoolVar(&df.probe, "probe", false, "下载前先探测文件信息，服务器返回网页或磁盘空间不足时不开始下载")
+	fs.BoolVar(&df.assets, "assets", false, "下载平台教材时，把封面和配套资源下载到教材旁边的同名文件夹，并写入 book.json")
+	return df
+}
+
+// validate 检查参数取值
+func (df *downloadFlags) validate() error {
+	if df.output != outputText && df.output != outputJSON {
+		return fmt.Errorf("-output 只能是 %s 或 %s", outputText, outputJSON)
+	}
+	if df.retries < 0 {
+		return errors.New("-retries 不能小于0")
+	}
+	return nil
+}
+
+// reporter 返回按参数输出事件的reporter
+func (df *downloadFlags) reporter() *reporter {
+	return &reporter{format: df.output, quiet: df.quiet, stdout: os.Stdout, stderr: os.Stderr}
+}
+
+// cliEvent JSON输出模式下的事件，字段含义见各事件类型
+type cliEvent struct {
+	Event      string       `json:"event"`
+	Time       time.Time    `json:"time"`
+	URL        string       `json:"url"`
+	Output     string       `json:"output,omitempty"`
+	ResumeFrom int64        `json:"resume_from,omitempty"` // started：已下载的字节数，将继续下载
+	Downloaded int64        `json:"downloaded,omitempty"`
+	Total      int64        `json:"total,omitempty"`
+	Percent    float64      `json:"percent,omitempty"`
+	SpeedBPS   float64      `json:"speed_bps,omitempty"`   // progress：最近几秒的平均速度，字节每秒
+	ETASeconds int64        `json:"eta_seconds,omitempty"` // progress：预计剩余秒数，-1表示未知
+	Elapsed    float64      `json:"elapsed,omitempty"`     // progress：本次下载已用的秒数
+	Attempt    int          `json:"attempt,omitempty"`     // retry：第几次重试
+	DelayMS    int64        `json:"delay_ms,omitempty"`    // retry：重试前等待的毫秒数
+	Error      *cliError    `json:"error,omitempty"`       // retry、failed：失败原因
+	Probe      *ProbeResult `json:"probe,omitempty"`       // probe：探测到的文件信息
+}
+
+// cliError 事件中的错误信息
+type cliError struct {
+	Code    string `json:"code"` // 错误码，见 ErrCode* 常量
+	Message string `json:"message"`
+	Status  int    `json:"status,omitempty"` // HTTP状态码
+}
+
+// newCLIError 根据错误创建事件中的错误信息，隐藏其中的敏感请求头
+func newCLIError(err error, config *Config) *cliError {
+	cerr := &cliError{Code: errorCode(err), Message: config.RedactString(err.Error())}
+	var derr *DownloadError
+	if errors.As(err, &derr) {
+		cerr.Status = derr.Status
+	}
+	return cerr
+}
+
+// reporter 输出命令行下载过程中的事件。文本模式输出到标准错误，标准输出只留给JSON事件和查询结果。
+// 批量下载时多个协程共用，输出按事件整体加锁
+type reporter struct {
+	mu     sync.Mutex
+	format string
+	quiet  bool
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// emit 输出一个事件
+func (r *reporter) emit(evt cliEvent) {
+	if r.quiet {
+		return
+	}
+	evt.Time = time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.format == outputJSON {
+		encoder := json.NewEncoder(r.stdout)
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(evt)
+		return
+	}
+
+	switch evt.Event {
+	case cliEventStarted:
+		fmt.Fprintf(r.stderr, "开始下载 %s\n", evt.URL)
+		if evt.ResumeFrom > 0 {
+			fmt.Fprintf(r.stderr, "发现已下载 %d bytes，将继续下载...\n", evt.ResumeFrom)
+		}
+	case cliEventProbe:
+		printProbe(r.stderr, evt.Probe)
+	case cliEventProgress:
+		printProgress(r.stderr, filepath.Base(evt.Output), downloadStats{
+			Downloaded: evt.Downloaded, Total: evt.Total, Percent: evt.Percent,
+			SpeedBPS: evt.SpeedBPS, ETASeconds: evt.ETASeconds,
+		})
+	case cliEventRetry:
+		fmt.Fprintf(r.stderr, "\n下载失败：%s，%v 后进行第 %d 次重试...\n", evt.Error.Message, time.Duration(evt.DelayMS)*time.Millisecond, evt.Attempt)
+	case cliEventCompleted:
+		fmt.Fprintf(r.stderr, "\n下载完成！文件保存至：%s\n", evt.Output)
+	case cliEventFailed:
+		fmt.Fprintf(r.stderr, "\n下载 %s 失败：%s\n", evt.URL, evt.Error.Message)
+	case cliEventBook:
+		fmt.Fprintf(r.stderr, "教材 %s 的文件清单已保存至：%s\n", evt.URL, evt.Output)
+	case cliEventMerged:
+		fmt.Fprintf(r.stderr, "教材 %s 的分册已合并为：%s\n", evt.URL, evt.Output)
+	case cliEventOutline:
+		fmt.Fprintf(r.stderr, "已根据章节目录为 %s 生成书签\n", evt.Output)
+	}
+}
+
+// printf 在文本模式下向标准错误输出提示信息，JSON模式和静默模式下忽略
+func (r *reporter) printf(format string, args ...interface{}) {
+	if r.quiet || r.format == outputJSON {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Fprintf(r.stderr, format, args...)
+}
+
+// failed 输出失败事件
+func (r *reporter) failed(url string, err error, config *Config) {
+	r.emit(cliEvent{Event: cliEventFailed, URL: url, Error: newCLIError(err, config)})
+}
+
+// printProbe 输出探测到的文件信息
+func printProbe(w io.Writer, p *ProbeResult) {
+	size, free := "未知", "未知"
+	if p.Size >= 0 {
+		size = formatSize(p.Size)
+	}
+	if p.FreeSpace >= 0 {
+		free = formatSize(p.FreeSpace)
+	}
+	resume := "否"
+	if p.AcceptRanges {
+		resume = "是"
+	}
+	fmt.Fprintf(w, "文件大小：%s，类型：%s，支持断点续传：%s，可用空间：%s\n", size, p.ContentType, resume, free)
+	if p.FinalURL != p.URL {
+		fmt.Fprintf(w, "重定向到：%s\n", p.FinalURL)
+	}
+}
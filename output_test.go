@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// decodeEvents 解析NDJSON格式的事件
+func decodeEvents(t *testing.T, data []byte) []cliEvent {
+	t.Helper()
+	var events []cliEvent
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for decoder.More() {
+		var evt cliEvent
+		if err := decoder.Decode(&evt); err != nil {
+			t.Fatalf("Invalid JSON event: %v\n%s", err, data)
+		}
+		events = append(events, evt)
+	}
+	return events
+}
+
+// TestDownloadOne_JSONOutput 测试JSON输出模式下的事件序列和重试
+func TestDownloadOne_JSONOutput(t *testing.T) {
+	oldDelay := retryDelay
+	retryDelay = time.Millisecond
+	defer func() { retryDelay = oldDelay }()
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/missing.pdf":
+			http.NotFound(w, r)
+		case r.URL.Path == "/flaky.pdf" && atomic.AddInt32(&requests, 1) == 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte("%PDF-1.4 test"))
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	config := &Config{OutputDir: dir, Timeout: "10s", ChunkSize: 1024, Headers: map[string]string{"X-Nd-Auth": "secret-token"}}
+	var stdout, stderr bytes.Buffer
+	out := &reporter{format: outputJSON, stdout: &stdout, stderr: &stderr}
+
+	if _, err := downloadOne(config, server.URL+"/flaky.pdf", &downloadFlags{retries: 2}, out); err != nil {
+		t.Fatalf("downloadOne failed: %v", err)
+	}
+	events := decodeEvents(t, stdout.Bytes())
+	var types []string
+	for _, evt := range events {
+		types = append(types, evt.Event)
+	}
+	got := strings.Join(types, ",")
+	if !strings.HasPrefix(got, "started,retry,") || !strings.HasSuffix(got, ",completed") {
+		t.Fatalf("Unexpected event sequence %s", got)
+	}
+	retry := events[1]
+	if retry.Attempt != 1 || retry.Error == nil || retry.Error.Code != ErrCodeServerError || retry.Error.Status != 503 {
+		t.Errorf("Unexpected retry event %+v", retry)
+	}
+	last := events[len(events)-1]
+	if last.Output != filepath.Join(dir, "flaky.pdf") || last.Total != int64(len("%PDF-1.4 test")) || last.Percent != 100 {
+		t.Errorf("Unexpected completed event %+v", last)
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("JSON mode wrote to stderr: %s", stderr.String())
+	}
+
+	// 404不重试，直接失败
+	stdout.Reset()
+	if _, err := downloadOne(config, server.URL+"/missing.pdf", &downloadFlags{retries: 2}, out); err == nil {
+		t.Fatal("Expected error for missing file")
+	}
+	events = decodeEvents(t, stdout.Bytes())
+	if len(events) != 2 || events[1].Event != cliEventFailed {
+		t.Fatalf("Expected started and failed events, got %+v", events)
+	}
+	if cerr := events[1].Error; cerr.Code != ErrCodeNotFound || cerr.Status != 404 {
+		t.Errorf("Unexpected error %+v", cerr)
+	}
+	if strings.Contains(stdout.String(), "secret-token") {
+		t.Errorf("Token leaked in JSON output")
+	}
+}
+
+// TestDownloadOne_TextAndQuiet 测试文本模式只输出到标准错误，静默模式不输出
+func TestDownloadOne_TextAndQuiet(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("%PDF-1.4 test"))
+	}))
+	defer server.Close()
+	config := &Config{OutputDir: t.TempDir(), Timeout: "10s", ChunkSize: 1024}
+
+	var stdout, stderr bytes.Buffer
+	out := &reporter{format: outputText, stdout: &stdout, stderr: &stderr}
+	if _, err := downloadOne(config, server.URL+"/a.pdf", &downloadFlags{}, out); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.Len() != 0 || !strings.Contains(stderr.String(), "下载完成") {
+		t.Errorf("Unexpected text output, stdout %q, stderr %q", stdout.String(), stderr.String())
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	out = &reporter{format: outputJSON, quiet: true, stdout: &stdout, stderr: &stderr}
+	if _, err := downloadOne(config, server.URL+"/b.pdf", &downloadFlags{}, out); err != nil {
+		t.Fatal(err)
+	}
+	out.printf("summary")
+	if stdout.Len() != 0 || stderr.Len() != 0 {
+		t.Errorf("Quiet mode wrote output, stdout %q, stderr %q", stdout.String(), stderr.String())
+	}
+}
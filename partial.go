@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 下载中的文件先写入 <输出文件>.part，下载完成并写入磁盘后再重命名为输出文件，
+// 程序崩溃或断电时输出文件要么不存在要么是完整的。<输出文件>.part.json 记录下载地址和文件版本，
+// 断点续传时用于判断已下载的部分是否属于同一个文件
+const (
+	partialSuffix     = ".part"
+	partialMetaSuffix = ".part.json"
+)
+
+// 输出文件已存在时的处理方式（on_exists 配置项）
+const (
+	OnExistsSkip      = "skip"      // 跳过，认为已下载完成
+	OnExistsOverwrite = "overwrite" // 重新下载并替换
+	OnExistsRename    = "rename"    // 下载到新文件名，如 book (1).pdf
+	OnExistsVerify    = "verify"    // 与服务器上的大小一致且文件完整时跳过，否则重新下载
+)
+
+// 启动时发现上次遗留的未完成下载的处理方式（partial_files 配置项）
+const (
+	PartialResume = "resume" // 保留，Web界面和终端界面中显示为已暂停的任务，可以继续下载
+	PartialRemove = "remove" // 删除
+)
+
+// partialMinAge 最近修改过的未完成文件可能属于另一个正在运行的下载器，启动时不处理
+const partialMinAge = time.Minute
+
+// partialMeta 未完成下载的记录
+type partialMeta struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Total        int64     `json:"total"` // 文件大小，未知时为-1
+	CreatedAt    time.Time `json:"created_at"`
+	// HLS下载已完成的分片数和这些分片写入的字节数，断点续传时从下一个分片开始
+	Segments     int   `json:"segments,omitempty"`
+	SegmentBytes int64 `json:"segment_bytes,omitempty"`
+}
+
+// partialPath 返回输出文件对应的未完成文件路径
+func partialPath(outputPath string) string {
+	return outputPath + partialSuffix
+}
+
+// partialMetaPath 返回输出文件对应的下载记录路径
+func partialMetaPath(outputPath string) string {
+	return outputPath + partialMetaSuffix
+}
+
+// readPartialMeta 读取下载记录，不存在或无法解析时返回nil
+func readPartialMeta(outputPath string) *partialMeta {
+	data, err := os.ReadFile(partialMetaPath(outputPath))
+	if err != nil {
+		return nil
+	}
+	var meta partialMeta
+	if json.Unmarshal(data, &meta) != nil || meta.URL == "" {
+		return nil
+	}
+	return &meta
+}
+
+// writePartialMeta 写入下载记录
+func writePartialMeta(outputPath string, meta *partialMeta) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(partialMetaPath(outputPath), data, 0644)
+}
+
+// ifRange 返回断点续传时 If-Range 使用的文件版本，文件在服务器上变化后服务器会返回完整文件。
+// 弱ETag不能用于 If-Range，此时使用修改时间
+func (meta *partialMeta) ifRange() string {
+	if meta.ETag != "" && !strings.HasPrefix(meta.ETag, "W/") {
+		return meta.ETag
+	}
+	return meta.LastModified
+}
+
+// resumableSize 返回上次未完成的下载中可以继续使用的字节数，下载记录中的地址与本次不同时为0
+func resumableSize(config *Config) int64 {
+	meta := readPartialMeta(config.OutputPath)
+	if meta == nil || meta.URL != config.URL {
+		return 0
+	}
+	info, err := os.Stat(partialPath(config.OutputPath))
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// removePartial 删除未完成的文件和下载记录
+func removePartial(outputPath string) {
+	os.Remove(partialPath(outputPath))
+	os.Remove(partialMetaPath(outputPath))
+}
+
+// finalizeDownload 把已下载完整的文件写入磁盘，重命名为输出文件并删除下载记录。
+// 重命名在同一目录内进行，是原子操作
+func finalizeDownload(f *os.File, outputPath string) error {
+	if err := f.Sync(); err != nil {
+		return downloadErrorf(ErrCodeFilesystem, "写入磁盘失败：%v", err)
+	}
+	if err := f.Close(); err != nil {
+		return downloadErrorf(ErrCodeFilesystem, "关闭文件失败：%v", err)
+	}
+	if err := os.Rename(partialPath(outputPath), outputPath); err != nil {
+		return downloadErrorf(ErrCodeFilesystem, "重命名文件失败：%v", err)
+	}
+	os.Remove(partialMetaPath(outputPath))
+	syncDir(filepath.Dir(outputPath))
+	return nil
+}
+
+// syncDir 把目录项的变化写入磁盘，确保重命名在断电后仍然有效。Windows不支持打开目录，忽略错误
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// uniqueOutputPath 输出文件已存在时在扩展名前加上序号，如 book (1).pdf，返回第一个未被使用的路径
+func uniqueOutputPath(outputPath string) string {
+	if !fileExists(outputPath) {
+		return outputPath
+	}
+	ext := filepath.Ext(outputPath)
+	base := strings.TrimSuffix(outputPath, ext)
+	for i := 1; ; i++ {
+		path := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if !fileExists(path) && !fileExists(partialPath(path)) {
+			return path
+		}
+	}
+}
+
+// fileExists 文件是否存在
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// skipExisting 按 on_exists 判断输出文件已存在时是否跳过下载，跳过时返回已有文件的信息，不跳过时返回nil。
+// rename 在生成任务配置时已经换成了新文件名，这里仍然存在说明文件是之后才出现的，按跳过处理以免覆盖
+func skipExisting(ctx context.Context, config *Config) (os.FileInfo, error) {
+	info, err := os.Stat(config.OutputPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, downloadErrorf(ErrCodeFilesystem, "无法读取输出文件：%v", err)
+	}
+	if info.IsDir() {
+		return nil, downloadErrorf(ErrCodeFilesystem, "输出路径 %s 是一个目录", config.OutputPath)
+	}
+	switch config.OnExists {
+	case OnExistsOverwrite:
+		return nil, nil
+	case OnExistsVerify:
+		if ok, err := verifyExisting(ctx, config, info.Size()); !ok || err != nil {
+			return nil, err
+		}
+	}
+	return info, nil
+}
+
+// reportExisting 跳过下载时把已有文件作为已完成的进度报告一次
+func reportExisting(info os.FileInfo, progressCallback func(stats downloadStats)) {
+	if progressCallback != nil {
+		now := time.Now()
+		progressCallback(newProgressTracker(now, info.Size()).stats(now, info.Size(), info.Size()))
+	}
+}
+
+// verifyExisting 检查已存在的输出文件是否完整：大小与服务器上的一致，PDF文件还要有文件头和结束标记
+func verifyExisting(ctx context.Context, config *Config, size int64) (bool, error) {
+	// HLS合并后的大小无法从服务器得知，只要求文件不为空
+	if isHLS(config.URL) {
+		return size > 0, nil
+	}
+	// 分册合并生成的文件没有服务器地址，只检查文件是否完整
+	if config.URL == "" {
+		return isCompletePDF(config.OutputPath, size), nil
+	}
+	result, err := probeFile(ctx, config)
+	if err != nil {
+		return false, err
+	}
+	isPDF := strings.EqualFold(filepath.Ext(config.OutputPath), ".pdf")
+	if result.Size >= 0 && result.Size != size {
+		// 生成书签后PDF末尾追加了增量更新，原文件的部分与服务器上的大小一致即可
+		return isPDF && size > result.Size && isCompletePDF(config.OutputPath, result.Size), nil
+	}
+	if isPDF {
+		return isCompletePDF(config.OutputPath, size), nil
+	}
+	return true, nil
+}
+
+// isCompletePDF 文件以 %PDF- 开头，并且前size字节的最后1KB内有 %%EOF 结束标记
+func isCompletePDF(path string, size int64) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, 5)
+	if _, err := io.ReadFull(f, head); err != nil || string(head) != "%PDF-" {
+		return false
+	}
+	tail := make([]byte, min(size, 1024))
+	if _, err := f.ReadAt(tail, size-int64(len(tail))); err != nil {
+		return false
+	}
+	return bytes.Contains(tail, []byte("%%EOF"))
+}
+
+// partialDownload 启动时发现的未完成下载
+type partialDownload struct {
+	OutputPath string
+	Meta       *partialMeta
+	Downloaded int64
+}
+
+// recoverPartials 处理输出目录中上次遗留的未完成下载。policy为 remove 时全部删除，否则返回可以继续下载的记录。
+// 输出目录中可能有用户自己的文件，只处理有本程序写入的下载记录的文件，其他 .part 文件保持不变。
+// 最近修改过的文件可能正在被另一个下载器写入，不做处理
+func recoverPartials(dir, policy string, now time.Time) ([]partialDownload, error) {
+	var found []partialDownload
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir && os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, partialSuffix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || now.Sub(info.ModTime()) < partialMinAge {
+			return nil
+		}
+		outputPath := strings.TrimSuffix(path, partialSuffix)
+		meta := readPartialMeta(outputPath)
+		if meta == nil {
+			return nil
+		}
+		if policy == PartialRemove {
+			removePartial(outputPath)
+			return nil
+		}
+		found = append(found, partialDownload{OutputPath: outputPath, Meta: meta, Downloaded: info.Size()})
+		return nil
+	})
+	return found, err
+}
+
+// adoptPartials 启动Web界面或终端界面时处理输出目录中上次未完成的下载，可以继续的加入任务列表。
+// 返回加入的任务数
+func adoptPartials(tm *TaskManager, config *Config, profile string) (int, error) {
+	partials, err := recoverPartials(config.OutputDir, config.PartialFiles, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("无法检查未完成的下载: %v", err)
+	}
+	for _, p := range partials {
+		taskConfig := config.Copy()
+		taskConfig.URL = p.Meta.URL
+		taskConfig.OutputPath = p.OutputPath
+		tm.Adopt(taskConfig, profile, p.Downloaded, p.Meta.Total)
+	}
+	return len(partials), nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writePartial 写入上次未完成的下载：.part 文件和对应的下载记录
+func writePartial(t *testing.T, outputPath, url string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(partialPath(outputPath), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writePartialMeta(outputPath, &partialMeta{URL: url, Total: -1, CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testPDF 返回有完整文件头和结束标记的PDF内容
+func testPDF() []byte {
+	return append(append([]byte("%PDF-1.4\n"), bytes.Repeat([]byte("0123456789"), 100)...), "\n%%EOF\n"...)
+}
+
+// TestDownloadPDF_PartialFile 测试下载完成前只写入 .part 文件，完成后重命名并删除下载记录
+func TestDownloadPDF_PartialFile(t *testing.T) {
+	data := testPDF()
+	path := filepath.Join(t.TempDir(), "book.pdf")
+	var checked bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method != http.MethodGet {
+			return
+		}
+		w.Write(data[:600])
+		w.(http.Flusher).Flush()
+		// 等到前一半写入 .part 文件后检查输出文件还不存在
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if info, err := os.Stat(partialPath(path)); err == nil && info.Size() == 600 {
+				checked = !fileExists(path) && readPartialMeta(path) != nil
+				break
+			}
+		}
+		w.Write(data[600:])
+	}))
+	defer server.Close()
+
+	config := Config{URL: server.URL + "/book.pdf", OutputPath: path, ChunkSize: 100}
+	if err := downloadPDFWithProgress(context.Background(), config, nil); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if !checked {
+		t.Errorf("Expected only the .part file and meta while downloading")
+	}
+	if content, _ := os.ReadFile(path); !bytes.Equal(content, data) {
+		t.Errorf("Unexpected content")
+	}
+	if fileExists(partialPath(path)) || fileExists(partialMetaPath(path)) {
+		t.Errorf("Partial file and meta should be removed after completion")
+	}
+}
+
+// TestDownloadPDF_PartialChanged 测试服务器上的文件变化或地址不同时丢弃已下载的部分
+func TestDownloadPDF_PartialChanged(t *testing.T) {
+	data := testPDF()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v2"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	download := func(path string) {
+		t.Helper()
+		config := Config{URL: server.URL + "/book.pdf", OutputPath: path, ChunkSize: 4096}
+		if err := downloadPDFWithProgress(context.Background(), config, nil); err != nil {
+			t.Fatalf("Download failed: %v", err)
+		}
+		if content, _ := os.ReadFile(path); !bytes.Equal(content, data) {
+			t.Errorf("%s: expected the whole file to be downloaded again, got %q", filepath.Base(path), content)
+		}
+	}
+
+	// 记录的ETag与服务器不同，If-Range 不匹配时服务器返回完整文件
+	changed := filepath.Join(dir, "changed.pdf")
+	if err := os.WriteFile(partialPath(changed), []byte("stale data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writePartialMeta(changed, &partialMeta{URL: server.URL + "/book.pdf", ETag: `"v1"`, Total: int64(len(data))})
+	download(changed)
+
+	// 下载记录中的地址不同
+	other := filepath.Join(dir, "other.pdf")
+	writePartial(t, other, server.URL+"/other.pdf", []byte("stale data"))
+	download(other)
+}
+
+// TestSkipExisting 测试输出文件已存在时的各种处理方式
+func TestSkipExisting(t *testing.T) {
+	data := testPDF()
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			requests++
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "book.pdf")
+	download := func(onExists string, existing []byte) []byte {
+		t.Helper()
+		if err := os.WriteFile(path, existing, 0644); err != nil {
+			t.Fatal(err)
+		}
+		requests = 0
+		config := Config{URL: server.URL + "/book.pdf", OutputPath: path, ChunkSize: 4096, OnExists: onExists}
+		if err := downloadPDFWithProgress(context.Background(), config, nil); err != nil {
+			t.Fatalf("%s: download failed: %v", onExists, err)
+		}
+		content, _ := os.ReadFile(path)
+		return content
+	}
+
+	if content := download(OnExistsSkip, []byte("old")); string(content) != "old" || requests != 0 {
+		t.Errorf("skip: file should be kept without downloading, got %q after %d requests", content, requests)
+	}
+	if content := download(OnExistsOverwrite, []byte("old")); !bytes.Equal(content, data) {
+		t.Errorf("overwrite: expected new content, got %q", content)
+	}
+	if content := download(OnExistsVerify, data); !bytes.Equal(content, data) || requests != 0 {
+		t.Errorf("verify: complete file should be kept, got %d requests", requests)
+	}
+	// 大小一致但缺少结束标记的PDF重新下载
+	truncated := append([]byte{}, data...)
+	copy(truncated[len(truncated)-7:], "0000000")
+	if content := download(OnExistsVerify, truncated); !bytes.Equal(content, data) || requests != 1 {
+		t.Errorf("verify: incomplete file should be downloaded again, got %d requests", requests)
+	}
+
+	// rename 在生成任务配置时换用新文件名
+	writePartial(t, filepath.Join(dir, "book (1).pdf"), server.URL+"/x.pdf", nil)
+	config := newTaskConfig(&Config{OutputDir: dir, OnExists: OnExistsRename}, server.URL+"/book.pdf")
+	if want := filepath.Join(dir, "book (2).pdf"); config.OutputPath != want {
+		t.Errorf("rename: expected %s, got %s", want, config.OutputPath)
+	}
+}
+
+// TestRecoverPartials 测试启动时处理上次遗留的未完成下载
+func TestRecoverPartials(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	old := now.Add(-time.Hour)
+	create := func(name string, withMeta bool, modTime time.Time) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if withMeta {
+			writePartial(t, path, "https://example.com/"+name, []byte("12345"))
+		} else if err := os.WriteFile(partialPath(path), []byte("12345"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(partialPath(path), modTime, modTime)
+		return path
+	}
+	adopted := create(filepath.Join("sub", "a.pdf"), true, old)
+	orphan := create("b.pdf", false, old)
+	recent := create("c.pdf", true, now)
+
+	found, err := recoverPartials(dir, PartialResume, now)
+	if err != nil {
+		t.Fatalf("recoverPartials failed: %v", err)
+	}
+	if len(found) != 1 || found[0].OutputPath != adopted || found[0].Downloaded != 5 ||
+		found[0].Meta.URL != "https://example.com/"+filepath.Join("sub", "a.pdf") {
+		t.Errorf("Unexpected partials: %+v", found)
+	}
+	if !fileExists(partialPath(orphan)) {
+		t.Errorf("Partial file without meta should be kept")
+	}
+	if !fileExists(partialPath(recent)) {
+		t.Errorf("Recently modified partial file should be kept")
+	}
+
+	found, _ = recoverPartials(dir, PartialRemove, now)
+	if len(found) != 0 || fileExists(partialPath(adopted)) || fileExists(partialMetaPath(adopted)) {
+		t.Errorf("Partial files should be removed with policy remove")
+	}
+	// 不是本程序创建的文件在任何策略下都不删除
+	if !fileExists(partialPath(orphan)) {
+		t.Errorf("Partial file without meta should be kept with policy remove")
+	}
+
+	if found, err := recoverPartials(filepath.Join(dir, "missing"), PartialResume, now); err != nil || len(found) != 0 {
+		t.Errorf("Missing output directory should be ignored, got %v %v", found, err)
+	}
+}
+
+// TestAdoptPartials 测试未完成的下载加入任务列表后可以继续下载
+func TestAdoptPartials(t *testing.T) {
+	data := testPDF()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "book.pdf")
+	writePartial(t, path, server.URL+"/book.pdf", data[:300])
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(partialPath(path), old, old)
+
+	tm := NewTaskManager(nil)
+	config := &Config{OutputDir: dir, Timeout: "10s", ChunkSize: 1024, PartialFiles: PartialResume}
+	n, err := adoptPartials(tm, config, "")
+	if err != nil || n != 1 {
+		t.Fatalf("Expected 1 adopted task, got %d %v", n, err)
+	}
+	tasks := tm.List()
+	if len(tasks) != 1 || tasks[0].Status != TaskStatusPaused || tasks[0].Downloaded != 300 || tasks[0].OutputPath != path {
+		t.Fatalf("Unexpected adopted task: %+v", tasks)
+	}
+	if _, err := tm.Resume(tasks[0].TaskID); err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	waitTaskStatus(t, tm, tasks[0].TaskID, TaskStatusCompleted)
+	if content, _ := os.ReadFile(path); !bytes.Equal(content, data) {
+		t.Errorf("Unexpected content after resuming")
+	}
+}
+
+// TestNewWebServer_NoPartialAdoption 测试创建Web服务时不处理输出目录中的文件，启动服务时才处理未完成的下载
+func TestNewWebServer_NoPartialAdoption(t *testing.T) {
+	dir := t.TempDir()
+	writePartial(t, filepath.Join(dir, "stray.pdf"), "https://example.com/stray.pdf", []byte("%PDF-1.4"))
+	stray := partialPath(filepath.Join(dir, "stray.pdf"))
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(stray, old, old)
+
+	config := getDefaultConfig()
+	config.OutputDir, config.PartialFiles = dir, PartialRemove
+	ws := NewWebServer(&ResolvedConfig{Config: config, Profile: defaultProfileName}, filepath.Join(dir, "config.json"))
+	if !fileExists(stray) || len(ws.tasks.List()) != 0 {
+		t.Error("Expected construction to leave partial files untouched")
+	}
+}
+
+// TestValidate_PartialPolicies 测试 on_exists 和 partial_files 的取值校验
+func TestValidate_PartialPolicies(t *testing.T) {
+	config := getDefaultConfig()
+	config.OnExists = "replace"
+	config.PartialFiles = "keep"
+	err := config.validate(func(string) bool { return true })
+	if err == nil || !strings.Contains(err.Error(), "on_exists") || !strings.Contains(err.Error(), "partial_files") {
+		t.Errorf("Expected validation errors for both fields, got %v", err)
+	}
+}
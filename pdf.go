@@ -0,0 +1,1130 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// 纯Go实现的PDF读取，供合并、生成书签和提取文字使用。支持传统交叉引用表、交叉引用流、对象流和增量更新，
+// 交叉引用损坏时扫描整个文件重建。不支持加密的PDF
+
+// pdfObject PDF对象：nil（null）、bool、int64、float64、pdfName、pdfString、pdfArray、pdfDict、pdfRef、*pdfStream，
+// 解析内容流时还有pdfKeyword（操作符）
+type pdfObject interface{}
+
+// pdfName 名称对象，不含开头的 /
+type pdfName string
+
+// pdfString 字符串对象的原始字节
+type pdfString string
+
+// pdfKeyword 不属于其他类型的关键字，如内容流中的操作符
+type pdfKeyword string
+
+// pdfArray 数组对象
+type pdfArray []pdfObject
+
+// pdfDict 字典对象
+type pdfDict map[pdfName]pdfObject
+
+// pdfRef 间接对象的引用
+type pdfRef struct {
+	Num int
+	Gen int
+}
+
+// pdfStream 流对象，Data是未解码的原始数据
+type pdfStream struct {
+	Dict pdfDict
+	Data []byte
+}
+
+// errPDFSyntax PDF语法错误
+var errPDFSyntax = errors.New("PDF格式错误")
+
+// maxPDFDepth 嵌套数组和字典的最大层数，防止损坏的文件导致栈溢出
+const maxPDFDepth = 256
+
+// pdfParser 词法和语法分析，data可以是整个PDF文件或解码后的内容流
+type pdfParser struct {
+	data []byte
+	pos  int
+	// streamLength 解析流对象时获取 /Length 的值，Length是间接对象时需要通过文件解析
+	streamLength func(v pdfObject) (int, bool)
+}
+
+// isPDFSpace 是否是PDF中的空白字符
+func isPDFSpace(c byte) bool {
+	switch c {
+	case 0, '\t', '\n', '\f', '\r', ' ':
+		return true
+	}
+	return false
+}
+
+// isPDFDelimiter 是否是PDF中的分隔符
+func isPDFDelimiter(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+// skipSpace 跳过空白和注释
+func (p *pdfParser) skipSpace() {
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		switch {
+		case isPDFSpace(c):
+			p.pos++
+		case c == '%':
+			for p.pos < len(p.data) && p.data[p.pos] != '\r' && p.data[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// regularToken 读取由普通字符组成的记号（数字、关键字）
+func (p *pdfParser) regularToken() []byte {
+	start := p.pos
+	for p.pos < len(p.data) && !isPDFSpace(p.data[p.pos]) && !isPDFDelimiter(p.data[p.pos]) {
+		p.pos++
+	}
+	return p.data[start:p.pos]
+}
+
+// parseObject 解析下一个对象，数据结束时返回io.EOF
+func (p *pdfParser) parseObject() (pdfObject, error) {
+	return p.parse(0)
+}
+
+func (p *pdfParser) parse(depth int) (pdfObject, error) {
+	if depth > maxPDFDepth {
+		return nil, fmt.Errorf("%w：嵌套层数过多", errPDFSyntax)
+	}
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil, io.EOF
+	}
+	switch c := p.data[p.pos]; {
+	case c == '/':
+		p.pos++
+		return p.parseName(), nil
+	case c == '(':
+		p.pos++
+		return p.parseLiteralString()
+	case c == '<' && p.pos+1 < len(p.data) && p.data[p.pos+1] == '<':
+		p.pos += 2
+		dict, err := p.parseDict(depth)
+		if err != nil {
+			return nil, err
+		}
+		return p.maybeStream(dict)
+	case c == '<':
+		p.pos++
+		return p.parseHexString()
+	case c == '[':
+		p.pos++
+		var arr pdfArray
+		for {
+			p.skipSpace()
+			if p.pos >= len(p.data) {
+				return nil, fmt.Errorf("%w：数组没有结束", errPDFSyntax)
+			}
+			if p.data[p.pos] == ']' {
+				p.pos++
+				return arr, nil
+			}
+			v, err := p.parse(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+	case c == ']' || c == '>' || c == ')' || c == '{' || c == '}':
+		// 不成对的分隔符，作为关键字返回，由调用方决定如何处理
+		p.pos++
+		return pdfKeyword(c), nil
+	}
+
+	start := p.pos
+	token := p.regularToken()
+	if len(token) == 0 {
+		p.pos++
+		return nil, fmt.Errorf("%w：位置 %d 有无法识别的字符", errPDFSyntax, start)
+	}
+	switch string(token) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	if n, ok := parsePDFInt(token); ok {
+		// 整数后面跟着“整数 R”时是间接引用
+		save := p.pos
+		p.skipSpace()
+		gen, ok := parsePDFInt(p.regularToken())
+		if ok {
+			p.skipSpace()
+			if kw := p.regularToken(); string(kw) == "R" {
+				return pdfRef{Num: int(n), Gen: int(gen)}, nil
+			}
+		}
+		p.pos = save
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(string(token), 64); err == nil && (token[0] == '.' || token[0] == '-' || token[0] == '+' || (token[0] >= '0' && token[0] <= '9')) {
+		return f, nil
+	}
+	return pdfKeyword(token), nil
+}
+
+// parsePDFInt 解析整数记号
+func parsePDFInt(token []byte) (int64, bool) {
+	if len(token) == 0 {
+		return 0, false
+	}
+	for i, c := range token {
+		if (c < '0' || c > '9') && !(i == 0 && (c == '-' || c == '+')) {
+			return 0, false
+		}
+	}
+	n, err := strconv.ParseInt(string(token), 10, 64)
+	return n, err == nil
+}
+
+// parseName 解析名称，#xx 表示十六进制字符
+func (p *pdfParser) parseName() pdfName {
+	token := p.regularToken()
+	if bytes.IndexByte(token, '#') < 0 {
+		return pdfName(token)
+	}
+	var b []byte
+	for i := 0; i < len(token); i++ {
+		if token[i] == '#' && i+2 < len(token) {
+			if v, err := strconv.ParseUint(string(token[i+1:i+3]), 16, 8); err == nil {
+				b = append(b, byte(v))
+				i += 2
+				continue
+			}
+		}
+		b = append(b, token[i])
+	}
+	return pdfName(b)
+}
+
+// parseLiteralString 解析 (...) 字符串，处理转义和嵌套的括号
+func (p *pdfParser) parseLiteralString() (pdfObject, error) {
+	var b []byte
+	depth := 1
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return pdfString(b), nil
+			}
+		case '\r':
+			// 行尾统一为 \n
+			if p.pos < len(p.data) && p.data[p.pos] == '\n' {
+				p.pos++
+			}
+			c = '\n'
+		case '\\':
+			if p.pos >= len(p.data) {
+				continue
+			}
+			e := p.data[p.pos]
+			p.pos++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				// 续行
+				if p.pos < len(p.data) && p.data[p.pos] == '\n' {
+					p.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '7'; i++ {
+						v = v*8 + int(p.data[p.pos]-'0')
+						p.pos++
+					}
+					c = byte(v)
+				} else {
+					c = e
+				}
+			}
+		}
+		b = append(b, c)
+	}
+	return nil, fmt.Errorf("%w：字符串没有结束", errPDFSyntax)
+}
+
+// parseHexString 解析 <...> 十六进制字符串，奇数个数字时最后补0
+func (p *pdfParser) parseHexString() (pdfObject, error) {
+	var b []byte
+	var hi byte
+	odd := false
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+		if c == '>' {
+			if odd {
+				b = append(b, hi<<4)
+			}
+			return pdfString(b), nil
+		}
+		v, ok := hexValue(c)
+		if !ok {
+			continue
+		}
+		if odd {
+			b = append(b, hi<<4|v)
+		} else {
+			hi = v
+		}
+		odd = !odd
+	}
+	return nil, fmt.Errorf("%w：十六进制字符串没有结束", errPDFSyntax)
+}
+
+// hexValue 十六进制数字的值
+func hexValue(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// parseDict 解析字典，开头的 << 已读取
+func (p *pdfParser) parseDict(depth int) (pdfDict, error) {
+	dict := make(pdfDict)
+	for {
+		p.skipSpace()
+		if p.pos+1 < len(p.data) && p.data[p.pos] == '>' && p.data[p.pos+1] == '>' {
+			p.pos += 2
+			return dict, nil
+		}
+		if p.pos >= len(p.data) {
+			return nil, fmt.Errorf("%w：字典没有结束", errPDFSyntax)
+		}
+		key, err := p.parse(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(pdfName)
+		if !ok {
+			// 跳过不是名称的键，尽量读取损坏的字典
+			continue
+		}
+		value, err := p.parse(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if kw, ok := value.(pdfKeyword); ok && (kw == ">" || kw == "]") {
+			return nil, fmt.Errorf("%w：字典中 /%s 缺少值", errPDFSyntax, name)
+		}
+		dict[name] = value
+	}
+}
+
+// maybeStream 字典后面是 stream 关键字时读取流数据
+func (p *pdfParser) maybeStream(dict pdfDict) (pdfObject, error) {
+	save := p.pos
+	p.skipSpace()
+	if !bytes.HasPrefix(p.data[p.pos:], []byte("stream")) {
+		p.pos = save
+		return dict, nil
+	}
+	p.pos += len("stream")
+	// stream 后面是 CRLF 或 LF
+	if p.pos < len(p.data) && p.data[p.pos] == '\r' {
+		p.pos++
+	}
+	if p.pos < len(p.data) && p.data[p.pos] == '\n' {
+		p.pos++
+	}
+	start := p.pos
+
+	length := -1
+	if p.streamLength != nil {
+		if n, ok := p.streamLength(dict["Length"]); ok {
+			length = n
+		}
+	} else if n, ok := dict["Length"].(int64); ok {
+		length = int(n)
+	}
+	// 长度错误时查找 endstream
+	if length < 0 || start+length > len(p.data) || !isStreamEnd(p.data[start+length:]) {
+		end := bytes.Index(p.data[start:], []byte("endstream"))
+		if end < 0 {
+			return nil, fmt.Errorf("%w：流没有结束", errPDFSyntax)
+		}
+		length = end
+		// endstream 前的换行不属于数据
+		for length > 0 && (p.data[start+length-1] == '\n' || p.data[start+length-1] == '\r') {
+			length--
+		}
+	}
+	data := p.data[start : start+length]
+	p.pos = start + length
+	p.skipSpace()
+	if bytes.HasPrefix(p.data[p.pos:], []byte("endstream")) {
+		p.pos += len("endstream")
+	}
+	return &pdfStream{Dict: dict, Data: data}, nil
+}
+
+// isStreamEnd 数据之后（跳过空白）是否是 endstream
+func isStreamEnd(rest []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(rest, "\x00\t\n\f\r "), []byte("endstream"))
+}
+
+// pdfXRefEntry 交叉引用中的一项：对象在文件中的位置，或所在的对象流和序号
+type pdfXRefEntry struct {
+	Offset    int64
+	Gen       int
+	StreamNum int // 大于0时对象在对象流中
+	Index     int
+}
+
+// pdfFile 解析后的PDF文件
+type pdfFile struct {
+	data    []byte
+	xref    map[int]pdfXRefEntry
+	trailer pdfDict
+	// startXRef 最后一个交叉引用的位置，增量更新时作为 /Prev
+	startXRef int64
+	// xrefStream 最后一个交叉引用是否是交叉引用流，增量更新时使用相同的格式
+	xrefStream bool
+	cache      map[int]pdfObject
+	objStreams map[int][]pdfObject
+	rebuilt    bool
+}
+
+// pdfObjectHeader 匹配 "编号 代数 obj"
+var pdfObjectHeader = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+
+// openPDF 读取并解析PDF文件
+func openPDF(path string) (*pdfFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := parsePDF(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return f, nil
+}
+
+// parsePDF 解析PDF文件内容
+func parsePDF(data []byte) (*pdfFile, error) {
+	// 文件头可以在前1024字节内的任意位置
+	if !bytes.Contains(data[:min(len(data), 1024)], []byte("%PDF-")) {
+		return nil, errors.New("不是PDF文件")
+	}
+	f := &pdfFile{data: data, xref: make(map[int]pdfXRefEntry), cache: make(map[int]pdfObject), objStreams: make(map[int][]pdfObject)}
+	if err := f.readXRefChain(); err != nil {
+		// 交叉引用损坏，扫描全部对象重建
+		if err := f.rebuildXRef(); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := f.trailer["Encrypt"]; ok {
+		return nil, errors.New("不支持加密的PDF")
+	}
+	if _, err := f.catalog(); err != nil {
+		if f.rebuilt {
+			return nil, err
+		}
+		if err := f.rebuildXRef(); err != nil {
+			return nil, err
+		}
+		if _, err := f.catalog(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// readXRefChain 从 startxref 开始读取交叉引用，沿 /Prev 读取之前的版本，较新的条目优先
+func (f *pdfFile) readXRefChain() error {
+	tail := f.data[max(0, len(f.data)-2048):]
+	i := bytes.LastIndex(tail, []byte("startxref"))
+	if i < 0 {
+		return fmt.Errorf("%w：找不到 startxref", errPDFSyntax)
+	}
+	p := &pdfParser{data: tail, pos: i + len("startxref")}
+	p.skipSpace()
+	offset, ok := parsePDFInt(p.regularToken())
+	if !ok {
+		return fmt.Errorf("%w：startxref 无效", errPDFSyntax)
+	}
+	f.startXRef = offset
+
+	visited := make(map[int64]bool)
+	for first := true; ; first = false {
+		if offset <= 0 || offset >= int64(len(f.data)) || visited[offset] {
+			if first {
+				return fmt.Errorf("%w：交叉引用的位置无效", errPDFSyntax)
+			}
+			return nil
+		}
+		visited[offset] = true
+		trailer, isStream, err := f.readXRefSection(offset)
+		if err != nil {
+			return err
+		}
+		if first {
+			f.trailer = trailer
+			f.xrefStream = isStream
+		}
+		// 混合格式的文件在 /XRefStm 中有补充的交叉引用流
+		if stm, ok := trailer["XRefStm"].(int64); ok && !visited[stm] {
+			visited[stm] = true
+			if _, _, err := f.readXRefSection(stm); err != nil {
+				return err
+			}
+		}
+		prev, ok := trailer["Prev"].(int64)
+		if !ok {
+			return nil
+		}
+		offset = prev
+	}
+}
+
+// readXRefSection 读取一个交叉引用表或交叉引用流，返回trailer字典
+func (f *pdfFile) readXRefSection(offset int64) (pdfDict, bool, error) {
+	p := &pdfParser{data: f.data, pos: int(offset)}
+	p.skipSpace()
+	if bytes.HasPrefix(f.data[p.pos:], []byte("xref")) {
+		p.pos += len("xref")
+		trailer, err := f.readXRefTable(p)
+		return trailer, false, err
+	}
+
+	_, obj, err := f.parseIndirectAt(p.pos)
+	if err != nil {
+		return nil, false, err
+	}
+	stream, ok := obj.(*pdfStream)
+	if !ok || stream.Dict["Type"] != pdfName("XRef") {
+		return nil, false, fmt.Errorf("%w：位置 %d 不是交叉引用", errPDFSyntax, offset)
+	}
+	return stream.Dict, true, f.readXRefStream(stream)
+}
+
+// readXRefTable 读取传统的交叉引用表和其后的trailer
+func (f *pdfFile) readXRefTable(p *pdfParser) (pdfDict, error) {
+	for {
+		p.skipSpace()
+		if bytes.HasPrefix(p.data[p.pos:], []byte("trailer")) {
+			p.pos += len("trailer")
+			obj, err := p.parseObject()
+			if err != nil {
+				return nil, err
+			}
+			trailer, ok := obj.(pdfDict)
+			if !ok {
+				return nil, fmt.Errorf("%w：trailer 不是字典", errPDFSyntax)
+			}
+			return trailer, nil
+		}
+		start, ok1 := parsePDFInt(p.regularToken())
+		p.skipSpace()
+		count, ok2 := parsePDFInt(p.regularToken())
+		if !ok1 || !ok2 || start < 0 || count < 0 {
+			return nil, fmt.Errorf("%w：交叉引用表无效", errPDFSyntax)
+		}
+		for i := int64(0); i < count; i++ {
+			p.skipSpace()
+			off, ok1 := parsePDFInt(p.regularToken())
+			p.skipSpace()
+			gen, ok2 := parsePDFInt(p.regularToken())
+			p.skipSpace()
+			kind := p.regularToken()
+			if !ok1 || !ok2 || len(kind) != 1 {
+				return nil, fmt.Errorf("%w：交叉引用表的第 %d 项无效", errPDFSyntax, start+i)
+			}
+			num := int(start + i)
+			if _, seen := f.xref[num]; seen {
+				continue
+			}
+			if kind[0] == 'n' {
+				f.xref[num] = pdfXRefEntry{Offset: off, Gen: int(gen)}
+			} else {
+				// 已删除的对象，记录下来以免使用更早版本中的条目
+				f.xref[num] = pdfXRefEntry{Offset: -1}
+			}
+		}
+	}
+}
+
+// readXRefStream 读取交叉引用流中的条目
+func (f *pdfFile) readXRefStream(stream *pdfStream) error {
+	data, err := f.streamData(stream)
+	if err != nil {
+		return err
+	}
+	w, ok := f.resolve(stream.Dict["W"]).(pdfArray)
+	if !ok || len(w) != 3 {
+		return fmt.Errorf("%w：交叉引用流缺少 /W", errPDFSyntax)
+	}
+	var widths [3]int
+	for i := range widths {
+		n, _ := w[i].(int64)
+		if n < 0 || n > 8 {
+			return fmt.Errorf("%w：交叉引用流的 /W 无效", errPDFSyntax)
+		}
+		widths[i] = int(n)
+	}
+	rowLen := widths[0] + widths[1] + widths[2]
+	if rowLen == 0 {
+		return fmt.Errorf("%w：交叉引用流的 /W 无效", errPDFSyntax)
+	}
+	index, _ := f.resolve(stream.Dict["Index"]).(pdfArray)
+	if index == nil {
+		size, _ := f.resolve(stream.Dict["Size"]).(int64)
+		index = pdfArray{int64(0), size}
+	}
+
+	field := func(row []byte, i int, def int64) int64 {
+		start := 0
+		for j := 0; j < i; j++ {
+			start += widths[j]
+		}
+		if widths[i] == 0 {
+			return def
+		}
+		var v int64
+		for _, b := range row[start : start+widths[i]] {
+			v = v<<8 | int64(b)
+		}
+		return v
+	}
+	pos := 0
+	for i := 0; i+1 < len(index); i += 2 {
+		start, _ := index[i].(int64)
+		count, _ := index[i+1].(int64)
+		for j := int64(0); j < count; j++ {
+			if pos+rowLen > len(data) {
+				return fmt.Errorf("%w：交叉引用流的数据不完整", errPDFSyntax)
+			}
+			row := data[pos : pos+rowLen]
+			pos += rowLen
+			num := int(start + j)
+			if _, seen := f.xref[num]; seen {
+				continue
+			}
+			switch field(row, 0, 1) {
+			case 0:
+				f.xref[num] = pdfXRefEntry{Offset: -1}
+			case 1:
+				f.xref[num] = pdfXRefEntry{Offset: field(row, 1, 0), Gen: int(field(row, 2, 0))}
+			case 2:
+				f.xref[num] = pdfXRefEntry{StreamNum: int(field(row, 1, 0)), Index: int(field(row, 2, 0))}
+			}
+		}
+	}
+	return nil
+}
+
+// rebuildXRef 扫描整个文件中的 "n g obj" 重建交叉引用，同一编号取最后出现的对象
+func (f *pdfFile) rebuildXRef() error {
+	f.rebuilt = true
+	f.xref = make(map[int]pdfXRefEntry)
+	f.cache = make(map[int]pdfObject)
+	f.objStreams = make(map[int][]pdfObject)
+	f.xrefStream = false
+	var trailer pdfDict
+	for _, m := range pdfObjectHeader.FindAllSubmatchIndex(f.data, -1) {
+		// 编号前必须是空白或文件开头，排除数字的一部分
+		if m[0] > 0 && !isPDFSpace(f.data[m[0]-1]) && !isPDFDelimiter(f.data[m[0]-1]) {
+			continue
+		}
+		num, _ := strconv.Atoi(string(f.data[m[2]:m[3]]))
+		gen, _ := strconv.Atoi(string(f.data[m[4]:m[5]]))
+		f.xref[num] = pdfXRefEntry{Offset: int64(m[0]), Gen: gen}
+	}
+	// 对象流中的对象
+	for num := range f.xref {
+		obj, err := f.object(pdfRef{Num: num})
+		if err != nil {
+			continue
+		}
+		stream, ok := obj.(*pdfStream)
+		if !ok {
+			continue
+		}
+		switch stream.Dict["Type"] {
+		case pdfName("ObjStm"):
+			nums, _, err := f.objectStreamHeader(stream)
+			if err != nil {
+				continue
+			}
+			for i, n := range nums {
+				if _, ok := f.xref[n]; !ok {
+					f.xref[n] = pdfXRefEntry{StreamNum: num, Index: i}
+				}
+			}
+		case pdfName("XRef"):
+			if _, ok := stream.Dict["Root"]; ok {
+				trailer = stream.Dict
+			}
+		}
+	}
+	// 最后一个 trailer 字典
+	for i := len(f.data); ; {
+		j := bytes.LastIndex(f.data[:i], []byte("trailer"))
+		if j < 0 {
+			break
+		}
+		p := &pdfParser{data: f.data, pos: j + len("trailer")}
+		if obj, err := p.parseObject(); err == nil {
+			if dict, ok := obj.(pdfDict); ok && dict["Root"] != nil {
+				trailer = dict
+				break
+			}
+		}
+		i = j
+	}
+	if trailer == nil {
+		// 没有trailer时查找文档目录
+		for num := range f.xref {
+			if obj, err := f.object(pdfRef{Num: num}); err == nil {
+				if dict, ok := obj.(pdfDict); ok && dict["Type"] == pdfName("Catalog") {
+					trailer = pdfDict{"Root": pdfRef{Num: num, Gen: f.xref[num].Gen}}
+					break
+				}
+			}
+		}
+	}
+	if trailer == nil {
+		return fmt.Errorf("%w：找不到文档目录", errPDFSyntax)
+	}
+	f.trailer = trailer
+	return nil
+}
+
+// parseIndirectAt 解析位置offset处的 "n g obj ... endobj"
+func (f *pdfFile) parseIndirectAt(offset int) (pdfRef, pdfObject, error) {
+	p := &pdfParser{data: f.data, pos: offset, streamLength: f.streamLength}
+	p.skipSpace()
+	num, ok1 := parsePDFInt(p.regularToken())
+	p.skipSpace()
+	gen, ok2 := parsePDFInt(p.regularToken())
+	p.skipSpace()
+	if !ok1 || !ok2 || string(p.regularToken()) != "obj" {
+		return pdfRef{}, nil, fmt.Errorf("%w：位置 %d 不是对象", errPDFSyntax, offset)
+	}
+	obj, err := p.parseObject()
+	if err != nil {
+		return pdfRef{}, nil, err
+	}
+	return pdfRef{Num: int(num), Gen: int(gen)}, obj, nil
+}
+
+// streamLength 解析流的 /Length，可以是间接对象
+func (f *pdfFile) streamLength(v pdfObject) (int, bool) {
+	n, ok := f.resolve(v).(int64)
+	return int(n), ok && n >= 0
+}
+
+// object 读取间接对象，不存在的对象返回nil
+func (f *pdfFile) object(ref pdfRef) (pdfObject, error) {
+	if obj, ok := f.cache[ref.Num]; ok {
+		return obj, nil
+	}
+	entry, ok := f.xref[ref.Num]
+	if !ok || entry.Offset < 0 {
+		return nil, nil
+	}
+	// 先记录为nil，循环引用（如 /Length 引用自身）时不会无限递归
+	f.cache[ref.Num] = nil
+	var obj pdfObject
+	var err error
+	if entry.StreamNum > 0 {
+		obj, err = f.objectFromStream(entry.StreamNum, entry.Index)
+	} else {
+		var got pdfRef
+		got, obj, err = f.parseIndirectAt(int(entry.Offset))
+		if err == nil && got.Num != ref.Num {
+			err = fmt.Errorf("%w：对象 %d 的位置不正确", errPDFSyntax, ref.Num)
+		}
+	}
+	if err != nil {
+		delete(f.cache, ref.Num)
+		return nil, err
+	}
+	f.cache[ref.Num] = obj
+	return obj, nil
+}
+
+// objectStreamHeader 读取对象流开头的对象编号和位置
+func (f *pdfFile) objectStreamHeader(stream *pdfStream) ([]int, []byte, error) {
+	data, err := f.streamData(stream)
+	if err != nil {
+		return nil, nil, err
+	}
+	n, _ := f.resolve(stream.Dict["N"]).(int64)
+	first, _ := f.resolve(stream.Dict["First"]).(int64)
+	if n < 0 || first < 0 || first > int64(len(data)) {
+		return nil, nil, fmt.Errorf("%w：对象流无效", errPDFSyntax)
+	}
+	p := &pdfParser{data: data[:first]}
+	nums := make([]int, 0, n)
+	for i := int64(0); i < n; i++ {
+		p.skipSpace()
+		num, ok1 := parsePDFInt(p.regularToken())
+		p.skipSpace()
+		_, ok2 := parsePDFInt(p.regularToken())
+		if !ok1 || !ok2 {
+			return nil, nil, fmt.Errorf("%w：对象流无效", errPDFSyntax)
+		}
+		nums = append(nums, int(num))
+	}
+	return nums, data, nil
+}
+
+// objectFromStream 读取对象流中的第index个对象
+func (f *pdfFile) objectFromStream(streamNum, index int) (pdfObject, error) {
+	objs, ok := f.objStreams[streamNum]
+	if !ok {
+		obj, err := f.object(pdfRef{Num: streamNum})
+		if err != nil {
+			return nil, err
+		}
+		stream, ok := obj.(*pdfStream)
+		if !ok {
+			return nil, fmt.Errorf("%w：对象 %d 不是对象流", errPDFSyntax, streamNum)
+		}
+		if _, _, err := f.objectStreamHeader(stream); err != nil {
+			return nil, err
+		}
+		data, _ := f.streamData(stream)
+		first, _ := f.resolve(stream.Dict["First"]).(int64)
+		n, _ := f.resolve(stream.Dict["N"]).(int64)
+		// 对象按顺序排列在 First 之后，依次解析
+		header := &pdfParser{data: data[:first]}
+		objs = make([]pdfObject, n)
+		for i := range objs {
+			header.skipSpace()
+			header.regularToken()
+			header.skipSpace()
+			off, _ := parsePDFInt(header.regularToken())
+			if first+off >= int64(len(data)) {
+				continue
+			}
+			p := &pdfParser{data: data, pos: int(first + off)}
+			objs[i], _ = p.parseObject()
+		}
+		f.objStreams[streamNum] = objs
+	}
+	if index < 0 || index >= len(objs) {
+		return nil, fmt.Errorf("%w：对象流 %d 中没有第 %d 个对象", errPDFSyntax, streamNum, index)
+	}
+	return objs[index], nil
+}
+
+// resolve 解析间接引用，无法读取时返回nil
+func (f *pdfFile) resolve(v pdfObject) pdfObject {
+	for i := 0; i < 32; i++ {
+		ref, ok := v.(pdfRef)
+		if !ok {
+			return v
+		}
+		obj, err := f.object(ref)
+		if err != nil {
+			return nil
+		}
+		v = obj
+	}
+	return nil
+}
+
+// dict 解析为字典，流返回流的字典，其他类型返回nil
+func (f *pdfFile) dict(v pdfObject) pdfDict {
+	switch o := f.resolve(v).(type) {
+	case pdfDict:
+		return o
+	case *pdfStream:
+		return o.Dict
+	}
+	return nil
+}
+
+// catalog 返回文档目录
+func (f *pdfFile) catalog() (pdfDict, error) {
+	catalog := f.dict(f.trailer["Root"])
+	if catalog == nil {
+		return nil, fmt.Errorf("%w：找不到文档目录", errPDFSyntax)
+	}
+	return catalog, nil
+}
+
+// pdfPage 页面对象和从上级节点继承的属性
+type pdfPage struct {
+	Ref       pdfRef
+	Dict      pdfDict
+	Resources pdfDict
+}
+
+// pages 按顺序返回全部页面
+func (f *pdfFile) pages() ([]pdfPage, error) {
+	catalog, err := f.catalog()
+	if err != nil {
+		return nil, err
+	}
+	root, ok := catalog["Pages"].(pdfRef)
+	if !ok {
+		return nil, fmt.Errorf("%w：文档目录缺少 /Pages", errPDFSyntax)
+	}
+	var pages []pdfPage
+	visited := make(map[pdfRef]bool)
+	var walk func(ref pdfRef, inherited pdfDict, depth int) error
+	walk = func(ref pdfRef, inherited pdfDict, depth int) error {
+		if visited[ref] || depth > maxPDFDepth {
+			return fmt.Errorf("%w：页面树中有循环", errPDFSyntax)
+		}
+		visited[ref] = true
+		node := f.dict(ref)
+		if node == nil {
+			return nil
+		}
+		if res := f.dict(node["Resources"]); res != nil {
+			inherited = res
+		}
+		if kids, ok := f.resolve(node["Kids"]).(pdfArray); ok && node["Type"] != pdfName("Page") {
+			for _, kid := range kids {
+				if kidRef, ok := kid.(pdfRef); ok {
+					if err := walk(kidRef, inherited, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}
+		pages = append(pages, pdfPage{Ref: ref, Dict: node, Resources: inherited})
+		return nil
+	}
+	if err := walk(root, nil, 0); err != nil {
+		return nil, err
+	}
+	return pages, nil
+}
+
+// streamData 返回流解码后的数据。支持 FlateDecode（含PNG预测器）、ASCIIHexDecode 和 ASCII85Decode，
+// 图片等其他编码返回错误
+func (f *pdfFile) streamData(s *pdfStream) ([]byte, error) {
+	data := s.Data
+	filters := f.resolve(s.Dict["Filter"])
+	params := f.resolve(s.Dict["DecodeParms"])
+	var names []pdfObject
+	var paramList []pdfObject
+	switch v := filters.(type) {
+	case pdfName:
+		names, paramList = pdfArray{v}, pdfArray{params}
+	case pdfArray:
+		names = v
+		paramList, _ = params.(pdfArray)
+	}
+	for i, nameObj := range names {
+		name, _ := f.resolve(nameObj).(pdfName)
+		var param pdfDict
+		if i < len(paramList) {
+			param = f.dict(paramList[i])
+		}
+		var err error
+		switch name {
+		case "FlateDecode", "Fl":
+			data, err = flateDecode(data)
+			if err == nil {
+				data, err = applyPredictor(data, param)
+			}
+		case "ASCIIHexDecode", "AHx":
+			data, err = asciiHexDecode(data)
+		case "ASCII85Decode", "A85":
+			data, err = ascii85Decode(data)
+		default:
+			return nil, fmt.Errorf("不支持的编码 %s", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("解码流失败：%v", err)
+		}
+	}
+	return data, nil
+}
+
+// flateDecode 解压zlib数据，数据不完整时返回已解压的部分
+func flateDecode(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	out, err := io.ReadAll(r)
+	if err != nil && len(out) > 0 && (errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, zlib.ErrChecksum)) {
+		return out, nil
+	}
+	return out, err
+}
+
+// applyPredictor 处理 /DecodeParms 中的PNG预测器（/Predictor 10~15），交叉引用流常用
+func applyPredictor(data []byte, param pdfDict) ([]byte, error) {
+	predictor, _ := param["Predictor"].(int64)
+	if predictor < 10 {
+		if predictor > 1 {
+			return nil, fmt.Errorf("不支持TIFF预测器")
+		}
+		return data, nil
+	}
+	columns, colors, bpc := int64(1), int64(1), int64(8)
+	if v, ok := param["Columns"].(int64); ok {
+		columns = v
+	}
+	if v, ok := param["Colors"].(int64); ok {
+		colors = v
+	}
+	if v, ok := param["BitsPerComponent"].(int64); ok {
+		bpc = v
+	}
+	bpp := int(max((colors*bpc+7)/8, 1))
+	rowLen := int((columns*colors*bpc + 7) / 8)
+	if rowLen <= 0 {
+		return nil, fmt.Errorf("预测器参数无效")
+	}
+	out := make([]byte, 0, len(data))
+	prev := make([]byte, rowLen)
+	for i := 0; i+1 <= len(data); i += rowLen + 1 {
+		end := min(i+1+rowLen, len(data))
+		kind, row := data[i], append([]byte(nil), data[i+1:end]...)
+		for j := range row {
+			var left, up, upLeft byte
+			if j >= bpp {
+				left, upLeft = row[j-bpp], prev[j-bpp]
+			}
+			up = prev[j]
+			switch kind {
+			case 1:
+				row[j] += left
+			case 2:
+				row[j] += up
+			case 3:
+				row[j] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[j] += paeth(left, up, upLeft)
+			}
+		}
+		out = append(out, row...)
+		copy(prev, row)
+	}
+	return out, nil
+}
+
+// paeth PNG的Paeth预测
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// asciiHexDecode 解码 ASCIIHexDecode，> 表示结束
+func asciiHexDecode(data []byte) ([]byte, error) {
+	p := &pdfParser{data: append(append([]byte(nil), data...), '>')}
+	s, err := p.parseHexString()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(s.(pdfString)), nil
+}
+
+// ascii85Decode 解码 ASCII85Decode，~> 表示结束，z 表示4个0字节
+func ascii85Decode(data []byte) ([]byte, error) {
+	var out []byte
+	var group [5]byte
+	n := 0
+	flush := func(count int) {
+		for i := count; i < 5; i++ {
+			group[i] = 'u'
+		}
+		var v uint32
+		for _, c := range group {
+			v = v*85 + uint32(c-'!')
+		}
+		b := []byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+		out = append(out, b[:count-1]...)
+	}
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '~':
+			if n > 1 {
+				flush(n)
+			}
+			return out, nil
+		case c == 'z' && n == 0:
+			out = append(out, 0, 0, 0, 0)
+		case c >= '!' && c <= 'u':
+			group[n] = c
+			if n++; n == 5 {
+				flush(5)
+				n = 0
+			}
+		case isPDFSpace(c):
+		default:
+			return nil, fmt.Errorf("ASCII85数据中有无效字符 %q", c)
+		}
+	}
+	if n > 1 {
+		flush(n)
+	}
+	return out, nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// zlibBytes 压缩测试数据
+func zlibBytes(s string) []byte {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	w.Write([]byte(s))
+	w.Close()
+	return b.Bytes()
+}
+
+// testPDFOptions 生成测试PDF的选项
+type testPDFOptions struct {
+	outline bool // 为每一页生成书签，第一个书签使用命名目标
+}
+
+// buildTestPDF 生成每页一段文字的PDF。对象1是文档目录，对象2是页面树，字体资源放在页面树上由页面继承
+func buildTestPDF(t *testing.T, texts []string, opts testPDFOptions) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	w, err := newPDFWriter(&b, "1.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalogRef, pagesRef, fontRef := w.alloc(), w.alloc(), w.alloc()
+	var kids pdfArray
+	for _, text := range texts {
+		pageRef, contentRef := w.alloc(), w.alloc()
+		content := fmt.Sprintf("BT /F1 12 Tf 72 700 Td (%s) Tj ET", text)
+		w.writeObject(contentRef, &pdfStream{Dict: pdfDict{"Filter": pdfName("FlateDecode")}, Data: zlibBytes(content)})
+		w.writeObject(pageRef, pdfDict{"Type": pdfName("Page"), "Parent": pagesRef, "MediaBox": pdfArray{0, 0, 595, 842}, "Contents": contentRef})
+		kids = append(kids, pageRef)
+	}
+	w.writeObject(fontRef, pdfDict{"Type": pdfName("Font"), "Subtype": pdfName("Type1"), "BaseFont": pdfName("Helvetica")})
+	w.writeObject(pagesRef, pdfDict{"Type": pdfName("Pages"), "Kids": kids, "Count": len(kids),
+		"Resources": pdfDict{"Font": pdfDict{"F1": fontRef}}})
+	catalog := pdfDict{"Type": pdfName("Catalog"), "Pages": pagesRef}
+	if opts.outline {
+		outlinesRef := w.alloc()
+		items := make([]pdfRef, len(kids))
+		for i := range items {
+			items[i] = w.alloc()
+		}
+		for i, page := range kids {
+			item := pdfDict{"Title": pdfTextString(fmt.Sprintf("第%d节", i+1)), "Parent": outlinesRef, "Dest": pdfArray{page, pdfName("Fit")}}
+			if i == 0 {
+				item["Dest"] = pdfString("p1")
+			}
+			if i > 0 {
+				item["Prev"] = items[i-1]
+			}
+			if i < len(items)-1 {
+				item["Next"] = items[i+1]
+			}
+			w.writeObject(items[i], item)
+		}
+		w.writeObject(outlinesRef, pdfDict{"Type": pdfName("Outlines"), "First": items[0], "Last": items[len(items)-1], "Count": len(items)})
+		catalog["Outlines"] = outlinesRef
+		catalog["Names"] = pdfDict{"Dests": pdfDict{"Names": pdfArray{pdfString("p1"), pdfArray{kids[0], pdfName("Fit")}}}}
+	}
+	w.writeObject(catalogRef, catalog)
+	if err := w.finish(pdfDict{"Root": catalogRef}); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// pageTexts 返回每一页内容流中 Tj 显示的文字
+func pageTexts(t *testing.T, f *pdfFile) []string {
+	t.Helper()
+	pages, err := f.pages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for _, page := range pages {
+		stream, ok := f.resolve(page.Dict["Contents"]).(*pdfStream)
+		if !ok {
+			t.Fatalf("Page %v has no content stream", page.Ref)
+		}
+		data, err := f.streamData(stream)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := &pdfParser{data: data}
+		for {
+			obj, err := p.parseObject()
+			if err != nil {
+				break
+			}
+			if s, ok := obj.(pdfString); ok {
+				texts = append(texts, string(s))
+			}
+		}
+	}
+	return texts
+}
+
+// TestPDFObject_RoundTrip 测试对象序列化后能解析回相同的值
+func TestPDFObject_RoundTrip(t *testing.T) {
+	obj := pdfDict{
+		"Name":   pdfName("A B#(x)"),
+		"String": pdfString("a (b) \\ c\n\x01"),
+		"Array":  pdfArray{int64(1), -2.5, true, nil, pdfRef{Num: 12, Gen: 0}},
+		"Title":  pdfTextString("数学 一年级"),
+		"Nested": pdfDict{"Empty": pdfArray{}},
+	}
+	p := &pdfParser{data: appendPDFObject(nil, obj)}
+	got, err := p.parseObject()
+	if err != nil {
+		t.Fatalf("Parse %s: %v", p.data, err)
+	}
+	want := pdfDict{
+		"Name":   pdfName("A B#(x)"),
+		"String": pdfString("a (b) \\ c\n\x01"),
+		"Array":  pdfArray{int64(1), -2.5, true, nil, pdfRef{Num: 12}},
+		"Title":  pdfTextString("数学 一年级"),
+		"Nested": pdfDict{"Empty": pdfArray(nil)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Round trip mismatch:\n got %#v\nwant %#v", got, want)
+	}
+	if s := decodePDFText(got.(pdfDict)["Title"].(pdfString)); s != "数学 一年级" {
+		t.Errorf("decodePDFText = %q", s)
+	}
+
+	// 十六进制字符串、八进制转义和注释
+	p = &pdfParser{data: []byte("<4E6F7> (\\101\\102C\\\nD) % comment\n/N#41me")}
+	for _, want := range []pdfObject{pdfString("No\x70"), pdfString("ABCD"), pdfName("NAme")} {
+		got, err := p.parseObject()
+		if err != nil || got != want {
+			t.Errorf("Parsed %#v (%v), want %#v", got, err, want)
+		}
+	}
+}
+
+// TestParsePDF 测试读取传统交叉引用表、交叉引用流和对象流、增量更新，以及交叉引用损坏时的重建
+func TestParsePDF(t *testing.T) {
+	data := buildTestPDF(t, []string{"one", "two", "three"}, testPDFOptions{})
+
+	t.Run("xref table", func(t *testing.T) {
+		f, err := parsePDF(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := pageTexts(t, f); !reflect.DeepEqual(got, []string{"one", "two", "three"}) {
+			t.Errorf("Unexpected page texts %q", got)
+		}
+		pages, _ := f.pages()
+		if font := f.dict(pages[0].Resources["Font"]); font == nil || font["F1"] == nil {
+			t.Errorf("Resources not inherited from the page tree: %v", pages[0].Resources)
+		}
+		if f.xrefStream || f.startXRef <= 0 {
+			t.Errorf("Unexpected xref info: stream=%v start=%d", f.xrefStream, f.startXRef)
+		}
+	})
+
+	t.Run("incremental update", func(t *testing.T) {
+		f, _ := parsePDF(data)
+		update := append([]byte(nil), data...)
+		offset := len(update)
+		update = append(update, "1 0 obj\n<</Type/Catalog/Pages 2 0 R/Lang(zh-CN)>>\nendobj\n"...)
+		xref := len(update)
+		update = fmt.Appendf(update, "xref\n0 1\n0000000000 65535 f\r\n1 1\n%010d 00000 n\r\ntrailer\n<</Size %d/Root 1 0 R/Prev %d>>\nstartxref\n%d\n%%%%EOF\n",
+			offset, len(f.xref), f.startXRef, xref)
+		f, err := parsePDF(update)
+		if err != nil {
+			t.Fatal(err)
+		}
+		catalog, _ := f.catalog()
+		if catalog["Lang"] != pdfString("zh-CN") {
+			t.Errorf("Expected the updated catalog, got %v", catalog)
+		}
+		if got := pageTexts(t, f); len(got) != 3 {
+			t.Errorf("Objects from the original revision lost: %q", got)
+		}
+	})
+
+	t.Run("rebuild", func(t *testing.T) {
+		broken := bytes.Replace(data, []byte("startxref\n"), []byte("startxref\n9"), 1)
+		f, err := parsePDF(broken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !f.rebuilt {
+			t.Error("Expected the xref to be rebuilt")
+		}
+		if got := pageTexts(t, f); !reflect.DeepEqual(got, []string{"one", "two", "three"}) {
+			t.Errorf("Unexpected page texts %q", got)
+		}
+	})
+
+	t.Run("xref stream", func(t *testing.T) {
+		f, err := parsePDF(buildXRefStreamPDF())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !f.xrefStream {
+			t.Error("Expected xrefStream to be set")
+		}
+		pages, err := f.pages()
+		if err != nil || len(pages) != 2 {
+			t.Fatalf("Expected 2 pages, got %d (%v)", len(pages), err)
+		}
+		if box, _ := pages[1].Dict["MediaBox"].(pdfArray); len(box) != 4 || box[2] != int64(200) {
+			t.Errorf("Unexpected second page %v", pages[1].Dict)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if _, err := parsePDF([]byte("<html>not a pdf</html>")); err == nil {
+			t.Error("Expected an error for a non-PDF file")
+		}
+		encrypted := bytes.Replace(data, []byte("trailer\n<<"), []byte("trailer\n<</Encrypt 99 0 R"), 1)
+		if _, err := parsePDF(encrypted); err == nil || !strings.Contains(err.Error(), "加密") {
+			t.Errorf("Expected an encryption error, got %v", err)
+		}
+	})
+}
+
+// buildXRefStreamPDF 生成使用对象流和交叉引用流（带PNG预测器）的PDF，两个页面都在对象流中
+func buildXRefStreamPDF() []byte {
+	objs := []string{
+		"<</Type/Catalog/Pages 2 0 R>>",
+		"<</Type/Pages/Kids[3 0 R 4 0 R]/Count 2>>",
+		"<</Type/Page/Parent 2 0 R/MediaBox[0 0 100 100]>>",
+		"<</Type/Page/Parent 2 0 R/MediaBox[0 0 200 200]>>",
+	}
+	var header, body strings.Builder
+	for i, obj := range objs {
+		fmt.Fprintf(&header, "%d %d ", i+1, body.Len())
+		body.WriteString(obj + " ")
+	}
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.5\n")
+	stm := zlibBytes(header.String() + body.String())
+	objStmOffset := b.Len()
+	fmt.Fprintf(&b, "5 0 obj\n<</Type/ObjStm/N %d/First %d/Filter/FlateDecode/Length %d>>stream\n", len(objs), header.Len(), len(stm))
+	b.Write(stm)
+	b.WriteString("\nendstream\nendobj\n")
+
+	// 每行：类型(1字节) 字段2(2字节) 字段3(1字节)，使用PNG Up预测器
+	xrefOffset := b.Len()
+	rows := [][]byte{{0, 0, 0, 0xff}}
+	for i := range objs {
+		rows = append(rows, []byte{2, 0, 5, byte(i)})
+	}
+	rows = append(rows, []byte{1, byte(objStmOffset >> 8), byte(objStmOffset), 0}, []byte{1, byte(xrefOffset >> 8), byte(xrefOffset), 0})
+	var raw []byte
+	prev := make([]byte, 4)
+	for _, row := range rows {
+		raw = append(raw, 2)
+		for i, c := range row {
+			raw = append(raw, c-prev[i])
+		}
+		prev = row
+	}
+	xref := zlibBytes(string(raw))
+	fmt.Fprintf(&b, "6 0 obj\n<</Type/XRef/Size 7/W[1 2 1]/Root 1 0 R/Filter/FlateDecode/DecodeParms<</Predictor 12/Columns 4>>/Length %d>>stream\n", len(xref))
+	b.Write(xref)
+	fmt.Fprintf(&b, "\nendstream\nendobj\nstartxref\n%d\n%%%%EOF\n", xrefOffset)
+	return b.Bytes()
+}
+
+// writeTestPDF 把测试PDF写入文件
+func writeTestPDF(t *testing.T, path string, texts []string, opts testPDFOptions) {
+	t.Helper()
+	if err := os.WriteFile(path, buildTestPDF(t, texts, opts), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// pdfOutline 返回书签的标题和层级，格式为 "  标题"，每层缩进两个空格
+func pdfOutline(t *testing.T, f *pdfFile) []string {
+	t.Helper()
+	catalog, _ := f.catalog()
+	var lines []string
+	var walk func(v pdfObject, depth int)
+	walk = func(v pdfObject, depth int) {
+		for n := 0; v != nil && n < 100; n++ {
+			item := f.dict(v)
+			lines = append(lines, strings.Repeat("  ", depth)+decodePDFText(item["Title"].(pdfString)))
+			walk(item["First"], depth+1)
+			v = item["Next"]
+		}
+	}
+	walk(f.dict(catalog["Outlines"])["First"], 0)
+	return lines
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// pdfProducer 生成的PDF中记录的制作程序
+const pdfProducer = "chinaTextBookDownloader"
+
+// pdfMergePart 要合并的一个文件和它在书签中的标题
+type pdfMergePart struct {
+	Path  string
+	Title string
+}
+
+// pdfCopier 把一个PDF中的对象及其引用的全部对象复制到写入器，重新分配对象编号
+type pdfCopier struct {
+	src      *pdfFile
+	w        *pdfWriter
+	refs     map[int]pdfRef    // 原对象编号对应的新引用
+	override map[int]pdfObject // 替换原对象的内容，如把命名目标替换为显式目标的书签
+	patch    map[int]pdfDict   // 复制后写入字典的项，值中的引用已是新引用，如页面树根节点的 /Parent
+	queue    []int
+}
+
+func newPDFCopier(src *pdfFile, w *pdfWriter) *pdfCopier {
+	return &pdfCopier{src: src, w: w, refs: make(map[int]pdfRef), override: make(map[int]pdfObject), patch: make(map[int]pdfDict)}
+}
+
+// ref 返回原对象的新引用，第一次遇到时分配编号并加入复制队列
+func (c *pdfCopier) ref(old pdfRef) pdfRef {
+	if r, ok := c.refs[old.Num]; ok {
+		return r
+	}
+	r := c.w.alloc()
+	c.refs[old.Num] = r
+	c.queue = append(c.queue, old.Num)
+	return r
+}
+
+// value 复制对象，其中的引用替换为新引用。流的 /Length 在写入时重新计算，不复制
+func (c *pdfCopier) value(v pdfObject) pdfObject {
+	switch o := v.(type) {
+	case pdfRef:
+		return c.ref(o)
+	case pdfArray:
+		arr := make(pdfArray, len(o))
+		for i, item := range o {
+			arr[i] = c.value(item)
+		}
+		return arr
+	case pdfDict:
+		dict := make(pdfDict, len(o))
+		for k, item := range o {
+			dict[k] = c.value(item)
+		}
+		return dict
+	case *pdfStream:
+		dict := make(pdfDict, len(o.Dict))
+		for k, item := range o.Dict {
+			if k != "Length" {
+				dict[k] = c.value(item)
+			}
+		}
+		return &pdfStream{Dict: dict, Data: o.Data}
+	}
+	return v
+}
+
+// run 写入队列中的全部对象，无法读取的对象写为null
+func (c *pdfCopier) run() error {
+	for len(c.queue) > 0 {
+		num := c.queue[0]
+		c.queue = c.queue[1:]
+		obj, ok := c.override[num]
+		if !ok {
+			obj, _ = c.src.object(pdfRef{Num: num})
+		}
+		v := c.value(obj)
+		if dict, ok := v.(pdfDict); ok {
+			for k, item := range c.patch[num] {
+				dict[k] = item
+			}
+		}
+		if err := c.w.writeObject(c.refs[num], v); err != nil {
+			return downloadErrorf(ErrCodeFilesystem, "写入文件失败：%v", err)
+		}
+	}
+	return nil
+}
+
+// namedDest 查找命名目标，返回显式目标数组。名称对象在 /Dests 字典中查找，字符串在 /Names 的 /Dests 名称树中查找
+func (f *pdfFile) namedDest(name pdfObject) pdfArray {
+	catalog, err := f.catalog()
+	if err != nil {
+		return nil
+	}
+	var dest pdfObject
+	switch n := name.(type) {
+	case pdfName:
+		dest = f.dict(catalog["Dests"])[n]
+	case pdfString:
+		dest = f.nameTreeLookup(f.dict(f.dict(catalog["Names"])["Dests"]), n, 0)
+	}
+	switch d := f.resolve(dest).(type) {
+	case pdfArray:
+		return d
+	case pdfDict:
+		arr, _ := f.resolve(d["D"]).(pdfArray)
+		return arr
+	}
+	return nil
+}
+
+// nameTreeLookup 在名称树中查找键
+func (f *pdfFile) nameTreeLookup(node pdfDict, key pdfString, depth int) pdfObject {
+	if node == nil || depth > maxPDFDepth {
+		return nil
+	}
+	if names, ok := f.resolve(node["Names"]).(pdfArray); ok {
+		for i := 0; i+1 < len(names); i += 2 {
+			if k, ok := f.resolve(names[i]).(pdfString); ok && k == key {
+				return names[i+1]
+			}
+		}
+	}
+	kids, _ := f.resolve(node["Kids"]).(pdfArray)
+	for _, kid := range kids {
+		kidDict := f.dict(kid)
+		if limits, ok := f.resolve(kidDict["Limits"]).(pdfArray); ok && len(limits) == 2 {
+			lo, _ := f.resolve(limits[0]).(pdfString)
+			hi, _ := f.resolve(limits[1]).(pdfString)
+			if key < lo || key > hi {
+				continue
+			}
+		}
+		if v := f.nameTreeLookup(kidDict, key, depth+1); v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+// resolveOutlineDests 把书签中的命名目标替换为显式目标。合并后不保留原文件的名称树，命名目标会失效
+func (c *pdfCopier) resolveOutlineDests(first pdfObject) {
+	visited := make(map[int]bool)
+	var walk func(v pdfObject, depth int)
+	walk = func(v pdfObject, depth int) {
+		for depth <= maxPDFDepth {
+			ref, ok := v.(pdfRef)
+			if !ok || visited[ref.Num] {
+				return
+			}
+			visited[ref.Num] = true
+			item := c.src.dict(ref)
+			if item == nil {
+				return
+			}
+			name := item["Dest"]
+			action := c.src.dict(item["A"])
+			if name == nil && action != nil && action["S"] == pdfName("GoTo") {
+				name = action["D"]
+			}
+			switch c.src.resolve(name).(type) {
+			case pdfName, pdfString:
+				if dest := c.src.namedDest(c.src.resolve(name)); dest != nil {
+					patched := make(pdfDict, len(item))
+					for k, v := range item {
+						patched[k] = v
+					}
+					delete(patched, "A")
+					patched["Dest"] = dest
+					c.override[ref.Num] = patched
+				}
+			}
+			walk(item["First"], depth+1)
+			v = item["Next"]
+		}
+	}
+	walk(first, 0)
+}
+
+// mergedPart 复制到输出文件中的一个文件
+type mergedPart struct {
+	root      pdfRef // 页面树的根节点
+	firstPage pdfRef
+	pages     int64
+	// 原有的书签，挂在这个文件的书签下
+	outlineFirst, outlineLast pdfObject
+	outlineCount              int64
+}
+
+// copyPDFPart 复制一个文件的页面树和书签。页面树保持原有结构，根节点的 /Parent 指向parent；
+// 原有的顶层书签成为书签项item的子项
+func copyPDFPart(f *pdfFile, w *pdfWriter, parent, item pdfRef) (*mergedPart, error) {
+	pages, err := f.pages()
+	if err != nil {
+		return nil, err
+	}
+	if len(pages) == 0 {
+		return nil, errors.New("文件中没有页面")
+	}
+	catalog, _ := f.catalog()
+	oldRoot := catalog["Pages"].(pdfRef)
+	c := newPDFCopier(f, w)
+	part := &mergedPart{pages: int64(len(pages))}
+
+	if oldOutlines, ok := catalog["Outlines"].(pdfRef); ok {
+		if outlines := f.dict(oldOutlines); outlines != nil && outlines["First"] != nil {
+			// 原书签根节点映射为新的书签项，子项的 /Parent 随之指向它
+			c.refs[oldOutlines.Num] = item
+			c.resolveOutlineDests(outlines["First"])
+			part.outlineFirst = c.value(outlines["First"])
+			part.outlineLast = c.value(outlines["Last"])
+			for v, n := outlines["First"], 0; v != nil && n <= len(f.xref); n++ {
+				part.outlineCount++
+				v = f.dict(v)["Next"]
+			}
+		}
+	}
+
+	c.patch[oldRoot.Num] = pdfDict{"Parent": parent}
+	if f.dict(oldRoot)["Type"] != pdfName("Page") {
+		c.patch[oldRoot.Num]["Count"] = part.pages
+	}
+	part.root = c.ref(oldRoot)
+	part.firstPage = c.ref(pages[0].Ref)
+	if err := c.run(); err != nil {
+		return nil, err
+	}
+	return part, nil
+}
+
+// pdfHeaderPattern 文件头中的PDF版本号
+var pdfHeaderPattern = regexp.MustCompile(`%PDF-(\d\.\d)`)
+
+// pdfHeaderVersion 读取文件头中的PDF版本号，如 1.7，无法读取时返回空字符串
+func pdfHeaderVersion(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	head := make([]byte, 1024)
+	n, _ := io.ReadFull(f, head)
+	if m := pdfHeaderPattern.FindSubmatch(head[:n]); m != nil {
+		return string(m[1])
+	}
+	return ""
+}
+
+// formatPDFDate 把时间格式化为PDF日期字符串，如 D:20240102150405+08'00'
+func formatPDFDate(t time.Time) pdfString {
+	s := t.Format("D:20060102150405-07'00'")
+	if _, offset := t.Zone(); offset == 0 {
+		s = t.Format("D:20060102150405") + "Z"
+	}
+	return pdfString(s)
+}
+
+// mergePDFs 按顺序合并PDF文件，每个文件的页面树作为新页面树的一个子节点保持不变，并为每个文件生成一个书签，
+// 原有书签放在该书签之下。先写入 .part 文件，完成后重命名为outputPath
+func mergePDFs(parts []pdfMergePart, outputPath, title string) error {
+	if len(parts) == 0 {
+		return errors.New("没有要合并的文件")
+	}
+	version := "1.4"
+	for _, part := range parts {
+		if v := pdfHeaderVersion(part.Path); v > version {
+			version = v
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return downloadErrorf(ErrCodeFilesystem, "无法创建输出目录：%v", err)
+	}
+	file, err := os.Create(partialPath(outputPath))
+	if err != nil {
+		return downloadErrorf(ErrCodeFilesystem, "无法创建文件：%v", err)
+	}
+	done := false
+	defer func() {
+		if !done {
+			file.Close()
+			os.Remove(partialPath(outputPath))
+		}
+	}()
+
+	w, err := newPDFWriter(file, version)
+	if err != nil {
+		return downloadErrorf(ErrCodeFilesystem, "写入文件失败：%v", err)
+	}
+	catalogRef, pagesRef, outlinesRef, infoRef := w.alloc(), w.alloc(), w.alloc(), w.alloc()
+	itemRefs := make([]pdfRef, len(parts))
+	for i := range itemRefs {
+		itemRefs[i] = w.alloc()
+	}
+
+	kids := make(pdfArray, 0, len(parts))
+	var total int64
+	merged := make([]*mergedPart, len(parts))
+	for i, part := range parts {
+		f, err := openPDF(part.Path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+				return downloadErrorf(ErrCodeFilesystem, "无法读取文件：%v", err)
+			}
+			return downloadErrorf(ErrCodeInvalidResponse, "无法解析PDF：%v", err)
+		}
+		m, err := copyPDFPart(f, w, pagesRef, itemRefs[i])
+		if err != nil {
+			return downloadErrorf(errorCodeOr(err, ErrCodeInvalidResponse), "无法合并 %s：%v", part.Path, err)
+		}
+		merged[i] = m
+		kids = append(kids, m.root)
+		total += m.pages
+	}
+
+	for i, m := range merged {
+		item := pdfDict{
+			"Title":  pdfTextString(parts[i].Title),
+			"Parent": outlinesRef,
+			"Dest":   pdfArray{m.firstPage, pdfName("Fit")},
+		}
+		if i > 0 {
+			item["Prev"] = itemRefs[i-1]
+		}
+		if i < len(merged)-1 {
+			item["Next"] = itemRefs[i+1]
+		}
+		if m.outlineFirst != nil {
+			// 原有书签默认折叠
+			item["First"], item["Last"], item["Count"] = m.outlineFirst, m.outlineLast, -m.outlineCount
+		}
+		if err := w.writeObject(itemRefs[i], item); err != nil {
+			return downloadErrorf(ErrCodeFilesystem, "写入文件失败：%v", err)
+		}
+	}
+
+	info := pdfDict{"Producer": pdfTextString(pdfProducer), "CreationDate": formatPDFDate(time.Now())}
+	if title != "" {
+		info["Title"] = pdfTextString(title)
+	}
+	objects := []struct {
+		ref pdfRef
+		obj pdfObject
+	}{
+		{pagesRef, pdfDict{"Type": pdfName("Pages"), "Kids": kids, "Count": total}},
+		{outlinesRef, pdfDict{"Type": pdfName("Outlines"), "First": itemRefs[0], "Last": itemRefs[len(itemRefs)-1], "Count": int64(len(itemRefs))}},
+		{catalogRef, pdfDict{"Type": pdfName("Catalog"), "Pages": pagesRef, "Outlines": outlinesRef, "PageMode": pdfName("UseOutlines")}},
+		{infoRef, info},
+	}
+	for _, o := range objects {
+		if err := w.writeObject(o.ref, o.obj); err != nil {
+			return downloadErrorf(ErrCodeFilesystem, "写入文件失败：%v", err)
+		}
+	}
+	if err := w.finish(pdfDict{"Root": catalogRef, "Info": infoRef}); err != nil {
+		return downloadErrorf(ErrCodeFilesystem, "写入文件失败：%v", err)
+	}
+	done = true
+	return finalizeDownload(file, outputPath)
+}
+
+// errorCodeOr 返回错误的错误码，没有错误码时返回def
+func errorCodeOr(err error, def string) string {
+	if code := errorCode(err); code != ErrCodeUnknown {
+		return code
+	}
+	return def
+}
+
+// partTitle 按文件名生成书签标题
+func partTitle(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
+
+// invalidFilenameChars 文件名中不允许的字符（按Windows的规则）
+var invalidFilenameChars = regexp.MustCompile(`[<>:"/\\|?*\x00-\x1f]`)
+
+// safeFilename 把教材标题等文字转换为可以作为文件名的字符串
+func safeFilename(name string) string {
+	name = strings.TrimSpace(invalidFilenameChars.ReplaceAllString(name, "_"))
+	return strings.TrimRight(name, ". ")
+}
+
+// mergeBookParts 教材有多个PDF分册且全部下载成功时，合并为与教材标题同名的PDF，保存在第一个分册所在的目录。
+// 返回合并后的文件路径，不需要合并或按 on_exists 跳过时返回空字符串
+func mergeBookParts(config *Config, resource *PlatformResource, mains []bookFile, out *reporter) (string, error) {
+	var parts []pdfMergePart
+	for _, main := range mains {
+		if resourceTypeOf(main.Path) == ResourceDocument {
+			parts = append(parts, pdfMergePart{Path: main.Path, Title: partTitle(main.Path)})
+		}
+	}
+	if len(parts) < 2 {
+		return "", nil
+	}
+	name := safeFilename(resource.Title)
+	if name == "" {
+		name = resource.ID
+	}
+	output := filepath.Join(filepath.Dir(parts[0].Path), name+".pdf")
+	for _, part := range parts {
+		if part.Path == output {
+			output = filepath.Join(filepath.Dir(parts[0].Path), name+"（合并）.pdf")
+		}
+	}
+	// 合并后的文件已存在时与下载的文件一样按 on_exists 处理
+	if config.OnExists == OnExistsRename {
+		output = uniqueOutputPath(output)
+	}
+	mergeConfig := config.Copy()
+	mergeConfig.URL = ""
+	mergeConfig.OutputPath = output
+	if info, err := skipExisting(context.Background(), mergeConfig); err != nil {
+		out.failed(resource.ID, err, config)
+		return "", err
+	} else if info != nil {
+		out.printf("%s 已存在，跳过合并\n", output)
+		return "", nil
+	}
+	if err := mergePDFs(parts, output, resource.Title); err != nil {
+		out.failed(resource.ID, err, config)
+		return "", err
+	}
+	out.emit(cliEvent{Event: cliEventMerged, URL: resource.ID, Output: output})
+	return output, nil
+}
+
+// runPDFMerge pdf merge 子命令
+func runPDFMerge(args []string) int {
+	fs := newFlagSet("pdf")
+	output := fs.String("out", "", "合并后的文件路径（必填）")
+	title := fs.String("title", "", "合并后文件的标题，默认为输出文件名")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *output == "" {
+		return usageError(fs, "必须用 -out 指定合并后的文件路径")
+	}
+	if fs.NArg() < 2 {
+		return usageError(fs, "至少需要两个要合并的文件")
+	}
+	parts := make([]pdfMergePart, fs.NArg())
+	for i, path := range fs.Args() {
+		parts[i] = pdfMergePart{Path: path, Title: partTitle(path)}
+	}
+	if *title == "" {
+		*title = partTitle(*output)
+	}
+	if err := mergePDFs(parts, *output, *title); err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 合并失败: %v\n", err)
+		return exitError
+	}
+	fmt.Fprintf(os.Stderr, "已合并 %d 个文件，保存至：%s\n", len(parts), *output)
+	return exitOK
+}
+
+// cmdPDF 处理本地PDF文件
+func cmdPDF(args []string) int {
+	fs := newFlagSet("pdf")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() == 0 {
+		return usageError(fs, "必须指定子命令 merge 或 outline")
+	}
+	switch sub := fs.Arg(0); sub {
+	case "merge":
+		return runPDFMerge(fs.Args()[1:])
+	case "outline":
+		return runPDFOutline(fs.Args()[1:])
+	default:
+		return usageError(fs, "未知的子命令 %q", sub)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestMergePDFs 测试合并后页面顺序、继承的资源、书签和原有书签的命名目标
+func TestMergePDFs(t *testing.T) {
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "上册.pdf"), filepath.Join(dir, "下册.pdf")
+	writeTestPDF(t, first, []string{"a1", "a2"}, testPDFOptions{outline: true})
+	writeTestPDF(t, second, []string{"b1", "b2", "b3"}, testPDFOptions{})
+	output := filepath.Join(dir, "out", "全册.pdf")
+
+	parts := []pdfMergePart{{Path: first, Title: "上册"}, {Path: second, Title: "下册"}}
+	if err := mergePDFs(parts, output, "数学 全册"); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(partialPath(output)) {
+		t.Error(".part file left behind")
+	}
+	f, err := openPDF(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pageTexts(t, f); !reflect.DeepEqual(got, []string{"a1", "a2", "b1", "b2", "b3"}) {
+		t.Errorf("Unexpected page order %q", got)
+	}
+
+	catalog, _ := f.catalog()
+	root := f.dict(catalog["Pages"])
+	if root["Count"] != int64(5) {
+		t.Errorf("Expected Count 5, got %v", root["Count"])
+	}
+	// 每个文件的页面树作为子节点保留，字体资源仍从原来的节点继承
+	kids := f.resolve(root["Kids"]).(pdfArray)
+	if len(kids) != 2 || f.dict(kids[1])["Parent"] != catalog["Pages"] || f.dict(kids[1])["Count"] != int64(3) {
+		t.Errorf("Part page trees not kept: %v", kids)
+	}
+	pages, _ := f.pages()
+	for _, page := range pages {
+		if f.dict(page.Resources["Font"])["F1"] == nil {
+			t.Errorf("Page %v lost its inherited font", page.Ref)
+		}
+	}
+
+	if got := pdfOutline(t, f); !reflect.DeepEqual(got, []string{"上册", "  第1节", "  第2节", "下册"}) {
+		t.Errorf("Unexpected outline %q", got)
+	}
+	outlines := f.dict(catalog["Outlines"])
+	part1 := f.dict(outlines["First"])
+	if dest := f.resolve(part1["Dest"]).(pdfArray); dest[0] != pages[0].Ref {
+		t.Errorf("Part bookmark points to %v, want %v", dest[0], pages[0].Ref)
+	}
+	if part1["Count"] != int64(-2) {
+		t.Errorf("Expected the original outline to be collapsed, got Count %v", part1["Count"])
+	}
+	// 命名目标替换为显式目标
+	if dest, ok := f.resolve(f.dict(part1["First"])["Dest"]).(pdfArray); !ok || dest[0] != pages[0].Ref {
+		t.Errorf("Named destination not resolved: %v", f.dict(part1["First"])["Dest"])
+	}
+	if f.dict(part1["First"])["Parent"] != outlines["First"] {
+		t.Error("Original bookmarks not attached to the part bookmark")
+	}
+	part2 := f.dict(part1["Next"])
+	if dest := f.resolve(part2["Dest"]).(pdfArray); dest[0] != pages[2].Ref {
+		t.Errorf("Second part bookmark points to %v, want %v", dest[0], pages[2].Ref)
+	}
+
+	info := f.dict(f.trailer["Info"])
+	if decodePDFText(info["Title"].(pdfString)) != "数学 全册" {
+		t.Errorf("Unexpected info %v", info)
+	}
+
+	// 无法解析的文件不生成输出
+	bad := filepath.Join(dir, "bad.pdf")
+	os.WriteFile(bad, []byte("%PDF-1.4\ngarbage"), 0644)
+	failed := filepath.Join(dir, "failed.pdf")
+	if err := mergePDFs([]pdfMergePart{{Path: first}, {Path: bad}}, failed, ""); err == nil || errorCode(err) != ErrCodeInvalidResponse {
+		t.Errorf("Expected an invalid_response error, got %v", err)
+	}
+	if fileExists(failed) || fileExists(partialPath(failed)) {
+		t.Error("Output written for a failed merge")
+	}
+}
+
+// TestPDFMergeCommand 测试 pdf merge 命令
+func TestPDFMergeCommand(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.pdf"), filepath.Join(dir, "b.pdf")
+	writeTestPDF(t, a, []string{"a"}, testPDFOptions{})
+	writeTestPDF(t, b, []string{"b"}, testPDFOptions{})
+	output := filepath.Join(dir, "ab.pdf")
+
+	for _, args := range [][]string{
+		{"pdf"},
+		{"pdf", "split"},
+		{"pdf", "merge", a, b},
+		{"pdf", "merge", "-out", output, a},
+	} {
+		if code := runCLI(args); code != exitUsage {
+			t.Errorf("runCLI(%q) = %d, want %d", args, code, exitUsage)
+		}
+	}
+	if code := runCLI([]string{"pdf", "merge", "-out", output, a, filepath.Join(dir, "missing.pdf")}); code != exitError {
+		t.Errorf("Expected exit code %d for a missing file, got %d", exitError, code)
+	}
+	if code := runCLI([]string{"pdf", "merge", "-out", output, a, b}); code != exitOK {
+		t.Fatalf("Merge failed with exit code %d", code)
+	}
+	f, err := openPDF(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pdfOutline(t, f); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("Unexpected outline %q", got)
+	}
+}
+
+// TestBatch_MergeParts 测试批量下载时自动合并元数据中列出的多个PDF分册
+func TestBatch_MergeParts(t *testing.T) {
+	const id = "0f5f1a43-5bb4-4c4e-9a0b-2bfc2f5d8a61"
+	files := map[string][]byte{
+		"/part1.pdf": buildTestPDF(t, []string{"p1"}, testPDFOptions{}),
+		"/part2.pdf": buildTestPDF(t, []string{"p2"}, testPDFOptions{}),
+	}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/details/"+id+".json" {
+			fmt.Fprintf(w, `{"id":%q,"title":"语文 一年级/上册","ti_items":[
+				{"ti_file_flag":"source","ti_format":"pdf","ti_storages":["%[2]s/part1.pdf"]},
+				{"ti_file_flag":"source","ti_format":"pdf","ti_storages":["%[2]s/part2.pdf"]}]}`, id, server.URL)
+			return
+		}
+		if data, ok := files[r.URL.Path]; ok {
+			w.Write(data)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+	oldURL := platformDetailsURL
+	platformDetailsURL = server.URL + "/details/%s.json"
+	defer func() { platformDetailsURL = oldURL }()
+
+	dir := t.TempDir()
+	list := filepath.Join(dir, "urls.txt")
+	os.WriteFile(list, []byte(id+"\n"), 0644)
+	code := runCLI([]string{"batch", "-config", filepath.Join(dir, "config.json"), "-dir", dir, "-quiet", "-retries", "0", list})
+	if code != exitOK {
+		t.Fatalf("Batch failed with exit code %d", code)
+	}
+	f, err := openPDF(filepath.Join(dir, "语文 一年级_上册.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pageTexts(t, f); !reflect.DeepEqual(got, []string{"p1", "p2"}) {
+		t.Errorf("Unexpected pages %q", got)
+	}
+	if got := strings.Join(pdfOutline(t, f), ","); got != "part1,part2" {
+		t.Errorf("Unexpected outline %s", got)
+	}
+}
+
+// TestMergeBookParts_OnExists 测试合并后的文件已存在时按 on_exists 跳过、替换或使用新文件名
+func TestMergeBookParts_OnExists(t *testing.T) {
+	dir := t.TempDir()
+	mains := []bookFile{{Path: filepath.Join(dir, "part1.pdf")}, {Path: filepath.Join(dir, "part2.pdf")}}
+	os.WriteFile(mains[0].Path, buildTestPDF(t, []string{"p1"}, testPDFOptions{}), 0644)
+	os.WriteFile(mains[1].Path, buildTestPDF(t, []string{"p2"}, testPDFOptions{}), 0644)
+	resource := &PlatformResource{ID: "0f5f1a43-5bb4-4c4e-9a0b-2bfc2f5d8a61", Title: "语文"}
+	existing := filepath.Join(dir, "语文.pdf")
+	complete := buildTestPDF(t, []string{"old"}, testPDFOptions{})
+
+	merge := func(onExists string, content []byte) string {
+		t.Helper()
+		os.WriteFile(existing, content, 0644)
+		out := &reporter{format: outputText, stdout: &bytes.Buffer{}, stderr: &bytes.Buffer{}}
+		merged, err := mergeBookParts(&Config{OnExists: onExists}, resource, mains, out)
+		if err != nil {
+			t.Fatalf("%s: %v", onExists, err)
+		}
+		return merged
+	}
+	if merged := merge(OnExistsSkip, []byte("old")); merged != "" {
+		t.Errorf("Expected skip, got %s", merged)
+	}
+	if merged := merge(OnExistsVerify, complete); merged != "" {
+		t.Errorf("Expected a complete file to be kept, got %s", merged)
+	}
+	if data, _ := os.ReadFile(existing); !bytes.Equal(data, complete) {
+		t.Error("Expected the existing file to be kept")
+	}
+	if merged := merge(OnExistsVerify, []byte("%PDF-1.4 truncated")); merged != existing {
+		t.Errorf("Expected an incomplete file to be merged again, got %s", merged)
+	}
+	if merged := merge(OnExistsOverwrite, []byte("old")); merged != existing {
+		t.Errorf("Expected overwrite, got %s", merged)
+	}
+	f, err := openPDF(existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pageTexts(t, f); !reflect.DeepEqual(got, []string{"p1", "p2"}) {
+		t.Errorf("Unexpected pages %q", got)
+	}
+	if merged := merge(OnExistsRename, []byte("old")); merged != filepath.Join(dir, "语文 (1).pdf") {
+		t.Errorf("Expected a new file name, got %s", merged)
+	}
+	if data, _ := os.ReadFile(existing); string(data) != "old" {
+		t.Error("Expected rename to keep the existing file")
+	}
+}
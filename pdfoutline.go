@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// errPDFHasOutline PDF中已有书签，未指定替换
+var errPDFHasOutline = errors.New("文件中已有书签")
+
+// outlineBuilder 把章节目录转换为书签对象，对象编号从next开始分配
+type outlineBuilder struct {
+	pages   []pdfPage
+	next    int
+	objects map[int]pdfObject
+	count   int // 生成的书签数
+}
+
+func (b *outlineBuilder) alloc() pdfRef {
+	ref := pdfRef{Num: b.next}
+	b.next++
+	return ref
+}
+
+// items 生成同一级的书签，返回第一项、最后一项和项数。有下一级的书签默认折叠；
+// 页码超出范围的书签不设置目标，只作为分组显示
+func (b *outlineBuilder) items(chapters []PlatformChapter, parent pdfRef, depth int) (pdfRef, pdfRef, int) {
+	var valid []PlatformChapter
+	for _, ch := range chapters {
+		if strings.TrimSpace(ch.Title) != "" {
+			valid = append(valid, ch)
+		}
+	}
+	if len(valid) == 0 {
+		return pdfRef{}, pdfRef{}, 0
+	}
+	refs := make([]pdfRef, len(valid))
+	for i := range refs {
+		refs[i] = b.alloc()
+	}
+	for i, ch := range valid {
+		item := pdfDict{"Title": pdfTextString(strings.TrimSpace(ch.Title)), "Parent": parent}
+		if ch.Page >= 1 && ch.Page <= len(b.pages) {
+			item["Dest"] = pdfArray{b.pages[ch.Page-1].Ref, pdfName("Fit")}
+		}
+		if i > 0 {
+			item["Prev"] = refs[i-1]
+		}
+		if i < len(refs)-1 {
+			item["Next"] = refs[i+1]
+		}
+		if depth < maxPDFDepth {
+			if first, last, n := b.items(ch.Children, refs[i], depth+1); n > 0 {
+				item["First"], item["Last"], item["Count"] = first, last, int64(-n)
+			}
+		}
+		b.objects[refs[i].Num] = item
+	}
+	b.count += len(valid)
+	return refs[0], refs[len(refs)-1], len(valid)
+}
+
+// writePDFOutline 按章节目录为PDF生成书签，以增量更新的方式写入文件，返回生成的书签数。
+// 文件中已有书签时，replace为false返回errPDFHasOutline，为true时替换原有书签
+func writePDFOutline(path string, chapters []PlatformChapter, replace bool) (int, error) {
+	f, err := openPDF(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+			return 0, downloadErrorf(ErrCodeFilesystem, "无法读取文件：%v", err)
+		}
+		return 0, downloadErrorf(ErrCodeInvalidResponse, "无法解析PDF：%v", err)
+	}
+	catalog, _ := f.catalog()
+	rootRef, ok := f.trailer["Root"].(pdfRef)
+	if !ok {
+		return 0, downloadErrorf(ErrCodeInvalidResponse, "文档目录不是间接对象，无法增量更新")
+	}
+	if f.dict(catalog["Outlines"])["First"] != nil && !replace {
+		return 0, errPDFHasOutline
+	}
+	pages, err := f.pages()
+	if err != nil {
+		return 0, downloadErrorf(ErrCodeInvalidResponse, "无法读取页面：%v", err)
+	}
+
+	b := &outlineBuilder{pages: pages, next: f.size(), objects: make(map[int]pdfObject)}
+	outlinesRef := b.alloc()
+	first, last, n := b.items(chapters, outlinesRef, 0)
+	if n == 0 {
+		return 0, nil
+	}
+	b.objects[outlinesRef.Num] = pdfDict{"Type": pdfName("Outlines"), "First": first, "Last": last, "Count": int64(n)}
+
+	// 新版本的文档目录，其他项保持不变
+	updated := make(pdfDict, len(catalog)+2)
+	for k, v := range catalog {
+		updated[k] = v
+	}
+	updated["Outlines"] = outlinesRef
+	updated["PageMode"] = pdfName("UseOutlines")
+	b.objects[rootRef.Num] = updated
+
+	if err := appendPDFUpdate(path, f, b.objects); err != nil {
+		return 0, downloadErrorf(errorCodeOr(err, ErrCodeInvalidResponse), "写入书签失败：%v", err)
+	}
+	return b.count, nil
+}
+
+// outlineBook 教材元数据中有章节目录时生成书签：合并后的文件替换其中按分册生成的书签，
+// 只有一个PDF的教材在其中没有书签时写入。章节目录中的页码按整本教材计算，有多个分册但没有合并时不生成
+func outlineBook(config *Config, resource *PlatformResource, mains []bookFile, merged string, out *reporter) error {
+	if len(resource.Chapters) == 0 {
+		return nil
+	}
+	path, replace := merged, true
+	if path == "" {
+		var docs []string
+		for _, main := range mains {
+			if resourceTypeOf(main.Path) == ResourceDocument {
+				docs = append(docs, main.Path)
+			}
+		}
+		if len(docs) != 1 {
+			return nil
+		}
+		path, replace = docs[0], false
+	}
+	n, err := writePDFOutline(path, resource.Chapters, replace)
+	if errors.Is(err, errPDFHasOutline) {
+		out.printf("%s 中已有书签，不再根据章节目录生成\n", path)
+		return nil
+	}
+	if err != nil {
+		out.failed(resource.ID, err, config)
+		return err
+	}
+	if n > 0 {
+		out.emit(cliEvent{Event: cliEventOutline, URL: resource.ID, Output: path})
+	}
+	return nil
+}
+
+// runPDFOutline pdf outline 子命令
+func runPDFOutline(args []string) int {
+	fs := newFlagSet("pdf")
+	opts := registerConfigFlags(fs, false)
+	replace := fs.Bool("replace", false, "文件中已有书签时替换原有书签")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 2 {
+		return usageError(fs, "用法: pdf outline [选项] <页面地址|资源ID> <文件>")
+	}
+	id, ok := parseContentID(fs.Arg(0))
+	if !ok {
+		return usageError(fs, "%q 不是平台教材页面地址或资源ID", fs.Arg(0))
+	}
+	config, _, code := loadOptions(opts)
+	if config == nil {
+		return code
+	}
+	resource, err := fetchResource(context.Background(), config, id)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 获取教材信息失败: %v\n", config.RedactString(err.Error()))
+		return exitError
+	}
+	if len(resource.Chapters) == 0 {
+		fmt.Fprintf(os.Stderr, "错误: 教材 %s 没有章节目录\n", resource.Title)
+		return exitError
+	}
+	n, err := writePDFOutline(fs.Arg(1), resource.Chapters, *replace)
+	if errors.Is(err, errPDFHasOutline) {
+		fmt.Fprintf(os.Stderr, "错误: %v，使用 -replace 替换\n", err)
+		return exitError
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		return exitError
+	}
+	fmt.Fprintf(os.Stderr, "已生成 %d 个书签：%s\n", n, fs.Arg(1))
+	return exitOK
+}
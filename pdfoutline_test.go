@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testChapters 测试用的章节目录，第二章的页码超出范围
+var testChapters = []PlatformChapter{
+	{Title: "第一单元 数与代数", Page: 1, Children: []PlatformChapter{
+		{Title: "1 分数的加减法", Page: 2},
+		{Title: "2 小数", Page: 3},
+	}},
+	{Title: "  ", Page: 3},
+	{Title: "第二单元 图形", Page: 99},
+}
+
+// TestWritePDFOutline 测试按章节目录以增量更新的方式写入书签
+func TestWritePDFOutline(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		data       []byte
+		xrefStream bool
+	}{
+		{"xref table", buildTestPDF(t, []string{"p1", "p2", "p3"}, testPDFOptions{}), false},
+		{"xref stream", buildXRefStreamPDF(), true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "book.pdf")
+			os.WriteFile(path, tt.data, 0644)
+
+			n, err := writePDFOutline(path, testChapters, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 4 {
+				t.Errorf("Expected 4 bookmarks, got %d", n)
+			}
+			updated, _ := os.ReadFile(path)
+			if !bytes.HasPrefix(updated, tt.data) {
+				t.Fatal("Original content changed, expected an incremental update")
+			}
+			f, err := openPDF(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.rebuilt || f.xrefStream != tt.xrefStream {
+				t.Errorf("Unexpected xref: rebuilt=%v stream=%v", f.rebuilt, f.xrefStream)
+			}
+			want := []string{"第一单元 数与代数", "  1 分数的加减法", "  2 小数", "第二单元 图形"}
+			if got := pdfOutline(t, f); !reflect.DeepEqual(got, want) {
+				t.Errorf("Unexpected outline %q", got)
+			}
+
+			pages, _ := f.pages()
+			catalog, _ := f.catalog()
+			if catalog["PageMode"] != pdfName("UseOutlines") || catalog["Pages"] == nil {
+				t.Errorf("Unexpected catalog %v", catalog)
+			}
+			unit1 := f.dict(f.dict(catalog["Outlines"])["First"])
+			if unit1["Count"] != int64(-2) {
+				t.Errorf("Expected a collapsed chapter with 2 lessons, got %v", unit1["Count"])
+			}
+			lesson2 := f.dict(f.dict(unit1["First"])["Next"])
+			// 交叉引用流的测试文件只有2页
+			if dest, _ := lesson2["Dest"].(pdfArray); len(pages) >= 3 && (len(dest) == 0 || dest[0] != pages[2].Ref) || len(pages) < 3 && dest != nil {
+				t.Errorf("Lesson points to %v", lesson2["Dest"])
+			}
+			if unit2 := f.dict(unit1["Next"]); unit2["Dest"] != nil {
+				t.Errorf("Out of range page should have no destination, got %v", unit2["Dest"])
+			}
+
+			if _, err := writePDFOutline(path, testChapters, false); !errors.Is(err, errPDFHasOutline) {
+				t.Errorf("Expected errPDFHasOutline, got %v", err)
+			}
+			if _, err := writePDFOutline(path, []PlatformChapter{{Title: "目录", Page: 1}}, true); err != nil {
+				t.Fatal(err)
+			}
+			f, _ = openPDF(path)
+			if got := pdfOutline(t, f); !reflect.DeepEqual(got, []string{"目录"}) {
+				t.Errorf("Outline not replaced: %q", got)
+			}
+		})
+	}
+}
+
+// TestDownload_ChapterOutline 测试下载平台教材后按元数据中的章节目录生成书签
+func TestDownload_ChapterOutline(t *testing.T) {
+	const id = "6d0e7a35-64a4-4b8f-a1b3-0c9d3e7f2a10"
+	book := buildTestPDF(t, []string{"p1", "p2", "p3"}, testPDFOptions{})
+	sum := md5.Sum(book)
+	downloads := 0
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/details/" + id + ".json":
+			fmt.Fprintf(w, `{"id":%q,"title":"数学五年级下册","ti_items":[
+				{"ti_file_flag":"source","ti_format":"pdf","ti_md5":"%x","ti_size":%d,"ti_storages":["%s/math.pdf"]}],
+				"chapters":[{"title":"第一单元","page_number":1,"children":[{"title":"分数的加减法","page_number":2}]}]}`, id, sum, len(book), server.URL)
+		case "/math.pdf":
+			if r.Method == http.MethodGet {
+				downloads++
+			}
+			w.Write(book)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	oldURL := platformDetailsURL
+	platformDetailsURL = server.URL + "/details/%s.json"
+	defer func() { platformDetailsURL = oldURL }()
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.json")
+	// 书签写入后正文的MD5与平台记录不同，-assets 校验原文件的部分
+	if code := runCLI([]string{"download", "-config", configPath, "-dir", dir, "-quiet", "-retries", "0", "-assets", id}); code != exitOK {
+		t.Fatalf("Download failed with exit code %d", code)
+	}
+	path := filepath.Join(dir, "math.pdf")
+	f, err := openPDF(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pdfOutline(t, f); !reflect.DeepEqual(got, []string{"第一单元", "  分数的加减法"}) {
+		t.Errorf("Unexpected outline %q", got)
+	}
+
+	// on_exists=verify 时追加了书签的文件仍视为完整，不重新下载
+	t.Setenv("TBD_ON_EXISTS", OnExistsVerify)
+	if code := runCLI([]string{"download", "-config", configPath, "-dir", dir, "-quiet", "-retries", "0", id}); code != exitOK || downloads != 1 {
+		t.Errorf("Expected the outlined file to be kept, exit code %d, %d downloads", code, downloads)
+	}
+
+	// pdf outline 命令：已有书签时需要 -replace
+	args := []string{"pdf", "outline", "-config", configPath, id, path}
+	if code := runCLI(args); code != exitError {
+		t.Errorf("Expected exit code %d without -replace, got %d", exitError, code)
+	}
+	args = append([]string{"pdf", "outline", "-replace"}, args[2:]...)
+	if code := runCLI(args); code != exitOK {
+		t.Errorf("Expected exit code %d with -replace, got %d", exitOK, code)
+	}
+	if code := runCLI([]string{"pdf", "outline", "-config", configPath, "not-an-id", path}); code != exitUsage {
+		t.Errorf("Expected exit code %d for an invalid id, got %d", exitUsage, code)
+	}
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// 从PDF内容流中提取文字，供全文索引使用。字体有 ToUnicode CMap 时按其映射（中文字体通常如此），
+// 简单字体按 /Differences 中的字形名称或Latin-1处理，无法映射的字符忽略。只提取文字内容，不还原版面
+
+// maxFormDepth 表单XObject嵌套的最大层数
+const maxFormDepth = 8
+
+// pdfCodespace CMap中的编码空间，决定每个字符编码的字节数
+type pdfCodespace struct {
+	lo, hi []byte
+}
+
+// pdfBFRange CMap中的一段连续映射
+type pdfBFRange struct {
+	lo, hi uint32
+	size   int      // 编码的字节数
+	base   []rune   // 第一个编码对应的文字，之后的编码依次递增最后一个字符
+	list   []string // 以数组给出的映射，按编码顺序排列
+}
+
+// pdfCMap ToUnicode CMap
+type pdfCMap struct {
+	codespaces []pdfCodespace
+	chars      map[string]string // 编码的字节 → 文字
+	ranges     []pdfBFRange
+}
+
+// pdfFont 解码字符串时使用的字体信息
+type pdfFont struct {
+	composite bool // Type0字体，没有编码空间时每个字符2字节
+	cmap      *pdfCMap
+	encoding  map[byte]rune // 简单字体 /Differences 中的字形
+}
+
+// glyphRunes 常用字形名称对应的字符，uniXXXX 形式的名称另外处理
+var glyphRunes = map[string]rune{
+	"space": ' ', "period": '.', "comma": ',', "colon": ':', "semicolon": ';', "hyphen": '-',
+	"parenleft": '(', "parenright": ')', "question": '?', "exclam": '!', "quotesingle": '\'', "quotedbl": '"',
+	"slash": '/', "plus": '+', "equal": '=', "percent": '%', "zero": '0', "one": '1', "two": '2', "three": '3',
+	"four": '4', "five": '5', "six": '6', "seven": '7', "eight": '8', "nine": '9',
+}
+
+// glyphRune 把字形名称转换为字符，无法识别时返回0
+func glyphRune(name string) rune {
+	if r, ok := glyphRunes[name]; ok {
+		return r
+	}
+	if len(name) == 1 && (name[0] >= 'a' && name[0] <= 'z' || name[0] >= 'A' && name[0] <= 'Z') {
+		return rune(name[0])
+	}
+	if len(name) == 7 && strings.HasPrefix(name, "uni") {
+		if v, err := strconv.ParseUint(name[3:], 16, 32); err == nil {
+			return rune(v)
+		}
+	}
+	return 0
+}
+
+// utf16Text 把CMap中的UTF-16BE目标字符串转换为文字
+func utf16Text(b []byte) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		u = append(u, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	if len(b)%2 == 1 {
+		u = append(u, uint16(b[len(b)-1]))
+	}
+	return string(utf16.Decode(u))
+}
+
+// codeValue 把编码的字节转换为整数
+func codeValue(b []byte) uint32 {
+	var v uint32
+	for _, c := range b {
+		v = v<<8 | uint32(c)
+	}
+	return v
+}
+
+// parseCMap 解析 ToUnicode CMap 中的 codespacerange、bfchar 和 bfrange
+func parseCMap(data []byte) *pdfCMap {
+	cmap := &pdfCMap{chars: make(map[string]string)}
+	p := &pdfParser{data: data}
+	var operands []pdfObject
+	for {
+		obj, err := p.parseObject()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			continue
+		}
+		kw, ok := obj.(pdfKeyword)
+		if !ok {
+			operands = append(operands, obj)
+			continue
+		}
+		switch kw {
+		case "endcodespacerange":
+			for i := 0; i+1 < len(operands); i += 2 {
+				lo, ok1 := operands[i].(pdfString)
+				hi, ok2 := operands[i+1].(pdfString)
+				if ok1 && ok2 && len(lo) == len(hi) && len(lo) > 0 {
+					cmap.codespaces = append(cmap.codespaces, pdfCodespace{lo: []byte(lo), hi: []byte(hi)})
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, ok := operands[i].(pdfString)
+				if !ok {
+					continue
+				}
+				switch dst := operands[i+1].(type) {
+				case pdfString:
+					cmap.chars[string(src)] = utf16Text([]byte(dst))
+				case pdfName:
+					if r := glyphRune(string(dst)); r != 0 {
+						cmap.chars[string(src)] = string(r)
+					}
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				lo, ok1 := operands[i].(pdfString)
+				hi, ok2 := operands[i+1].(pdfString)
+				if !ok1 || !ok2 || len(lo) == 0 {
+					continue
+				}
+				r := pdfBFRange{lo: codeValue([]byte(lo)), hi: codeValue([]byte(hi)), size: len(lo)}
+				switch dst := operands[i+2].(type) {
+				case pdfString:
+					r.base = []rune(utf16Text([]byte(dst)))
+				case pdfArray:
+					for _, item := range dst {
+						s, _ := item.(pdfString)
+						r.list = append(r.list, utf16Text([]byte(s)))
+					}
+				}
+				if r.hi >= r.lo && (len(r.base) > 0 || len(r.list) > 0) {
+					cmap.ranges = append(cmap.ranges, r)
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+	return cmap
+}
+
+// codeLength 按编码空间确定data开头的字符编码的字节数
+func (c *pdfCMap) codeLength(data []byte, def int) int {
+	for _, cs := range c.codespaces {
+		n := len(cs.lo)
+		if n > len(data) {
+			continue
+		}
+		match := true
+		for i := 0; i < n; i++ {
+			if data[i] < cs.lo[i] || data[i] > cs.hi[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return n
+		}
+	}
+	return def
+}
+
+// lookup 查找编码对应的文字
+func (c *pdfCMap) lookup(code []byte) (string, bool) {
+	if s, ok := c.chars[string(code)]; ok {
+		return s, true
+	}
+	v := codeValue(code)
+	for _, r := range c.ranges {
+		if r.size != len(code) || v < r.lo || v > r.hi {
+			continue
+		}
+		offset := int(v - r.lo)
+		if r.list != nil {
+			if offset < len(r.list) {
+				return r.list[offset], true
+			}
+			return "", false
+		}
+		text := append([]rune(nil), r.base...)
+		text[len(text)-1] += rune(offset)
+		return string(text), true
+	}
+	return "", false
+}
+
+// decode 把内容流中的字符串按字体转换为文字
+func (font *pdfFont) decode(s pdfString) string {
+	data := []byte(s)
+	var b strings.Builder
+	def := 1
+	if font.composite {
+		def = 2
+	}
+	for len(data) > 0 {
+		n := def
+		if font.cmap != nil {
+			n = font.cmap.codeLength(data, def)
+		}
+		n = min(n, len(data))
+		code := data[:n]
+		data = data[n:]
+		if font.cmap != nil {
+			if text, ok := font.cmap.lookup(code); ok {
+				b.WriteString(text)
+				continue
+			}
+		}
+		if font.composite || n != 1 {
+			// 复合字体没有映射时无法得知字符
+			continue
+		}
+		if r, ok := font.encoding[code[0]]; ok {
+			b.WriteRune(r)
+		} else if code[0] >= 0x20 && code[0] < 0x7f || code[0] >= 0xa0 {
+			b.WriteRune(rune(code[0]))
+		}
+	}
+	return b.String()
+}
+
+// textExtractor 提取一个文件中各页的文字，字体按对象缓存
+type textExtractor struct {
+	f     *pdfFile
+	fonts map[pdfRef]*pdfFont
+	out   strings.Builder
+}
+
+func newTextExtractor(f *pdfFile) *textExtractor {
+	return &textExtractor{f: f, fonts: make(map[pdfRef]*pdfFont)}
+}
+
+// font 读取字体信息
+func (e *textExtractor) font(v pdfObject) *pdfFont {
+	ref, isRef := v.(pdfRef)
+	if isRef {
+		if font, ok := e.fonts[ref]; ok {
+			return font
+		}
+	}
+	dict := e.f.dict(v)
+	font := &pdfFont{composite: dict["Subtype"] == pdfName("Type0")}
+	if stream, ok := e.f.resolve(dict["ToUnicode"]).(*pdfStream); ok {
+		if data, err := e.f.streamData(stream); err == nil {
+			font.cmap = parseCMap(data)
+		}
+	}
+	if enc := e.f.dict(dict["Encoding"]); enc != nil {
+		if diffs, ok := e.f.resolve(enc["Differences"]).(pdfArray); ok {
+			font.encoding = make(map[byte]rune)
+			code := 0
+			for _, item := range diffs {
+				switch d := item.(type) {
+				case int64:
+					code = int(d)
+				case pdfName:
+					if r := glyphRune(string(d)); r != 0 && code >= 0 && code < 256 {
+						font.encoding[byte(code)] = r
+					}
+					code++
+				}
+			}
+		}
+	}
+	if isRef {
+		e.fonts[ref] = font
+	}
+	return font
+}
+
+// newline 开始新的一行，避免重复的换行
+func (e *textExtractor) newline() {
+	s := e.out.String()
+	if len(s) > 0 && s[len(s)-1] != '\n' {
+		e.out.WriteByte('\n')
+	}
+}
+
+// space 在词之间加空格
+func (e *textExtractor) space() {
+	s := e.out.String()
+	if len(s) > 0 && s[len(s)-1] != '\n' && s[len(s)-1] != ' ' {
+		e.out.WriteByte(' ')
+	}
+}
+
+// page 提取一页的文字
+func (e *textExtractor) page(p pdfPage) string {
+	e.out.Reset()
+	var data []byte
+	switch c := e.f.resolve(p.Dict["Contents"]).(type) {
+	case *pdfStream:
+		data, _ = e.f.streamData(c)
+	case pdfArray:
+		// 多个内容流按顺序拼接，之间加空白避免操作符连在一起
+		for _, item := range c {
+			if s, ok := e.f.resolve(item).(*pdfStream); ok {
+				if d, err := e.f.streamData(s); err == nil {
+					data = append(append(data, d...), '\n')
+				}
+			}
+		}
+	}
+	e.run(data, p.Resources, 0)
+	return strings.TrimSpace(e.out.String())
+}
+
+// run 解释内容流中与文字有关的操作符
+func (e *textExtractor) run(data []byte, resources pdfDict, depth int) {
+	p := &pdfParser{data: data}
+	var operands []pdfObject
+	var font *pdfFont
+	fonts := e.f.dict(resources["Font"])
+	decode := func(v pdfObject) {
+		if s, ok := v.(pdfString); ok && font != nil {
+			e.out.WriteString(font.decode(s))
+		}
+	}
+	for {
+		obj, err := p.parseObject()
+		if err != nil {
+			if err == io.EOF {
+				return
+			}
+			// 无法解析的内容跳过，尽量提取其余的文字
+			operands = operands[:0]
+			continue
+		}
+		op, ok := obj.(pdfKeyword)
+		if !ok {
+			operands = append(operands, obj)
+			continue
+		}
+		switch op {
+		case "Tf":
+			if len(operands) >= 1 {
+				if name, ok := operands[0].(pdfName); ok {
+					font = e.font(fonts[name])
+				}
+			}
+		case "Tj":
+			if len(operands) >= 1 {
+				decode(operands[len(operands)-1])
+			}
+		case "'", "\"":
+			e.newline()
+			if len(operands) >= 1 {
+				decode(operands[len(operands)-1])
+			}
+		case "TJ":
+			if len(operands) >= 1 {
+				arr, _ := operands[len(operands)-1].(pdfArray)
+				for _, item := range arr {
+					switch v := item.(type) {
+					case pdfString:
+						decode(v)
+					case int64:
+						// 较大的间距视为词之间的空格
+						if v < -200 {
+							e.space()
+						}
+					case float64:
+						if v < -200 {
+							e.space()
+						}
+					}
+				}
+			}
+		case "Td", "TD":
+			if len(operands) >= 2 {
+				if ty, ok := pdfNumber(operands[1]); ok && ty != 0 {
+					e.newline()
+				} else {
+					e.space()
+				}
+			}
+		case "T*", "Tm", "ET":
+			e.newline()
+		case "Do":
+			if len(operands) >= 1 && depth < maxFormDepth {
+				name, _ := operands[0].(pdfName)
+				form, ok := e.f.resolve(e.f.dict(resources["XObject"])[name]).(*pdfStream)
+				if ok && form.Dict["Subtype"] == pdfName("Form") {
+					formResources := e.f.dict(form.Dict["Resources"])
+					if formResources == nil {
+						formResources = resources
+					}
+					if d, err := e.f.streamData(form); err == nil {
+						e.run(d, formResources, depth+1)
+					}
+				}
+			}
+		case "BI":
+			// 内嵌图片的数据是二进制，直接跳到 EI 之后
+			skipInlineImage(p)
+		}
+		operands = operands[:0]
+	}
+}
+
+// skipInlineImage 跳过内嵌图片，p位于 BI 之后
+func skipInlineImage(p *pdfParser) {
+	i := bytes.Index(p.data[p.pos:], []byte("ID"))
+	if i < 0 {
+		p.pos = len(p.data)
+		return
+	}
+	p.pos += i + 2
+	for p.pos < len(p.data) {
+		j := bytes.Index(p.data[p.pos:], []byte("EI"))
+		if j < 0 {
+			p.pos = len(p.data)
+			return
+		}
+		end := p.pos + j
+		p.pos = end + 2
+		// EI 前后必须是空白，否则是图片数据的一部分
+		if isPDFSpace(p.data[end-1]) && (p.pos >= len(p.data) || isPDFSpace(p.data[p.pos])) {
+			return
+		}
+	}
+}
+
+// pdfNumber 把整数或实数转换为float64
+func pdfNumber(v pdfObject) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// extractPDFText 提取文件中每一页的文字
+func extractPDFText(path string) ([]string, *pdfFile, error) {
+	f, err := openPDF(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	pages, err := f.pages()
+	if err != nil {
+		return nil, nil, err
+	}
+	e := newTextExtractor(f)
+	texts := make([]string, len(pages))
+	for i, page := range pages {
+		texts[i] = e.page(page)
+	}
+	return texts, f, nil
+}
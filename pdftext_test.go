@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// buildCJKTestPDF 生成使用Type0字体的PDF：字符按两字节的CID编码，通过ToUnicode CMap转换为文字，
+// 与教材PDF中的中文字体相同。每页由若干行组成，行内的空格写为 TJ 中的间距。
+// 第二页的文字放在表单XObject中，前面有一张内嵌图片
+func buildCJKTestPDF(t *testing.T, pages [][]string) []byte {
+	t.Helper()
+	// 汉字按出现顺序分配CID，ASCII字符的CID为 0x100 加字符编码
+	cids := make(map[rune]int)
+	var chars []rune
+	for _, lines := range pages {
+		for _, line := range lines {
+			for _, r := range line {
+				if r >= 0x80 && cids[r] == 0 {
+					chars = append(chars, r)
+					cids[r] = len(chars)
+				}
+			}
+		}
+	}
+	encode := func(s string) string {
+		var b strings.Builder
+		b.WriteByte('<')
+		for _, r := range s {
+			cid := 0x100 + int(r)
+			if r >= 0x80 {
+				cid = cids[r]
+			}
+			fmt.Fprintf(&b, "%04X", cid)
+		}
+		b.WriteByte('>')
+		return b.String()
+	}
+
+	// 前一半汉字使用 bfchar，其余使用数组形式的 bfrange，ASCII字符使用起始值形式的 bfrange
+	half := len(chars) / 2
+	var cmap strings.Builder
+	cmap.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n")
+	cmap.WriteString("1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	fmt.Fprintf(&cmap, "%d beginbfchar\n", half)
+	for i, r := range chars[:half] {
+		fmt.Fprintf(&cmap, "<%04X> <%04X>\n", i+1, r)
+	}
+	cmap.WriteString("endbfchar\n2 beginbfrange\n")
+	fmt.Fprintf(&cmap, "<%04X> <%04X> [", half+1, len(chars))
+	for _, r := range chars[half:] {
+		fmt.Fprintf(&cmap, "<%04X> ", r)
+	}
+	cmap.WriteString("]\n<0100> <017F> <0000>\nendbfrange\nendcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+
+	var b bytes.Buffer
+	w, err := newPDFWriter(&b, "1.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalogRef, pagesRef, fontRef, cmapRef := w.alloc(), w.alloc(), w.alloc(), w.alloc()
+	var kids pdfArray
+	for i, lines := range pages {
+		var content strings.Builder
+		content.WriteString("BT /F2 12 Tf 72 700 Td\n")
+		for j, line := range lines {
+			if j > 0 {
+				content.WriteString("0 -20 Td\n")
+			}
+			content.WriteByte('[')
+			for k, word := range strings.Fields(line) {
+				if k > 0 {
+					content.WriteString(" -300 ")
+				}
+				content.WriteString(encode(word))
+			}
+			content.WriteString("] TJ\n")
+		}
+		content.WriteString("ET\n")
+
+		pageRef, contentRef := w.alloc(), w.alloc()
+		page := pdfDict{"Type": pdfName("Page"), "Parent": pagesRef, "MediaBox": pdfArray{0, 0, 595, 842}, "Contents": contentRef}
+		data := content.String()
+		if i == 1 {
+			formRef := w.alloc()
+			w.writeObject(formRef, &pdfStream{Dict: pdfDict{"Type": pdfName("XObject"), "Subtype": pdfName("Form"), "BBox": pdfArray{0, 0, 595, 842}}, Data: []byte(data)})
+			page["Resources"] = pdfDict{"Font": pdfDict{"F2": fontRef}, "XObject": pdfDict{"Fm0": formRef}}
+			data = "q BI /W 2 /H 1 /BPC 8 /CS /G ID \x00(\xff EI Q\n/Fm0 Do\n"
+		}
+		w.writeObject(contentRef, &pdfStream{Dict: pdfDict{"Filter": pdfName("FlateDecode")}, Data: zlibBytes(data)})
+		w.writeObject(pageRef, page)
+		kids = append(kids, pageRef)
+	}
+	w.writeObject(cmapRef, &pdfStream{Dict: pdfDict{}, Data: []byte(cmap.String())})
+	w.writeObject(fontRef, pdfDict{"Type": pdfName("Font"), "Subtype": pdfName("Type0"), "BaseFont": pdfName("SimSun"),
+		"Encoding": pdfName("Identity-H"), "ToUnicode": cmapRef})
+	w.writeObject(pagesRef, pdfDict{"Type": pdfName("Pages"), "Kids": kids, "Count": len(kids),
+		"Resources": pdfDict{"Font": pdfDict{"F2": fontRef}}})
+	w.writeObject(catalogRef, pdfDict{"Type": pdfName("Catalog"), "Pages": pagesRef})
+	if err := w.finish(pdfDict{"Root": catalogRef}); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// testCJKPages 测试用的教材内容
+var testCJKPages = [][]string{
+	{"第三单元 分数的加减法", "1. 同分母分数加、减法"},
+	{"小数的意义 Decimals", "0.5 读作 零点五"},
+}
+
+// TestExtractPDFText 测试按字体的ToUnicode CMap提取文字
+func TestExtractPDFText(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.pdf")
+	os.WriteFile(path, buildCJKTestPDF(t, testCJKPages), 0644)
+
+	texts, _, err := extractPDFText(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, lines := range testCJKPages {
+		want = append(want, strings.Join(lines, "\n"))
+	}
+	if !reflect.DeepEqual(texts, want) {
+		t.Errorf("Expected %q, got %q", want, texts)
+	}
+
+	// 简单字体没有ToUnicode时按字节取字符
+	os.WriteFile(path, buildTestPDF(t, []string{"Fractions", "Decimals"}, testPDFOptions{}), 0644)
+	texts, _, err = extractPDFText(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(texts, []string{"Fractions", "Decimals"}) {
+		t.Errorf("Unexpected text %q", texts)
+	}
+}
+
+// TestParseCMap 测试CMap中代理对、多个字符的目标和字形名的解析
+func TestParseCMap(t *testing.T) {
+	cmap := parseCMap([]byte(`1 begincodespacerange <00> <FF> endcodespacerange
+3 beginbfchar <01> <D835DC00> <02> /uni4E01 <03> <00660066> endbfchar
+1 beginbfrange <10> <12> <4E00> endbfrange`))
+	for code, want := range map[string]string{"\x01": "\U0001D400", "\x02": "丁", "\x03": "ff", "\x11": "丁"} {
+		if got, ok := cmap.lookup([]byte(code)); !ok || got != want {
+			t.Errorf("Code %x: expected %q, got %q", code, want, got)
+		}
+	}
+	if _, ok := cmap.lookup([]byte{0x13}); ok {
+		t.Error("Expected no mapping outside the range")
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"unicode/utf16"
+)
+
+// appendPDFObject 把对象序列化后追加到dst。流对象的 /Length 按数据长度重新设置
+func appendPDFObject(dst []byte, v pdfObject) []byte {
+	switch o := v.(type) {
+	case nil:
+		return append(dst, "null"...)
+	case bool:
+		return strconv.AppendBool(dst, o)
+	case int:
+		return strconv.AppendInt(dst, int64(o), 10)
+	case int64:
+		return strconv.AppendInt(dst, o, 10)
+	case float64:
+		return strconv.AppendFloat(dst, o, 'f', -1, 64)
+	case pdfName:
+		return appendPDFName(dst, o)
+	case pdfString:
+		return appendPDFString(dst, o)
+	case pdfKeyword:
+		return append(dst, o...)
+	case pdfRef:
+		return fmt.Appendf(dst, "%d %d R", o.Num, o.Gen)
+	case pdfArray:
+		dst = append(dst, '[')
+		for i, item := range o {
+			if i > 0 {
+				dst = append(dst, ' ')
+			}
+			dst = appendPDFObject(dst, item)
+		}
+		return append(dst, ']')
+	case pdfDict:
+		// 按键排序，输出结果稳定
+		keys := make([]string, 0, len(o))
+		for k := range o {
+			keys = append(keys, string(k))
+		}
+		sort.Strings(keys)
+		dst = append(dst, "<<"...)
+		for _, k := range keys {
+			dst = appendPDFName(dst, pdfName(k))
+			dst = append(dst, ' ')
+			dst = appendPDFObject(dst, o[pdfName(k)])
+		}
+		return append(dst, ">>"...)
+	case *pdfStream:
+		dict := make(pdfDict, len(o.Dict)+1)
+		for k, v := range o.Dict {
+			dict[k] = v
+		}
+		dict["Length"] = int64(len(o.Data))
+		dst = appendPDFObject(dst, dict)
+		dst = append(dst, "\nstream\n"...)
+		dst = append(dst, o.Data...)
+		return append(dst, "\nendstream"...)
+	}
+	panic(fmt.Sprintf("无法序列化PDF对象 %T", v))
+}
+
+// appendPDFName 序列化名称，分隔符、空白和非ASCII字符写为 #xx
+func appendPDFName(dst []byte, name pdfName) []byte {
+	dst = append(dst, '/')
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c < 0x21 || c > 0x7e || c == '#' || isPDFDelimiter(c) {
+			dst = fmt.Appendf(dst, "#%02X", c)
+			continue
+		}
+		dst = append(dst, c)
+	}
+	return dst
+}
+
+// appendPDFString 序列化字符串，括号和反斜杠转义，控制字符写为八进制
+func appendPDFString(dst []byte, s pdfString) []byte {
+	dst = append(dst, '(')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '(' || c == ')' || c == '\\':
+			dst = append(dst, '\\', c)
+		case c < 0x20 || c == 0x7f:
+			dst = fmt.Appendf(dst, "\\%03o", c)
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return append(dst, ')')
+}
+
+// pdfTextString 把文字编码为PDF文本字符串：ASCII原样保存，其他文字使用带BOM的UTF-16BE
+func pdfTextString(s string) pdfString {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return pdfString(s)
+	}
+	b := []byte{0xfe, 0xff}
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(u>>8), byte(u))
+	}
+	return pdfString(b)
+}
+
+// decodePDFText 解码PDF文本字符串：带BOM的UTF-16BE或UTF-8，其他按Latin-1处理（PDFDocEncoding的常用字符与之相同）
+func decodePDFText(s pdfString) string {
+	b := []byte(s)
+	switch {
+	case len(b) >= 2 && b[0] == 0xfe && b[1] == 0xff:
+		u := make([]uint16, 0, (len(b)-2)/2)
+		for i := 2; i+1 < len(b); i += 2 {
+			u = append(u, uint16(b[i])<<8|uint16(b[i+1]))
+		}
+		return string(utf16.Decode(u))
+	case len(b) >= 3 && b[0] == 0xef && b[1] == 0xbb && b[2] == 0xbf:
+		return string(b[3:])
+	}
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
+
+// pdfWriter 按顺序写入间接对象，最后写入交叉引用表和trailer
+type pdfWriter struct {
+	w       *bufio.Writer
+	offset  int64
+	offsets []int64 // 对象在文件中的位置，下标为对象编号，-1表示已分配但未写入
+	buf     []byte
+}
+
+// newPDFWriter 创建写入器并写入文件头
+func newPDFWriter(w io.Writer, version string) (*pdfWriter, error) {
+	pw := &pdfWriter{w: bufio.NewWriterSize(w, 64*1024), offsets: []int64{0}}
+	// 第二行的高位字节表示文件包含二进制数据
+	if err := pw.write([]byte("%PDF-" + version + "\n%\xe2\xe3\xcf\xd3\n")); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+func (pw *pdfWriter) write(b []byte) error {
+	n, err := pw.w.Write(b)
+	pw.offset += int64(n)
+	return err
+}
+
+// alloc 分配一个新的对象编号
+func (pw *pdfWriter) alloc() pdfRef {
+	pw.offsets = append(pw.offsets, -1)
+	return pdfRef{Num: len(pw.offsets) - 1}
+}
+
+// writeObject 写入间接对象
+func (pw *pdfWriter) writeObject(ref pdfRef, obj pdfObject) error {
+	pw.offsets[ref.Num] = pw.offset
+	pw.buf = fmt.Appendf(pw.buf[:0], "%d 0 obj\n", ref.Num)
+	pw.buf = appendPDFObject(pw.buf, obj)
+	pw.buf = append(pw.buf, "\nendobj\n"...)
+	return pw.write(pw.buf)
+}
+
+// finish 写入交叉引用表和trailer。已分配但未写入的对象记为空闲
+func (pw *pdfWriter) finish(trailer pdfDict) error {
+	start := pw.offset
+	b := fmt.Appendf(nil, "xref\n0 %d\n0000000000 65535 f\r\n", len(pw.offsets))
+	for _, offset := range pw.offsets[1:] {
+		if offset < 0 {
+			b = append(b, "0000000000 00001 f\r\n"...)
+			continue
+		}
+		b = fmt.Appendf(b, "%010d 00000 n\r\n", offset)
+	}
+	dict := make(pdfDict, len(trailer)+1)
+	for k, v := range trailer {
+		dict[k] = v
+	}
+	dict["Size"] = int64(len(pw.offsets))
+	b = append(b, "trailer\n"...)
+	b = appendPDFObject(b, dict)
+	b = fmt.Appendf(b, "\nstartxref\n%d\n%%%%EOF\n", start)
+	if err := pw.write(b); err != nil {
+		return err
+	}
+	return pw.w.Flush()
+}
+
+// size 返回文件中下一个可用的对象编号
+func (f *pdfFile) size() int {
+	size := 0
+	if n, ok := f.trailer["Size"].(int64); ok {
+		size = int(n)
+	}
+	for num := range f.xref {
+		size = max(size, num+1)
+	}
+	return size
+}
+
+// appendPDFUpdate 以增量更新的方式写入对象：原文件内容保持不变，在末尾追加新的和替换的对象、交叉引用和trailer。
+// 原文件使用交叉引用流时同样写入交叉引用流。结果先写入 .part 文件，完成后替换原文件
+func appendPDFUpdate(path string, f *pdfFile, objects map[int]pdfObject) error {
+	if f.rebuilt {
+		return errors.New("文件的交叉引用已损坏，无法增量更新")
+	}
+	nums := make([]int, 0, len(objects)+1)
+	size := f.size()
+	for num := range objects {
+		nums = append(nums, num)
+		size = max(size, num+1)
+	}
+	sort.Ints(nums)
+
+	base := int64(len(f.data))
+	var b []byte
+	if len(f.data) > 0 && f.data[len(f.data)-1] != '\n' && f.data[len(f.data)-1] != '\r' {
+		b = append(b, '\n')
+	}
+	offsets := make(map[int]int64, len(nums)+1)
+	gens := make(map[int]int, len(nums)+1)
+	writeObject := func(num int, obj pdfObject) {
+		gen := 0
+		if entry, ok := f.xref[num]; ok && entry.Offset >= 0 && entry.StreamNum == 0 {
+			gen = entry.Gen
+		}
+		offsets[num], gens[num] = base+int64(len(b)), gen
+		b = fmt.Appendf(b, "%d %d obj\n", num, gen)
+		b = appendPDFObject(b, obj)
+		b = append(b, "\nendobj\n"...)
+	}
+	for _, num := range nums {
+		writeObject(num, objects[num])
+	}
+
+	trailer := pdfDict{"Prev": f.startXRef}
+	for _, k := range []pdfName{"Root", "Info", "ID"} {
+		if v, ok := f.trailer[k]; ok {
+			trailer[k] = v
+		}
+	}
+	xrefOffset := base + int64(len(b))
+	if f.xrefStream {
+		// 交叉引用流自身也是一个对象，每行为 类型(1字节) 位置(4字节) 代数(2字节)
+		num := size
+		size++
+		nums = append(nums, num)
+		offsets[num] = xrefOffset
+		var index pdfArray
+		var rows []byte
+		for i, n := range nums {
+			if i == 0 || n != nums[i-1]+1 {
+				index = append(index, int64(n), int64(0))
+			}
+			index[len(index)-1] = index[len(index)-1].(int64) + 1
+			rows = append(rows, 1, byte(offsets[n]>>24), byte(offsets[n]>>16), byte(offsets[n]>>8), byte(offsets[n]), byte(gens[n]>>8), byte(gens[n]))
+		}
+		trailer["Type"] = pdfName("XRef")
+		trailer["Size"] = int64(size)
+		trailer["W"] = pdfArray{int64(1), int64(4), int64(2)}
+		trailer["Index"] = index
+		writeObject(num, &pdfStream{Dict: trailer, Data: rows})
+	} else {
+		b = append(b, "xref\n"...)
+		for i := 0; i < len(nums); {
+			j := i + 1
+			for j < len(nums) && nums[j] == nums[j-1]+1 {
+				j++
+			}
+			b = fmt.Appendf(b, "%d %d\n", nums[i], j-i)
+			for _, n := range nums[i:j] {
+				b = fmt.Appendf(b, "%010d %05d n\r\n", offsets[n], gens[n])
+			}
+			i = j
+		}
+		trailer["Size"] = int64(size)
+		b = append(b, "trailer\n"...)
+		b = appendPDFObject(b, trailer)
+		b = append(b, '\n')
+	}
+	b = fmt.Appendf(b, "startxref\n%d\n%%%%EOF\n", xrefOffset)
+
+	file, err := os.Create(partialPath(path))
+	if err != nil {
+		return downloadErrorf(ErrCodeFilesystem, "无法创建文件：%v", err)
+	}
+	if _, err := file.Write(f.data); err == nil {
+		_, err = file.Write(b)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(partialPath(path))
+		return downloadErrorf(ErrCodeFilesystem, "写入文件失败：%v", err)
+	}
+	return finalizeDownload(file, path)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// 平台元数据接口地址，定义为变量以便测试时替换为本地服务
+var (
+	platformDetailsURL = "https://s-file-1.ykt.cbern.com.cn/zxx/ndrv2/resources/tch_material/details/%s.json"
+	platformCatalogURL = "https://s-file-1.ykt.cbern.com.cn/zxx/ndrs/resources/tch_material/version/data_version.json"
+)
+
+// contentIDPattern 平台资源ID的格式（UUID）
+var contentIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// PlatformResource 平台资源元数据（教材详情和目录中的条目结构相同）
+type PlatformResource struct {
+	ID      string         `json:"id"`
+	Title   string         `json:"title"`
+	TagList []PlatformTag  `json:"tag_list"`
+	Items   []PlatformItem `json:"ti_items"`
+	// Chapters 教材的章节目录，用于生成PDF书签
+	Chapters []PlatformChapter `json:"chapters,omitempty"`
+	// CustomProperties 资源的附加属性，其中有封面缩略图的地址
+	CustomProperties struct {
+		Thumbnails []string `json:"thumbnails,omitempty"`
+	} `json:"custom_properties"`
+}
+
+// PlatformTag 资源标签（学段、学科、版本、年级等）
+type PlatformTag struct {
+	TagID   string `json:"tag_id"`
+	TagName string `json:"tag_name"`
+}
+
+// PlatformItem 资源包含的文件
+type PlatformItem struct {
+	FileFlag string   `json:"ti_file_flag"` // source 表示源文件
+	Format   string   `json:"ti_format"`
+	Size     int64    `json:"ti_size"`
+	MD5      string   `json:"ti_md5"`
+	Storages []string `json:"ti_storages"` // 文件的下载地址（多个镜像）
+}
+
+// PlatformChapter 教材目录中的章或课，Children 为下一级的节或课
+type PlatformChapter struct {
+	Title    string            `json:"title"`
+	Page     int               `json:"page_number"` // 起始页在整本教材PDF中的页码，从1开始，0表示未知
+	Children []PlatformChapter `json:"children,omitempty"`
+}
+
+// Tags 返回以空格分隔的标签名称
+func (r *PlatformResource) Tags() string {
+	names := make([]string, 0, len(r.TagList))
+	for _, tag := range r.TagList {
+		names = append(names, tag.TagName)
+	}
+	return strings.Join(names, " ")
+}
+
+// DownloadURLs 返回资源中属于types中资源类型的源文件的下载地址
+func (r *PlatformResource) DownloadURLs(types []string) []string {
+	var urls []string
+	for _, item := range r.Items {
+		if !wantsResource(types, item.Format) || len(item.Storages) == 0 {
+			continue
+		}
+		if item.FileFlag != "" && item.FileFlag != "source" {
+			continue
+		}
+		urls = append(urls, item.Storages[0])
+	}
+	return urls
+}
+
+// parseContentID 从平台页面地址（如 .../tchMaterial/detail?contentId=xxx）或资源ID中提取资源ID
+func parseContentID(target string) (string, bool) {
+	target = strings.TrimSpace(target)
+	if contentIDPattern.MatchString(target) {
+		return target, true
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", false
+	}
+	id := u.Query().Get("contentId")
+	if contentIDPattern.MatchString(id) {
+		return id, true
+	}
+	return "", false
+}
+
+// fetchPlatformJSON 请求平台接口并解析JSON，每个请求最多等待配置的超时时间
+func fetchPlatformJSON(ctx context.Context, config *Config, apiURL string, v interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, config.GetTimeoutDuration())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return fmt.Errorf("创建请求失败：%v", err)
+	}
+	for k, v := range getDefaultHttpHeaders() {
+		req.Header.Set(k, v)
+	}
+	for k, v := range config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := newHTTPClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("请求失败：%v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		if pe := readPlatformError(resp); pe != nil {
+			return fmt.Errorf("服务器返回错误状态码：%d (%s)：%s", resp.StatusCode, resp.Status, pe)
+		}
+		return fmt.Errorf("服务器返回错误状态码：%d (%s)", resp.StatusCode, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("读取响应失败：%v", err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("解析响应失败：%v", err)
+	}
+	return nil
+}
+
+// fetchResource 获取单个资源的元数据
+func fetchResource(ctx context.Context, config *Config, id string) (*PlatformResource, error) {
+	var resource PlatformResource
+	if err := fetchPlatformJSON(ctx, config, fmt.Sprintf(platformDetailsURL, id), &resource); err != nil {
+		return nil, err
+	}
+	return &resource, nil
+}
+
+// fetchCatalog 获取平台的全部教材目录
+func fetchCatalog(ctx context.Context, config *Config) ([]PlatformResource, error) {
+	var version struct {
+		URLs string `json:"urls"` // 以逗号分隔的目录分片地址
+	}
+	if err := fetchPlatformJSON(ctx, config, platformCatalogURL, &version); err != nil {
+		return nil, err
+	}
+
+	var catalog []PlatformResource
+	for _, partURL := range strings.Split(version.URLs, ",") {
+		partURL = strings.TrimSpace(partURL)
+		if partURL == "" {
+			continue
+		}
+		var part []PlatformResource
+		if err := fetchPlatformJSON(ctx, config, partURL, &part); err != nil {
+			return nil, fmt.Errorf("获取目录分片失败：%v", err)
+		}
+		catalog = append(catalog, part...)
+	}
+	return catalog, nil
+}
+
+// resolveTarget 将命令行或批量文件中的条目解析为实际的下载地址。
+// 平台页面地址和资源ID会通过元数据接口解析，其他地址原样返回
+func resolveTarget(ctx context.Context, config *Config, target string) ([]string, error) {
+	_, urls, err := resolveBook(ctx, config, target)
+	return urls, err
+}
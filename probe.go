@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// ProbeResult 下载前探测到的文件信息和磁盘空间
+type ProbeResult struct {
+	URL          string `json:"url"`
+	FinalURL     string `json:"final_url"`               // 跟随重定向后的最终地址
+	Size         int64  `json:"size"`                    // 文件大小，服务器没有给出时为-1
+	ContentType  string `json:"content_type,omitempty"`  // 服务器声明的内容类型
+	AcceptRanges bool   `json:"accept_ranges"`           // 是否支持断点续传
+	ETag         string `json:"etag,omitempty"`          // 文件的版本标识
+	LastModified string `json:"last_modified,omitempty"` // 文件的修改时间
+	OutputPath   string `json:"output_path"`
+	Existing     int64  `json:"existing"`   // 上次未完成的下载已有的字节数
+	Required     int64  `json:"required"`   // 还需要写入的字节数，大小未知时为-1
+	FreeSpace    int64  `json:"free_space"` // 输出目录所在磁盘的可用空间，无法查询时为-1
+}
+
+// probeFile 在下载前探测文件信息。先发送HEAD请求，服务器不支持HEAD时改用只请求第一个字节的GET请求。
+// 只有请求本身失败时返回错误，是否可以开始下载由check判断
+func probeFile(ctx context.Context, config *Config) (*ProbeResult, error) {
+	resp, err := probeRequest(ctx, config, http.MethodHead)
+	if err != nil {
+		return nil, err
+	}
+	// 部分服务器不支持HEAD，预签名地址的签名也可能只对GET有效
+	switch resp.StatusCode {
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented, http.StatusForbidden:
+		if resp, err = probeRequest(ctx, config, http.MethodGet); err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, httpStatusError(resp)
+	}
+
+	result := &ProbeResult{
+		URL:          config.URL,
+		FinalURL:     resp.Request.URL.String(),
+		Size:         resp.ContentLength,
+		ContentType:  resp.Header.Get("Content-Type"),
+		AcceptRanges: acceptsByteRanges(resp.Header.Get("Accept-Ranges")),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		OutputPath:   config.OutputPath,
+		Required:     -1,
+		FreeSpace:    -1,
+	}
+	if resp.StatusCode == http.StatusPartialContent {
+		// GET请求只返回了第一个字节，文件大小在 Content-Range 的完整长度中
+		result.AcceptRanges = true
+		result.Size = -1
+		if cr, err := parseContentRange(resp.Header.Get("Content-Range")); err == nil {
+			result.Size = cr.Complete
+		}
+	}
+
+	if isHLS(config.URL) {
+		// 响应的是播放列表，合并后的文件大小要下载完全部分片才能知道
+		result.Size = -1
+	}
+	result.Existing = resumableSize(config)
+	if result.Size >= 0 {
+		result.Required = result.Size
+		// 支持断点续传时只需要下载剩余部分
+		if result.AcceptRanges && result.Existing <= result.Size {
+			result.Required -= result.Existing
+		}
+	}
+	if free, err := freeSpace(filepath.Dir(config.OutputPath)); err == nil {
+		result.FreeSpace = free
+	}
+	return result, nil
+}
+
+// probeRequest 发送探测请求，GET请求只请求第一个字节
+func probeRequest(ctx context.Context, config *Config, method string) (*http.Response, error) {
+	req, err := newDownloadRequest(ctx, method, config)
+	if err != nil {
+		return nil, err
+	}
+	if method == http.MethodGet {
+		req.Header.Set("Range", "bytes=0-0")
+	}
+	resp, err := newHTTPClient().Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, contextError(ctx)
+		}
+		return nil, downloadErrorf(ErrCodeNetwork, "探测文件信息失败：%v", err)
+	}
+	// 保留响应体的开头，错误状态时用于解析平台的错误信息
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	return resp, nil
+}
+
+// acceptsByteRanges Accept-Ranges 是否包含 bytes，none 或没有该头时认为不支持
+func acceptsByteRanges(value string) bool {
+	for _, unit := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+			return true
+		}
+	}
+	return false
+}
+
+// check 判断是否可以开始下载：服务器返回网页或JSON（通常是登录页或错误信息）或磁盘空间不足时拒绝
+func (p *ProbeResult) check() error {
+	if mt := mediaType(p.ContentType); isErrorMediaType(mt) {
+		return unexpectedContentError(mt)
+	}
+	if p.Required > 0 && p.FreeSpace >= 0 && p.Required > p.FreeSpace {
+		return downloadErrorf(ErrCodeNoSpace, "磁盘空间不足：需要 %s，%s 所在磁盘只剩 %s",
+			formatSize(p.Required), filepath.Dir(p.OutputPath), formatSize(p.FreeSpace))
+	}
+	return nil
+}
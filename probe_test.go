@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestProbeFile 测试HEAD探测、重定向、HEAD不可用时改用GET，以及断点续传时需要的空间
+func TestProbeFile(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old.pdf":
+			http.Redirect(w, r, "/book.pdf", http.StatusFound)
+		case "/book.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("ETag", `"v1"`)
+			http.ServeContent(w, r, "", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), bytes.NewReader(data))
+		case "/nohead.pdf":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+		case "/login":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte("<html>请登录</html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	base := &Config{OutputDir: filepath.Join(dir, "books")}
+	ctx := context.Background()
+
+	result, err := probeFile(ctx, newTaskConfig(base, server.URL+"/old.pdf"))
+	if err != nil {
+		t.Fatalf("probeFile failed: %v", err)
+	}
+	if result.FinalURL != server.URL+"/book.pdf" || result.Size != 1000 || !result.AcceptRanges ||
+		result.ETag != `"v1"` || result.ContentType != "application/pdf" || result.LastModified == "" {
+		t.Errorf("Unexpected probe result: %+v", result)
+	}
+	// 输出目录还不存在时查询上级目录所在的磁盘
+	if result.Required != 1000 || result.FreeSpace <= 0 {
+		t.Errorf("Expected required 1000 and free space, got %+v", result)
+	}
+	if err := result.check(); err != nil {
+		t.Errorf("check failed: %v", err)
+	}
+
+	// 已下载一部分时只需要剩余部分的空间
+	config := newTaskConfig(&Config{OutputDir: dir}, server.URL+"/nohead.pdf")
+	writePartial(t, config.OutputPath, config.URL, data[:400])
+	result, err = probeFile(ctx, config)
+	if err != nil {
+		t.Fatalf("probeFile failed: %v", err)
+	}
+	if result.Size != 1000 || !result.AcceptRanges || result.Existing != 400 || result.Required != 600 {
+		t.Errorf("Unexpected GET probe result: %+v", result)
+	}
+
+	result, err = probeFile(ctx, newTaskConfig(base, server.URL+"/login"))
+	if err != nil {
+		t.Fatalf("probeFile failed: %v", err)
+	}
+	if err := result.check(); errorCode(err) != ErrCodeUnexpectedType {
+		t.Errorf("Expected unexpected_content error, got %v", err)
+	}
+
+	if _, err := probeFile(ctx, newTaskConfig(base, server.URL+"/missing.pdf")); errorCode(err) != ErrCodeNotFound {
+		t.Errorf("Expected not_found error, got %v", err)
+	}
+}
+
+// TestProbeResult_Check 测试磁盘空间不足和大小未知时的检查
+func TestProbeResult_Check(t *testing.T) {
+	p := &ProbeResult{Size: 3 << 30, Required: 3 << 30, FreeSpace: 1 << 30, OutputPath: filepath.Join("books", "a.pdf")}
+	err := p.check()
+	if errorCode(err) != ErrCodeNoSpace || !strings.Contains(err.Error(), "3.0 GB") || !strings.Contains(err.Error(), "1.0 GB") {
+		t.Errorf("Expected insufficient_space error, got %v", err)
+	}
+	// 大小或可用空间未知时不阻止下载
+	for _, p := range []*ProbeResult{{Size: -1, Required: -1, FreeSpace: 10}, {Size: 100, Required: 100, FreeSpace: -1}} {
+		if err := p.check(); err != nil {
+			t.Errorf("check(%+v): unexpected error %v", p, err)
+		}
+	}
+}
+
+// TestDownloadOne_Probe 测试启用探测时检查不通过不开始下载
+func TestDownloadOne_Probe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html></html>"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	config := &Config{OutputDir: dir, Timeout: "10s", ChunkSize: 1024}
+	var stdout bytes.Buffer
+	out := &reporter{format: outputJSON, stdout: &stdout, stderr: &bytes.Buffer{}}
+	_, err := downloadOne(config, server.URL+"/book.pdf", &downloadFlags{retries: 2, probe: true}, out)
+	if errorCode(err) != ErrCodeUnexpectedType {
+		t.Fatalf("Expected unexpected_content error, got %v", err)
+	}
+	events := decodeEvents(t, stdout.Bytes())
+	if len(events) != 3 || events[1].Event != cliEventProbe || events[1].Probe == nil || events[2].Event != cliEventFailed {
+		t.Errorf("Unexpected events: %s", stdout.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "book.pdf")); !os.IsNotExist(err) {
+		t.Errorf("Output file should not be created")
+	}
+}
+
+// TestAPI_Probe 测试探测接口返回文件信息和检查结果
+func TestAPI_Probe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/page" {
+			w.Header().Set("Content-Type", "text/html")
+		}
+		w.Write([]byte("%PDF-1.4 test"))
+	}))
+	defer server.Close()
+
+	_, handler := newTestWebServer(t)
+	rec := doRequest(handler, http.MethodPost, "/api/v1/probe", `{"url":"`+server.URL+`/book.pdf"}`)
+	var resp probeResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Unexpected response %d: %s", rec.Code, rec.Body)
+	}
+	if resp.Size != 13 || resp.Error != nil || !strings.HasSuffix(resp.OutputPath, "book.pdf") {
+		t.Errorf("Unexpected probe response: %s", rec.Body)
+	}
+
+	rec = doRequest(handler, http.MethodPost, "/api/v1/probe", `{"url":"`+server.URL+`/page"}`)
+	resp = probeResponse{}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.Error == nil || resp.Error.Code != ErrCodeUnexpectedType {
+		t.Errorf("Expected unexpected_content error: %s", rec.Body)
+	}
+
+	server.Close()
+	rec = doRequest(handler, http.MethodPost, "/api/v1/probe", `{"url":"`+server.URL+`/book.pdf"}`)
+	if rec.Code != http.StatusBadGateway || !strings.Contains(rec.Body.String(), ErrCodeNetwork) {
+		t.Errorf("Expected 502 network error, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := doRequest(handler, http.MethodPost, "/api/v1/probe", `{}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without url, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// defaultProfileName 默认配置方案的名称
+const defaultProfileName = "default"
+
+// profileNamePattern 配置方案名称的格式，名称会出现在API路径中
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+var (
+	ErrProfileNotFound    = errors.New("配置方案不存在")
+	ErrProfileActive      = errors.New("不能删除当前使用的配置方案")
+	errInvalidProfileName = errors.New("配置方案名称无效，只能包含字母、数字和 _.-，且不能以符号开头")
+)
+
+// ConfigFile 配置文件，包含多个命名的配置方案（如不同账号的令牌和输出目录），其中一个为当前使用的方案
+type ConfigFile struct {
+	Version       int                `json:"version" yaml:"version" toml:"version"`
+	ActiveProfile string             `json:"active_profile" yaml:"active_profile" toml:"active_profile"`
+	Profiles      map[string]*Config `json:"profiles" yaml:"profiles" toml:"profiles"`
+}
+
+// newConfigFile 创建只包含一个配置方案的配置文件
+func newConfigFile(name string, config *Config) *ConfigFile {
+	return &ConfigFile{
+		Version:       configVersion,
+		ActiveProfile: name,
+		Profiles:      map[string]*Config{name: config.Copy()},
+	}
+}
+
+// isProfileName 判断配置方案名称是否合法
+func isProfileName(name string) bool {
+	return profileNamePattern.MatchString(name)
+}
+
+// Names 返回按名称排序的所有配置方案
+func (cf *ConfigFile) Names() []string {
+	names := make([]string, 0, len(cf.Profiles))
+	for name := range cf.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Profile 返回指定的配置方案，name为空时返回当前方案
+func (cf *ConfigFile) Profile(name string) (*Config, error) {
+	if name == "" {
+		name = cf.ActiveProfile
+	}
+	config, ok := cf.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q，可用的配置方案: %s", ErrProfileNotFound, name, strings.Join(cf.Names(), ", "))
+	}
+	return config, nil
+}
+
+// SetProfile 添加或替换配置方案
+func (cf *ConfigFile) SetProfile(name string, config *Config) error {
+	if !isProfileName(name) {
+		return fmt.Errorf("%w: %q", errInvalidProfileName, name)
+	}
+	cf.Profiles[name] = config.Copy()
+	return nil
+}
+
+// DeleteProfile 删除配置方案，当前方案不能删除
+func (cf *ConfigFile) DeleteProfile(name string) error {
+	if _, err := cf.Profile(name); err != nil {
+		return err
+	}
+	if name == cf.ActiveProfile {
+		return fmt.Errorf("%w: %q", ErrProfileActive, name)
+	}
+	delete(cf.Profiles, name)
+	return nil
+}
+
+// Activate 将指定的配置方案设为当前方案
+func (cf *ConfigFile) Activate(name string) error {
+	if _, err := cf.Profile(name); err != nil {
+		return err
+	}
+	cf.ActiveProfile = name
+	return nil
+}
+
+// LoadConfigFile 加载并校验配置文件，包含所有配置方案
+func LoadConfigFile(filePath string) (*ConfigFile, error) {
+	cf, _, err := loadConfigFields(filePath)
+	return cf, err
+}
+
+// SaveConfigFile 按扩展名对应的格式保存配置文件，总是写入当前的格式版本。
+// 敏感请求头存入加密的保险库，配置文件中不保存其取值，文件权限为0600
+func SaveConfigFile(filePath string, cf *ConfigFile) error {
+	cf.Version = configVersion
+	stored, secrets := cf.splitSecrets()
+	previous, _ := os.ReadFile(filePath)
+	data, err := marshalConfigFile(configFormatOf(filePath), stored, previous)
+	if err != nil {
+		return fmt.Errorf("无法序列化配置: %v", err)
+	}
+	// 先写保险库，失败时配置文件保持原样，不会丢失令牌
+	if err := saveVaultSecrets(filePath, secrets); err != nil {
+		return err
+	}
+	return writePrivateFile(filePath, data)
+}
+
+// updateConfigFile 读取配置文件，修改后写回。文件不存在时从空的配置文件开始
+func updateConfigFile(filePath string, update func(cf *ConfigFile) error) error {
+	cf := &ConfigFile{Profiles: make(map[string]*Config)}
+	if _, err := os.Stat(filePath); !errors.Is(err, os.ErrNotExist) {
+		loaded, err := LoadConfigFile(filePath)
+		if err != nil {
+			return err
+		}
+		cf = loaded
+	}
+	if err := update(cf); err != nil {
+		return err
+	}
+	return SaveConfigFile(filePath, cf)
+}
+
+// SaveProfile 保存单个配置方案，name为空时保存到当前方案。
+// 文件不存在时创建配置文件，并将该方案设为当前方案
+func SaveProfile(filePath, name string, config *Config) error {
+	return updateConfigFile(filePath, func(cf *ConfigFile) error {
+		if name == "" {
+			name = cf.ActiveProfile
+		}
+		if name == "" {
+			name = defaultProfileName
+		}
+		if cf.ActiveProfile == "" {
+			cf.ActiveProfile = name
+		}
+		return cf.SetProfile(name, config)
+	})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const twoProfilesJSON = `{
+	"version": 2,
+	"active_profile": "school-a",
+	"profiles": {
+		"school-a": {"output_dir": "/books/a", "headers": {"X-Nd-Auth": "token-a"}},
+		"school-b": {"output_dir": "/books/b", "timeout": "5m", "headers": {"X-Nd-Auth": "token-b"}}
+	}
+}`
+
+// writeConfigFile 在临时目录中写入配置文件，返回文件路径
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestConfigOptions_Profile 测试 -profile 和 TBD_PROFILE 选择配置方案
+func TestConfigOptions_Profile(t *testing.T) {
+	path := writeConfigFile(t, twoProfilesJSON)
+
+	tests := []struct {
+		name    string
+		env     string
+		args    []string
+		profile string
+		token   string
+		timeout string
+	}{
+		{"active profile", "", nil, "school-a", "token-a", "30s"},
+		{"flag", "", []string{"-profile", "school-b"}, "school-b", "token-b", "5m"},
+		{"env", "school-b", nil, "school-b", "token-b", "5m"},
+		{"flag overrides env", "school-b", []string{"-profile", "school-a"}, "school-a", "token-a", "30s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TBD_PROFILE", tt.env)
+			fs := newFlagSet("download")
+			opts := registerConfigFlags(fs, true)
+			opts.environ = func() []string { return nil }
+			if err := fs.Parse(append([]string{"-config", path}, tt.args...)); err != nil {
+				t.Fatal(err)
+			}
+			resolved, err := opts.resolve()
+			if err != nil {
+				t.Fatalf("resolve failed: %v", err)
+			}
+			if resolved.Profile != tt.profile {
+				t.Errorf("Expected profile %s, got %s", tt.profile, resolved.Profile)
+			}
+			if token := resolved.Config.Headers["X-Nd-Auth"]; token != tt.token {
+				t.Errorf("Expected token %s, got %s", tt.token, token)
+			}
+			if resolved.Config.Timeout != tt.timeout {
+				t.Errorf("Expected timeout %s, got %s", tt.timeout, resolved.Config.Timeout)
+			}
+			if origin := resolved.Sources["header.X-Nd-Auth"].Origin; origin != path+"#"+tt.profile {
+				t.Errorf("Unexpected origin %s", origin)
+			}
+		})
+	}
+
+	fs := newFlagSet("download")
+	opts := registerConfigFlags(fs, true)
+	fs.Parse([]string{"-config", path, "-profile", "missing"})
+	if _, err := opts.resolve(); !errors.Is(err, ErrProfileNotFound) {
+		t.Errorf("Expected ErrProfileNotFound, got %v", err)
+	}
+}
+
+// TestLoadConfigFile_ProfileValidation 测试配置方案的名称、当前方案和字段校验
+func TestLoadConfigFile_ProfileValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		field string
+	}{
+		{"missing active", `{"version":2,"active_profile":"x","profiles":{"a":{}}}`, "active_profile"},
+		{"no profiles", `{"version":2,"active_profile":"a","profiles":{}}`, "profiles"},
+		{"invalid name", `{"version":2,"active_profile":"a","profiles":{"a":{},"b/c":{}}}`, "profiles.b/c"},
+		{"invalid field", `{"version":2,"active_profile":"a","profiles":{"a":{"chunk_size":0}}}`, "profiles.a.chunk_size"},
+		{"unknown top-level field", `{"version":2,"active_profile":"a","profiles":{"a":{}},"timeout":"1m"}`, "timeout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfigFile(writeConfigFile(t, tt.file))
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Expected ValidationError, got %v", err)
+			}
+			if len(verr.Fields) != 1 || verr.Fields[0].Field != tt.field {
+				t.Errorf("Expected error for %s, got %v", tt.field, verr.Fields)
+			}
+		})
+	}
+}
+
+// TestConfigFile_Profiles 测试配置方案的增删和切换
+func TestConfigFile_Profiles(t *testing.T) {
+	path := writeConfigFile(t, twoProfilesJSON)
+
+	if err := SaveProfile(path, "school-c", &Config{OutputDir: "/books/c"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveConfig(path, &Config{OutputDir: "/books/a2"}); err != nil {
+		t.Fatal(err)
+	}
+	cf, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cf.Profiles) != 3 || cf.Profiles["school-a"].OutputDir != "/books/a2" || cf.Profiles["school-b"].OutputDir != "/books/b" {
+		t.Errorf("Unexpected profiles after save: %v", cf.Names())
+	}
+
+	if err := cf.DeleteProfile("school-a"); !errors.Is(err, ErrProfileActive) {
+		t.Errorf("Expected ErrProfileActive, got %v", err)
+	}
+	if err := cf.Activate("school-c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cf.DeleteProfile("school-a"); err != nil {
+		t.Errorf("DeleteProfile failed: %v", err)
+	}
+	if err := cf.Activate("school-a"); !errors.Is(err, ErrProfileNotFound) {
+		t.Errorf("Expected ErrProfileNotFound, got %v", err)
+	}
+	if err := cf.SetProfile("../x", &Config{}); err == nil {
+		t.Errorf("Expected invalid profile name to be rejected")
+	}
+}
+
+// TestCLI_ConfigProfiles 测试 config 子命令管理配置方案
+func TestCLI_ConfigProfiles(t *testing.T) {
+	path := writeConfigFile(t, twoProfilesJSON)
+
+	steps := []struct {
+		args []string
+		code int
+	}{
+		{[]string{"config", "-config", path, "create", "school-c"}, exitOK},
+		{[]string{"config", "-config", path, "create", "school-c"}, exitConfig},
+		{[]string{"config", "-config", path, "-profile", "school-c", "set", "output_dir", t.TempDir()}, exitOK},
+		{[]string{"config", "-config", path, "use", "school-c"}, exitOK},
+		{[]string{"config", "-config", path, "delete", "school-c"}, exitConfig},
+		{[]string{"config", "-config", path, "delete", "school-b"}, exitOK},
+		{[]string{"config", "-config", path, "use", "missing"}, exitConfig},
+		{[]string{"config", "-config", path, "use"}, exitUsage},
+	}
+	for _, step := range steps {
+		if code := runCLI(step.args); code != step.code {
+			t.Errorf("%v: expected exit code %d, got %d", step.args, step.code, code)
+		}
+	}
+
+	cf, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cf.ActiveProfile != "school-c" || len(cf.Profiles) != 2 {
+		t.Errorf("Unexpected config file: active %s, profiles %v", cf.ActiveProfile, cf.Names())
+	}
+	// 新方案复制自当前方案，再单独修改输出目录
+	if c := cf.Profiles["school-c"]; c.Headers["X-Nd-Auth"] != "token-a" || c.OutputDir == "/books/a" {
+		t.Errorf("Unexpected school-c profile: %+v", c)
+	}
+}
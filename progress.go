@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// speedWindow 计算下载速度的时间窗口。取最近这段时间的平均速度，既能跟上网速变化又不会频繁跳动
+const speedWindow = 5 * time.Second
+
+// downloadStats 下载循环每次报告的进度统计
+type downloadStats struct {
+	Downloaded int64         // 已下载的字节数，包括断点续传前已有的部分
+	Total      int64         // 文件总大小，未知时为-1
+	Percent    float64       // 完成百分比
+	SpeedBPS   float64       // 最近一段时间的平均速度，字节每秒
+	ETASeconds int64         // 预计剩余秒数，速度未知时为-1
+	StartedAt  time.Time     // 本次下载开始的时间
+	Elapsed    time.Duration // 本次下载已用的时间
+}
+
+// speedSample 速度采样：采样时间和本次下载累计传输的字节数
+type speedSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// speedMeter 用时间窗口内的移动平均估算速度
+type speedMeter struct {
+	samples []speedSample // 按时间顺序，第一个是窗口的起点
+}
+
+// add 记录一次采样并返回窗口内的平均速度，字节每秒
+func (m *speedMeter) add(at time.Time, bytes int64) float64 {
+	m.samples = append(m.samples, speedSample{at: at, bytes: bytes})
+	// 第二个采样也已超出窗口时，第一个采样不再需要
+	drop := 0
+	for drop < len(m.samples)-1 && at.Sub(m.samples[drop+1].at) >= speedWindow {
+		drop++
+	}
+	m.samples = m.samples[drop:]
+
+	first := m.samples[0]
+	elapsed := at.Sub(first.at).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(bytes-first.bytes) / elapsed
+}
+
+// progressTracker 统计一次下载的进度、速度和剩余时间。
+// 速度只按本次传输的字节计算，断点续传时已有的startPos字节不计入
+type progressTracker struct {
+	startedAt time.Time
+	startPos  int64
+	meter     speedMeter
+}
+
+// newProgressTracker 在开始传输时创建统计
+func newProgressTracker(now time.Time, startPos int64) *progressTracker {
+	pt := &progressTracker{startedAt: now, startPos: startPos}
+	pt.meter.add(now, 0)
+	return pt
+}
+
+// stats 返回当前的进度统计
+func (pt *progressTracker) stats(now time.Time, downloaded, total int64) downloadStats {
+	stats := downloadStats{
+		Downloaded: downloaded,
+		Total:      total,
+		SpeedBPS:   pt.meter.add(now, downloaded-pt.startPos),
+		ETASeconds: -1,
+		StartedAt:  pt.startedAt,
+		Elapsed:    now.Sub(pt.startedAt),
+	}
+	switch {
+	case total < 0:
+		// 大小未知，无法计算百分比和剩余时间
+	case downloaded >= total:
+		stats.Percent, stats.ETASeconds = 100, 0
+	default:
+		stats.Percent = float64(downloaded) / float64(total) * 100
+		if stats.SpeedBPS > 0 {
+			stats.ETASeconds = int64(math.Ceil(float64(total-downloaded) / stats.SpeedBPS))
+		}
+	}
+	return stats
+}
+
+// indeterminateBar 大小未知时的进度条，标记随已下载的字节数移动，表示下载仍在进行
+func indeterminateBar(length int, downloaded int64) string {
+	const marker = "<=>"
+	if length <= len(marker) {
+		return strings.Repeat(" ", max(length, 0))
+	}
+	pos := int(downloaded/(256<<10)) % (length - len(marker) + 1)
+	return strings.Repeat(" ", pos) + marker + strings.Repeat(" ", length-len(marker)-pos)
+}
+
+// formatSize 把字节数格式化为便于阅读的大小
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, suffix := float64(n)/unit, "KB"
+	for _, s := range []string{"MB", "GB", "TB"} {
+		if value < unit {
+			break
+		}
+		value, suffix = value/unit, s
+	}
+	return fmt.Sprintf("%.1f %s", value, suffix)
+}
+
+// formatSpeed 格式化下载速度
+func formatSpeed(bps float64) string {
+	return formatSize(int64(bps)) + "/s"
+}
+
+// formatETA 把剩余秒数格式化为 mm:ss 或 h:mm:ss，未知时为 --:--
+func formatETA(seconds int64) string {
+	switch {
+	case seconds < 0:
+		return "--:--"
+	case seconds >= 3600:
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%02d:%02d", seconds/60, seconds%60)
+}
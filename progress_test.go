@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestProgressTracker 测试速度的移动平均和剩余时间，断点续传已有的字节不计入速度
+func TestProgressTracker(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	pt := newProgressTracker(start, 900_000)
+
+	// 1秒内传输了100KB，续传前已有的900KB不影响速度
+	stats := pt.stats(start.Add(time.Second), 1_000_000, 2_000_000)
+	if stats.SpeedBPS != 100_000 {
+		t.Errorf("Expected 100000 B/s, got %v", stats.SpeedBPS)
+	}
+	if stats.ETASeconds != 10 || stats.Percent != 50 || stats.Elapsed != time.Second {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	// 之后速度降到每秒10KB，窗口滑过之后只反映最近的速度
+	downloaded := int64(1_000_000)
+	for i := 2; i <= 8; i++ {
+		downloaded += 10_000
+		stats = pt.stats(start.Add(time.Duration(i)*time.Second), downloaded, 2_000_000)
+	}
+	if stats.SpeedBPS < 9_999 || stats.SpeedBPS > 10_001 {
+		t.Errorf("Expected about 10000 B/s after the window, got %v", stats.SpeedBPS)
+	}
+
+	// 刚开始时速度未知
+	pt = newProgressTracker(start, 0)
+	if stats := pt.stats(start, 0, 1000); stats.ETASeconds != -1 || stats.SpeedBPS != 0 {
+		t.Errorf("Expected unknown ETA, got %+v", stats)
+	}
+	if stats := pt.stats(start.Add(time.Second), 1000, 1000); stats.ETASeconds != 0 {
+		t.Errorf("Expected ETA 0 when finished, got %+v", stats)
+	}
+
+	// 大小未知时只有速度
+	if stats := pt.stats(start.Add(2*time.Second), 3000, -1); stats.Percent != 0 || stats.ETASeconds != -1 || stats.SpeedBPS != 1500 {
+		t.Errorf("Unexpected stats for unknown size %+v", stats)
+	}
+	for _, downloaded := range []int64{0, 1 << 20, 100 << 20} {
+		if bar := indeterminateBar(20, downloaded); len(bar) != 20 || !strings.Contains(bar, "<=>") {
+			t.Errorf("Unexpected indeterminate bar %q", bar)
+		}
+	}
+}
+
+// TestFormatProgress 测试速度和剩余时间的显示格式
+func TestFormatProgress(t *testing.T) {
+	tests := map[string]string{
+		formatSpeed(1536):   "1.5 KB/s",
+		formatSize(3 << 30): "3.0 GB",
+		formatSize(512):     "512 B",
+		formatETA(-1):       "--:--",
+		formatETA(75):       "01:15",
+		formatETA(3723):     "1:02:03",
+	}
+	for got, want := range tests {
+		if got != want {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+	}
+
+	var buf bytes.Buffer
+	printProgress(&buf, "book.pdf", downloadStats{Downloaded: 1 << 20, Total: 4 << 20, Percent: 25, SpeedBPS: 512 << 10, ETASeconds: 6})
+	if out := buf.String(); !strings.Contains(out, "512.0 KB/s") || !strings.Contains(out, "剩余 00:06") {
+		t.Errorf("Unexpected progress line %q", out)
+	}
+}
+
+// TestTaskManager_Stats 测试任务进度中的速度、开始时间和用时
+func TestTaskManager_Stats(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 64<<10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	// 已下载一半，继续下载
+	tm := NewTaskManager(nil)
+	config := newTaskConfig(&Config{OutputDir: dir, Timeout: "10s", ChunkSize: 1024}, server.URL+"/a.pdf")
+	writePartial(t, config.OutputPath, config.URL, data[:32<<10])
+	task := tm.Start(config, "")
+	if task.ETASeconds != -1 || task.StartedAt != nil {
+		t.Errorf("Unexpected initial task %+v", task)
+	}
+	done := waitTaskStatus(t, tm, task.TaskID, TaskStatusCompleted)
+	if done.StartedAt == nil || done.StartedAt.Before(task.CreatedAt) || done.Elapsed <= 0 {
+		t.Errorf("Expected start time and elapsed, got %+v", done)
+	}
+	if done.SpeedBPS != 0 || done.ETASeconds != 0 || done.Downloaded != int64(len(data)) {
+		t.Errorf("Unexpected completed task %+v", done)
+	}
+}
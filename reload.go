@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+)
+
+// configPollInterval 检查配置文件是否被修改的间隔
+const configPollInterval = 2 * time.Second
+
+// configFileState 配置文件和保险库的修改时间与大小，任意一项变化即认为文件被修改
+type configFileState struct {
+	configModTime, configSize int64
+	vaultModTime, vaultSize   int64
+}
+
+// statConfigFiles 读取配置文件和保险库的状态，文件不存在时对应的值为0
+func statConfigFiles(configPath string) configFileState {
+	var state configFileState
+	if info, err := os.Stat(configPath); err == nil {
+		state.configModTime, state.configSize = info.ModTime().UnixNano(), info.Size()
+	}
+	if info, err := os.Stat(vaultPath(configPath)); err == nil {
+		state.vaultModTime, state.vaultSize = info.ModTime().UnixNano(), info.Size()
+	}
+	return state
+}
+
+// watchConfig 定期检查配置文件，被外部修改时重新加载。
+// 正在运行的任务使用创建时的配置副本，不受影响
+func (ws *WebServer) watchConfig(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := ws.reloadConfig(); err != nil {
+			fmt.Printf("重新加载配置文件失败，继续使用原配置: %v\n", err)
+		}
+	}
+}
+
+// reloadConfig 配置文件或保险库被修改时重新加载当前配置方案，校验通过后整体替换当前配置，
+// 并广播config_changed事件。文件未修改、文件被删除或有效配置没有变化时返回false
+func (ws *WebServer) reloadConfig() (bool, error) {
+	ws.configMu.Lock()
+	defer ws.configMu.Unlock()
+
+	state := statConfigFiles(ws.configPath)
+	if state == ws.fileState {
+		return false, nil
+	}
+	// 无论加载是否成功都记录新状态，同一次修改只报告一次错误
+	ws.fileState = state
+	if state.configModTime == 0 {
+		return false, nil
+	}
+
+	snapshot := ws.config.Load()
+	config, err := ws.profileConfig(snapshot.profile)
+	if err == nil {
+		err = config.Validate()
+	}
+	if err != nil {
+		ws.events.Publish(Event{Type: EventLog, Level: LogLevelError, Message: fmt.Sprintf("配置文件重新加载失败，继续使用原配置：%v", err)})
+		return false, err
+	}
+	if reflect.DeepEqual(config, snapshot.config) {
+		return false, nil
+	}
+
+	ws.config.Store(&configSnapshot{profile: snapshot.profile, config: config})
+	ws.events.Publish(Event{
+		Type:    EventConfigChanged,
+		Profile: snapshot.profile,
+		Config:  config.Redacted(),
+		Message: "配置文件已更新，新任务将使用新配置",
+	})
+	return true, nil
+}
+
+// recordConfigWritten 记录程序自身写入配置文件后的状态，避免把自己的修改当作外部修改重新加载。
+// 调用方需持有configMu
+func (ws *WebServer) recordConfigWritten() {
+	ws.fileState = statConfigFiles(ws.configPath)
+}
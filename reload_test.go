@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventRecorder 记录收到的事件
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *eventRecorder) Deliver(evt *Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, *evt)
+}
+
+// ofType 返回指定类型的事件
+func (r *eventRecorder) ofType(eventType string) []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var events []Event
+	for _, evt := range r.events {
+		if evt.Type == eventType {
+			events = append(events, evt)
+		}
+	}
+	return events
+}
+
+// touchConfigFile 写入配置文件并调整修改时间，避免文件系统时间精度导致修改未被发现
+func touchConfigFile(t *testing.T, path, content string, offset time.Duration) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(offset)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestWebServer_ReloadConfig 测试配置文件被外部修改后重新加载并广播事件
+func TestWebServer_ReloadConfig(t *testing.T) {
+	t.Setenv(envVaultPassphrase, "")
+	ws, _ := newTestWebServer(t)
+	recorder := &eventRecorder{}
+	ws.events.Subscribe(recorder)
+
+	config := ws.currentConfig().Copy()
+	if err := ws.replaceConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := ws.reloadConfig(); changed || err != nil {
+		t.Errorf("Own write should not trigger a reload: %v %v", changed, err)
+	}
+
+	dir := strings.TrimSuffix(ws.configPath, "config.json")
+	touchConfigFile(t, ws.configPath, `{"version":2,"active_profile":"default","profiles":{"default":{
+		"output_dir":"`+dir+`","timeout":"5m","headers":{"X-Nd-Auth":"new-token"}}}}`, time.Second)
+	changed, err := ws.reloadConfig()
+	if err != nil || !changed {
+		t.Fatalf("Expected reload, got %v %v", changed, err)
+	}
+	current := ws.currentConfig()
+	if current.Timeout != "5m" || current.Headers["X-Nd-Auth"] != "new-token" {
+		t.Errorf("Unexpected config after reload: %+v", current)
+	}
+	events := recorder.ofType(EventConfigChanged)
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 config_changed event, got %d", len(events))
+	}
+	if events[0].Profile != defaultProfileName || events[0].Config.Timeout != "5m" {
+		t.Errorf("Unexpected event %+v", events[0])
+	}
+	if events[0].Config.Headers["X-Nd-Auth"] != redactedValue {
+		t.Errorf("Token leaked in config_changed event")
+	}
+
+	// 加载令牌时文件被改写，再次检查不应重复广播
+	if changed, _ := ws.reloadConfig(); changed {
+		t.Errorf("Unchanged config should not be reloaded again")
+	}
+
+	// 无效的修改保留原配置
+	touchConfigFile(t, ws.configPath, `{"version":2,"active_profile":"default","profiles":{"default":{"timeout":"soon"}}}`, 2*time.Second)
+	if _, err := ws.reloadConfig(); err == nil {
+		t.Errorf("Expected error for invalid config")
+	}
+	if ws.currentConfig().Timeout != "5m" {
+		t.Errorf("Invalid config replaced the current config")
+	}
+	if len(recorder.ofType(EventLog)) == 0 {
+		t.Errorf("Expected a log event for the failed reload")
+	}
+	if len(recorder.ofType(EventConfigChanged)) != 1 {
+		t.Errorf("Failed reload should not broadcast config_changed")
+	}
+}
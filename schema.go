@@ -0,0 +1,367 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// configVersion 当前配置文件格式的版本，修改格式时递增并在configMigrations中添加迁移函数
+const configVersion = 2
+
+// maxChunkSize 分块大小的上限
+const maxChunkSize = 256 * 1024 * 1024
+
+// on_exists 和 partial_files 的可选值，resource_types 的可选值见 resourceTypes
+var (
+	onExistsPolicies = []string{OnExistsSkip, OnExistsOverwrite, OnExistsRename, OnExistsVerify}
+	partialPolicies  = []string{PartialResume, PartialRemove}
+)
+
+// configMigrations 配置文件迁移函数，第i个函数将版本i的配置升级到版本i+1
+var configMigrations = []func(raw map[string]interface{}) error{
+	migrateConfigV0,
+	migrateConfigV1,
+}
+
+// migrateConfigV0 早期版本没有version字段，Web界面会保存空的output_dir、timeout和0值的chunk_size。
+// 去掉这些无效值以使用默认值，数字形式的timeout按秒转换
+func migrateConfigV0(raw map[string]interface{}) error {
+	for _, key := range []string{"output_dir", "output_path", "url", "timeout"} {
+		if value, ok := raw[key].(string); ok && strings.TrimSpace(value) == "" {
+			delete(raw, key)
+		}
+	}
+	if seconds, ok := raw["timeout"].(float64); ok {
+		if seconds > 0 {
+			raw["timeout"] = (time.Duration(seconds) * time.Second).String()
+		} else {
+			delete(raw, "timeout")
+		}
+	}
+	if size, ok := raw["chunk_size"].(float64); ok && size <= 0 {
+		delete(raw, "chunk_size")
+	}
+	if raw["headers"] == nil {
+		delete(raw, "headers")
+	}
+	return nil
+}
+
+// migrateConfigV1 版本1只有一套配置，将其移入名为default的配置方案并设为当前方案
+func migrateConfigV1(raw map[string]interface{}) error {
+	profile := make(map[string]interface{})
+	for key, value := range raw {
+		if key != "version" {
+			profile[key] = value
+			delete(raw, key)
+		}
+	}
+	raw["active_profile"] = defaultProfileName
+	raw["profiles"] = map[string]interface{}{defaultProfileName: profile}
+	return nil
+}
+
+// FieldError 单个配置项的校验错误
+type FieldError struct {
+	Field   string `json:"field"` // 配置项键名，请求头为 header.<名称>
+	Message string `json:"message"`
+}
+
+// ValidationError 配置校验错误，包含所有不合法的配置项
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		parts[i] = f.Field + ": " + f.Message
+	}
+	return "配置无效: " + strings.Join(parts, "；")
+}
+
+func (e *ValidationError) add(field, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// errOrNil 没有错误时返回nil，避免返回带类型的空指针
+func (e *ValidationError) errOrNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Validate 校验完整的配置，包括输出目录是否可写
+func (dc *Config) Validate() error {
+	verr := dc.validate(func(string) bool { return true })
+	if verr.Fields == nil && dc.OutputDir != "" {
+		if err := checkWritableDir(dc.OutputDir); err != nil {
+			verr.add("output_dir", "%v", err)
+		}
+	}
+	return verr.errOrNil()
+}
+
+// validate 校验present返回true的配置项，只检查取值本身，不访问文件系统。请求头总是会被校验
+func (dc *Config) validate(present func(key string) bool) *ValidationError {
+	verr := &ValidationError{}
+	if present("url") && dc.URL != "" {
+		if u, err := url.Parse(dc.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			verr.add("url", "必须是 http 或 https 地址")
+		}
+	}
+	if present("output_dir") && strings.TrimSpace(dc.OutputDir) == "" {
+		verr.add("output_dir", "不能为空")
+	}
+	if present("timeout") {
+		if d, err := time.ParseDuration(dc.Timeout); err != nil {
+			verr.add("timeout", "%q 不是有效的时间长度，应为 30s、5m 等格式", dc.Timeout)
+		} else if d <= 0 {
+			verr.add("timeout", "必须大于0")
+		}
+	}
+	if present("chunk_size") {
+		if dc.ChunkSize <= 0 {
+			verr.add("chunk_size", "必须大于0")
+		} else if dc.ChunkSize > maxChunkSize {
+			verr.add("chunk_size", "不能超过 %d 字节", maxChunkSize)
+		}
+	}
+	if present("on_exists") && dc.OnExists != "" && !slices.Contains(onExistsPolicies, dc.OnExists) {
+		verr.add("on_exists", "只能是 %s", strings.Join(onExistsPolicies, "、"))
+	}
+	if present("partial_files") && dc.PartialFiles != "" && !slices.Contains(partialPolicies, dc.PartialFiles) {
+		verr.add("partial_files", "只能是 %s", strings.Join(partialPolicies, "、"))
+	}
+	if present("resource_types") {
+		for _, t := range dc.ResourceTypes {
+			if !slices.Contains(resourceTypes, t) {
+				verr.add("resource_types", "%q 不是支持的资源类型，只能是 %s", t, strings.Join(resourceTypes, "、"))
+			}
+		}
+	}
+	if present("secret_headers") {
+		for _, name := range dc.SecretHeaders {
+			if !isHeaderName(name) {
+				verr.add("secret_headers", "%q 不是合法的请求头名称", name)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(dc.Headers))
+	for name := range dc.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !isHeaderName(name) {
+			verr.add("header."+name, "请求头名称只能包含字母、数字和 !#$%%&'*+-.^_`|~")
+		} else if strings.ContainsAny(dc.Headers[name], "\r\n\x00") {
+			verr.add("header."+name, "请求头的值不能包含换行符")
+		}
+	}
+	return verr
+}
+
+// isHeaderName 判断是否为合法的HTTP请求头名称（RFC 9110 token）
+func isHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("!#$%&'*+-.^_`|~", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// checkWritableDir 检查目录是否可写。目录不存在时检查最近的已存在上级目录，下载时会自动创建
+func checkWritableDir(dir string) error {
+	dir = filepath.Clean(dir)
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s 不是目录", dir)
+			}
+			f, err := os.CreateTemp(dir, ".tbd-write-test-*")
+			if err != nil {
+				return fmt.Errorf("目录 %s 不可写", dir)
+			}
+			f.Close()
+			os.Remove(f.Name())
+			return nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("无法访问 %s: %v", dir, err)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil
+		}
+		dir = parent
+	}
+}
+
+// knownConfigFields 配置方案中允许出现的字段
+func knownConfigFields() map[string]bool {
+	known := map[string]bool{"headers": true}
+	for _, key := range configKeys {
+		known[key] = true
+	}
+	return known
+}
+
+// parseConfigData 解析配置文件内容，必要时迁移到当前版本并校验每个配置方案中出现的配置项。
+// 返回解析后的配置文件、每个配置方案中实际出现的字段，以及文件原来的版本
+func parseConfigData(data []byte) (*ConfigFile, map[string]map[string]interface{}, int, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, 0, fmt.Errorf("无法解析配置文件: %v", err)
+	}
+	if raw == nil {
+		raw = make(map[string]interface{})
+	}
+
+	version := 0
+	if v, ok := raw["version"]; ok {
+		f, ok := v.(float64)
+		if !ok || f != float64(int(f)) || f < 0 {
+			return nil, nil, 0, &ValidationError{Fields: []FieldError{{Field: "version", Message: "必须是非负整数"}}}
+		}
+		version = int(f)
+	}
+	if version > configVersion {
+		return nil, nil, version, fmt.Errorf("配置文件版本 %d 高于当前程序支持的版本 %d，请升级程序", version, configVersion)
+	}
+	for v := version; v < configVersion; v++ {
+		if err := configMigrations[v](raw); err != nil {
+			return nil, nil, version, fmt.Errorf("无法将配置从版本 %d 升级到 %d: %v", v, v+1, err)
+		}
+	}
+	raw["version"] = configVersion
+
+	// 未知字段多为拼写错误，逐一报告而不是静默忽略
+	verr := &ValidationError{}
+	for _, key := range sortedKeys(raw) {
+		if key != "version" && key != "active_profile" && key != "profiles" {
+			verr.add(key, "未知的配置项")
+		}
+	}
+
+	cf := &ConfigFile{Version: configVersion, Profiles: make(map[string]*Config)}
+	if active, ok := raw["active_profile"].(string); ok {
+		cf.ActiveProfile = active
+	} else {
+		verr.add("active_profile", "必须是配置方案的名称")
+	}
+	profiles, ok := raw["profiles"].(map[string]interface{})
+	if !ok || len(profiles) == 0 {
+		verr.add("profiles", "至少需要一个配置方案")
+	}
+	fields := make(map[string]map[string]interface{}, len(profiles))
+	for _, name := range sortedKeys(profiles) {
+		prefix := "profiles." + name
+		if !isProfileName(name) {
+			verr.add(prefix, "名称只能包含字母、数字和 _.-，且不能以符号开头")
+			continue
+		}
+		profileRaw, ok := profiles[name].(map[string]interface{})
+		if !ok {
+			verr.add(prefix, "必须是对象")
+			continue
+		}
+		config, perr := decodeProfile(profileRaw)
+		for _, f := range perr.Fields {
+			field := prefix
+			if f.Field != "" {
+				field += "." + f.Field
+			}
+			verr.add(field, "%s", f.Message)
+		}
+		cf.Profiles[name] = config
+		fields[name] = profileRaw
+	}
+	if cf.ActiveProfile != "" && len(profiles) > 0 && profiles[cf.ActiveProfile] == nil {
+		verr.add("active_profile", "配置方案 %q 不存在", cf.ActiveProfile)
+	}
+	if len(verr.Fields) > 0 {
+		return nil, nil, version, verr
+	}
+	return cf, fields, version, nil
+}
+
+// decodeProfile 解析并校验单个配置方案，只校验其中出现的配置项
+func decodeProfile(raw map[string]interface{}) (*Config, *ValidationError) {
+	verr := &ValidationError{}
+	known := knownConfigFields()
+	for _, key := range sortedKeys(raw) {
+		if !known[key] {
+			verr.add(key, "未知的配置项")
+		}
+	}
+	if len(verr.Fields) > 0 {
+		return nil, verr
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		verr.add("", "无法序列化配置: %v", err)
+		return nil, verr
+	}
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			verr.add(typeErr.Field, "类型错误，应为 %s", typeErr.Type.String())
+		} else {
+			verr.add("", "无法解析: %v", err)
+		}
+		return nil, verr
+	}
+	return &config, config.validate(func(key string) bool {
+		_, ok := raw[key]
+		return ok
+	})
+}
+
+// sortedKeys 返回按名称排序的键
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// migrateConfigFile 备份旧版本的配置文件，并写入迁移后的内容。
+// 敏感请求头迁移后保存在保险库中，备份中的明文取值替换为占位符，其余内容与原文件相同
+func migrateConfigFile(filePath string, data []byte, cf *ConfigFile, from int) error {
+	backup := fmt.Sprintf("%s.v%d.bak", filePath, from)
+	text := string(data)
+	for _, config := range cf.Profiles {
+		text = config.RedactString(text)
+	}
+	if err := writePrivateFile(backup, []byte(text)); err != nil {
+		return fmt.Errorf("无法备份配置文件: %v", err)
+	}
+	if err := SaveConfigFile(filePath, cf); err != nil {
+		return fmt.Errorf("无法写入升级后的配置文件: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "已将配置文件 %s 从版本 %d 升级到 %d，原文件备份为 %s\n", filePath, from, configVersion, backup)
+	return nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLoadConfig_Validation 测试加载配置文件时的校验错误
+func TestLoadConfig_Validation(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		field string
+	}{
+		{"zero chunk_size", `{"version":1,"chunk_size":0}`, "chunk_size"},
+		{"negative chunk_size", `{"version":1,"chunk_size":-1}`, "chunk_size"},
+		{"huge chunk_size", `{"version":1,"chunk_size":1099511627776}`, "chunk_size"},
+		{"invalid timeout", `{"version":1,"timeout":"soon"}`, "timeout"},
+		{"negative timeout", `{"version":1,"timeout":"-1s"}`, "timeout"},
+		{"empty output_dir", `{"version":1,"output_dir":""}`, "output_dir"},
+		{"invalid url", `{"version":1,"url":"ftp://example.com/a.pdf"}`, "url"},
+		{"invalid header name", `{"version":1,"headers":{"X Bad":"1"}}`, "header.X Bad"},
+		{"header value with newline", `{"version":1,"headers":{"X-Test":"a\r\nb"}}`, "header.X-Test"},
+		{"unknown field", `{"version":1,"chunksize":1024}`, "chunksize"},
+		{"wrong type", `{"version":1,"chunk_size":"1024"}`, "chunk_size"},
+		{"invalid version", `{"version":"1"}`, "version"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(tt.file), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadConfig(path)
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Expected ValidationError, got %v", err)
+			}
+			if len(verr.Fields) != 1 || verr.Fields[0].Field != tt.field && verr.Fields[0].Field != "profiles.default."+tt.field {
+				t.Errorf("Expected error for %s, got %v", tt.field, verr.Fields)
+			}
+		})
+	}
+}
+
+// TestLoadConfig_NewerVersion 测试不支持更高版本的配置文件
+func TestLoadConfig_NewerVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"version":99}`), 0644)
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "99") {
+		t.Errorf("Expected version error, got %v", err)
+	}
+}
+
+// TestLoadConfig_Migration 测试旧版本配置文件的自动升级和备份
+func TestLoadConfig_Migration(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	original := `{"url":"","output_dir":"","output_path":"","timeout":60,"chunk_size":0,"headers":{"X-Nd-Auth":"token"}}`
+	if err := os.WriteFile(path, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if config.Timeout != "1m0s" || config.Headers["X-Nd-Auth"] != "token" {
+		t.Errorf("Unexpected migrated config: %+v", config)
+	}
+
+	backup, err := os.ReadFile(path + ".v0.bak")
+	if err != nil {
+		t.Fatalf("Backup not written: %v", err)
+	}
+	// 令牌移入保险库，备份中不保留明文
+	if want := strings.Replace(original, `"token"`, `"`+redactedValue+`"`, 1); string(backup) != want {
+		t.Errorf("Expected backup %s, got %s", want, backup)
+	}
+
+	var raw struct {
+		Version       int                               `json:"version"`
+		ActiveProfile string                            `json:"active_profile"`
+		Profiles      map[string]map[string]interface{} `json:"profiles"`
+	}
+	data, _ := os.ReadFile(path)
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if raw.Version != configVersion || raw.ActiveProfile != defaultProfileName {
+		t.Errorf("Expected version %d with active profile %s, got %d %s", configVersion, defaultProfileName, raw.Version, raw.ActiveProfile)
+	}
+	profile, ok := raw.Profiles[defaultProfileName]
+	if !ok {
+		t.Fatalf("Expected settings to move into the %s profile", defaultProfileName)
+	}
+	for _, key := range []string{"output_dir", "chunk_size", "url"} {
+		if _, ok := profile[key]; ok {
+			t.Errorf("Expected invalid %s to be dropped", key)
+		}
+	}
+
+	// 升级后的文件与默认值合并后是有效配置
+	file, name, _, err := fileSettings(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolved, err := resolveConfig(defaultSettings(), file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != defaultProfileName {
+		t.Errorf("Expected profile %s, got %s", defaultProfileName, name)
+	}
+	if err := resolved.Config.Validate(); err != nil {
+		t.Errorf("Migrated config is invalid: %v", err)
+	}
+}
+
+// TestLoadConfig_MigrationReadOnly 测试只读目录中的旧版本配置文件只在内存中升级，加载和只读的命令不会失败
+func TestLoadConfig_MigrationReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	original := `{"timeout":60,"headers":{"X-Nd-Auth":"token"}}`
+	writeConfigFileAt(t, path, original)
+	os.Chmod(dir, 0555)
+	defer os.Chmod(dir, 0755)
+	if f, err := os.Create(filepath.Join(dir, "probe")); err == nil {
+		// 以root运行时不受目录权限限制，改为让备份无法写入
+		f.Close()
+		os.Remove(f.Name())
+		os.Mkdir(path+".v0.bak", 0755)
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if config.Timeout != "1m0s" || config.Headers["X-Nd-Auth"] != "token" {
+		t.Errorf("Unexpected migrated config: %+v", config)
+	}
+	if data, _ := os.ReadFile(path); string(data) != original {
+		t.Errorf("Expected the config file to be unchanged, got %s", data)
+	}
+	if code := runCLI([]string{"config", "-config", path, "get", "timeout"}); code != exitOK {
+		t.Errorf("Expected config get to succeed, got exit code %d", code)
+	}
+}
+
+// TestConfig_ValidateOutputDir 测试输出目录可写性检查
+func TestConfig_ValidateOutputDir(t *testing.T) {
+	dir := t.TempDir()
+	config := getDefaultConfig()
+
+	config.OutputDir = filepath.Join(dir, "not", "created", "yet")
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected missing dir under writable parent to be valid: %v", err)
+	}
+
+	file := filepath.Join(dir, "file")
+	os.WriteFile(file, nil, 0644)
+	config.OutputDir = file
+	var verr *ValidationError
+	if err := config.Validate(); !errors.As(err, &verr) || verr.Fields[0].Field != "output_dir" {
+		t.Errorf("Expected output_dir error, got %v", err)
+	}
+}
+
+// TestConfigOptions_InvalidMerged 测试合并后的配置校验失败时注明来源
+func TestConfigOptions_InvalidMerged(t *testing.T) {
+	fs := newFlagSet("download")
+	opts := registerConfigFlags(fs, true)
+	opts.environ = func() []string { return nil }
+	if err := fs.Parse([]string{"-config", filepath.Join(t.TempDir(), "missing.json"), "-chunk", "0"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := opts.resolve()
+	if err == nil || !strings.Contains(err.Error(), "-chunk") {
+		t.Errorf("Expected error mentioning -chunk, got %v", err)
+	}
+}
+
+// TestWebServer_SaveConfigValidation 测试设置表单保存无效配置时返回每个配置项的错误
+func TestWebServer_SaveConfigValidation(t *testing.T) {
+	ws, handler := newTestWebServer(t)
+	before := ws.currentConfig()
+
+	rec := doRequest(handler, http.MethodPost, "/save-config", `{"output_dir":"","timeout":"x","chunk_size":0,"headers":{"Bad Name":"1"}}`)
+	var resp struct {
+		Success bool         `json:"success"`
+		Errors  []FieldError `json:"errors"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Success {
+		t.Fatalf("Expected invalid config to be rejected")
+	}
+	fields := make(map[string]bool)
+	for _, f := range resp.Errors {
+		fields[f.Field] = true
+	}
+	for _, field := range []string{"output_dir", "timeout", "chunk_size", "header.Bad Name"} {
+		if !fields[field] {
+			t.Errorf("Expected error for %s, got %v", field, resp.Errors)
+		}
+	}
+	if ws.currentConfig() != before {
+		t.Errorf("Invalid config replaced the current config")
+	}
+	if _, err := os.Stat(ws.configPath); err == nil {
+		t.Errorf("Invalid config was written to disk")
+	}
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// searchIndexName 全文索引的文件名，保存在输出目录中
+const searchIndexName = ".search-index.json"
+
+// searchIndexVersion 索引格式的版本，格式变化时重新建立索引
+const searchIndexVersion = 1
+
+// snippetRunes 搜索结果摘要中匹配位置前后保留的字数
+const snippetRunes = 30
+
+// SearchIndex 输出目录中PDF文件的全文索引。中文、日文和韩文按相邻两个字（bigram）切分，其他文字按单词切分并转为小写
+type SearchIndex struct {
+	Version  int                `json:"version"`
+	Books    []*IndexedBook     `json:"books"`
+	Postings map[string][]int32 `json:"postings"` // 词 → 出现该词的页面编号，页面按 Books 的顺序依次编号
+
+	pages []indexedPage // 页面编号对应的文件和页码，加载时生成
+}
+
+// IndexedBook 已建立索引的文件
+type IndexedBook struct {
+	Path    string    `json:"path"` // 相对于输出目录的路径，以 / 分隔
+	Title   string    `json:"title"`
+	ID      string    `json:"id,omitempty"` // 平台资源ID，来自 book.json
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Pages   []string  `json:"pages"`           // 每一页的文字
+	Error   string    `json:"error,omitempty"` // 无法提取文字的原因，文件变化后重试
+}
+
+// indexedPage 页面编号对应的位置
+type indexedPage struct {
+	book, page int
+}
+
+// SearchResult 搜索结果中的一页
+type SearchResult struct {
+	Path    string `json:"path"` // 相对于输出目录的路径，以 / 分隔
+	Title   string `json:"title"`
+	ID      string `json:"id,omitempty"`
+	Page    int    `json:"page"` // 页码，从1开始
+	Snippet string `json:"snippet"`
+	Score   int    `json:"score"`
+	URL     string `json:"url,omitempty"` // Web界面中打开该页的地址
+}
+
+// isCJK 是否是按bigram切分的字符
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// searchTokens 把文字切分为索引词。连续的中日韩文字产生相邻两字的组合，只有一个字时作为单字词；
+// 中日韩文字之间的空白不打断组合，因为提取的文字常在字之间或行尾插入空白
+func searchTokens(text string) []string {
+	var tokens []string
+	var word []rune
+	var run []rune // 当前连续的中日韩文字
+	flushWord := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, string(word))
+			word = word[:0]
+		}
+	}
+	flushRun := func() {
+		if len(run) == 1 {
+			tokens = append(tokens, string(run))
+		}
+		run = run[:0]
+	}
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			flushWord()
+			run = append(run, r)
+			if len(run) >= 2 {
+				tokens = append(tokens, string(run[len(run)-2:]))
+			}
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushRun()
+			word = append(word, unicode.ToLower(r))
+		case unicode.IsSpace(r):
+			flushWord()
+		default:
+			flushWord()
+			flushRun()
+		}
+	}
+	flushWord()
+	flushRun()
+	return tokens
+}
+
+// normalizeSearchText 去掉空白并转为小写，用于判断页面是否包含完整的搜索词。
+// 返回的每个字符对应原文中的位置，用于截取摘要
+func normalizeSearchText(text string) ([]rune, []int) {
+	runes := []rune(text)
+	norm := make([]rune, 0, len(runes))
+	positions := make([]int, 0, len(runes))
+	for i, r := range runes {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		norm = append(norm, unicode.ToLower(r))
+		positions = append(positions, i)
+	}
+	return norm, positions
+}
+
+// loadSearchIndex 读取输出目录中的索引，不存在或格式版本不同时返回空索引
+func loadSearchIndex(dir string) (*SearchIndex, error) {
+	idx := &SearchIndex{Version: searchIndexVersion}
+	data, err := os.ReadFile(filepath.Join(dir, searchIndexName))
+	if errors.Is(err, os.ErrNotExist) {
+		idx.rebuildPostings()
+		return idx, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取索引失败：%v", err)
+	}
+	if err := json.Unmarshal(data, idx); err != nil || idx.Version != searchIndexVersion {
+		// 损坏或旧版本的索引重新建立
+		idx = &SearchIndex{Version: searchIndexVersion}
+		idx.rebuildPostings()
+		return idx, nil
+	}
+	idx.pages = idx.pages[:0]
+	for b, book := range idx.Books {
+		for p := range book.Pages {
+			idx.pages = append(idx.pages, indexedPage{book: b, page: p})
+		}
+	}
+	return idx, nil
+}
+
+// save 写入索引，先写临时文件再重命名
+func (idx *SearchIndex) save(dir string) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, searchIndexName)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("写入索引失败：%v", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("写入索引失败：%v", err)
+	}
+	return nil
+}
+
+// clone 返回可以独立更新的副本，已提取的文字与原索引共用
+func (idx *SearchIndex) clone() *SearchIndex {
+	return &SearchIndex{
+		Version:  idx.Version,
+		Books:    slices.Clone(idx.Books),
+		Postings: idx.Postings,
+		pages:    slices.Clone(idx.pages),
+	}
+}
+
+// update 扫描输出目录，为新增和修改过的PDF提取文字，删除已不存在的文件，返回索引是否有变化。
+// progress 在开始提取每个文件时调用，可以为nil
+func (idx *SearchIndex) update(dir string, progress func(path string)) (bool, error) {
+	existing := make(map[string]*IndexedBook, len(idx.Books))
+	for _, book := range idx.Books {
+		existing[book.Path] = book
+	}
+	var books []*IndexedBook
+	changed := false
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			return nil
+		}
+		if d.IsDir() || !strings.EqualFold(filepath.Ext(path), ".pdf") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if book, ok := existing[rel]; ok && book.Size == info.Size() && book.ModTime.Equal(info.ModTime()) {
+			books = append(books, book)
+			return nil
+		}
+		if progress != nil {
+			progress(path)
+		}
+		books = append(books, indexBook(path, rel, info))
+		changed = true
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("扫描输出目录失败：%v", err)
+	}
+	if len(books) != len(idx.Books) {
+		changed = true
+	}
+	if changed {
+		idx.Books = books
+		idx.rebuildPostings()
+	}
+	return changed, nil
+}
+
+// indexBook 提取一个文件的文字和标题。标题依次取教材文件夹中 book.json 记录的标题、PDF文档信息中的标题和文件名
+func indexBook(path, rel string, info fs.FileInfo) *IndexedBook {
+	book := &IndexedBook{Path: rel, Size: info.Size(), ModTime: info.ModTime()}
+	pages, f, err := extractPDFText(path)
+	if err != nil {
+		book.Error = err.Error()
+	} else {
+		book.Pages = pages
+		if title, ok := f.dict(f.trailer["Info"])["Title"].(pdfString); ok {
+			book.Title = strings.TrimSpace(decodePDFText(title))
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(bookDir(path), bookManifestName)); err == nil {
+		var manifest BookManifest
+		if json.Unmarshal(data, &manifest) == nil && manifest.Title != "" {
+			book.Title, book.ID = manifest.Title, manifest.ID
+		}
+	}
+	if book.Title == "" {
+		book.Title = partTitle(path)
+	}
+	return book
+}
+
+// rebuildPostings 按全部页面的文字重新生成倒排索引
+func (idx *SearchIndex) rebuildPostings() {
+	idx.Postings = make(map[string][]int32)
+	idx.pages = idx.pages[:0]
+	for b, book := range idx.Books {
+		for p, text := range book.Pages {
+			id := int32(len(idx.pages))
+			idx.pages = append(idx.pages, indexedPage{book: b, page: p})
+			seen := make(map[string]bool)
+			for _, token := range searchTokens(text) {
+				if !seen[token] {
+					seen[token] = true
+					idx.Postings[token] = append(idx.Postings[token], id)
+				}
+			}
+		}
+	}
+}
+
+// tokenPages 返回包含索引词的页面。单个汉字还要查找包含它的两字组合
+func (idx *SearchIndex) tokenPages(token string) []int32 {
+	runes := []rune(token)
+	if len(runes) != 1 || !isCJK(runes[0]) {
+		return idx.Postings[token]
+	}
+	var pages []int32
+	for key, ids := range idx.Postings {
+		if strings.ContainsRune(key, runes[0]) && len([]rune(key)) <= 2 {
+			pages = append(pages, ids...)
+		}
+	}
+	slices.Sort(pages)
+	return slices.Compact(pages)
+}
+
+// intersect 求两个有序页面列表的交集
+func intersect(a, b []int32) []int32 {
+	var out []int32
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+// search 查找包含全部搜索词的页面。包含完整搜索词（忽略空白和大小写）的页面排在前面，按出现次数排序；
+// limit 大于0时最多返回limit个结果
+func (idx *SearchIndex) search(query string, limit int) []SearchResult {
+	tokens := searchTokens(query)
+	if len(tokens) == 0 {
+		return nil
+	}
+	slices.Sort(tokens)
+	tokens = slices.Compact(tokens)
+	candidates := idx.tokenPages(tokens[0])
+	for _, token := range tokens[1:] {
+		if len(candidates) == 0 {
+			break
+		}
+		candidates = intersect(candidates, idx.tokenPages(token))
+	}
+
+	phrase, _ := normalizeSearchText(query)
+	results := make([]SearchResult, 0, len(candidates))
+	for _, id := range candidates {
+		loc := idx.pages[id]
+		book := idx.Books[loc.book]
+		text := book.Pages[loc.page]
+		norm, positions := normalizeSearchText(text)
+		matches := 0
+		first := -1
+		for i := 0; i+len(phrase) <= len(norm); i++ {
+			if slices.Equal(norm[i:i+len(phrase)], phrase) {
+				if first < 0 {
+					first = i
+				}
+				matches++
+			}
+		}
+		score := 1
+		if matches > 0 {
+			score = 10 * matches
+		} else {
+			// 没有完整的搜索词时，摘要从第一个索引词开始
+			first = indexRunes(norm, []rune(tokens[0]))
+		}
+		results = append(results, SearchResult{
+			Path: book.Path, Title: book.Title, ID: book.ID, Page: loc.page + 1, Score: score,
+			Snippet: snippet([]rune(text), positions, first, len(phrase)),
+		})
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if results[i].Path != results[j].Path {
+			return results[i].Path < results[j].Path
+		}
+		return results[i].Page < results[j].Page
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// indexRunes 查找sub在s中第一次出现的位置，没有时返回-1
+func indexRunes(s, sub []rune) int {
+	for i := 0; i+len(sub) <= len(s); i++ {
+		if slices.Equal(s[i:i+len(sub)], sub) {
+			return i
+		}
+	}
+	return -1
+}
+
+// snippet 截取匹配位置前后的文字作为摘要，空白合并为一个空格
+func snippet(text []rune, positions []int, match, length int) string {
+	if len(positions) == 0 {
+		return ""
+	}
+	start, end := 0, len(text)
+	if match >= 0 {
+		start = max(positions[match]-snippetRunes, 0)
+		end = min(positions[min(match+max(length, 1), len(positions))-1]+1+snippetRunes, len(text))
+	} else {
+		end = min(2*snippetRunes, len(text))
+	}
+	s := strings.Join(strings.Fields(string(text[start:end])), " ")
+	if start > 0 {
+		s = "…" + s
+	}
+	if end < len(text) {
+		s += "…"
+	}
+	return s
+}
+
+// openSearchIndex 读取输出目录中的索引并为新增和修改过的文件更新，有变化时保存。reindex为true时重新建立全部索引
+func openSearchIndex(dir string, reindex bool, progress func(path string)) (*SearchIndex, error) {
+	idx := &SearchIndex{Version: searchIndexVersion}
+	if !reindex {
+		var err error
+		if idx, err = loadSearchIndex(dir); err != nil {
+			return nil, err
+		}
+	}
+	changed, err := idx.update(dir, progress)
+	if err != nil {
+		return nil, err
+	}
+	if changed || reindex {
+		if err := idx.save(dir); err != nil {
+			return nil, err
+		}
+	}
+	return idx, nil
+}
+
+// cmdSearch 在已下载的PDF中搜索文字
+func cmdSearch(args []string) int {
+	fs := newFlagSet("search")
+	opts := registerConfigFlags(fs, false)
+	asJSON := fs.Bool("json", false, "以JSON格式输出")
+	limit := fs.Int("limit", 20, "最多输出的结果数（0表示不限制）")
+	reindex := fs.Bool("reindex", false, "重新提取全部文件的文字")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	query := strings.Join(fs.Args(), " ")
+	if len(searchTokens(query)) == 0 {
+		return usageError(fs, "必须指定要搜索的关键字")
+	}
+	config, _, code := loadOptions(opts)
+	if config == nil {
+		return code
+	}
+
+	idx, err := openSearchIndex(config.OutputDir, *reindex, func(path string) {
+		if !*asJSON {
+			fmt.Fprintf(os.Stderr, "正在提取文字：%s\n", path)
+		}
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		return exitError
+	}
+	results := idx.search(query, *limit)
+	if *asJSON {
+		if results == nil {
+			results = []SearchResult{}
+		}
+		printJSON(results)
+		return exitOK
+	}
+	if len(results) == 0 {
+		fmt.Fprintf(os.Stderr, "在 %s 中没有找到 %q\n", config.OutputDir, query)
+		return exitOK
+	}
+	for _, r := range results {
+		fmt.Printf("%s\t第%d页\t%s\n\t%s\n", r.Title, r.Page, filepath.Join(config.OutputDir, filepath.FromSlash(r.Path)), r.Snippet)
+	}
+	return exitOK
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSearchTokens 测试中文按相邻两字切分、其他文字按单词切分
+func TestSearchTokens(t *testing.T) {
+	for _, tt := range []struct {
+		text string
+		want []string
+	}{
+		{"分数的加减法", []string{"分数", "数的", "的加", "加减", "减法"}},
+		{"分数 的\n加减", []string{"分数", "数的", "的加", "加减"}},
+		{"第3课 数", []string{"第", "3", "课数"}},
+		{"Unit 1：Hello, World", []string{"unit", "1", "hello", "world"}},
+		{"小数Decimals。", []string{"小数", "decimals"}},
+		{" ，。", nil},
+	} {
+		if got := searchTokens(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("searchTokens(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+// writeTestLibrary 在输出目录中写入两本教材：math.pdf 有 book.json 记录的标题，notes.pdf 使用文件名作为标题
+func writeTestLibrary(t *testing.T, dir string) {
+	t.Helper()
+	os.WriteFile(filepath.Join(dir, "math.pdf"), buildCJKTestPDF(t, testCJKPages), 0644)
+	os.MkdirAll(filepath.Join(dir, "math"), 0755)
+	manifest, _ := json.Marshal(BookManifest{ID: "6d0e7a35-64a4-4b8f-a1b3-0c9d3e7f2a10", Title: "数学五年级下册"})
+	os.WriteFile(filepath.Join(dir, "math", bookManifestName), manifest, 0644)
+	os.MkdirAll(filepath.Join(dir, "notes"), 0755)
+	os.WriteFile(filepath.Join(dir, "notes", "notes.pdf"), buildCJKTestPDF(t, [][]string{{"分数的加法和减法，加减法"}, {"分数的加减法 分数的加减法"}}), 0644)
+	// 未完成的下载和损坏的文件不影响其他文件
+	os.WriteFile(filepath.Join(dir, "broken.pdf.part"), []byte("%PDF-1.4"), 0644)
+	os.WriteFile(filepath.Join(dir, "broken.pdf"), []byte("not a pdf"), 0644)
+}
+
+// TestSearchIndex 测试建立索引、增量更新和按页搜索
+func TestSearchIndex(t *testing.T) {
+	dir := t.TempDir()
+	writeTestLibrary(t, dir)
+
+	var extracted []string
+	progress := func(path string) { extracted = append(extracted, filepath.Base(path)) }
+	idx, err := openSearchIndex(dir, false, progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(extracted) != 3 {
+		t.Errorf("Expected 3 files to be extracted, got %q", extracted)
+	}
+
+	results := idx.search("分数的加减法", 0)
+	var got []string
+	for _, r := range results {
+		got = append(got, r.Title+"#"+strconv.Itoa(r.Page))
+	}
+	// 出现两次的页面排在前面，跨行的搜索词也能匹配，只包含部分词的页面排在最后
+	want := []string{"notes#2", "数学五年级下册#1", "notes#1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected %q, got %q", want, got)
+	}
+	if r := results[1]; r.Path != "math.pdf" || r.ID == "" || !strings.Contains(r.Snippet, "分数的加减法") {
+		t.Errorf("Unexpected result %+v", r)
+	}
+	if results := idx.search("减", 0); len(results) != 3 {
+		t.Errorf("Expected a single character to match 3 pages, got %d", len(results))
+	}
+	if results := idx.search("decimals 零点五", 0); len(results) != 1 || results[0].Page != 2 {
+		t.Errorf("Unexpected results %+v", results)
+	}
+	if results := idx.search("分数 除法", 0); len(results) != 0 {
+		t.Errorf("Expected no results, got %+v", results)
+	}
+	if results := idx.search("分数", 1); len(results) != 1 {
+		t.Errorf("Expected limit to apply, got %d results", len(results))
+	}
+
+	// 重新打开时只提取修改过的文件，删除的文件从索引中移除
+	extracted = nil
+	os.Remove(filepath.Join(dir, "notes", "notes.pdf"))
+	os.WriteFile(filepath.Join(dir, "math.pdf"), buildCJKTestPDF(t, [][]string{{"图形的运动"}}), 0644)
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(filepath.Join(dir, "math.pdf"), future, future)
+	idx, err = openSearchIndex(dir, false, progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(extracted, []string{"math.pdf"}) {
+		t.Errorf("Expected only math.pdf to be extracted, got %q", extracted)
+	}
+	if results := idx.search("加减法", 0); len(results) != 0 {
+		t.Errorf("Expected stale pages to be removed, got %+v", results)
+	}
+	if results := idx.search("图形", 0); len(results) != 1 || results[0].Title != "数学五年级下册" {
+		t.Errorf("Unexpected results %+v", results)
+	}
+}
+
+// TestSearchCommand 测试 search 命令
+func TestSearchCommand(t *testing.T) {
+	dir := t.TempDir()
+	writeTestLibrary(t, dir)
+	configPath := filepath.Join(t.TempDir(), "config.json")
+
+	if code := runCLI([]string{"search", "-config", configPath, "-dir", dir, "-json", "分数的加减法"}); code != exitOK {
+		t.Fatalf("Expected exit code %d, got %d", exitOK, code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, searchIndexName)); err != nil {
+		t.Errorf("Expected the index to be saved: %v", err)
+	}
+	if code := runCLI([]string{"search", "-config", configPath, "-dir", dir, "-reindex", "没有的内容"}); code != exitOK {
+		t.Errorf("Expected exit code %d without results, got %d", exitOK, code)
+	}
+	if code := runCLI([]string{"search", "-config", configPath, "-dir", dir, "，"}); code != exitUsage {
+		t.Errorf("Expected exit code %d without keywords, got %d", exitUsage, code)
+	}
+}
+
+// TestAPI_Search 测试搜索接口和打开搜索结果中的文件
+func TestAPI_Search(t *testing.T) {
+	ws, handler := newTestWebServer(t)
+	writeTestLibrary(t, ws.currentConfig().OutputDir)
+
+	// 索引建立之前没有结果，只报告正在建立索引
+	rec := doRequest(handler, http.MethodGet, "/api/v1/search?q=x", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"results":[]`) {
+		t.Fatalf("Expected empty results before indexing, got %d: %s", rec.Code, rec.Body.String())
+	}
+	ws.startSearchIndexer()
+	waitSearchIndexed(t, ws)
+
+	rec = doRequest(handler, http.MethodGet, "/api/v1/search?q="+"%E5%8A%A0%E5%87%8F%E6%B3%95&limit=2", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Query    string         `json:"query"`
+		Results  []SearchResult `json:"results"`
+		Indexing bool           `json:"indexing"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.Query != "加减法" || len(resp.Results) != 2 || resp.Indexing {
+		t.Fatalf("Unexpected response %s", rec.Body.String())
+	}
+	if r := resp.Results[0]; r.URL != "/api/v1/library/notes/notes.pdf#page=2" {
+		t.Errorf("Unexpected url %q", r.URL)
+	}
+
+	rec = doRequest(handler, http.MethodGet, "/api/v1/library/notes/notes.pdf", "")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Body.String(), "%PDF-") {
+		t.Errorf("Expected the PDF file, got %d", rec.Code)
+	}
+	for _, path := range []string{"/api/v1/library/missing.pdf", "/api/v1/library/" + searchIndexName, "/api/v1/library/..%2Fconfig.json"} {
+		if rec := doRequest(handler, http.MethodGet, path, ""); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", path, rec.Code)
+		}
+	}
+	if rec := doRequest(handler, http.MethodGet, "/api/v1/search?q=", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a query, got %d", rec.Code)
+	}
+	if rec := doRequest(handler, http.MethodPost, "/api/v1/search?q=x", ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", rec.Code)
+	}
+}
+
+// waitSearchIndexed 等待后台的索引更新结束，最多等待5秒
+func waitSearchIndexed(t *testing.T, ws *WebServer) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		ws.searchMu.Lock()
+		indexing := ws.searchIndexing
+		ws.searchMu.Unlock()
+		if !indexing {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Timed out waiting for the search index")
+}
+
+// TestAPI_SearchRefresh 测试搜索请求不更新索引，下载完成后在后台更新
+func TestAPI_SearchRefresh(t *testing.T) {
+	ws, handler := newTestWebServer(t)
+	dir := ws.currentConfig().OutputDir
+	ws.startSearchIndexer()
+	waitSearchIndexed(t, ws)
+
+	os.WriteFile(filepath.Join(dir, "new.pdf"), buildCJKTestPDF(t, testCJKPages), 0644)
+	search := func() (results int, indexing bool) {
+		rec := doRequest(handler, http.MethodGet, "/api/v1/search?q=%E5%88%86%E6%95%B0", "")
+		var resp struct {
+			Results  []SearchResult `json:"results"`
+			Indexing bool           `json:"indexing"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return len(resp.Results), resp.Indexing
+	}
+	if n, indexing := search(); n != 0 || indexing {
+		t.Fatalf("Expected the search not to index new files, got %d results, indexing %v", n, indexing)
+	}
+	if _, err := os.Stat(filepath.Join(dir, searchIndexName)); err == nil {
+		t.Error("Expected an empty library not to save an index")
+	}
+
+	// 其他事件不触发更新
+	ws.events.Publish(Event{Type: EventProgress, Task: &DownloadProgress{Status: TaskStatusDownloading}})
+	waitSearchIndexed(t, ws)
+	if n, _ := search(); n != 0 {
+		t.Fatalf("Expected no refresh on progress events, got %d results", n)
+	}
+	ws.events.Publish(Event{Type: EventStateChanged, Task: &DownloadProgress{Status: TaskStatusCompleted}})
+	waitSearchIndexed(t, ws)
+	if n, indexing := search(); n != 1 || indexing {
+		t.Errorf("Expected the new file to be indexed, got %d results, indexing %v", n, indexing)
+	}
+	if _, err := os.Stat(filepath.Join(dir, searchIndexName)); err != nil {
+		t.Errorf("Expected the index to be saved: %v", err)
+	}
+}
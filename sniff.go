@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// sniffLen 写入文件前检查的响应开头的字节数，与 http.DetectContentType 使用的长度相同
+const sniffLen = 512
+
+// maxErrorBody 解析错误信息时最多读取的响应体大小
+const maxErrorBody = 64 << 10
+
+// fileSignatures 常见资源文件开头的标识。内容以这些标识开头时，即使服务器声明的类型不对也认为是文件
+var fileSignatures = [][]byte{
+	[]byte("%PDF-"),
+	[]byte("PK\x03\x04"),       // zip、docx、pptx
+	[]byte("\x89PNG\r\n"),      // png
+	[]byte("\xff\xd8\xff"),     // jpeg
+	[]byte("ID3"),              // mp3
+	[]byte("\xd0\xcf\x11\xe0"), // doc、ppt
+}
+
+// titlePattern 提取网页标题
+var titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// isErrorMediaType 是否是错误页面常用的内容类型：网页、JSON或XML
+func isErrorMediaType(mediaType string) bool {
+	switch mediaType {
+	case "text/html", "application/xhtml+xml", "application/json", "text/json", "application/xml", "text/xml":
+		return true
+	}
+	return strings.HasSuffix(mediaType, "+json")
+}
+
+// mediaType 返回 Content-Type 中的类型部分，无法解析时返回空字符串
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mt
+}
+
+// sniffMediaType 根据内容开头判断是否是网页、JSON或XML，都不是时返回空字符串
+func sniffMediaType(head []byte) string {
+	trimmed := bytes.TrimLeft(head, "\t\n\r \xef\xbb\xbf")
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return "application/json"
+	}
+	if mt := mediaType(http.DetectContentType(head)); isErrorMediaType(mt) {
+		return mt
+	}
+	return ""
+}
+
+// guardContent 在写入文件前检查响应内容，服务器返回网页、JSON或XML错误信息而不是文件时返回错误。
+// fromStart为false表示断点续传，响应从文件中间开始，只检查服务器声明的类型。
+// 返回的Reader包含已读取用于检查的部分，应代替resp.Body读取
+func guardContent(resp *http.Response, fromStart bool) (io.Reader, error) {
+	body := bufio.NewReaderSize(resp.Body, sniffLen)
+	declared := mediaType(resp.Header.Get("Content-Type"))
+	if !fromStart {
+		if isErrorMediaType(declared) {
+			return nil, contentError(declared, body)
+		}
+		return body, nil
+	}
+
+	head, err := body.Peek(sniffLen)
+	if err != nil && err != io.EOF && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, downloadErrorf(ErrCodeNetwork, "读取数据失败：%v", err)
+	}
+	for _, signature := range fileSignatures {
+		if bytes.HasPrefix(head, signature) {
+			return body, nil
+		}
+	}
+	if sniffed := sniffMediaType(head); sniffed != "" {
+		return nil, contentError(sniffed, body)
+	}
+	if isErrorMediaType(declared) {
+		return nil, contentError(declared, body)
+	}
+	return body, nil
+}
+
+// contentError 读取错误页面并返回包含平台错误信息的下载错误
+func contentError(mediaType string, body io.Reader) error {
+	data, _ := io.ReadAll(io.LimitReader(body, maxErrorBody))
+	if pe := parsePlatformError(data); pe != nil {
+		code := ErrCodeUnexpectedType
+		if pe.isAuth() {
+			code = ErrCodeUnauthorized
+		}
+		return downloadErrorf(code, "服务器返回了错误信息而不是文件：%s", pe)
+	}
+	return unexpectedContentError(mediaType)
+}
+
+// unexpectedContentError 服务器返回的不是文件时的错误
+func unexpectedContentError(mediaType string) error {
+	return downloadErrorf(ErrCodeUnexpectedType, "服务器返回的是%s（%s）而不是文件，请检查地址和令牌是否有效", mediaTypeName(mediaType), mediaType)
+}
+
+// mediaTypeName 错误提示中的类型名称
+func mediaTypeName(mediaType string) string {
+	switch {
+	case strings.Contains(mediaType, "html"):
+		return "网页"
+	case strings.Contains(mediaType, "json"):
+		return "JSON"
+	case strings.Contains(mediaType, "xml"):
+		return "XML"
+	}
+	return "其他内容"
+}
+
+// platformError 从错误响应中解析出的错误码和错误信息
+type platformError struct {
+	Code    string
+	Message string
+}
+
+func (pe *platformError) String() string {
+	if pe.Code == "" {
+		return pe.Message
+	}
+	if pe.Message == "" {
+		return pe.Code
+	}
+	return fmt.Sprintf("%s（%s）", pe.Message, pe.Code)
+}
+
+// isAuth 错误码是否表示令牌无效或过期，如 UC/AUTH_TOKEN_EXPIRED
+func (pe *platformError) isAuth() bool {
+	code := strings.ToUpper(pe.Code)
+	return strings.Contains(code, "AUTH") || strings.Contains(code, "TOKEN")
+}
+
+// 常见错误JSON中表示错误码和错误信息的字段，按优先级排列
+var (
+	errorCodeKeys    = []string{"code", "errcode", "error_code", "error"}
+	errorMessageKeys = []string{"message", "msg", "errmsg", "error_msg", "error_description", "detail"}
+)
+
+// parsePlatformError 解析错误响应，支持平台接口的 {"code": "...", "message": "..."}、
+// {"error": {"code": "...", "message": "..."}}、{"errcode": ..., "errmsg": "..."} 等JSON格式，
+// 对象存储的 <Error><Code>..</Code><Message>..</Message></Error> 格式，以及网页标题。无法识别时返回nil
+func parsePlatformError(data []byte) *platformError {
+	data = bytes.TrimSpace(data)
+	var object map[string]interface{}
+	if json.Unmarshal(data, &object) == nil {
+		return platformErrorFromJSON(object)
+	}
+
+	var xmlError struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}
+	if xml.Unmarshal(data, &xmlError) == nil && (xmlError.Code != "" || xmlError.Message != "") {
+		return &platformError{Code: strings.TrimSpace(xmlError.Code), Message: strings.TrimSpace(xmlError.Message)}
+	}
+
+	if m := titlePattern.FindSubmatch(data); m != nil {
+		if title := strings.TrimSpace(html.UnescapeString(string(m[1]))); title != "" {
+			return &platformError{Message: "网页标题：" + title}
+		}
+	}
+	return nil
+}
+
+// platformErrorFromJSON 从JSON对象中取出错误码和错误信息，error字段是对象时从中查找
+func platformErrorFromJSON(object map[string]interface{}) *platformError {
+	if nested, ok := object["error"].(map[string]interface{}); ok {
+		if pe := platformErrorFromJSON(nested); pe != nil {
+			return pe
+		}
+	}
+	pe := &platformError{Code: firstJSONString(object, errorCodeKeys), Message: firstJSONString(object, errorMessageKeys)}
+	if pe.Code == "" && pe.Message == "" {
+		return nil
+	}
+	return pe
+}
+
+// firstJSONString 返回第一个存在的字段的值，数字转换为字符串，其他类型忽略
+func firstJSONString(object map[string]interface{}, keys []string) string {
+	for _, key := range keys {
+		switch v := object[key].(type) {
+		case string:
+			if v = strings.TrimSpace(v); v != "" {
+				return v
+			}
+		case float64:
+			return fmt.Sprint(v)
+		}
+	}
+	return ""
+}
+
+// readPlatformError 读取错误状态响应的响应体并解析错误信息，响应体已关闭或无法识别时返回nil
+func readPlatformError(resp *http.Response) *platformError {
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if err != nil && len(data) == 0 {
+		return nil
+	}
+	return parsePlatformError(data)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestParsePlatformError 测试各种错误响应格式的解析
+func TestParsePlatformError(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string // 空字符串表示无法识别
+	}{
+		{"platform", `{"code":"UC/AUTH_TOKEN_EXPIRED","message":"令牌已过期","host_id":"x"}`, "令牌已过期（UC/AUTH_TOKEN_EXPIRED）"},
+		{"nested", `{"error":{"code":"forbidden","message":"无权访问"}}`, "无权访问（forbidden）"},
+		{"errcode", `{"errcode":40001,"errmsg":"invalid credential"}`, "invalid credential（40001）"},
+		{"oauth", `{"error":"invalid_token","error_description":"expired"}`, "expired（invalid_token）"},
+		{"message only", `{"msg":"资源不存在"}`, "资源不存在"},
+		{"oss", `<?xml version="1.0" encoding="UTF-8"?><Error><Code>AccessDenied</Code><Message>Request has expired.</Message></Error>`, "Request has expired.（AccessDenied）"},
+		{"html", "<html><head><title> 登录 &amp; 注册 </title></head></html>", "网页标题：登录 & 注册"},
+		{"empty object", `{}`, ""},
+		{"plain", "not found", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pe := parsePlatformError([]byte(tt.body))
+			got := ""
+			if pe != nil {
+				got = pe.String()
+			}
+			if got != tt.want {
+				t.Errorf("parsePlatformError(%s) = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDownloadPDF_RejectsErrorPages 测试服务器返回错误页面时不写入文件，并显示平台的错误信息
+func TestDownloadPDF_RejectsErrorPages(t *testing.T) {
+	pdf := []byte("%PDF-1.4 test")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/expired.pdf":
+			// 声明为PDF，内容却是平台的错误信息
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write([]byte(`{"code":"UC/AUTH_TOKEN_EXPIRED","message":"令牌已过期"}`))
+		case "/login.pdf":
+			w.Write([]byte("<!DOCTYPE html><html><body>请登录</body></html>"))
+		case "/json.pdf":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`"busy"`))
+		case "/denied.pdf":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`<Error><Code>AccessDenied</Code><Message>Request has expired.</Message></Error>`))
+		case "/mislabeled.pdf":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write(pdf)
+		case "/resume.pdf":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Range", "bytes 4-12/13")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte(`{"msg":"x"}`))
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	download := func(name string) (string, error) {
+		path := filepath.Join(dir, name)
+		config := Config{URL: server.URL + "/" + name, OutputPath: path, ChunkSize: 4096}
+		return path, downloadPDFWithProgress(context.Background(), config, nil)
+	}
+
+	tests := []struct {
+		name    string
+		code    string
+		message string
+	}{
+		{"expired.pdf", ErrCodeUnauthorized, "令牌已过期（UC/AUTH_TOKEN_EXPIRED）"},
+		{"login.pdf", ErrCodeUnexpectedType, "网页"},
+		{"json.pdf", ErrCodeUnexpectedType, "JSON"},
+		{"denied.pdf", ErrCodeUnauthorized, "Request has expired.（AccessDenied）"},
+	}
+	for _, tt := range tests {
+		path, err := download(tt.name)
+		if errorCode(err) != tt.code || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s: expected %s error containing %q, got %v", tt.name, tt.code, tt.message, err)
+		}
+		if fileExists(path) || fileExists(partialPath(path)) {
+			t.Errorf("%s: error page should not be saved", tt.name)
+		}
+	}
+
+	// 内容以PDF标识开头时，即使声明的类型不对也正常保存
+	path, err := download("mislabeled.pdf")
+	if err != nil {
+		t.Fatalf("Expected mislabeled PDF to be saved, got %v", err)
+	}
+	if content, _ := os.ReadFile(path); !bytes.Equal(content, pdf) {
+		t.Errorf("Unexpected content %q", content)
+	}
+
+	// 断点续传时检查声明的类型，已下载的部分保留
+	path = filepath.Join(dir, "resume.pdf")
+	writePartial(t, path, server.URL+"/resume.pdf", pdf[:4])
+	if _, err := download("resume.pdf"); errorCode(err) != ErrCodeUnexpectedType {
+		t.Errorf("Expected unexpected_content error when resuming, got %v", err)
+	}
+	if content, _ := os.ReadFile(partialPath(path)); !bytes.Equal(content, pdf[:4]) {
+		t.Errorf("Partial file should be kept, got %q", content)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// sseKeepAliveInterval SSE保活注释的发送间隔，防止代理因空闲断开连接
+const sseKeepAliveInterval = 15 * time.Second
+
+// sseQueueSize 每个SSE连接的待发送事件队列长度
+const sseQueueSize = 256
+
+// sseClient 单个SSE连接，事件先进入队列，由处理请求的goroutine写出
+type sseClient struct {
+	events   chan Event
+	overflow chan struct{} // 队列溢出时关闭，让客户端带着Last-Event-ID重连补发
+}
+
+// Deliver 实现EventSubscriber接口，队列已满时断开连接而不是阻塞发布者
+func (c *sseClient) Deliver(evt *Event) {
+	select {
+	case c.events <- *evt:
+	default:
+		select {
+		case <-c.overflow:
+		default:
+			close(c.overflow)
+		}
+	}
+}
+
+// handleEvents 处理SSE事件流请求，消息内容与WebSocket推送的事件一致
+func (ws *WebServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // 关闭nginx等反向代理的缓冲
+
+	client := &sseClient{
+		events:   make(chan Event, sseQueueSize),
+		overflow: make(chan struct{}),
+	}
+
+	// 浏览器重连时通过Last-Event-ID头告知最后收到的事件，首次连接也可以通过查询参数指定
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+
+	var seq uint64
+	write := func(evt Event) bool {
+		seq++
+		evt.Seq = seq
+		if evt.Time.IsZero() {
+			evt.Time = time.Now()
+		}
+		data, err := json.Marshal(evt)
+		if err != nil {
+			fmt.Printf("序列化事件失败: %v\n", err)
+			return true
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", evt.ID, evt.Type, data); err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+
+	// 能够补发时只发送错过的事件，否则发送全量快照
+	replayed := false
+	if afterID, err := strconv.ParseUint(lastEventID, 10, 64); err == nil {
+		missed, ok := ws.events.SubscribeSince(client, afterID)
+		if ok {
+			replayed = true
+			for _, evt := range missed {
+				if !write(evt) {
+					ws.events.Unsubscribe(client)
+					return
+				}
+			}
+		}
+	}
+	if !replayed {
+		lastID := ws.events.Subscribe(client)
+		if !write(ws.snapshotEvent(lastID)) {
+			ws.events.Unsubscribe(client)
+			return
+		}
+	}
+	defer ws.events.Unsubscribe(client)
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-client.overflow:
+			return
+		case evt := <-client.events:
+			if !write(evt) {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
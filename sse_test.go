@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// nopSubscriber 不处理任何事件的订阅者
+type nopSubscriber struct{}
+
+func (nopSubscriber) Deliver(evt *Event) {}
+
+// readSSEEvent 从SSE流中读取一条事件，跳过保活注释
+func readSSEEvent(t *testing.T, reader *bufio.Reader) (id uint64, evt Event) {
+	t.Helper()
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Read SSE stream failed: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id, _ = strconv.ParseUint(strings.TrimPrefix(line, "id: "), 10, 64)
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &evt); err != nil {
+				t.Fatalf("Invalid SSE data: %v", err)
+			}
+		case line == "" && evt.Type != "":
+			return id, evt
+		}
+	}
+}
+
+// TestSSE_SnapshotAndReplay 测试首次连接的快照和Last-Event-ID补发
+func TestSSE_SnapshotAndReplay(t *testing.T) {
+	ws, handler := newTestWebServer(t)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events")
+	if err != nil {
+		t.Fatalf("GET /events failed: %v", err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Unexpected Content-Type: %s", ct)
+	}
+	reader := bufio.NewReader(resp.Body)
+	_, evt := readSSEEvent(t, reader)
+	if evt.Type != EventSnapshot {
+		t.Fatalf("Expected snapshot, got %+v", evt)
+	}
+
+	ws.events.Publish(Event{Type: EventLog, Message: "first"})
+	id, evt := readSSEEvent(t, reader)
+	if evt.Message != "first" {
+		t.Fatalf("Expected first log event, got %+v", evt)
+	}
+	resp.Body.Close()
+
+	// 断线期间发布的事件在重连后按顺序补发
+	ws.events.Publish(Event{Type: EventLog, Message: "second"})
+	ws.events.Publish(Event{Type: EventLog, Message: "third"})
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/events", nil)
+	req.Header.Set("Last-Event-ID", strconv.FormatUint(id, 10))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Reconnect failed: %v", err)
+	}
+	defer resp.Body.Close()
+	reader = bufio.NewReader(resp.Body)
+	for _, want := range []string{"second", "third"} {
+		_, evt = readSSEEvent(t, reader)
+		if evt.Type != EventLog || evt.Message != want {
+			t.Fatalf("Expected replayed %q, got %+v", want, evt)
+		}
+	}
+}
+
+// TestEventHub_SubscribeSince 测试环形缓冲区的补发范围
+func TestEventHub_SubscribeSince(t *testing.T) {
+	hub := NewEventHub()
+	for i := 0; i < eventHistorySize+10; i++ {
+		hub.Publish(Event{Type: EventLog})
+	}
+	last := hub.LastID()
+
+	missed, ok := hub.SubscribeSince(nopSubscriber{}, last-3)
+	if !ok || len(missed) != 3 || missed[0].ID != last-2 {
+		t.Errorf("Expected 3 replayed events starting at %d, got ok=%v %d", last-2, ok, len(missed))
+	}
+
+	// 已被覆盖的事件无法补发
+	if _, ok := hub.SubscribeSince(nopSubscriber{}, 1); ok {
+		t.Errorf("Expected replay to fail for evicted events")
+	}
+
+	// 未来的编号（例如服务重启后）无法补发
+	if _, ok := hub.SubscribeSince(nopSubscriber{}, last+100); ok {
+		t.Errorf("Expected replay to fail for unknown event id")
+	}
+}
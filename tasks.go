@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 任务状态
+const (
+	TaskStatusPending     = "pending"
+	TaskStatusDownloading = "downloading"
+	TaskStatusCompleted   = "completed"
+	TaskStatusFailed      = "failed"
+	TaskStatusCanceled    = "canceled"
+	TaskStatusPaused      = "paused"
+)
+
+var (
+	// ErrTaskNotFound 任务不存在
+	ErrTaskNotFound = errors.New("任务不存在")
+	// ErrTaskState 任务当前的状态不能执行该操作，如继续一个未暂停的任务
+	ErrTaskState = errors.New("任务当前的状态不能执行该操作")
+)
+
+// Task 下载任务
+type Task struct {
+	progress DownloadProgress
+	config   *Config            // 任务启动时的配置快照
+	elapsed  time.Duration      // 之前几次下载累计的用时
+	cancel   context.CancelFunc // 当前这次下载的取消函数，暂停后继续和重试时替换
+	done     chan struct{}      // 当前这次下载结束时关闭
+}
+
+// TaskManager 下载任务管理器，负责任务的创建、查询、暂停、重试和取消。Web界面和终端界面共用
+type TaskManager struct {
+	mu     sync.RWMutex
+	tasks  map[string]*Task
+	events *EventHub // 任务创建、进度和状态变化都会发布到这里
+}
+
+// NewTaskManager 创建任务管理器
+func NewTaskManager(events *EventHub) *TaskManager {
+	return &TaskManager{
+		tasks:  make(map[string]*Task),
+		events: events,
+	}
+}
+
+// newTaskConfig 基于当前配置生成某个URL的下载配置。
+// 返回的是独立的快照，之后对全局配置的替换不会影响正在运行的任务
+func newTaskConfig(base *Config, url string) *Config {
+	taskConfig := base.Copy()
+	taskConfig.URL = url
+	if taskConfig.OutputPath == "" {
+		taskConfig.OutputPath = filepath.Join(taskConfig.OutputDir, getDefaultFilename(url))
+	}
+	// 输出文件已存在时换用新的文件名，任务显示的保存路径与实际一致
+	if taskConfig.OnExists == OnExistsRename {
+		taskConfig.OutputPath = uniqueOutputPath(taskConfig.OutputPath)
+	}
+	return taskConfig
+}
+
+// Start 创建并启动下载任务，返回任务的初始状态
+func (tm *TaskManager) Start(config *Config, profile string) *DownloadProgress {
+	task := tm.add(config, profile, func(p *DownloadProgress) {
+		p.Status = TaskStatusPending
+	})
+	tm.log(task.progress.TaskID, LogLevelInfo, config.RedactString(fmt.Sprintf("开始下载 %s", config.URL)))
+
+	snapshot := tm.snapshot(task)
+	tm.run(task)
+	return snapshot
+}
+
+// Adopt 把启动时发现的上次未完成的下载加入任务列表，状态为已暂停，继续时从已下载的位置断点续传
+func (tm *TaskManager) Adopt(config *Config, profile string, downloaded, total int64) *DownloadProgress {
+	task := tm.add(config, profile, func(p *DownloadProgress) {
+		p.Status = TaskStatusPaused
+		p.Downloaded, p.Total = downloaded, total
+		if total > 0 {
+			p.Percent = float64(downloaded) / float64(total) * 100
+		}
+	})
+	// 还没有运行过，继续时不需要等待上一次下载结束
+	done := make(chan struct{})
+	close(done)
+	task.cancel, task.done = func() {}, done
+	tm.log(task.progress.TaskID, LogLevelInfo, fmt.Sprintf("发现上次未完成的下载 %s，已下载 %d 字节", config.OutputPath, downloaded))
+	return tm.snapshot(task)
+}
+
+// add 创建任务并加入任务列表，init设置任务的初始状态
+func (tm *TaskManager) add(config *Config, profile string, init func(p *DownloadProgress)) *Task {
+	now := time.Now()
+	task := &Task{
+		progress: DownloadProgress{
+			TaskID:     fmt.Sprintf("%d", now.UnixNano()),
+			URL:        config.URL,
+			Profile:    profile,
+			Filename:   filepath.Base(config.OutputPath),
+			OutputPath: config.OutputPath,
+			ETASeconds: -1,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		},
+		config: config,
+	}
+	init(&task.progress)
+
+	tm.mu.Lock()
+	tm.tasks[task.progress.TaskID] = task
+	snapshot := task.progress
+	tm.mu.Unlock()
+	tm.publish(EventCreated, &snapshot)
+	return task
+}
+
+// snapshot 返回任务当前状态的副本
+func (tm *TaskManager) snapshot(task *Task) *DownloadProgress {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	snapshot := task.progress
+	return &snapshot
+}
+
+// run 在goroutine中执行下载，这样可以立即返回任务信息。
+// 暂停后继续和重试也从这里重新开始，已下载的部分通过断点续传保留
+func (tm *TaskManager) run(task *Task) {
+	config := task.config
+	ctx, cancel := context.WithTimeout(context.Background(), config.GetTimeoutDuration())
+	done := make(chan struct{})
+	tm.mu.Lock()
+	task.cancel, task.done = cancel, done
+	taskID := task.progress.TaskID
+	tm.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		defer cancel()
+
+		var elapsed time.Duration
+		output, err := downloadResource(ctx, *config, func(stats downloadStats) {
+			elapsed = stats.Elapsed
+			tm.update(task, func(p *DownloadProgress) {
+				p.Percent = stats.Percent
+				p.Downloaded = stats.Downloaded
+				p.Total = stats.Total
+				p.SpeedBPS = stats.SpeedBPS
+				p.ETASeconds = stats.ETASeconds
+				if p.StartedAt == nil {
+					p.StartedAt = &stats.StartedAt
+				}
+				p.Elapsed = (task.elapsed + stats.Elapsed).Seconds()
+				p.Status = TaskStatusDownloading
+			})
+		})
+
+		// 下载完成后更新状态
+		var stopped string
+		tm.update(task, func(p *DownloadProgress) {
+			task.elapsed += elapsed
+			p.Elapsed = task.elapsed.Seconds()
+			p.SpeedBPS, p.ETASeconds = 0, -1
+			switch {
+			case err == nil:
+				p.Status = TaskStatusCompleted
+				p.Percent = 100
+				p.ETASeconds = 0
+				// HLS下载按分片格式确定扩展名，实际保存的路径可能与创建任务时不同
+				p.OutputPath, p.Filename = output, filepath.Base(output)
+			case p.Status == TaskStatusCanceled || p.Status == TaskStatusPaused:
+				// 已被用户取消或暂停，保留该状态
+				stopped = p.Status
+			default:
+				p.Status = TaskStatusFailed
+				p.Percent = 0
+				p.ErrorMsg = config.RedactString(err.Error())
+				p.ErrorCode = errorCode(err)
+			}
+		})
+		switch {
+		case stopped == TaskStatusCanceled:
+			tm.log(taskID, LogLevelInfo, "任务已取消")
+		case stopped == TaskStatusPaused:
+			tm.log(taskID, LogLevelInfo, "任务已暂停")
+		case err != nil:
+			tm.log(taskID, LogLevelError, config.RedactString(fmt.Sprintf("下载失败：%v", err)))
+		default:
+			tm.log(taskID, LogLevelInfo, fmt.Sprintf("下载完成，文件保存至：%s", output))
+		}
+	}()
+}
+
+// update 在锁内修改任务进度，并发布进度或状态变化事件
+func (tm *TaskManager) update(task *Task, fn func(p *DownloadProgress)) {
+	tm.mu.Lock()
+	oldStatus := task.progress.Status
+	fn(&task.progress)
+	task.progress.UpdatedAt = time.Now()
+	snapshot := task.progress
+	tm.mu.Unlock()
+
+	if snapshot.Status != oldStatus {
+		tm.publish(EventStateChanged, &snapshot)
+	} else {
+		tm.publish(EventProgress, &snapshot)
+	}
+}
+
+// publish 发布任务事件
+func (tm *TaskManager) publish(eventType string, progress *DownloadProgress) {
+	if tm.events == nil {
+		return
+	}
+	tm.events.Publish(Event{
+		Type:   eventType,
+		TaskID: progress.TaskID,
+		Task:   progress,
+	})
+}
+
+// log 发布任务相关的日志事件
+func (tm *TaskManager) log(taskID, level, message string) {
+	if tm.events == nil {
+		return
+	}
+	tm.events.Publish(Event{
+		Type:    EventLog,
+		TaskID:  taskID,
+		Level:   level,
+		Message: message,
+	})
+}
+
+// Get 获取任务状态快照
+func (tm *TaskManager) Get(id string) (*DownloadProgress, error) {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	task, ok := tm.tasks[id]
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	snapshot := task.progress
+	return &snapshot, nil
+}
+
+// List 按创建时间倒序返回所有任务的状态快照
+func (tm *TaskManager) List() []*DownloadProgress {
+	tm.mu.RLock()
+	list := make([]*DownloadProgress, 0, len(tm.tasks))
+	for _, task := range tm.tasks {
+		snapshot := task.progress
+		list = append(list, &snapshot)
+	}
+	tm.mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list
+}
+
+// Cancel 取消正在进行或已暂停的任务，已结束的任务不受影响
+func (tm *TaskManager) Cancel(id string) (*DownloadProgress, error) {
+	task, err := tm.task(id)
+	if err != nil {
+		return nil, err
+	}
+	tm.stop(task, TaskStatusCanceled, TaskStatusPending, TaskStatusDownloading, TaskStatusPaused)
+	return tm.Get(id)
+}
+
+// Pause 暂停正在进行的任务，已下载的部分保留在输出文件中，继续时断点续传
+func (tm *TaskManager) Pause(id string) (*DownloadProgress, error) {
+	task, err := tm.task(id)
+	if err != nil {
+		return nil, err
+	}
+	if !tm.stop(task, TaskStatusPaused, TaskStatusPending, TaskStatusDownloading) {
+		return nil, ErrTaskState
+	}
+	return tm.Get(id)
+}
+
+// Resume 继续已暂停的任务
+func (tm *TaskManager) Resume(id string) (*DownloadProgress, error) {
+	return tm.restart(id, "继续下载", TaskStatusPaused)
+}
+
+// Retry 重新开始失败或已取消的任务，从已下载的位置继续
+func (tm *TaskManager) Retry(id string) (*DownloadProgress, error) {
+	return tm.restart(id, "重试下载", TaskStatusFailed, TaskStatusCanceled)
+}
+
+// task 按ID查找任务
+func (tm *TaskManager) task(id string) (*Task, error) {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	task, ok := tm.tasks[id]
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	return task, nil
+}
+
+// transition 任务处于from中的某个状态时切换到to状态并发布状态变化事件，返回是否切换
+func (tm *TaskManager) transition(task *Task, to string, from ...string) bool {
+	tm.mu.Lock()
+	if !slices.Contains(from, task.progress.Status) {
+		tm.mu.Unlock()
+		return false
+	}
+	task.progress.Status = to
+	if to == TaskStatusPending {
+		task.progress.ErrorMsg = ""
+		task.progress.ErrorCode = ""
+	}
+	task.progress.UpdatedAt = time.Now()
+	snapshot := task.progress
+	tm.mu.Unlock()
+	tm.publish(EventStateChanged, &snapshot)
+	return true
+}
+
+// stop 把任务切换到取消或暂停状态并停止下载
+func (tm *TaskManager) stop(task *Task, to string, from ...string) bool {
+	if !tm.transition(task, to, from...) {
+		return false
+	}
+	tm.mu.RLock()
+	cancel := task.cancel
+	tm.mu.RUnlock()
+	cancel()
+	return true
+}
+
+// restart 重新运行处于from状态的任务。先等待上一次下载结束，避免两次下载同时写入同一个文件
+func (tm *TaskManager) restart(id, message string, from ...string) (*DownloadProgress, error) {
+	task, err := tm.task(id)
+	if err != nil {
+		return nil, err
+	}
+	tm.mu.RLock()
+	status, done := task.progress.Status, task.done
+	tm.mu.RUnlock()
+	if !slices.Contains(from, status) {
+		return nil, ErrTaskState
+	}
+	<-done
+	if !tm.transition(task, TaskStatusPending, from...) {
+		return nil, ErrTaskState
+	}
+	tm.log(id, LogLevelInfo, message)
+	tm.run(task)
+	return tm.Get(id)
+}
+
+// Remove 取消并移除任务
+func (tm *TaskManager) Remove(id string) error {
+	if _, err := tm.Cancel(id); err != nil {
+		return err
+	}
+
+	tm.mu.Lock()
+	delete(tm.tasks, id)
+	tm.mu.Unlock()
+	return nil
+}
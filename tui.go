@@ -0,0 +1,616 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// tuiRefreshInterval 终端界面的刷新间隔，与下载进度的更新频率一致
+const tuiRefreshInterval = 200 * time.Millisecond
+
+// 终端界面的按键类型
+const (
+	keyRune = iota
+	keyEnter
+	keyBackspace
+	keyEsc
+	keyUp
+	keyDown
+	keyCtrlC
+	keyCtrlU
+	keyPaste
+)
+
+// 括号粘贴模式下终端包围粘贴内容的序列，用于区分粘贴的地址和按键
+const (
+	pasteStart = "\x1b[200~"
+	pasteEnd   = "\x1b[201~"
+)
+
+// tuiKey 解析后的一次按键或一次粘贴
+type tuiKey struct {
+	kind int
+	r    rune   // keyRune 时的字符
+	text string // keyPaste 时粘贴的内容
+}
+
+// parseTUIKeys 把终端原始模式下读到的字节解析为按键。
+// 末尾不完整的转义序列、粘贴内容或UTF-8字符作为第二个返回值，留到下次读取后拼接再解析
+func parseTUIKeys(data []byte) ([]tuiKey, []byte) {
+	var keys []tuiKey
+	for len(data) > 0 {
+		switch c := data[0]; {
+		case bytes.HasPrefix(data, []byte(pasteStart)):
+			end := bytes.Index(data, []byte(pasteEnd))
+			if end < 0 {
+				return keys, data
+			}
+			keys = append(keys, tuiKey{kind: keyPaste, text: string(data[len(pasteStart):end])})
+			data = data[end+len(pasteEnd):]
+		case c == 0x1b:
+			if len(data) == 1 || (data[1] != '[' && data[1] != 'O') {
+				keys = append(keys, tuiKey{kind: keyEsc})
+				data = data[1:]
+				continue
+			}
+			// CSI 序列：ESC [ 参数 结束字符（0x40-0x7e）
+			end := 2
+			for end < len(data) && (data[end] < 0x40 || data[end] > 0x7e) {
+				end++
+			}
+			if end == len(data) {
+				return keys, data
+			}
+			switch data[end] {
+			case 'A':
+				keys = append(keys, tuiKey{kind: keyUp})
+			case 'B':
+				keys = append(keys, tuiKey{kind: keyDown})
+			}
+			data = data[end+1:]
+		case c == '\r' || c == '\n':
+			keys = append(keys, tuiKey{kind: keyEnter})
+			data = data[1:]
+		case c == 0x7f || c == 0x08:
+			keys = append(keys, tuiKey{kind: keyBackspace})
+			data = data[1:]
+		case c == 0x03:
+			keys = append(keys, tuiKey{kind: keyCtrlC})
+			data = data[1:]
+		case c == 0x15:
+			keys = append(keys, tuiKey{kind: keyCtrlU})
+			data = data[1:]
+		case c < 0x20:
+			// 忽略其他控制字符
+			data = data[1:]
+		default:
+			if !utf8.FullRune(data) {
+				return keys, data
+			}
+			r, size := utf8.DecodeRune(data)
+			keys = append(keys, tuiKey{kind: keyRune, r: r})
+			data = data[size:]
+		}
+	}
+	return keys, nil
+}
+
+// tuiModel 终端界面的状态和按键处理，与终端的读写分开，便于测试
+type tuiModel struct {
+	tasks       *TaskManager
+	config      *Config
+	profile     string
+	selected    string // 选中任务的ID
+	editing     bool   // 是否正在输入新地址
+	input       []rune
+	message     string
+	confirmQuit bool // 有任务在下载时第一次按q只提示，再按一次才退出
+	quit        bool
+	notices     chan string // 后台解析地址的结果
+}
+
+// newTUIModel 创建终端界面，新任务使用config和profile创建
+func newTUIModel(tasks *TaskManager, config *Config, profile string) *tuiModel {
+	return &tuiModel{
+		tasks:   tasks,
+		config:  config,
+		profile: profile,
+		notices: make(chan string, 16),
+	}
+}
+
+// taskList 按创建时间顺序返回任务，新添加的任务排在最后
+func (m *tuiModel) taskList() []*DownloadProgress {
+	list := m.tasks.List()
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	return list
+}
+
+// selectedIndex 返回选中任务在列表中的位置，选中的任务不存在时选中第一个
+func (m *tuiModel) selectedIndex(list []*DownloadProgress) int {
+	for i, p := range list {
+		if p.TaskID == m.selected {
+			return i
+		}
+	}
+	m.selected = ""
+	if len(list) > 0 {
+		m.selected = list[0].TaskID
+		return 0
+	}
+	return -1
+}
+
+// move 上下移动选中的任务
+func (m *tuiModel) move(delta int) {
+	list := m.taskList()
+	i := m.selectedIndex(list)
+	if i < 0 {
+		return
+	}
+	i += delta
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(list) {
+		i = len(list) - 1
+	}
+	m.selected = list[i].TaskID
+	m.message = ""
+}
+
+// handleKey 处理一次按键
+func (m *tuiModel) handleKey(key tuiKey) {
+	if key.kind == keyCtrlC {
+		m.quit = true
+		return
+	}
+	if m.editing {
+		m.handleInput(key)
+		return
+	}
+	confirmQuit := m.confirmQuit
+	m.confirmQuit = false
+	// 选中的任务可能已被移除，先更新选中状态
+	m.selectedIndex(m.taskList())
+
+	switch key.kind {
+	case keyUp:
+		m.move(-1)
+	case keyDown:
+		m.move(1)
+	case keyPaste:
+		// 直接粘贴地址时自动进入输入状态，确认后再添加
+		m.editing = true
+		m.input = append(m.input, []rune(pasteText(key.text))...)
+	case keyRune:
+		switch key.r {
+		case 'k':
+			m.move(-1)
+		case 'j':
+			m.move(1)
+		case 'p', ' ':
+			m.togglePause()
+		case 'c':
+			m.act(m.tasks.Cancel, "已取消")
+		case 'r':
+			m.act(m.tasks.Retry, "重新开始下载")
+		case 'd':
+			m.remove()
+		case 'a', 'i':
+			m.editing = true
+		case 'q':
+			running := m.running()
+			if running == 0 || confirmQuit {
+				m.quit = true
+				return
+			}
+			m.confirmQuit = true
+			m.message = fmt.Sprintf("还有 %d 个任务正在下载，再按一次 q 退出，已下载的部分之后可以继续下载", running)
+		}
+	}
+}
+
+// handleInput 处理输入地址时的按键
+func (m *tuiModel) handleInput(key tuiKey) {
+	switch key.kind {
+	case keyEnter:
+		text := strings.TrimSpace(string(m.input))
+		m.input, m.editing = nil, false
+		if text == "" {
+			return
+		}
+		m.message = "正在添加..."
+		// 平台页面地址需要联网解析，放到后台避免界面卡住
+		go func() { m.notices <- m.addTargets(text) }()
+	case keyEsc:
+		m.input, m.editing = nil, false
+	case keyBackspace:
+		if len(m.input) > 0 {
+			m.input = m.input[:len(m.input)-1]
+		}
+	case keyCtrlU:
+		m.input = nil
+	case keyPaste:
+		m.input = append(m.input, []rune(pasteText(key.text))...)
+	case keyRune:
+		m.input = append(m.input, key.r)
+	}
+}
+
+// pasteText 把粘贴内容中的换行和制表符替换为空格，一次粘贴多行地址时每行作为一个地址
+func pasteText(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == '\t' {
+			return ' '
+		}
+		return r
+	}, text)
+}
+
+// addTargets 解析输入的地址并创建下载任务，多个地址用空白分隔，返回要显示的结果
+func (m *tuiModel) addTargets(text string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), m.config.GetTimeoutDuration())
+	defer cancel()
+
+	added := 0
+	var errs []string
+	for _, target := range strings.Fields(text) {
+		urls, err := resolveTarget(ctx, m.config, target)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("解析 %s 失败：%s", target, m.config.RedactString(err.Error())))
+			continue
+		}
+		for _, url := range urls {
+			m.tasks.Start(newTaskConfig(m.config, url), m.profile)
+			added++
+		}
+	}
+	message := fmt.Sprintf("已添加 %d 个任务", added)
+	if len(errs) > 0 {
+		message += "；" + strings.Join(errs, "；")
+	}
+	return message
+}
+
+// act 对选中的任务执行操作
+func (m *tuiModel) act(action func(id string) (*DownloadProgress, error), done string) {
+	if m.selected == "" {
+		m.message = "没有选中的任务"
+		return
+	}
+	progress, err := action(m.selected)
+	if err != nil {
+		m.message = err.Error()
+		return
+	}
+	m.message = fmt.Sprintf("%s：%s", done, progress.Filename)
+}
+
+// togglePause 暂停或继续选中的任务
+func (m *tuiModel) togglePause() {
+	progress, err := m.tasks.Get(m.selected)
+	if err != nil {
+		m.message = "没有选中的任务"
+		return
+	}
+	if progress.Status == TaskStatusPaused {
+		m.act(m.tasks.Resume, "继续下载")
+	} else {
+		m.act(m.tasks.Pause, "已暂停")
+	}
+}
+
+// remove 移除选中的任务，已下载的文件保留
+func (m *tuiModel) remove() {
+	progress, err := m.tasks.Get(m.selected)
+	if err != nil {
+		m.message = "没有选中的任务"
+		return
+	}
+	m.tasks.Remove(progress.TaskID)
+	m.message = fmt.Sprintf("已移除：%s", progress.Filename)
+}
+
+// running 返回等待中和下载中的任务数
+func (m *tuiModel) running() int {
+	count := 0
+	for _, p := range m.tasks.List() {
+		if p.Status == TaskStatusPending || p.Status == TaskStatusDownloading {
+			count++
+		}
+	}
+	return count
+}
+
+// render 生成一屏的内容，每行的显示宽度不超过width
+func (m *tuiModel) render(width, height int) []string {
+	width, height = max(width, 20), max(height, 6)
+	list := m.taskList()
+	selected := m.selectedIndex(list)
+	counts := make(map[string]int)
+	for _, p := range list {
+		counts[p.Status]++
+	}
+
+	title := fmt.Sprintf(" 教材下载器  下载中 %d  已暂停 %d  已完成 %d  失败 %d  共 %d",
+		counts[TaskStatusPending]+counts[TaskStatusDownloading], counts[TaskStatusPaused],
+		counts[TaskStatusCompleted], counts[TaskStatusFailed], len(list))
+	if m.profile != "" {
+		title += "  配置方案 " + m.profile
+	}
+	separator := strings.Repeat("-", width)
+	lines := []string{fitWidth(title, width), separator}
+
+	// 标题、分隔线和底部三行之外的空间用于显示任务，任务过多时滚动到选中的任务
+	rows := height - 5
+	if rows < 1 {
+		rows = 1
+	}
+	offset := 0
+	if selected >= rows {
+		offset = selected - rows + 1
+	}
+	if len(list) == 0 {
+		lines = append(lines, fitWidth("  暂无任务，按 a 或直接粘贴下载地址、平台页面地址或资源ID", width))
+	}
+	for i := offset; i < len(list) && i < offset+rows; i++ {
+		lines = append(lines, m.renderTask(list[i], i == selected, width))
+	}
+	for len(lines) < height-3 {
+		lines = append(lines, "")
+	}
+
+	message := m.message
+	if message == "" && selected >= 0 && list[selected].Status == TaskStatusFailed {
+		message = "错误：" + list[selected].ErrorMsg
+	}
+	lines = append(lines, separator, fitWidth(" "+message, width))
+	if m.editing {
+		prompt := " 地址> "
+		lines = append(lines, prompt+tailWidth(string(m.input)+"_", width-displayWidth(prompt)))
+	} else {
+		lines = append(lines, fitWidth(" ↑↓ 选择  p 暂停/继续  c 取消  r 重试  d 移除  a 添加地址  q 退出", width))
+	}
+	return lines
+}
+
+// renderTask 生成一个任务的显示行：文件名、进度条、百分比、大小、速度、剩余时间和状态
+func (m *tuiModel) renderTask(p *DownloadProgress, selected bool, width int) string {
+	speed, eta := "", ""
+	if p.Status == TaskStatusDownloading {
+		speed, eta = formatSpeed(p.SpeedBPS), formatETA(p.ETASeconds)
+	}
+
+	// 进度条和文件名按终端宽度分配剩余空间，其他各列约占55列
+	barLength := min(max((width-55)/3, 5), 30)
+	filled := int(p.Percent / 100 * float64(barLength))
+	if filled > barLength {
+		filled = barLength
+	}
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", barLength-filled)
+	percent := fmt.Sprintf("%5.1f%%", p.Percent)
+	size := formatSize(p.Downloaded)
+	switch {
+	case p.Total > 0:
+		size += "/" + formatSize(p.Total)
+	case p.Total < 0 && p.Status == TaskStatusDownloading:
+		// 大小未知，进度条只表示下载仍在进行
+		bar, percent = indeterminateBar(barLength, p.Downloaded), "    ?"
+	}
+	detail := fmt.Sprintf(" [%s] %s %15s %10s %7s  %s", bar, percent, size, speed, eta, taskStatusText(p.Status))
+
+	marker := "  "
+	if selected {
+		marker = "> "
+	}
+	nameWidth := width - displayWidth(marker) - displayWidth(detail)
+	if nameWidth < 12 {
+		nameWidth = 12
+	}
+	return fitWidth(marker+padWidth(fitWidth(p.Filename, nameWidth), nameWidth)+detail, width)
+}
+
+// draw 把一屏内容输出到终端，每行清除旧内容，最后一行不换行以免屏幕滚动
+func (m *tuiModel) draw(w io.Writer, width, height int) {
+	var buf bytes.Buffer
+	buf.WriteString("\x1b[H")
+	lines := m.render(width-1, height)
+	for i, line := range lines {
+		buf.WriteString(line)
+		buf.WriteString("\x1b[K")
+		if i < len(lines)-1 {
+			buf.WriteString("\r\n")
+		}
+	}
+	buf.WriteString("\x1b[J")
+	w.Write(buf.Bytes())
+}
+
+// taskStatusText 返回任务状态的中文名称
+func taskStatusText(status string) string {
+	switch status {
+	case TaskStatusPending:
+		return "等待中"
+	case TaskStatusDownloading:
+		return "下载中"
+	case TaskStatusPaused:
+		return "已暂停"
+	case TaskStatusCompleted:
+		return "已完成"
+	case TaskStatusFailed:
+		return "失败"
+	case TaskStatusCanceled:
+		return "已取消"
+	}
+	return status
+}
+
+// runeWidth 返回字符在终端中占用的列数，中日韩文字和全角符号占两列
+func runeWidth(r rune) int {
+	switch {
+	case r >= 0x1100 && r <= 0x115f,
+		r >= 0x2e80 && r <= 0xa4cf,
+		r >= 0xac00 && r <= 0xd7a3,
+		r >= 0xf900 && r <= 0xfaff,
+		r >= 0xfe30 && r <= 0xfe4f,
+		r >= 0xff00 && r <= 0xff60,
+		r >= 0xffe0 && r <= 0xffe6,
+		r >= 0x20000 && r <= 0x3fffd:
+		return 2
+	}
+	return 1
+}
+
+// displayWidth 返回字符串在终端中的显示宽度
+func displayWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		width += runeWidth(r)
+	}
+	return width
+}
+
+// fitWidth 截断字符串使显示宽度不超过width，被截断时以省略号结尾
+func fitWidth(s string, width int) string {
+	if displayWidth(s) <= width {
+		return s
+	}
+	if width < 3 {
+		return strings.Repeat(".", max(width, 0))
+	}
+	var b strings.Builder
+	used := 0
+	for _, r := range s {
+		if used+runeWidth(r) > width-3 {
+			break
+		}
+		b.WriteRune(r)
+		used += runeWidth(r)
+	}
+	return b.String() + "..."
+}
+
+// tailWidth 保留字符串末尾显示宽度不超过width的部分，用于显示较长的输入
+func tailWidth(s string, width int) string {
+	runes := []rune(s)
+	used := 0
+	i := len(runes)
+	for i > 0 && used+runeWidth(runes[i-1]) <= width {
+		used += runeWidth(runes[i-1])
+		i--
+	}
+	return string(runes[i:])
+}
+
+// padWidth 在字符串末尾补空格，使显示宽度达到width
+func padWidth(s string, width int) string {
+	if pad := width - displayWidth(s); pad > 0 {
+		return s + strings.Repeat(" ", pad)
+	}
+	return s
+}
+
+// cmdTUI 启动全屏终端界面
+func cmdTUI(args []string) int {
+	fs := newFlagSet("tui")
+	opts := registerConfigFlags(fs, false)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		fmt.Fprintln(os.Stderr, "错误: tui 命令需要在终端中运行，脚本中请使用 download 或 batch 命令")
+		return exitUsage
+	}
+	resolved, err := opts.resolve()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		return exitConfig
+	}
+
+	tm := NewTaskManager(nil)
+	m := newTUIModel(tm, resolved.Config, resolved.Profile)
+	if n, err := adoptPartials(tm, resolved.Config, resolved.Profile); err != nil {
+		m.message = err.Error()
+	} else if n > 0 {
+		m.message = fmt.Sprintf("发现 %d 个未完成的下载，按 p 继续", n)
+	}
+	if fs.NArg() > 0 {
+		m.message = m.addTargets(strings.Join(fs.Args(), " "))
+	}
+	if err := runTUI(m, os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// runTUI 切换终端到原始模式和备用屏幕并运行界面，直到用户退出。
+// 退出时恢复终端，未完成的下载保留已下载的部分
+func runTUI(m *tuiModel, in, out *os.File) error {
+	state, err := term.MakeRaw(int(in.Fd()))
+	if err != nil {
+		return fmt.Errorf("无法切换终端模式: %v", err)
+	}
+	defer term.Restore(int(in.Fd()), state)
+	restore, err := enableVirtualTerminal(out)
+	if err != nil {
+		return fmt.Errorf("终端不支持全屏界面: %v", err)
+	}
+	defer restore()
+
+	// 使用备用屏幕、隐藏光标并开启括号粘贴模式，退出时按相反顺序恢复
+	fmt.Fprint(out, "\x1b[?1049h\x1b[?25l\x1b[?2004h")
+	defer fmt.Fprint(out, "\x1b[?2004l\x1b[?25h\x1b[?1049l")
+
+	input := make(chan []byte)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := in.Read(buf)
+			if err != nil {
+				close(input)
+				return
+			}
+			input <- bytes.Clone(buf[:n])
+		}
+	}()
+
+	ticker := time.NewTicker(tuiRefreshInterval)
+	defer ticker.Stop()
+	var pending []byte
+	for !m.quit {
+		// 部分终端无法获取大小时返回0，按标准的80x24处理
+		width, height, err := term.GetSize(int(out.Fd()))
+		if err != nil || width <= 0 || height <= 0 {
+			width, height = 80, 24
+		}
+		m.draw(out, width, height)
+
+		select {
+		case data, ok := <-input:
+			if !ok {
+				return nil
+			}
+			var keys []tuiKey
+			keys, pending = parseTUIKeys(append(pending, data...))
+			for _, key := range keys {
+				m.handleKey(key)
+			}
+		case message := <-m.notices:
+			m.message = message
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
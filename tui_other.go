@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// enableVirtualTerminal 类Unix系统的终端默认支持ANSI转义序列，无需设置
+func enableVirtualTerminal(f *os.File) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestParseTUIKeys 测试终端输入的解析，包括方向键、粘贴内容和被拆开的序列
+func TestParseTUIKeys(t *testing.T) {
+	input := "j\x1b[A\x1b[B\r\x7f\x03" + pasteStart + "https://a/1.pdf\nhttps://a/2.pdf" + pasteEnd + "数"
+	keys, rest := parseTUIKeys([]byte(input))
+	if len(rest) != 0 {
+		t.Fatalf("Unexpected rest %q", rest)
+	}
+	kinds := []int{keyRune, keyUp, keyDown, keyEnter, keyBackspace, keyCtrlC, keyPaste, keyRune}
+	if len(keys) != len(kinds) {
+		t.Fatalf("Expected %d keys, got %+v", len(kinds), keys)
+	}
+	for i, kind := range kinds {
+		if keys[i].kind != kind {
+			t.Errorf("Key %d: expected kind %d, got %+v", i, kind, keys[i])
+		}
+	}
+	if keys[6].text != "https://a/1.pdf\nhttps://a/2.pdf" || keys[7].r != '数' {
+		t.Errorf("Unexpected paste or rune: %+v %+v", keys[6], keys[7])
+	}
+
+	// 一次读取只收到一部分时，剩余部分留到下次解析
+	for _, partial := range []string{"\x1b[", pasteStart + "https://", "\xe6\x95"} {
+		keys, rest := parseTUIKeys([]byte("a" + partial))
+		if len(keys) != 1 || string(rest) != partial {
+			t.Errorf("parseTUIKeys(%q): got keys %+v, rest %q", partial, keys, rest)
+		}
+	}
+	if keys, _ := parseTUIKeys([]byte("\x1b")); len(keys) != 1 || keys[0].kind != keyEsc {
+		t.Errorf("Expected Esc, got %+v", keys)
+	}
+}
+
+// TestDisplayWidth 测试中文字符按两列计算宽度
+func TestDisplayWidth(t *testing.T) {
+	if w := displayWidth("数学a"); w != 5 {
+		t.Errorf("Expected width 5, got %d", w)
+	}
+	if s := fitWidth("一年级数学上册.pdf", 10); displayWidth(s) > 10 || !strings.HasSuffix(s, "...") {
+		t.Errorf("Unexpected fitWidth result %q", s)
+	}
+	if s := tailWidth("https://example.com/a.pdf", 5); s != "a.pdf" {
+		t.Errorf("Unexpected tailWidth result %q", s)
+	}
+}
+
+// waitTaskStatus 等待任务进入指定状态
+func waitTaskStatus(t *testing.T, tm *TaskManager, id, status string) *DownloadProgress {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if p, err := tm.Get(id); err == nil && p.Status == status {
+			return p
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	p, _ := tm.Get(id)
+	t.Fatalf("Task did not reach status %s: %+v", status, p)
+	return nil
+}
+
+// TestTUIModel_TaskControl 测试通过按键添加、暂停、继续和重试任务
+func TestTUIModel_TaskControl(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	var failed atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/flaky.pdf" && !failed.Swap(true):
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Path == "/slow.pdf" && r.Header.Get("Range") == "":
+			// 先发送一半内容，然后等待客户端断开
+			w.Header().Set("Content-Length", "10000")
+			w.Write(data[:5000])
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		default:
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	config := &Config{OutputDir: dir, Timeout: "10s", ChunkSize: 1024}
+	tm := NewTaskManager(nil)
+	t.Cleanup(func() { waitTasksDone(tm) })
+	m := newTUIModel(tm, config, defaultProfileName)
+
+	// 粘贴地址后按回车添加
+	m.handleKey(tuiKey{kind: keyPaste, text: server.URL + "/slow.pdf\n"})
+	if !m.editing {
+		t.Fatal("Paste should start editing")
+	}
+	m.handleKey(tuiKey{kind: keyEnter})
+	select {
+	case message := <-m.notices:
+		if message != "已添加 1 个任务" {
+			t.Errorf("Unexpected message %q", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out adding task")
+	}
+	list := m.taskList()
+	if len(list) != 1 {
+		t.Fatalf("Expected 1 task, got %d", len(list))
+	}
+	id := list[0].TaskID
+	output := filepath.Join(dir, "slow.pdf")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if info, err := os.Stat(partialPath(output)); err == nil && info.Size() == 5000 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the first half")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	m.handleKey(tuiKey{kind: keyRune, r: 'p'})
+	waitTaskStatus(t, tm, id, TaskStatusPaused)
+	if screen := strings.Join(m.render(120, 10), "\n"); !strings.Contains(screen, "slow.pdf") || !strings.Contains(screen, "已暂停") {
+		t.Errorf("Unexpected screen:\n%s", screen)
+	}
+	m.handleKey(tuiKey{kind: keyRune, r: 'p'})
+	waitTaskStatus(t, tm, id, TaskStatusCompleted)
+	if content, _ := os.ReadFile(output); !bytes.Equal(content, data) {
+		t.Errorf("Resumed file has %d bytes, expected %d", len(content), len(data))
+	}
+
+	// 失败的任务按r重试
+	if message := m.addTargets(server.URL + "/flaky.pdf"); message != "已添加 1 个任务" {
+		t.Fatalf("Unexpected message %q", message)
+	}
+	m.handleKey(tuiKey{kind: keyDown})
+	flaky := m.taskList()[1]
+	if m.selected != flaky.TaskID {
+		t.Fatalf("Expected the second task to be selected")
+	}
+	waitTaskStatus(t, tm, flaky.TaskID, TaskStatusFailed)
+	if screen := strings.Join(m.render(120, 10), "\n"); !strings.Contains(screen, "404") {
+		t.Errorf("Expected the error of the selected task on screen:\n%s", screen)
+	}
+	m.handleKey(tuiKey{kind: keyRune, r: 'r'})
+	waitTaskStatus(t, tm, flaky.TaskID, TaskStatusCompleted)
+
+	m.handleKey(tuiKey{kind: keyRune, r: 'q'})
+	if !m.quit {
+		t.Errorf("Expected quit without running tasks")
+	}
+}
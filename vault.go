@@ -0,0 +1,385 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// defaultSecretHeaders 总是视为敏感信息的请求头，其他请求头可以通过 secret_headers 配置项标记
+var defaultSecretHeaders = []string{authHeader, "Authorization", "Cookie"}
+
+// redactedValue 接口响应和日志中代替敏感请求头取值的占位符。保存配置时取值仍为占位符的请求头保留原值
+const redactedValue = "******"
+
+// IsSecretHeader 判断请求头是否为敏感信息，名称不区分大小写
+func (dc *Config) IsSecretHeader(name string) bool {
+	for _, list := range [][]string{defaultSecretHeaders, dc.SecretHeaders} {
+		for _, secret := range list {
+			if strings.EqualFold(secret, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Redacted 返回敏感请求头的值替换为占位符后的副本，用于接口响应和命令行输出
+func (dc *Config) Redacted() *Config {
+	config := dc.Copy()
+	for name, value := range config.Headers {
+		if value != "" && config.IsSecretHeader(name) {
+			config.Headers[name] = redactedValue
+		}
+	}
+	return config
+}
+
+// RedactString 将字符串中出现的敏感请求头取值替换为占位符，用于日志和错误信息
+func (dc *Config) RedactString(s string) string {
+	for name, value := range dc.Headers {
+		if value != "" && dc.IsSecretHeader(name) {
+			s = strings.ReplaceAll(s, value, redactedValue)
+		}
+	}
+	return s
+}
+
+// restoreSecrets 将取值为占位符的请求头恢复为previous中的值，previous中没有该请求头时删除。
+// 客户端拿到的是隐藏后的配置，原样提交时不应覆盖真实的令牌
+func (dc *Config) restoreSecrets(previous *Config) {
+	for name, value := range dc.Headers {
+		if value != redactedValue {
+			continue
+		}
+		if previous != nil && previous.Headers[name] != "" {
+			dc.Headers[name] = previous.Headers[name]
+		} else {
+			delete(dc.Headers, name)
+		}
+	}
+}
+
+// 保险库相关的环境变量
+const (
+	envVaultPassphrase = envPrefix + "VAULT_PASSPHRASE" // 口令，设置后新写入的保险库使用口令派生的密钥
+	envVaultKeyFile    = envPrefix + "VAULT_KEYFILE"    // 密钥文件路径，默认为配置文件路径加 .key
+)
+
+// 保险库密钥的来源
+const (
+	vaultKDFPassphrase = "pbkdf2-sha256" // 由口令通过PBKDF2-HMAC-SHA256派生
+	vaultKDFKeyFile    = "keyfile"       // 随机生成并保存在权限为0600的密钥文件中
+)
+
+// vaultFormat 保险库文件格式的版本
+const vaultFormat = 1
+
+// pbkdf2Iterations 由口令派生密钥时的迭代次数，写入保险库文件，测试时可以调低
+var pbkdf2Iterations = 600000
+
+// vaultFile 保险库文件的格式，敏感请求头以AES-256-GCM加密后保存在Data中
+type vaultFile struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Salt       []byte `json:"salt,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+	Nonce      []byte `json:"nonce"`
+	Data       []byte `json:"data"`
+}
+
+// vaultSecrets 保险库中的敏感请求头，键依次为配置方案名称和请求头名称
+type vaultSecrets map[string]map[string]string
+
+// vaultPath 返回配置文件对应的保险库路径
+func vaultPath(configPath string) string {
+	return configPath + ".vault"
+}
+
+// vaultKeyPath 返回密钥文件路径
+func vaultKeyPath(configPath string) string {
+	if path := os.Getenv(envVaultKeyFile); path != "" {
+		return path
+	}
+	return configPath + ".key"
+}
+
+// vaultPassphrase 读取保险库口令，支持 TBD_VAULT_PASSPHRASE_FILE。未设置时返回空字符串
+func vaultPassphrase() (string, error) {
+	if passphrase := os.Getenv(envVaultPassphrase); passphrase != "" {
+		return passphrase, nil
+	}
+	if path := os.Getenv(envVaultPassphrase + envFileSuffix); path != "" {
+		passphrase, err := readSecretFile(path)
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", envVaultPassphrase+envFileSuffix, err)
+		}
+		return passphrase, nil
+	}
+	return "", nil
+}
+
+// readVaultFile 读取保险库文件，不存在时返回nil
+func readVaultFile(configPath string) (*vaultFile, error) {
+	path := vaultPath(configPath)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("无法读取保险库: %v", err)
+	}
+	var vf vaultFile
+	if err := json.Unmarshal(data, &vf); err != nil {
+		return nil, fmt.Errorf("无法解析保险库 %s: %v", path, err)
+	}
+	if vf.Version != vaultFormat {
+		return nil, fmt.Errorf("保险库 %s 的版本 %d 不受支持", path, vf.Version)
+	}
+	return &vf, nil
+}
+
+// readVault 读取并解密保险库，保险库不存在时返回nil
+func readVault(configPath string) (vaultSecrets, error) {
+	vf, err := readVaultFile(configPath)
+	if err != nil || vf == nil {
+		return nil, err
+	}
+	key, err := vaultKey(configPath, vf, false)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newVaultCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, vf.Nonce, vf.Data, []byte(vf.KDF))
+	if err != nil {
+		return nil, fmt.Errorf("无法解密保险库 %s，口令或密钥文件不正确", vaultPath(configPath))
+	}
+	var secrets vaultSecrets
+	if err := json.Unmarshal(plain, &secrets); err != nil {
+		return nil, fmt.Errorf("无法解析保险库内容: %v", err)
+	}
+	return secrets, nil
+}
+
+// writeVault 加密并写入保险库。设置了口令时使用口令派生的密钥，否则使用密钥文件，密钥文件不存在时自动生成
+func writeVault(configPath string, secrets vaultSecrets) error {
+	passphrase, err := vaultPassphrase()
+	if err != nil {
+		return err
+	}
+	vf := &vaultFile{Version: vaultFormat, KDF: vaultKDFKeyFile}
+	if passphrase != "" {
+		vf.KDF = vaultKDFPassphrase
+		// 沿用已有保险库的盐，口令不变时派生的密钥可以直接从缓存中取得
+		if old, err := readVaultFile(configPath); err == nil && old != nil && old.KDF == vaultKDFPassphrase {
+			vf.Salt, vf.Iterations = old.Salt, old.Iterations
+		} else {
+			vf.Salt, vf.Iterations = make([]byte, 16), pbkdf2Iterations
+			if _, err := rand.Read(vf.Salt); err != nil {
+				return fmt.Errorf("无法生成随机数: %v", err)
+			}
+		}
+	}
+	key, err := vaultKey(configPath, vf, true)
+	if err != nil {
+		return err
+	}
+	gcm, err := newVaultCipher(key)
+	if err != nil {
+		return err
+	}
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		return fmt.Errorf("无法序列化保险库内容: %v", err)
+	}
+	vf.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(vf.Nonce); err != nil {
+		return fmt.Errorf("无法生成随机数: %v", err)
+	}
+	vf.Data = gcm.Seal(nil, vf.Nonce, plain, []byte(vf.KDF))
+
+	data, err := json.MarshalIndent(vf, "", "  ")
+	if err != nil {
+		return fmt.Errorf("无法序列化保险库: %v", err)
+	}
+	return writePrivateFile(vaultPath(configPath), data)
+}
+
+// newVaultCipher 创建AES-256-GCM加密器
+func newVaultCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("无法创建加密器: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// vaultKeyCache 缓存由口令派生的密钥，避免每次读取配置都重复计算
+var vaultKeyCache sync.Map
+
+// vaultKey 按保险库记录的方式取得密钥。create为true时密钥文件不存在则生成新的密钥文件
+func vaultKey(configPath string, vf *vaultFile, create bool) ([]byte, error) {
+	switch vf.KDF {
+	case vaultKDFPassphrase:
+		passphrase, err := vaultPassphrase()
+		if err != nil {
+			return nil, err
+		}
+		if passphrase == "" {
+			return nil, fmt.Errorf("保险库 %s 使用口令加密，请通过 %s 提供口令", vaultPath(configPath), envVaultPassphrase)
+		}
+		if vf.Iterations <= 0 || len(vf.Salt) == 0 {
+			return nil, fmt.Errorf("保险库 %s 缺少密钥派生参数", vaultPath(configPath))
+		}
+		id := sha256.Sum256([]byte(fmt.Sprintf("%x:%d:%s", vf.Salt, vf.Iterations, passphrase)))
+		if key, ok := vaultKeyCache.Load(id); ok {
+			return key.([]byte), nil
+		}
+		key := pbkdf2.Key([]byte(passphrase), vf.Salt, vf.Iterations, 32, sha256.New)
+		vaultKeyCache.Store(id, key)
+		return key, nil
+	case vaultKDFKeyFile:
+		return readKeyFile(vaultKeyPath(configPath), create)
+	}
+	return nil, fmt.Errorf("未知的密钥来源 %q", vf.KDF)
+}
+
+// readKeyFile 读取十六进制格式的密钥文件，拒绝其他用户可以访问的密钥文件
+func readKeyFile(path string, create bool) ([]byte, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) && create {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("无法生成密钥: %v", err)
+		}
+		if err := writePrivateFile(path, []byte(hex.EncodeToString(key)+"\n")); err != nil {
+			return nil, fmt.Errorf("无法写入密钥文件: %v", err)
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("无法读取密钥文件: %v", err)
+	}
+	// Windows没有Unix权限位，依赖用户目录的访问控制
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("密钥文件 %s 的权限为 %04o，只能允许所有者读写，请执行 chmod 600 %s", path, info.Mode().Perm(), path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("无法读取密钥文件: %v", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("密钥文件 %s 格式错误，应为64位十六进制字符", path)
+	}
+	return key, nil
+}
+
+// writePrivateFile 以0600权限写入文件。先写临时文件再重命名，已存在文件的宽松权限也会被替换
+func writePrivateFile(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0600); err != nil && runtime.GOOS != "windows" {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// splitSecrets 返回去掉敏感请求头后用于写入配置文件的副本，以及需要存入保险库的敏感请求头
+func (cf *ConfigFile) splitSecrets() (*ConfigFile, vaultSecrets) {
+	stored := &ConfigFile{Version: cf.Version, ActiveProfile: cf.ActiveProfile, Profiles: make(map[string]*Config, len(cf.Profiles))}
+	secrets := make(vaultSecrets)
+	for name, config := range cf.Profiles {
+		config = config.Copy()
+		for header, value := range config.Headers {
+			if config.IsSecretHeader(header) {
+				if secrets[name] == nil {
+					secrets[name] = make(map[string]string)
+				}
+				secrets[name][header] = value
+				delete(config.Headers, header)
+			}
+		}
+		stored.Profiles[name] = config
+	}
+	return stored, secrets
+}
+
+// plaintextSecrets 返回配置文件中以明文保存的敏感请求头，格式为 方案.请求头
+func (cf *ConfigFile) plaintextSecrets() []string {
+	var found []string
+	for name, config := range cf.Profiles {
+		for header := range config.Headers {
+			if config.IsSecretHeader(header) {
+				found = append(found, name+"."+header)
+			}
+		}
+	}
+	sort.Strings(found)
+	return found
+}
+
+// mergeVaultSecrets 将保险库中的敏感请求头合并到各配置方案。
+// 配置文件中手动写入的明文取值较新，优先于保险库中的值
+func mergeVaultSecrets(configPath string, cf *ConfigFile) error {
+	secrets, err := readVault(configPath)
+	if err != nil {
+		return err
+	}
+	for name, headers := range secrets {
+		config, ok := cf.Profiles[name]
+		if !ok {
+			continue
+		}
+		for header, value := range headers {
+			if _, ok := config.Headers[header]; ok {
+				continue
+			}
+			if config.Headers == nil {
+				config.Headers = make(map[string]string)
+			}
+			config.Headers[header] = value
+		}
+	}
+	return nil
+}
+
+// saveVaultSecrets 写入保险库。没有敏感请求头且保险库不存在时不创建保险库和密钥文件
+func saveVaultSecrets(configPath string, secrets vaultSecrets) error {
+	if len(secrets) == 0 {
+		if _, err := os.Stat(vaultPath(configPath)); errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+	}
+	if err := writeVault(configPath, secrets); err != nil {
+		return fmt.Errorf("无法写入保险库: %v", err)
+	}
+	return nil
+}
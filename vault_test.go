@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestVault_KeyFile 测试明文令牌在保存配置时移入保险库，配置文件、保险库和密钥文件的权限为0600
+func TestVault_KeyFile(t *testing.T) {
+	t.Setenv(envVaultPassphrase, "")
+	original := `{"version":2,"active_profile":"default","profiles":{"default":{"timeout":"1m","headers":{"X-Nd-Auth":"secret-token","X-Custom":"plain"}}}}`
+	path := writeConfigFile(t, original)
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if config.Headers["X-Nd-Auth"] != "secret-token" {
+		t.Errorf("Expected token to be loaded, got %q", config.Headers["X-Nd-Auth"])
+	}
+	// 加载和只读的命令不修改配置文件
+	if code := runCLI([]string{"config", "-config", path, "show"}); code != exitOK {
+		t.Fatalf("config show failed with exit code %d", code)
+	}
+	if data, _ := os.ReadFile(path); string(data) != original {
+		t.Errorf("Expected loading not to rewrite the file, got %s", data)
+	}
+	if _, err := os.Stat(vaultPath(path)); !os.IsNotExist(err) {
+		t.Errorf("Expected loading not to create the vault, got %v", err)
+	}
+
+	if err := SaveConfig(path, config); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "secret-token") {
+		t.Errorf("Token is still stored in plaintext: %s", data)
+	}
+	if !strings.Contains(string(data), "X-Custom") {
+		t.Errorf("Non-secret header was removed: %s", data)
+	}
+	vault, _ := os.ReadFile(vaultPath(path))
+	if strings.Contains(string(vault), "secret-token") {
+		t.Errorf("Vault is not encrypted")
+	}
+	if runtime.GOOS != "windows" {
+		for _, p := range []string{path, vaultPath(path), vaultKeyPath(path)} {
+			if info, err := os.Stat(p); err != nil || info.Mode().Perm() != 0600 {
+				t.Errorf("Expected %s with mode 0600, got %v (%v)", p, info.Mode().Perm(), err)
+			}
+		}
+	}
+
+	// 再次加载时从保险库读取
+	config, err = LoadConfig(path)
+	if err != nil || config.Headers["X-Nd-Auth"] != "secret-token" {
+		t.Fatalf("Expected token from vault, got %v (%v)", config, err)
+	}
+
+	// 删除请求头后保险库中也不再保留
+	delete(config.Headers, "X-Nd-Auth")
+	if err := SaveConfig(path, config); err != nil {
+		t.Fatal(err)
+	}
+	if config, _ = LoadConfig(path); config.Headers["X-Nd-Auth"] != "" {
+		t.Errorf("Expected token to be deleted, got %q", config.Headers["X-Nd-Auth"])
+	}
+
+	if runtime.GOOS != "windows" {
+		os.Chmod(vaultKeyPath(path), 0644)
+		if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "chmod 600") {
+			t.Errorf("Expected error for world-readable key file, got %v", err)
+		}
+	}
+}
+
+// TestVault_Passphrase 测试使用口令加密的保险库
+func TestVault_Passphrase(t *testing.T) {
+	iterations := pbkdf2Iterations
+	pbkdf2Iterations = 1000
+	defer func() { pbkdf2Iterations = iterations }()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	t.Setenv(envVaultPassphrase, "correct horse")
+	config := getDefaultConfig()
+	config.Headers["Cookie"] = "session=abc"
+	config.SecretHeaders = []string{"X-Token"}
+	config.Headers["X-Token"] = "custom-secret"
+	if err := SaveConfig(path, config); err != nil {
+		t.Fatalf("SaveConfig failed: %v", err)
+	}
+	if _, err := os.Stat(vaultKeyPath(path)); !os.IsNotExist(err) {
+		t.Errorf("Key file should not be created when using a passphrase")
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "session=abc") || strings.Contains(string(data), "custom-secret") {
+		t.Errorf("Secrets stored in plaintext: %s", data)
+	}
+
+	loaded, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Headers["Cookie"] != "session=abc" || loaded.Headers["X-Token"] != "custom-secret" {
+		t.Errorf("Unexpected headers %v", loaded.Headers)
+	}
+
+	t.Setenv(envVaultPassphrase, "wrong")
+	if _, err := LoadConfig(path); err == nil {
+		t.Errorf("Expected error for wrong passphrase")
+	}
+	t.Setenv(envVaultPassphrase, "")
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), envVaultPassphrase) {
+		t.Errorf("Expected error asking for passphrase, got %v", err)
+	}
+}
+
+// TestConfig_Redacted 测试敏感请求头的隐藏和恢复
+func TestConfig_Redacted(t *testing.T) {
+	config := &Config{
+		Headers:       map[string]string{"x-nd-auth": "token-1", "X-Token": "token-2", "Referer": "https://a/"},
+		SecretHeaders: []string{"x-token"},
+	}
+	redacted := config.Redacted()
+	if redacted.Headers["x-nd-auth"] != redactedValue || redacted.Headers["X-Token"] != redactedValue {
+		t.Errorf("Expected secret headers to be redacted: %v", redacted.Headers)
+	}
+	if redacted.Headers["Referer"] != "https://a/" || config.Headers["x-nd-auth"] != "token-1" {
+		t.Errorf("Redacted changed the wrong values")
+	}
+	if msg := config.RedactString("请求失败: token-1 无效"); strings.Contains(msg, "token-1") {
+		t.Errorf("Token not redacted in %q", msg)
+	}
+
+	redacted.Headers["X-New"] = redactedValue
+	redacted.restoreSecrets(config)
+	if redacted.Headers["x-nd-auth"] != "token-1" || redacted.Headers["X-Token"] != "token-2" {
+		t.Errorf("Expected secrets to be restored: %v", redacted.Headers)
+	}
+	if _, ok := redacted.Headers["X-New"]; ok {
+		t.Errorf("Placeholder without previous value should be dropped")
+	}
+}
+
+// TestAPI_SecretRedaction 测试接口响应中不返回令牌，提交占位符时保留原令牌
+func TestAPI_SecretRedaction(t *testing.T) {
+	t.Setenv(envVaultPassphrase, "")
+	ws, handler := newTestWebServer(t)
+	config := ws.currentConfig().Copy()
+	config.Headers["X-Nd-Auth"] = "secret-token"
+	if err := ws.replaceConfig(config); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/config", "/api/v1/config", "/api/v1/profiles", "/api/v1/profiles/default", "/"} {
+		rec := doRequest(handler, http.MethodGet, path, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected 200, got %d", path, rec.Code)
+		}
+		if strings.Contains(rec.Body.String(), "secret-token") {
+			t.Errorf("GET %s leaked the token", path)
+		}
+	}
+
+	rec := doRequest(handler, http.MethodGet, "/api/v1/config", "")
+	rec = doRequest(handler, http.MethodPut, "/api/v1/config", strings.Replace(rec.Body.String(), `"timeout":"30s"`, `"timeout":"1m"`, 1))
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "secret-token") {
+		t.Fatalf("Unexpected PUT response %d: %s", rec.Code, rec.Body.String())
+	}
+	if token := ws.currentConfig().Headers["X-Nd-Auth"]; token != "secret-token" {
+		t.Errorf("Expected token to be kept, got %q", token)
+	}
+	if ws.currentConfig().Timeout != "1m" {
+		t.Errorf("Expected timeout to be updated")
+	}
+	saved, err := LoadConfig(ws.configPath)
+	if err != nil || saved.Headers["X-Nd-Auth"] != "secret-token" {
+		t.Errorf("Expected token in vault, got %v (%v)", saved, err)
+	}
+}
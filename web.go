@@ -1,18 +1,18 @@
 package main
 
 import (
-	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
+	"mime"
 	"net/http"
 	"os"
-	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/gorilla/websocket"
 )
 
 //go:embed templates/*
@@ -20,39 +20,56 @@ var templatesFS embed.FS
 
 // WebServer Web服务结构体
 type WebServer struct {
-	config     *Config
+	config     atomic.Pointer[configSnapshot] // 当前配置，只整体替换，不在原对象上修改
+	configMu   sync.Mutex                     // 串行化配置的保存与替换
 	configPath string
+	overrides  []configSetting // 来自环境变量和命令行参数的配置项，不写入配置文件
+	fileState  configFileState // 最后一次加载或写入时配置文件的状态，由configMu保护
 	template   *template.Template
 	server     *http.Server
-	progress   map[string]*DownloadProgress
-	mu         sync.RWMutex
-	lastActive time.Time
+	tasks      *TaskManager
+	events     *EventHub
+	lastActive atomic.Int64 // 最后活动时间（UnixNano）
 	exitChan   chan bool
-	clients    map[*websocket.Conn]bool // WebSocket客户端连接
-	clientsMu  sync.RWMutex             // 保护clients的互斥锁
+	// 全文索引在后台建立和更新，搜索接口只读取已完成的索引
+	searchMu       sync.Mutex    // 保护以下索引状态
+	searchIdx      *SearchIndex  // 最近建立的全文索引，更新时整体替换，不在原对象上修改
+	searchDir      string        // searchIdx对应的输出目录
+	searchErr      error         // 最近一次更新索引的错误
+	searchIndexing bool          // 有正在进行或等待进行的更新
+	searchRefresh  chan struct{} // 更新请求，多次请求合并为一次
+	searchOnce     sync.Once     // 第一次请求更新时启动后台协程
 }
 
 // DownloadProgress 下载进度信息
 type DownloadProgress struct {
-	TaskID     string  `json:"task_id"`
-	Filename   string  `json:"filename"`
-	Percent    float64 `json:"percent"`
-	Downloaded int64   `json:"downloaded"`
-	Total      int64   `json:"total"`
-	Status     string  `json:"status"` // pending, downloading, completed, failed
-	OutputPath string  `json:"output_path"`
-	ErrorMsg   string  `json:"error_msg,omitempty"` // 错误信息
+	TaskID     string     `json:"task_id"`
+	URL        string     `json:"url"`
+	Profile    string     `json:"profile,omitempty"` // 创建任务时使用的配置方案
+	Filename   string     `json:"filename"`
+	Percent    float64    `json:"percent"`
+	Downloaded int64      `json:"downloaded"`
+	Total      int64      `json:"total"`  // 文件总大小，服务器未给出时为-1，下载完成后为实际大小
+	Status     string     `json:"status"` // pending, downloading, paused, completed, failed, canceled
+	OutputPath string     `json:"output_path"`
+	ErrorMsg   string     `json:"error_msg,omitempty"`  // 错误信息
+	ErrorCode  string     `json:"error_code,omitempty"` // 错误码，与命令行JSON输出的错误码相同
+	SpeedBPS   float64    `json:"speed_bps"`            // 最近几秒的平均下载速度，字节每秒，未在下载时为0
+	ETASeconds int64      `json:"eta_seconds"`          // 预计剩余秒数，未知时为-1
+	StartedAt  *time.Time `json:"started_at,omitempty"` // 第一次开始传输的时间
+	Elapsed    float64    `json:"elapsed"`              // 累计下载用时（秒），不含暂停的时间
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
 }
 
-// WebSocket升级器
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // 允许所有来源
-	},
+// configSnapshot 当前配置方案的名称和合并后的有效配置
+type configSnapshot struct {
+	profile string
+	config  *Config
 }
 
 // NewWebServer 创建新的Web服务器实例
-func NewWebServer(config *Config, configPath string) *WebServer {
+func NewWebServer(resolved *ResolvedConfig, configPath string) *WebServer {
 	// 解析嵌入的模板文件
 	tmpl, err := template.ParseFS(templatesFS, "templates/*.html")
 	if err != nil {
@@ -60,14 +77,17 @@ func NewWebServer(config *Config, configPath string) *WebServer {
 	}
 
 	server := &WebServer{
-		config:     config,
-		configPath: configPath,
-		template:   tmpl,
-		progress:   make(map[string]*DownloadProgress),
-		lastActive: time.Now(),
-		exitChan:   make(chan bool, 1),
-		clients:    make(map[*websocket.Conn]bool),
+		configPath:    configPath,
+		overrides:     resolved.Overrides(),
+		template:      tmpl,
+		exitChan:      make(chan bool, 1),
+		events:        NewEventHub(),
+		searchRefresh: make(chan struct{}, 1),
 	}
+	server.config.Store(&configSnapshot{profile: resolved.Profile, config: resolved.Config})
+	server.fileState = statConfigFiles(configPath)
+	server.updateLastActive()
+	server.tasks = NewTaskManager(server.events)
 
 	// 启动自动退出检查协程
 	go server.autoExitChecker()
@@ -84,7 +104,7 @@ func (ws *WebServer) autoExitChecker() {
 		select {
 		case <-ticker.C:
 			// 检查是否超过5分钟无活动
-			if time.Since(ws.lastActive) > 5*time.Minute {
+			if time.Since(time.Unix(0, ws.lastActive.Load())) > 5*time.Minute {
 				fmt.Println("程序因长时间无操作自动退出")
 				os.Exit(0)
 			}
@@ -97,11 +117,166 @@ func (ws *WebServer) autoExitChecker() {
 
 // updateLastActive 更新最后活动时间
 func (ws *WebServer) updateLastActive() {
-	ws.lastActive = time.Now()
+	ws.lastActive.Store(time.Now().UnixNano())
+}
+
+// currentConfig 返回当前配置的快照，调用方不得修改返回的对象
+func (ws *WebServer) currentConfig() *Config {
+	return ws.config.Load().config
+}
+
+// currentProfile 返回当前使用的配置方案名称
+func (ws *WebServer) currentProfile() string {
+	return ws.config.Load().profile
+}
+
+// replaceConfig 校验配置并保存到当前配置方案，成功后替换当前配置。校验失败时返回*ValidationError。
+// 来自环境变量和命令行参数且未被修改的配置项不会写入文件，取值为占位符的敏感请求头保持不变
+func (ws *WebServer) replaceConfig(config *Config) error {
+	ws.configMu.Lock()
+	defer ws.configMu.Unlock()
+
+	// 客户端拿到的敏感请求头是占位符，原样提交时保留当前的取值
+	config = config.Copy()
+	config.restoreSecrets(ws.currentConfig())
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	profile := ws.currentProfile()
+	if err := SaveProfile(ws.configPath, profile, persistableConfig(config, ws.overrides, ws.configPath, profile)); err != nil {
+		return err
+	}
+	ws.recordConfigWritten()
+	ws.config.Store(&configSnapshot{profile: profile, config: config})
+	return nil
+}
+
+// resetConfig 将当前配置方案恢复为默认值，当前配置为默认值加上环境变量和命令行参数的覆盖值
+func (ws *WebServer) resetConfig() error {
+	ws.configMu.Lock()
+	defer ws.configMu.Unlock()
+
+	profile := ws.currentProfile()
+	if err := SaveProfile(ws.configPath, profile, getDefaultConfig()); err != nil {
+		return err
+	}
+	ws.recordConfigWritten()
+	ws.config.Store(&configSnapshot{profile: profile, config: applyOverrides(getDefaultConfig(), ws.overrides)})
+	return nil
+}
+
+// profileConfig 返回配置方案合并默认值、环境变量和命令行参数后的有效配置
+func (ws *WebServer) profileConfig(name string) (*Config, error) {
+	file, _, _, err := fileSettings(ws.configPath, name)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := resolveConfig(defaultSettings(), file, ws.overrides)
+	if err != nil {
+		return nil, err
+	}
+	return resolved.Config, nil
+}
+
+// readProfiles 读取配置文件中的所有配置方案。配置文件不存在时只包含当前配置方案
+func (ws *WebServer) readProfiles() (*ConfigFile, error) {
+	if _, err := os.Stat(ws.configPath); errors.Is(err, os.ErrNotExist) {
+		snapshot := ws.config.Load()
+		return newConfigFile(snapshot.profile, persistableConfig(snapshot.config, ws.overrides, ws.configPath, snapshot.profile)), nil
+	}
+	return LoadConfigFile(ws.configPath)
+}
+
+// updateProfiles 修改配置文件中的配置方案
+func (ws *WebServer) updateProfiles(update func(cf *ConfigFile) error) error {
+	ws.configMu.Lock()
+	defer ws.configMu.Unlock()
+	return ws.updateProfilesLocked(update)
+}
+
+// updateProfilesLocked 同updateProfiles，调用方需持有configMu。配置文件不存在时先写入当前配置方案
+func (ws *WebServer) updateProfilesLocked(update func(cf *ConfigFile) error) error {
+	cf, err := ws.readProfiles()
+	if err != nil {
+		return err
+	}
+	if err := update(cf); err != nil {
+		return err
+	}
+	if err := SaveConfigFile(ws.configPath, cf); err != nil {
+		return err
+	}
+	ws.recordConfigWritten()
+	return nil
+}
+
+// switchProfile 切换当前配置方案并写入配置文件
+func (ws *WebServer) switchProfile(name string) error {
+	ws.configMu.Lock()
+	defer ws.configMu.Unlock()
+
+	if err := ws.updateProfilesLocked(func(cf *ConfigFile) error { return cf.Activate(name) }); err != nil {
+		return err
+	}
+	config, err := ws.profileConfig(name)
+	if err != nil {
+		return err
+	}
+	ws.config.Store(&configSnapshot{profile: name, config: config})
+	return nil
+}
+
+// startTask 使用指定的配置方案（为空时使用当前方案）创建并启动下载任务
+func (ws *WebServer) startTask(url, profile string) (*DownloadProgress, error) {
+	config, profile, err := ws.taskConfig(url, profile)
+	if err != nil {
+		return nil, err
+	}
+	return ws.tasks.Start(config, profile), nil
+}
+
+// taskConfig 返回使用指定配置方案（为空时使用当前方案）下载url的配置和实际使用的方案名
+func (ws *WebServer) taskConfig(url, profile string) (*Config, string, error) {
+	snapshot := ws.config.Load()
+	config := snapshot.config
+	if profile == "" {
+		profile = snapshot.profile
+	} else if profile != snapshot.profile {
+		var err error
+		if config, err = ws.profileConfig(profile); err != nil {
+			return nil, "", err
+		}
+	}
+	return newTaskConfig(config, url), profile, nil
 }
 
 // Start 启动Web服务
 func (ws *WebServer) Start(port string) error {
+	ws.server = &http.Server{
+		Addr:    ":" + port,
+		Handler: ws.routes(),
+	}
+
+	// 输出目录中上次未完成的下载加入任务列表
+	if n, err := adoptPartials(ws.tasks, ws.currentConfig(), ws.currentProfile()); err != nil {
+		fmt.Printf("警告: %v\n", err)
+	} else if n > 0 {
+		fmt.Printf("发现 %d 个未完成的下载，已加入下载列表，可以继续下载\n", n)
+	}
+
+	// 监视配置文件，外部修改（如推送新的令牌）无需重启即可生效
+	go ws.watchConfig(configPollInterval)
+
+	// 在后台为输出目录中的PDF建立全文索引，下载完成后更新
+	ws.startSearchIndexer()
+
+	fmt.Printf("Web服务器启动成功，访问地址: http://localhost:%s\n", port)
+	return ws.server.ListenAndServe()
+}
+
+// routes 注册所有HTTP路由
+func (ws *WebServer) routes() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		ws.updateLastActive()
@@ -131,69 +306,52 @@ func (ws *WebServer) Start(port string) error {
 		ws.updateLastActive()
 		ws.handleWebSocket(w, r)
 	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		ws.updateLastActive()
+		ws.handleEvents(w, r)
+	})
 	mux.HandleFunc("/static/", func(w http.ResponseWriter, r *http.Request) {
 		ws.updateLastActive()
 		ws.handleStatic(w, r)
 	})
-
-	ws.server = &http.Server{
-		Addr:    ":" + port,
-		Handler: mux,
-	}
-
-	fmt.Printf("Web服务器启动成功，访问地址: http://localhost:%s\n", port)
-	return ws.server.ListenAndServe()
+	ws.registerAPI(mux)
+	return sameOriginOnly(mux)
 }
 
-// handleWebSocket 处理WebSocket连接
-func (ws *WebServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		fmt.Printf("WebSocket升级失败: %v\n", err)
-		return
-	}
-	defer conn.Close()
-
-	// 添加客户端到连接池
-	ws.clientsMu.Lock()
-	ws.clients[conn] = true
-	ws.clientsMu.Unlock()
-	defer func() {
-		ws.clientsMu.Lock()
-		delete(ws.clients, conn)
-		ws.clientsMu.Unlock()
-	}()
-
-	// 监听客户端消息（这里主要是为了保持连接）
-	for {
-		_, _, err := conn.ReadMessage()
-		if err != nil {
-			break
+// sameOriginOnly 拒绝其他网页发起的修改状态的请求。创建的下载任务会带上配置的认证请求头，
+// 跨域的POST即使读不到响应也能造成影响，因此除 GET、HEAD 以外的请求必须同源（规则与WebSocket相同），
+// 带请求体时必须是 application/json：浏览器跨域发送这种请求前会先做预检，不能伪装成简单请求
+func sameOriginOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
 		}
-	}
+		isAPI := strings.HasPrefix(r.URL.Path, apiPrefix+"/")
+		if !checkSameOrigin(r) {
+			if isAPI {
+				sendAPIError(w, http.StatusForbidden, "forbidden", "不允许其他网站的页面发起请求")
+			} else {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+			}
+			return
+		}
+		if r.ContentLength != 0 && !isJSONContentType(r.Header.Get("Content-Type")) {
+			if isAPI {
+				sendAPIError(w, http.StatusUnsupportedMediaType, "unsupported_media_type", "请求体的 Content-Type 必须是 application/json")
+			} else {
+				http.Error(w, "Unsupported Media Type", http.StatusUnsupportedMediaType)
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
-// broadcastProgress 广播进度更新到所有WebSocket客户端
-func (ws *WebServer) broadcastProgress(progress *DownloadProgress) {
-	ws.clientsMu.RLock()
-	defer ws.clientsMu.RUnlock()
-
-	// 序列化进度数据
-	data, err := json.Marshal(progress)
-	if err != nil {
-		fmt.Printf("序列化进度数据失败: %v\n", err)
-		return
-	}
-
-	// 发送给所有连接的客户端
-	for client := range ws.clients {
-		err := client.WriteMessage(websocket.TextMessage, data)
-		if err != nil {
-			// 如果发送失败，标记连接为断开
-			fmt.Printf("向客户端发送消息失败: %v\n", err)
-			client.Close()
-		}
-	}
+// isJSONContentType Content-Type 是否为 application/json（可以带 charset 等参数）
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "application/json"
 }
 
 // handleIndex 处理首页请求
@@ -203,7 +361,7 @@ func (ws *WebServer) handleIndex(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := ws.template.ExecuteTemplate(w, "index.html", ws.config)
+	err := ws.template.ExecuteTemplate(w, "index.html", ws.currentConfig().Redacted())
 	if err != nil {
 		fmt.Printf("模板执行错误: %v\n", err)
 		// 检查是否已经写入了响应头
@@ -227,10 +385,18 @@ func (ws *WebServer) handleSaveConfig(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
-	ws.config = &data
 
-	// 保存配置到文件
-	if err := SaveConfig(ws.configPath, ws.config); err != nil {
+	// 保存配置到文件，校验失败时返回每个配置项的错误，供设置表单标注
+	if err := ws.replaceConfig(&data); err != nil {
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			sendJSONResponse(w, map[string]interface{}{
+				"success": false,
+				"message": err.Error(),
+				"errors":  verr.Fields,
+			})
+			return
+		}
 		sendJSONResponse(w, map[string]interface{}{
 			"success": false,
 			"message": fmt.Sprintf("保存配置失败: %v", err),
@@ -251,10 +417,8 @@ func (ws *WebServer) handleResetConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 创建默认配置
-	defaultConfig := getDefaultConfig()
-	// 保存默认配置到文件
-	if err := SaveConfig(ws.configPath, defaultConfig); err != nil {
+	// 保存默认配置到文件，并更新当前配置
+	if err := ws.resetConfig(); err != nil {
 		sendJSONResponse(w, map[string]interface{}{
 			"success": false,
 			"message": fmt.Sprintf("保存默认配置失败: %v", err),
@@ -262,9 +426,6 @@ func (ws *WebServer) handleResetConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 更新当前配置
-	ws.config = defaultConfig
-
 	sendJSONResponse(w, map[string]interface{}{
 		"success": true,
 		"message": "已恢复默认配置",
@@ -286,10 +447,11 @@ func (ws *WebServer) handleDownload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 获取URL
+	config := ws.currentConfig()
 	url, ok := requestData["url"].(string)
 	if !ok || url == "" {
 		// 如果请求中没有提供URL，则使用配置中的URL
-		url = ws.config.URL
+		url = config.URL
 	}
 
 	// 检查URL是否为空
@@ -301,83 +463,25 @@ func (ws *WebServer) handleDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 设置默认输出路径
-	downloadConfig := ws.config.Copy()
-	downloadConfig.URL = url
-	var fileName string
-	if downloadConfig.OutputPath == "" {
-		fileName = getDefaultFilename(url)
-		downloadConfig.OutputPath = filepath.Join(downloadConfig.OutputDir, fileName)
-	} else {
-		fileName = filepath.Base(url)
-	}
-	timeout := downloadConfig.GetTimeoutDuration()
-
-	// 添加HTTP请求头
-	header := ws.config.Headers
-	downloadConfig.Headers = make(map[string]string)
-	for k, v := range header {
-		downloadConfig.Headers[k] = v
-	}
-
-	// 创建上下文
-	ctx, _ := context.WithTimeout(context.Background(), timeout)
-
-	// 生成任务ID
-	taskID := fmt.Sprintf("%d", time.Now().UnixNano())
-
-	// 从URL中提取文件名
-	filename := getDefaultFilename(url)
-
-	// 创建初始进度信息
-	progress := &DownloadProgress{
-		TaskID:     taskID,
-		Filename:   filename,
-		Percent:    0,
-		Downloaded: 0,
-		Total:      0,
-		Status:     "pending",
-		OutputPath: ws.config.OutputPath,
-	}
-
-	// 广播初始进度
-	ws.broadcastProgress(progress)
-
-	// 在goroutine中执行下载（带进度回调），这样可以立即返回任务信息
-	go func() {
-		// 执行下载（带进度回调）
-		err := downloadPDFWithProgress(ctx, *downloadConfig, func(percent float64, downloaded, total int64) {
-			// 更新进度信息
-			progress.Percent = percent
-			progress.Downloaded = downloaded
-			progress.Total = total
-			progress.Status = "downloading"
-
-			// 广播进度更新
-			ws.broadcastProgress(progress)
+	// 创建并启动下载任务
+	progress, err := ws.startTask(url, "")
+	if err != nil {
+		sendJSONResponse(w, map[string]interface{}{
+			"success": false,
+			"message": err.Error(),
 		})
-
-		// 下载完成后更新状态
-		if err != nil {
-			progress.Status = "failed"
-			progress.Percent = 0
-			progress.ErrorMsg = err.Error()
-			ws.broadcastProgress(progress)
-		} else {
-			progress.Status = "completed"
-			progress.Percent = 100
-			ws.broadcastProgress(progress)
-		}
-	}()
+		return
+	}
 
 	// 立即返回任务信息
 	sendJSONResponse(w, map[string]interface{}{
 		"success":     true,
-		"task_id":     taskID,
-		"filename":    filename,
-		"output_path": ws.config.OutputPath,
+		"task_id":     progress.TaskID,
+		"filename":    progress.Filename,
+		"output_path": progress.OutputPath,
+		"profile":     progress.Profile,
 		"total_size":  0, // 总大小将在下载开始后通过WebSocket更新
-		"status":      "pending",
+		"status":      progress.Status,
 		"message":     "下载任务已启动",
 	})
 }
@@ -422,20 +526,26 @@ func (ws *WebServer) handleGetConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 构造返回数据
+	// 构造返回数据，敏感请求头只返回占位符
+	config := ws.currentConfig().Redacted()
 	configData := map[string]interface{}{
-		"url":         ws.config.URL,
-		"output_path": ws.config.OutputPath,
-		"output_dir":  ws.config.OutputDir,
-		"timeout":     ws.config.Timeout,
-		"chunk_size":  ws.config.ChunkSize,
-		"headers":     ws.config.Headers,
+		"url":         config.URL,
+		"output_path": config.OutputPath,
+		"output_dir":  config.OutputDir,
+		"timeout":     config.Timeout,
+		"chunk_size":  config.ChunkSize,
+		"headers":     config.Headers,
+
+		"on_exists":      config.OnExists,
+		"partial_files":  config.PartialFiles,
+		"resource_types": config.ResourceTypes,
+		"secret_headers": config.SecretHeaders,
 	}
 
 	sendJSONResponse(w, configData)
 }
 
-// parseJSON 解析JSON请求体
+// parseJSON 解析JSON请求体，Content-Type 已由 sameOriginOnly 检查
 func parseJSON(r *http.Request, v interface{}) error {
 	decoder := json.NewDecoder(r.Body)
 	return decoder.Decode(v)
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestWebServer_ConcurrentRequests 并发访问所有接口，需配合 go test -race 运行
+func TestWebServer_ConcurrentRequests(t *testing.T) {
+	pdf := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("%PDF-1.4 test"))
+	}))
+	defer pdf.Close()
+
+	ws, handler := newTestWebServer(t)
+	outputDir := ws.currentConfig().OutputDir
+	configBody := fmt.Sprintf(`{"output_dir":%q,"timeout":"30s","chunk_size":1024,"headers":{"X-Test":"1"}}`, outputDir)
+
+	requests := []struct {
+		method, path, body string
+	}{
+		{http.MethodGet, "/", ""},
+		{http.MethodGet, "/config", ""},
+		{http.MethodPost, "/save-config", configBody},
+		{http.MethodPost, "/reset-config", ""},
+		{http.MethodPost, "/download", `{"url":"` + pdf.URL + `/a.pdf"}`},
+		{http.MethodGet, "/api/v1/tasks", ""},
+		{http.MethodPost, "/api/v1/tasks", `{"url":"` + pdf.URL + `/b.pdf"}`},
+		{http.MethodGet, "/api/v1/config", ""},
+		{http.MethodPut, "/api/v1/config", configBody},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		for _, req := range requests {
+			wg.Add(1)
+			go func(method, path, body string) {
+				defer wg.Done()
+				rec := doRequest(handler, method, path, body)
+				if rec.Code >= 500 {
+					t.Errorf("%s %s: unexpected status %d", method, path, rec.Code)
+				}
+			}(req.method, req.path, req.body)
+		}
+		// 同时取消和删除已有任务
+		for _, task := range ws.tasks.List() {
+			wg.Add(2)
+			go func(id string) {
+				defer wg.Done()
+				doRequest(handler, http.MethodPost, "/api/v1/tasks/"+id+"/cancel", "")
+			}(task.TaskID)
+			go func(id string) {
+				defer wg.Done()
+				doRequest(handler, http.MethodDelete, "/api/v1/tasks/"+id, "")
+			}(task.TaskID)
+		}
+	}
+	wg.Wait()
+}
+
+// TestWebServer_TaskConfigSnapshot 测试替换配置不会影响已创建任务的配置快照
+func TestWebServer_TaskConfigSnapshot(t *testing.T) {
+	ws, _ := newTestWebServer(t)
+	base := ws.currentConfig()
+
+	taskConfig := newTaskConfig(base, "https://example.com/book.pdf")
+	replaced := base.Copy()
+	replaced.Headers["X-Nd-Auth"] = "new-token"
+	elsewhere := t.TempDir()
+	replaced.OutputDir = elsewhere
+	if err := ws.replaceConfig(replaced); err != nil {
+		t.Fatalf("replaceConfig failed: %v", err)
+	}
+
+	if _, ok := taskConfig.Headers["X-Nd-Auth"]; ok {
+		t.Errorf("Task config shares headers with the replaced config")
+	}
+	if _, ok := base.Headers["X-Nd-Auth"]; ok {
+		t.Errorf("Copy shares headers with the original config")
+	}
+	if ws.currentConfig().OutputDir != elsewhere {
+		t.Errorf("Expected replaced config to be current")
+	}
+}
+
+// TestWebServer_CrossOrigin 测试其他网站的页面不能通过接口创建任务或修改配置，包括伪装成简单请求的 text/plain 请求
+func TestWebServer_CrossOrigin(t *testing.T) {
+	ws, handler := newTestWebServer(t)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	before := ws.currentConfig().OutputDir
+
+	post := func(path, origin, contentType, body string) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, server.URL+path, strings.NewReader(body))
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		req.Header.Set("Content-Type", contentType)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for _, path := range []string{"/api/v1/tasks", "/download", "/save-config", "/api/v1/profiles/default/activate"} {
+		body := `{"url":"http://evil.example/steal.pdf","output_dir":"/tmp/evil"}`
+		if code := post(path, "http://evil.example", "application/json", body); code != http.StatusForbidden {
+			t.Errorf("%s: expected 403 for a cross-origin request, got %d", path, code)
+		}
+		if code := post(path, "http://evil.example", "text/plain", body); code != http.StatusForbidden {
+			t.Errorf("%s: expected 403 for a cross-origin simple request, got %d", path, code)
+		}
+		// 没有 Origin 时不是浏览器发起的请求，但请求体仍然必须是JSON
+		if code := post(path, "", "text/plain", body); code != http.StatusUnsupportedMediaType {
+			t.Errorf("%s: expected 415 for a text/plain body, got %d", path, code)
+		}
+	}
+	if tasks := ws.tasks.List(); len(tasks) != 0 {
+		t.Errorf("Expected no tasks, got %+v", tasks)
+	}
+	if dir := ws.currentConfig().OutputDir; dir != before {
+		t.Errorf("Expected the config to be unchanged, got output_dir %s", dir)
+	}
+
+	// 本页面发起的请求带有相同的 Origin
+	if code := post("/api/v1/probe", server.URL, "application/json; charset=utf-8", `{"url":""}`); code == http.StatusForbidden || code == http.StatusUnsupportedMediaType {
+		t.Errorf("Expected a same-origin request to be accepted, got %d", code)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 客户端命令类型
+const (
+	CommandSubscribe   = "subscribe"   // 订阅指定任务的事件
+	CommandUnsubscribe = "unsubscribe" // 取消订阅指定任务的事件
+	CommandStart       = "start"       // 创建下载任务
+	CommandCancel      = "cancel"      // 取消下载任务
+	CommandResync      = "resync"      // 重新获取全量快照（检测到消息丢失时使用）
+)
+
+// subscribeAll 订阅全部任务时使用的特殊任务ID
+const subscribeAll = "*"
+
+// WebSocket连接参数
+const (
+	wsSendQueueSize  = 256       // 每个连接的待发送消息队列长度
+	wsMaxMessageSize = 64 * 1024 // 客户端消息的最大长度
+)
+
+// 定义为变量以便测试时缩短
+var (
+	wsWriteWait  = 10 * time.Second    // 单条消息的写超时
+	wsPongWait   = 60 * time.Second    // 等待pong的超时，超时视为连接已断开
+	wsPingPeriod = wsPongWait * 9 / 10 // 发送ping的间隔，必须小于wsPongWait
+)
+
+// WebSocket升级器
+var upgrader = websocket.Upgrader{
+	CheckOrigin: checkSameOrigin,
+}
+
+// checkSameOrigin 只允许本页面建立WebSocket连接。客户端可以通过连接创建下载任务，
+// 任务会带上配置的认证请求头，其他网页发起的跨域连接必须拒绝；没有 Origin 的请求不是来自浏览器，允许连接
+func checkSameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// ClientCommand 客户端通过WebSocket发送的命令
+type ClientCommand struct {
+	Type      string   `json:"type"`
+	RequestID string   `json:"request_id,omitempty"`
+	TaskID    string   `json:"task_id,omitempty"`  // cancel 使用
+	TaskIDs   []string `json:"task_ids,omitempty"` // subscribe/unsubscribe 使用
+	URL       string   `json:"url,omitempty"`      // start 使用
+	Profile   string   `json:"profile,omitempty"`  // start 使用，为空时使用当前配置方案
+}
+
+// wsClient 单个WebSocket连接。
+// gorilla/websocket不允许并发写，所有消息先进入队列，由writePump串行写出；
+// 队列已满时丢弃消息而不是阻塞发布者，客户端通过seq的间隔发现丢失并请求重新同步。
+type wsClient struct {
+	conn     *websocket.Conn
+	queue    chan []byte
+	done     chan struct{} // 连接关闭后关闭
+	once     sync.Once
+	mu       sync.Mutex // 保护seq和订阅信息，并保证入队顺序与seq一致
+	seq      uint64
+	dropped  uint64
+	pingWait time.Duration       // 创建时从wsPingPeriod复制
+	pongWait time.Duration       // 创建时从wsPongWait复制
+	filtered bool                // 为true时只接收subs中任务的事件
+	subs     map[string]struct{} // 已订阅的任务ID
+}
+
+// newWSClient 创建连接对象，需要调用writePump和readPump才会开始收发
+func newWSClient(conn *websocket.Conn) *wsClient {
+	return &wsClient{
+		conn:     conn,
+		queue:    make(chan []byte, wsSendQueueSize),
+		done:     make(chan struct{}),
+		pingWait: wsPingPeriod,
+		pongWait: wsPongWait,
+		subs:     make(map[string]struct{}),
+	}
+}
+
+// Deliver 实现EventSubscriber接口，过滤掉未订阅任务的事件，不会阻塞
+func (c *wsClient) Deliver(evt *Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.filtered && evt.TaskID != "" {
+		if _, ok := c.subs[evt.TaskID]; !ok {
+			return
+		}
+	}
+	c.enqueueLocked(*evt)
+}
+
+// send 发送仅属于当前连接的消息
+func (c *wsClient) send(evt Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.enqueueLocked(evt)
+}
+
+// enqueueLocked 分配连接内序号并放入发送队列，调用方需持有c.mu
+func (c *wsClient) enqueueLocked(evt Event) {
+	c.seq++
+	evt.Seq = c.seq
+	if evt.Time.IsZero() {
+		evt.Time = time.Now()
+	}
+	data, err := json.Marshal(evt)
+	if err != nil {
+		fmt.Printf("序列化事件失败: %v\n", err)
+		return
+	}
+	select {
+	case c.queue <- data:
+	case <-c.done:
+	default:
+		// 队列已满，丢弃消息；seq已经递增，客户端会发现间隔
+		c.dropped++
+	}
+}
+
+// close 关闭连接，可重复调用
+func (c *wsClient) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// writePump 串行写出队列中的消息，并定期发送ping
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(c.pingWait)
+	defer func() {
+		ticker.Stop()
+		c.close()
+	}()
+
+	for {
+		select {
+		case data := <-c.queue:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				fmt.Printf("向客户端发送消息失败: %v\n", err)
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// readPump 读取客户端消息，直到连接断开或pong超时
+func (c *wsClient) readPump(handle func(data []byte)) {
+	defer c.close()
+
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(c.pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(c.pongWait))
+		return nil
+	})
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		handle(data)
+	}
+}
+
+// handleWebSocket 处理WebSocket连接
+func (ws *WebServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		fmt.Printf("WebSocket升级失败: %v\n", err)
+		return
+	}
+	client := newWSClient(conn)
+
+	// 先订阅再入队快照，持有锁期间事件无法入队，保证快照是第一条消息
+	client.mu.Lock()
+	lastID := ws.events.Subscribe(client)
+	client.enqueueLocked(ws.snapshotEvent(lastID))
+	client.mu.Unlock()
+	defer ws.events.Unsubscribe(client)
+
+	go client.writePump()
+	client.readPump(func(data []byte) {
+		ws.updateLastActive()
+
+		var cmd ClientCommand
+		if err := json.Unmarshal(data, &cmd); err != nil {
+			client.send(Event{Type: EventError, Message: fmt.Sprintf("无效的命令: %v", err)})
+			return
+		}
+		ws.handleClientCommand(client, &cmd)
+	})
+}
+
+// snapshotEvent 生成全量任务快照事件
+func (ws *WebServer) snapshotEvent(lastID uint64) Event {
+	return Event{
+		ID:    lastID,
+		Type:  EventSnapshot,
+		Tasks: ws.tasks.List(),
+	}
+}
+
+// handleClientCommand 执行客户端命令，并回复ack或error
+func (ws *WebServer) handleClientCommand(client *wsClient, cmd *ClientCommand) {
+	reply := func(task *DownloadProgress, err error) {
+		if err != nil {
+			client.send(Event{Type: EventError, RequestID: cmd.RequestID, TaskID: cmd.TaskID, Message: err.Error()})
+			return
+		}
+		evt := Event{Type: EventAck, RequestID: cmd.RequestID, Task: task}
+		if task != nil {
+			evt.TaskID = task.TaskID
+		}
+		client.send(evt)
+	}
+
+	switch cmd.Type {
+	case CommandSubscribe:
+		client.mu.Lock()
+		for _, id := range cmd.TaskIDs {
+			if id == subscribeAll {
+				client.filtered = false
+				client.subs = make(map[string]struct{})
+				break
+			}
+			client.filtered = true
+			client.subs[id] = struct{}{}
+		}
+		client.mu.Unlock()
+		reply(nil, nil)
+	case CommandUnsubscribe:
+		client.mu.Lock()
+		client.filtered = true
+		for _, id := range cmd.TaskIDs {
+			delete(client.subs, id)
+		}
+		client.mu.Unlock()
+		reply(nil, nil)
+	case CommandStart:
+		if cmd.URL == "" {
+			reply(nil, fmt.Errorf("请提供PDF文件URL"))
+			return
+		}
+		// 已过滤的连接自动订阅自己创建的任务
+		progress, err := ws.startTask(cmd.URL, cmd.Profile)
+		if err != nil {
+			reply(nil, err)
+			return
+		}
+		client.mu.Lock()
+		if client.filtered {
+			client.subs[progress.TaskID] = struct{}{}
+		}
+		client.mu.Unlock()
+		reply(progress, nil)
+	case CommandCancel:
+		reply(ws.tasks.Cancel(cmd.TaskID))
+	case CommandResync:
+		client.mu.Lock()
+		client.enqueueLocked(ws.snapshotEvent(ws.events.LastID()))
+		client.mu.Unlock()
+	default:
+		reply(nil, fmt.Errorf("未知的命令类型: %s", cmd.Type))
+	}
+}
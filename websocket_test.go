@@ -0,0 +1,283 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialTestWebSocket 连接测试服务的 /ws 接口
+func dialTestWebSocket(t *testing.T, server *httptest.Server) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	return conn
+}
+
+// readEvent 读取一条事件，超时则测试失败
+func readEvent(t *testing.T, conn *websocket.Conn) Event {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var evt Event
+	if err := conn.ReadJSON(&evt); err != nil {
+		t.Fatalf("Read event failed: %v", err)
+	}
+	return evt
+}
+
+// TestWebSocket_Protocol 测试快照、命令和事件序号
+func TestWebSocket_Protocol(t *testing.T) {
+	pdf := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("%PDF-1.4 test"))
+	}))
+	defer pdf.Close()
+
+	_, handler := newTestWebServer(t)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	conn := dialTestWebSocket(t, server)
+	defer conn.Close()
+
+	// 第一条消息必须是快照
+	evt := readEvent(t, conn)
+	if evt.Type != EventSnapshot || evt.Seq != 1 {
+		t.Fatalf("Expected snapshot with seq 1, got %+v", evt)
+	}
+
+	// 未知命令返回错误
+	conn.WriteJSON(ClientCommand{Type: "unknown", RequestID: "r1"})
+	evt = readEvent(t, conn)
+	if evt.Type != EventError || evt.RequestID != "r1" {
+		t.Errorf("Expected error reply, got %+v", evt)
+	}
+
+	// 通过命令创建任务，直到任务完成为止序号必须连续
+	conn.WriteJSON(ClientCommand{Type: CommandStart, RequestID: "r2", URL: pdf.URL + "/book.pdf"})
+	lastSeq := evt.Seq
+	var acked, completed bool
+	for !(acked && completed) {
+		evt = readEvent(t, conn)
+		if evt.Seq != lastSeq+1 {
+			t.Fatalf("Sequence gap: expected %d, got %d", lastSeq+1, evt.Seq)
+		}
+		lastSeq = evt.Seq
+		switch {
+		case evt.Type == EventAck && evt.RequestID == "r2":
+			acked = true
+		case evt.Type == EventStateChanged && evt.Task.Status == TaskStatusCompleted:
+			completed = true
+		case evt.Type == EventStateChanged && evt.Task.Status == TaskStatusFailed:
+			t.Fatalf("Task failed: %s", evt.Task.ErrorMsg)
+		}
+	}
+
+	// 重新连接后快照中包含已完成的任务
+	conn2 := dialTestWebSocket(t, server)
+	defer conn2.Close()
+	evt = readEvent(t, conn2)
+	if len(evt.Tasks) != 1 || evt.Tasks[0].Status != TaskStatusCompleted {
+		t.Errorf("Expected completed task in snapshot, got %+v", evt.Tasks)
+	}
+}
+
+// TestWebSocket_CrossOrigin 测试拒绝其他网页发起的连接，无法通过跨域连接创建任务
+func TestWebSocket_CrossOrigin(t *testing.T) {
+	ws, handler := newTestWebServer(t)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	conn, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"http://evil.example"}})
+	if err == nil {
+		conn.WriteJSON(ClientCommand{Type: CommandStart, RequestID: "r1", URL: "http://evil.example/steal.pdf"})
+		conn.Close()
+		t.Fatal("Expected cross-origin connection to be refused")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403, got %v", resp)
+	}
+	if tasks := ws.tasks.List(); len(tasks) != 0 {
+		t.Errorf("Expected no tasks, got %+v", tasks)
+	}
+
+	// 本页面发起的连接带有相同的 Origin
+	conn, _, err = websocket.DefaultDialer.Dial(url, http.Header{"Origin": {server.URL}})
+	if err != nil {
+		t.Fatalf("Same-origin dial failed: %v", err)
+	}
+	conn.Close()
+}
+
+// TestWebSocket_Subscribe 测试按任务过滤事件
+func TestWebSocket_Subscribe(t *testing.T) {
+	pdf := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("%PDF-1.4 test"))
+	}))
+	defer pdf.Close()
+
+	ws, handler := newTestWebServer(t)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	conn := dialTestWebSocket(t, server)
+	defer conn.Close()
+	readEvent(t, conn) // 快照
+
+	conn.WriteJSON(ClientCommand{Type: CommandSubscribe, TaskIDs: []string{"other"}})
+	if evt := readEvent(t, conn); evt.Type != EventAck {
+		t.Fatalf("Expected ack, got %+v", evt)
+	}
+
+	// 未订阅任务的事件不应推送，下一条消息必须是重新同步的快照
+	ws.startTask(pdf.URL+"/book.pdf", "")
+	time.Sleep(100 * time.Millisecond)
+	conn.WriteJSON(ClientCommand{Type: CommandResync})
+	evt := readEvent(t, conn)
+	if evt.Type != EventSnapshot {
+		t.Fatalf("Expected snapshot, got %+v", evt)
+	}
+	if len(evt.Tasks) != 1 {
+		t.Errorf("Expected 1 task in snapshot, got %d", len(evt.Tasks))
+	}
+}
+
+// TestWSClient_DropOnOverflow 测试队列已满时丢弃消息而不阻塞
+func TestWSClient_DropOnOverflow(t *testing.T) {
+	client := newWSClient(nil)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < wsSendQueueSize+10; i++ {
+			client.Deliver(&Event{Type: EventLog})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Deliver blocked on a full queue")
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if len(client.queue) != wsSendQueueSize {
+		t.Errorf("Expected full queue of %d, got %d", wsSendQueueSize, len(client.queue))
+	}
+	if client.dropped != 10 {
+		t.Errorf("Expected 10 dropped messages, got %d", client.dropped)
+	}
+	if client.seq != wsSendQueueSize+10 {
+		t.Errorf("Expected seq to count dropped messages, got %d", client.seq)
+	}
+}
+
+// TestWebSocket_ConcurrentBroadcast 测试多个goroutine同时发布时，每个连接收到的消息序号连续
+func TestWebSocket_ConcurrentBroadcast(t *testing.T) {
+	ws, handler := newTestWebServer(t)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	const clients, publishers, perPublisher = 4, 8, 25
+	conns := make([]*websocket.Conn, clients)
+	for i := range conns {
+		conns[i] = dialTestWebSocket(t, server)
+		defer conns[i].Close()
+		readEvent(t, conns[i]) // 快照
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < publishers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perPublisher; j++ {
+				ws.events.Publish(Event{Type: EventLog, Message: "tick"})
+			}
+		}()
+	}
+
+	for _, conn := range conns {
+		var lastID uint64
+		for n := 0; n < publishers*perPublisher; n++ {
+			evt := readEvent(t, conn)
+			if evt.Seq != uint64(n+2) {
+				t.Fatalf("Expected seq %d, got %d", n+2, evt.Seq)
+			}
+			if evt.ID <= lastID {
+				t.Fatalf("Event ids out of order: %d after %d", evt.ID, lastID)
+			}
+			lastID = evt.ID
+		}
+	}
+	wg.Wait()
+}
+
+// TestWebSocket_SlowClientDoesNotBlock 测试不读取消息的客户端不会阻塞发布者
+func TestWebSocket_SlowClientDoesNotBlock(t *testing.T) {
+	ws, handler := newTestWebServer(t)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	conn := dialTestWebSocket(t, server)
+	defer conn.Close()
+
+	message := strings.Repeat("x", 16*1024)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 1000; i++ {
+			ws.events.Publish(Event{Type: EventLog, Message: message})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Publish blocked by a slow client")
+	}
+}
+
+// TestWebSocket_Ping 测试服务端定期发送ping
+func TestWebSocket_Ping(t *testing.T) {
+	oldPing := wsPingPeriod
+	wsPingPeriod = 50 * time.Millisecond
+	defer func() { wsPingPeriod = oldPing }()
+
+	_, handler := newTestWebServer(t)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	conn := dialTestWebSocket(t, server)
+	defer conn.Close()
+
+	pinged := make(chan struct{}, 1)
+	conn.SetPingHandler(func(string) error {
+		select {
+		case pinged <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+	// 控制消息只在读取时处理
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-pinged:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("No ping received")
+	}
+}